@@ -0,0 +1,36 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Command proxmox-helper is the helper the plugin pushes to a node when
+// the node_helper option points to it. It answers the requests of the
+// plugin on its standard input and output, see the nodehelper package:
+//
+//	CGO_ENABLED=0 GOOS=linux go build ./cmd/proxmox-helper
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/nodehelper"
+)
+
+func main() {
+	if err := nodehelper.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "proxmox-helper: %v\n", err)
+		os.Exit(1)
+	}
+}
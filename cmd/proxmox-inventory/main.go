@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Command proxmox-inventory lists what a backup of a proxmox source would
+// import, without dumping anything:
+//
+//	proxmox-inventory [-json] location=proxmox://<node> [key=value]...
+//
+// It takes the importer options, e.g. vmid=100, pool=prod or all=true.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/gillesdubois/plakar-integration-proxmox/importer"
+)
+
+func main() {
+	asJSON := flag.Bool("json", false, "print the inventory as JSON")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+
+	config := make(map[string]string, flag.NArg())
+	for _, arg := range flag.Args() {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			usage()
+		}
+		config[key] = value
+	}
+
+	if err := run(context.Background(), config, *asJSON); err != nil {
+		fmt.Fprintf(os.Stderr, "proxmox-inventory: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: proxmox-inventory [-json] location=proxmox://<node> [key=value]...")
+	os.Exit(2)
+}
+
+func run(ctx context.Context, config map[string]string, asJSON bool) error {
+	imp, err := importer.NewProxmoxImporter(ctx, &connectors.Options{Stderr: os.Stderr}, "proxmox", config)
+	if err != nil {
+		return err
+	}
+	defer imp.Close(ctx)
+
+	entries, err := imp.(*importer.ProxmoxImporter).Inventory(ctx)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+
+	var total int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tVMID\tTYPE\tNAME\tPOOL\tESTIMATED SIZE\tPATH")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%d\t%s\n", entry.Node, entry.VMID, entry.Type, entry.Name, entry.Pool, entry.EstimatedSize, entry.Path)
+		total += entry.EstimatedSize
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d guests, %d bytes estimated\n", len(entries), total)
+	return nil
+}
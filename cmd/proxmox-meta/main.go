@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Command proxmox-meta inspects the _meta.json sidecars stored next to
+// archives, to debug restores offline:
+//
+//	proxmox-meta show <meta.json>...
+//	proxmox-meta check <meta.json> [archive]
+//	proxmox-meta convert [-to major.minor] <meta.json>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "show":
+		err = show(os.Args[2:])
+	case "check":
+		err = check(os.Args[2:])
+	case "convert":
+		err = convert(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "proxmox-meta: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: proxmox-meta show <meta.json>...")
+	fmt.Fprintln(os.Stderr, "       proxmox-meta check <meta.json> [archive]")
+	fmt.Fprintln(os.Stderr, "       proxmox-meta convert [-to major.minor] <meta.json>")
+	os.Exit(2)
+}
+
+func readMetadata(filename string) (proxmox.DumpMetadata, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return proxmox.DumpMetadata{}, err
+	}
+	meta, err := proxmox.DecodeDumpMetadata(data)
+	if err != nil {
+		return proxmox.DumpMetadata{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	return meta, nil
+}
+
+func show(args []string) error {
+	if len(args) == 0 {
+		usage()
+	}
+	for i, filename := range args {
+		meta, err := readMetadata(filename)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printMetadata(filename, meta)
+	}
+	return nil
+}
+
+func printMetadata(filename string, meta proxmox.DumpMetadata) {
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Printf("  %-12s %s\n", name+":", value)
+		}
+	}
+
+	fmt.Printf("%s:\n", filename)
+	field("version", fmt.Sprintf("%d.%d", meta.Version, meta.Minor))
+	field("archive", meta.Archive)
+	field("engine", meta.Engine)
+	field("guest", fmt.Sprintf("%s %d", meta.Type, meta.VMID))
+	field("name", meta.Name)
+	field("node", meta.Node)
+	field("cluster", meta.Cluster)
+	if !meta.CreatedAt.IsZero() {
+		field("created", meta.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+	}
+	field("pve-manager", meta.Hypervisor.PVEManager)
+	field("kernel", meta.Hypervisor.Kernel)
+	field("qemu", meta.Hypervisor.QEMU)
+	field("lxc", meta.Hypervisor.LXC)
+	if meta.Settings != nil {
+		description, _, _ := strings.Cut(meta.Settings.Description, "\n")
+		field("description", description)
+		field("onboot", meta.Settings.OnBoot)
+		field("startup", meta.Settings.Startup)
+		field("boot", meta.Settings.Boot)
+	}
+	for _, disk := range meta.Disks {
+		var flags []string
+		if disk.Format != "" {
+			flags = append(flags, disk.Format)
+		}
+		if disk.Size > 0 {
+			flags = append(flags, fmt.Sprintf("%d bytes", disk.Size))
+		}
+		if disk.Discard {
+			flags = append(flags, "discard")
+		}
+		if disk.IOThread {
+			flags = append(flags, "iothread")
+		}
+		if !disk.Backed {
+			flags = append(flags, "not backed up")
+		}
+		field(disk.Key, disk.VolID+" ("+strings.Join(flags, ", ")+")")
+	}
+}
+
+// check validates a metadata sidecar against its archive, by default the
+// file it sits next to.
+func check(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		usage()
+	}
+	meta, err := readMetadata(args[0])
+	if err != nil {
+		return err
+	}
+
+	archivePath := filepath.Join(filepath.Dir(args[0]), meta.Archive)
+	if len(args) == 2 {
+		archivePath = args[1]
+	}
+	if err := proxmox.CheckDumpMetadata(meta, filepath.Base(archivePath)); err != nil {
+		return err
+	}
+	if meta.Engine != proxmox.EngineVzdump {
+		// Disk engine archives are a set of images, named after the
+		// archive; there is no single file to read.
+		fmt.Printf("%s: ok (%s %d, engine=%s)\n", archivePath, meta.Type, meta.VMID, meta.Engine)
+		return nil
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := make([]byte, 16)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	expected := ""
+	for _, suffix := range []string{".gz", ".zst", ".lzo"} {
+		if strings.HasSuffix(strings.ToLower(archivePath), suffix) {
+			expected = suffix
+		}
+	}
+	if actual := proxmox.DetectCompressionSuffix(header[:n]); actual != expected {
+		return fmt.Errorf("%s: content compression %q does not match its name: %w", archivePath, actual, proxmox.ErrArchiveCorrupt)
+	}
+
+	fmt.Printf("%s: ok (%s %d)\n", archivePath, meta.Type, meta.VMID)
+	return nil
+}
+
+func convert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	to := flags.String("to", fmt.Sprintf("%d.%d", proxmox.DumpMetadataVersion, proxmox.DumpMetadataMinor), "target metadata version")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		usage()
+	}
+
+	var major, minor int
+	if _, err := fmt.Sscanf(*to, "%d.%d", &major, &minor); err != nil {
+		return fmt.Errorf("invalid -to version %q", *to)
+	}
+	meta, err := readMetadata(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	meta, err = proxmox.ConvertDumpMetadata(meta, major, minor)
+	if err != nil {
+		return err
+	}
+	data, err := proxmox.EncodeDumpMetadata(meta)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+const defaultRestoreCheckpointWindow = 7 * 24 * time.Hour
+
+// restoreCheckpoint records which archives of a run were restored, so
+// that an interrupted restore can be resumed without uploading and
+// restoring them again. Archives are keyed by their snapshot directory,
+// name and target VMID.
+type restoreCheckpoint struct {
+	path string
+
+	mu sync.Mutex
+	// skipped holds the guests skipped by this run, reported once.
+	skipped map[string]proxmox.GuestSummary
+
+	Origin    string    `json:"origin"`
+	StartedAt time.Time `json:"started_at"`
+	Completed []string  `json:"completed"`
+}
+
+// loadRestoreCheckpoint returns the checkpoint stored at path when it
+// restores onto the same origin and was started within window; otherwise
+// it returns a fresh checkpoint for this run.
+func loadRestoreCheckpoint(path, origin string, window time.Duration) (*restoreCheckpoint, error) {
+	fresh := &restoreCheckpoint{
+		path:      path,
+		skipped:   make(map[string]proxmox.GuestSummary),
+		Origin:    origin,
+		StartedAt: time.Now(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fresh, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read restore checkpoint %s: %w", path, err)
+	}
+
+	previous := &restoreCheckpoint{}
+	if err := json.Unmarshal(data, previous); err != nil {
+		return nil, fmt.Errorf("invalid restore checkpoint %s: %w", path, err)
+	}
+	if previous.Origin != origin || time.Since(previous.StartedAt) > window {
+		return fresh, nil
+	}
+
+	previous.path = path
+	previous.skipped = make(map[string]proxmox.GuestSummary)
+	return previous, nil
+}
+
+// restoreCheckpointKey identifies an archive of the snapshot and where it
+// is restored.
+func restoreCheckpointKey(pathname, archiveBase string, target int) string {
+	return path.Join(path.Dir(pathname), archiveBase) + "@" + strconv.Itoa(target)
+}
+
+// checkpointTarget returns the VMID the archives of pending are recorded
+// under. VMIDs allocated by restore_target_vmid=auto change from run to
+// run, so the source VMID is recorded instead.
+func (p *ProxmoxExporter) checkpointTarget(pending pendingRestore) int {
+	if p.restoreOpts.autoVMID {
+		return pending.vmid
+	}
+	return p.targetVMID(pending)
+}
+
+// skip reports whether the archive was restored onto target by an
+// earlier run, and records it to be reported by skippedGuests.
+func (c *restoreCheckpoint) skip(pathname, archiveBase string, target int) bool {
+	key := restoreCheckpointKey(pathname, archiveBase, target)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !slices.Contains(c.Completed, key) {
+		return false
+	}
+	if _, ok := c.skipped[key]; !ok {
+		guest := proxmox.GuestSummary{VMID: target, Archive: archiveBase, Status: proxmox.GuestStatusSkipped, Error: "already restored by an interrupted run"}
+		if vmType, vmid, err := proxmox.ParseArchiveName(archiveBase); err == nil {
+			guest.Type, guest.SourceVMID = vmType, vmid
+		}
+		c.skipped[key] = guest
+	}
+	return true
+}
+
+// skippedGuests returns the guests skipped by this run, for the summary.
+func (c *restoreCheckpoint) skippedGuests() []proxmox.GuestSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	guests := make([]proxmox.GuestSummary, 0, len(c.skipped))
+	for _, guest := range c.skipped {
+		guests = append(guests, guest)
+	}
+	slices.SortFunc(guests, func(a, b proxmox.GuestSummary) int { return a.VMID - b.VMID })
+	return guests
+}
+
+func (c *restoreCheckpoint) markCompleted(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slices.Contains(c.Completed, key) {
+		return nil
+	}
+	c.Completed = append(c.Completed, key)
+	slices.Sort(c.Completed)
+	return c.save()
+}
+
+func (c *restoreCheckpoint) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to write restore checkpoint: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to write restore checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to write restore checkpoint: %w", err)
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// clear removes the checkpoint once every archive of the run was restored.
+func (c *restoreCheckpoint) clear() error {
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
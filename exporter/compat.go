@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// nodeArchs lists the guest architectures a node runs natively, keyed by
+// the node uname -m. QEMU names them like uname, LXC like Debian.
+var nodeArchs = map[string][]string{
+	"x86_64":  {"x86_64", "amd64", "i386"},
+	"aarch64": {"aarch64", "arm64", "armhf"},
+}
+
+var machineVersionRegex = regexp.MustCompile(`^(?:pc-(?:i440fx|q35)|virt)-\d+\.\d+`)
+
+// checkCompatibility warns when the config sidecar asks for a machine
+// version, CPU model or architecture the target node does not provide.
+// With strict=true the restore is refused instead.
+func (p *ProxmoxExporter) checkCompatibility(ctx context.Context, pending pendingRestore, configData []byte) error {
+	if len(configData) == 0 {
+		return nil
+	}
+	caps, err := p.client.NodeCapabilities(ctx)
+	if err != nil {
+		if p.restoreOpts.strict {
+			return err
+		}
+		p.logger.Warn("unable to read node capabilities", "error", err)
+		return nil
+	}
+
+	problems := guestCompatibility(pending.vmType, configData, caps)
+	for _, problem := range problems {
+		p.logger.Warn("target node may not run the guest", "vmid", pending.vmid, "archive", pending.dumpBase, "problem", problem)
+	}
+	if p.restoreOpts.strict && len(problems) > 0 {
+		return fmt.Errorf("refusing restore of %s %d with strict=true: %s", pending.vmType, pending.vmid, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// guestCompatibility returns what in config the node described by caps
+// cannot provide.
+func guestCompatibility(vmType string, config []byte, caps proxmox.NodeCapabilities) []string {
+	var problems []string
+
+	if arch := configValue(config, "arch"); arch != "" && caps.Arch != "" {
+		native, ok := nodeArchs[caps.Arch]
+		if !ok {
+			native = []string{caps.Arch}
+		}
+		if !slices.Contains(native, arch) {
+			problems = append(problems, fmt.Sprintf("guest architecture %s does not run natively on a %s node", arch, caps.Arch))
+		}
+	}
+	if vmType != "qemu" {
+		return problems
+	}
+
+	// machine: [type=]<machine>[,viommu=...], pinned versions only.
+	machine, _, _ := strings.Cut(configValue(config, "machine"), ",")
+	machine = strings.TrimPrefix(machine, "type=")
+	if version := machineVersionRegex.FindString(machine); version != "" && len(caps.Machines) > 0 {
+		found := false
+		for _, id := range caps.Machines {
+			if base, _, _ := strings.Cut(id, "+"); base == version {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("machine type %s is not supported by the node QEMU", machine))
+		}
+	}
+
+	// cpu: [cputype=]<model>[,flags=...]; host and max follow the node.
+	cpu, _, _ := strings.Cut(configValue(config, "cpu"), ",")
+	cpu = strings.TrimPrefix(cpu, "cputype=")
+	if cpu != "" && cpu != "host" && cpu != "max" && len(caps.CPUModels) > 0 && !slices.Contains(caps.CPUModels, cpu) {
+		problems = append(problems, fmt.Sprintf("CPU model %s is not known on the node", cpu))
+	}
+	return problems
+}
@@ -0,0 +1,213 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+func conflictPending(seq, vmid int, createdAt string) pendingRestore {
+	at, err := time.Parse(time.RFC3339, createdAt)
+	if err != nil {
+		panic(err)
+	}
+	return pendingRestore{
+		seq:       seq,
+		record:    &connectors.Record{Pathname: "/backup/qemu/dump", Reader: io.NopCloser(strings.NewReader(""))},
+		vmType:    "qemu",
+		vmid:      vmid,
+		dumpBase:  fmt.Sprintf("vzdump-qemu-%d-%d.vma.zst", vmid, seq),
+		dumpPath:  fmt.Sprintf("/var/lib/vz/dump/vzdump-qemu-%d-%d.vma.zst", vmid, seq),
+		createdAt: at,
+	}
+}
+
+func TestConflictDrops(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		newID   int
+		failed  map[int]int
+		pending []pendingRestore
+		order   []int        // seq of the kept dumps, in restore order
+		errs    map[int]bool // seq of the dropped dumps, true when failed
+	}{
+		{
+			name:   "all orders oldest first",
+			policy: conflictPolicyAll,
+			pending: []pendingRestore{
+				conflictPending(0, 100, "2026-03-02T00:00:00Z"),
+				conflictPending(1, 101, "2026-03-03T00:00:00Z"),
+				conflictPending(2, 100, "2026-03-01T00:00:00Z"),
+			},
+			order: []int{2, 1, 0},
+		},
+		{
+			name:   "latest keeps the newest",
+			policy: conflictPolicyLatest,
+			pending: []pendingRestore{
+				conflictPending(0, 100, "2026-03-02T00:00:00Z"),
+				conflictPending(1, 100, "2026-03-03T00:00:00Z"),
+				conflictPending(2, 100, "2026-03-01T00:00:00Z"),
+				conflictPending(3, 101, "2026-03-01T00:00:00Z"),
+			},
+			order: []int{1, 3},
+			errs:  map[int]bool{0: false, 2: false},
+		},
+		{
+			name:   "latest groups by newid",
+			policy: conflictPolicyLatest,
+			newID:  900,
+			pending: []pendingRestore{
+				conflictPending(0, 100, "2026-03-02T00:00:00Z"),
+				conflictPending(1, 101, "2026-03-01T00:00:00Z"),
+			},
+			order: []int{0},
+			errs:  map[int]bool{1: false},
+		},
+		{
+			name:   "fail refuses the whole group",
+			policy: conflictPolicyFail,
+			pending: []pendingRestore{
+				conflictPending(0, 100, "2026-03-02T00:00:00Z"),
+				conflictPending(1, 101, "2026-03-01T00:00:00Z"),
+				conflictPending(2, 100, "2026-03-01T00:00:00Z"),
+			},
+			order: []int{1},
+			errs:  map[int]bool{0: true, 2: true},
+		},
+		{
+			name:   "fail refuses archives resolved later",
+			policy: conflictPolicyFail,
+			failed: map[int]int{100: 2},
+			pending: []pendingRestore{
+				conflictPending(0, 100, "2026-03-02T00:00:00Z"),
+				conflictPending(1, 101, "2026-03-01T00:00:00Z"),
+			},
+			order: []int{1},
+			errs:  map[int]bool{0: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ProxmoxExporter{restoreOpts: restoreOptions{conflictPolicy: tt.policy, newID: tt.newID}}
+			failed := tt.failed
+			if failed == nil {
+				failed = make(map[int]int)
+			}
+			dropped := p.conflictDrops(tt.pending, failed)
+
+			var order []int
+			errs := make(map[int]bool)
+			for i, pending := range tt.pending {
+				if err, ok := dropped[i]; ok {
+					errs[pending.seq] = err != nil
+					continue
+				}
+				order = append(order, pending.seq)
+			}
+			if !slices.Equal(order, tt.order) {
+				t.Errorf("kept %v, want %v", order, tt.order)
+			}
+			if len(errs) != len(tt.errs) {
+				t.Fatalf("dropped %v, want %v", errs, tt.errs)
+			}
+			for seq, failed := range tt.errs {
+				if got, ok := errs[seq]; !ok || got != failed {
+					t.Errorf("dump %d: dropped %v failed %v, want failed %v", seq, ok, got, failed)
+				}
+			}
+		})
+	}
+}
+
+func TestConflictDropsFailCount(t *testing.T) {
+	p := &ProxmoxExporter{restoreOpts: restoreOptions{conflictPolicy: conflictPolicyFail}}
+	failed := map[int]int{100: 2}
+	dropped := p.conflictDrops([]pendingRestore{conflictPending(0, 100, "2026-03-02T00:00:00Z")}, failed)
+	if err := dropped[0]; err == nil || !strings.Contains(err.Error(), "3 archives target vmid 100") {
+		t.Errorf("error = %v, want 3 archives target vmid 100", err)
+	}
+	if failed[100] != 3 {
+		t.Errorf("failed[100] = %d, want 3", failed[100])
+	}
+}
+
+func TestResolveConflictsBeforeStaging(t *testing.T) {
+	p := &ProxmoxExporter{
+		logger:      proxmox.NewLogger(io.Discard, 0),
+		cfg:         &proxmox.Config{Cleanup: true},
+		restoreOpts: restoreOptions{conflictPolicy: conflictPolicyLatest},
+	}
+	pending := []pendingRestore{
+		conflictPending(0, 100, "2026-03-01T00:00:00Z"),
+		conflictPending(1, 100, "2026-03-02T00:00:00Z"),
+	}
+	results := make(chan *connectors.Result, len(pending))
+	summary := proxmox.NewRunSummary("restore", "test")
+
+	kept := p.resolveConflicts(context.Background(), pending, false, make(map[int]int), results, summary)
+	if len(kept) != 1 || kept[0].seq != 1 {
+		t.Fatalf("kept %v, want dump 1", kept)
+	}
+	if pending[0].record.Reader != nil {
+		t.Error("dropped record was not closed")
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	if result := <-results; result.Err != nil {
+		t.Errorf("result error = %v, want nil", result.Err)
+	}
+	if len(summary.Guests) != 1 {
+		t.Fatalf("got %d summary guests, want 1", len(summary.Guests))
+	}
+	guest := summary.Guests[0]
+	if guest.Status != proxmox.GuestStatusSkipped || !strings.Contains(guest.Error, "restore_conflict=latest") {
+		t.Errorf("summary guest = %+v, want skipped by restore_conflict=latest", guest)
+	}
+}
+
+func TestResolveConflictsCanceled(t *testing.T) {
+	p := &ProxmoxExporter{
+		logger:      proxmox.NewLogger(io.Discard, 0),
+		cfg:         &proxmox.Config{},
+		restoreOpts: restoreOptions{conflictPolicy: conflictPolicyFail},
+	}
+	pending := []pendingRestore{
+		conflictPending(0, 100, "2026-03-01T00:00:00Z"),
+		conflictPending(1, 100, "2026-03-02T00:00:00Z"),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Nobody reads results: a canceled run must not block on them.
+	kept := p.resolveConflicts(ctx, pending, false, make(map[int]int), make(chan *connectors.Result), proxmox.NewRunSummary("restore", "test"))
+	if len(kept) != 0 {
+		t.Errorf("kept %d dumps, want none", len(kept))
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// diskContent returns the storage content type the disks of a guest of
+// vmType need.
+func diskContent(vmType string) string {
+	if vmType == "lxc" {
+		return "rootdir"
+	}
+	return "images"
+}
+
+// diskStorages returns the storages the disks of configData end up on:
+// storage when set, else their storage_map destination or their original
+// storage.
+func diskStorages(vmType string, configData []byte, storage string, mapping map[string]string) []string {
+	if storage != "" {
+		return []string{storage}
+	}
+	var storages []string
+	for _, disk := range proxmox.ParseGuestDisks(vmType, configData) {
+		if !disk.Backed() {
+			continue
+		}
+		dst, ok := mapping[disk.Storage]
+		if !ok {
+			dst = disk.Storage
+		}
+		if !slices.Contains(storages, dst) {
+			storages = append(storages, dst)
+		}
+	}
+	return storages
+}
+
+// checkStorageContent verifies, before the target is changed, that every
+// storage the disks are restored into allows the content type of the
+// guest, which qmrestore and pct restore only report as a generic error
+// once the target is gone. Without storage or a config sidecar the
+// storages are not known and nothing is checked.
+func (p *ProxmoxExporter) checkStorageContent(ctx context.Context, vmType string, configData []byte) error {
+	content := diskContent(vmType)
+	for _, id := range diskStorages(vmType, configData, p.restoreOpts.storage, p.restoreOpts.storageMap) {
+		info, err := p.client.Storage(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !info.AllowsContent(content) {
+			return fmt.Errorf("restore storage %s does not allow %s content, which %s disks need (allowed: %s)", id, content, vmType, info.Content)
+		}
+	}
+	return nil
+}
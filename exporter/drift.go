@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// driftIgnoredKeys change with every snapshot or task and say nothing
+// about the guest configuration.
+var driftIgnoredKeys = map[string]bool{"lock": true, "parent": true, "digest": true}
+
+// configDrift compares the config of an existing restore target with the
+// config sidecar of the archive about to overwrite it. The changes the
+// restore rolls back are logged and returned; with strict=true they are
+// refused unless restore_confirm_drift=true.
+func (p *ProxmoxExporter) configDrift(ctx context.Context, pending pendingRestore, configData []byte) ([]string, error) {
+	if len(configData) == 0 {
+		return nil, nil
+	}
+	target := p.targetVMID(pending)
+	state, err := p.vmStateOn(ctx, pending.node, pending.vmType, target)
+	if err != nil || !state.exists {
+		return nil, err
+	}
+
+	current, err := p.readGuestConfig(ctx, pending.node, pending.vmType, target)
+	if err != nil {
+		p.logger.Warn("unable to read the config of the restore target", "vmid", target, "error", err)
+		return nil, nil
+	}
+
+	drift := diffGuestConfig(current, configData, pending.vmid, target)
+	if len(drift) == 0 {
+		return nil, nil
+	}
+	p.logger.Warn("restore rolls back config changes of the target guest", "vmid", target, "archive", pending.dumpBase, "changes", len(drift), "diff", strings.Join(drift, "\n"))
+	if p.restoreOpts.strict && !p.restoreOpts.confirmDrift {
+		return drift, fmt.Errorf("refusing to overwrite %s %d with strict=true: its config changed since the backup (%d lines differ), set restore_confirm_drift=true to roll it back", pending.vmType, target, len(drift))
+	}
+	return drift, nil
+}
+
+// diffGuestConfig returns the differences between the current section of
+// two guest configs, sorted by key: "-key: value" for the current value
+// that is lost, "+key: value" for the archived one that replaces it.
+// Volumes of the archive are renamed from the source to the target VMID
+// first, so a restore under newid does not report every disk.
+func diffGuestConfig(current, archived []byte, from, to int) []string {
+	currentValues := guestConfigValues(current)
+	archivedValues := guestConfigValues(archived)
+	if from != to {
+		owner := regexp.MustCompile(`\b(vm|subvol|base|basevol)-` + strconv.Itoa(from) + `-`)
+		for key, value := range archivedValues {
+			value = owner.ReplaceAllString(value, "${1}-"+strconv.Itoa(to)+"-")
+			archivedValues[key] = strings.ReplaceAll(value, ":"+strconv.Itoa(from)+"/", ":"+strconv.Itoa(to)+"/")
+		}
+	}
+
+	keys := make([]string, 0, len(currentValues)+len(archivedValues))
+	for key := range currentValues {
+		keys = append(keys, key)
+	}
+	for key := range archivedValues {
+		if _, ok := currentValues[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var drift []string
+	for _, key := range keys {
+		currentValue, inCurrent := currentValues[key]
+		archivedValue, inArchive := archivedValues[key]
+		if inCurrent && inArchive && currentValue == archivedValue {
+			continue
+		}
+		if inCurrent {
+			drift = append(drift, "-"+key+": "+currentValue)
+		}
+		if inArchive {
+			drift = append(drift, "+"+key+": "+archivedValue)
+		}
+	}
+	return drift
+}
+
+// guestConfigValues returns the keys of the current section of a guest
+// config. The "#" notes lines are joined as the description.
+func guestConfigValues(data []byte) map[string]string {
+	values := make(map[string]string)
+	var notes []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			break
+		}
+		if note, ok := strings.CutPrefix(line, "#"); ok {
+			notes = append(notes, note)
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || driftIgnoredKeys[key] {
+			continue
+		}
+		values[key] = strings.TrimSpace(value)
+	}
+	if len(notes) > 0 {
+		values["description"] = strings.Join(notes, " ")
+	}
+	return values
+}
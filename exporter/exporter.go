@@ -21,9 +21,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"os"
 	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PlakarKorp/kloset/connectors"
@@ -36,6 +42,30 @@ type ProxmoxExporter struct {
 	cfg         *proxmox.Config
 	client      *proxmox.Client
 	restoreOpts restoreOptions
+	logger      *slog.Logger
+	bwLimiter   *proxmox.RateLimiter
+
+	// dumpNode is the {node} of dump_name_template in staged dump names.
+	dumpNode string
+
+	// stagingDir is where dumps are staged: dump_dir, or the backup
+	// directory of staging_storage.
+	stagingDir string
+
+	// pbsStorage is the storage of pbs_storage with restore_mode=pbs.
+	pbsStorage proxmox.StorageInfo
+
+	// nodeLimiter enforces restore_node_concurrency.
+	nodeLimiter *nodeLimiter
+
+	// checkpoint is the restore_checkpoint_file of the run, if any.
+	checkpoint *restoreCheckpoint
+
+	// targetIDs allocates the VMIDs of restore_target_vmid=auto.
+	targetIDs *vmidAllocator
+
+	vmLocksMu sync.Mutex
+	vmLocks   map[int]*sync.Mutex
 }
 
 type vmConfigSidecar struct {
@@ -44,11 +74,22 @@ type vmConfigSidecar struct {
 }
 
 type pendingRestore struct {
-	record   *connectors.Record
-	vmType   string
-	vmid     int
-	dumpBase string
-	dumpPath string
+	seq       int
+	record    *connectors.Record
+	vmType    string
+	vmid      int
+	dumpBase  string
+	dumpPath  string
+	createdAt time.Time
+
+	// engine and images are set for disk engine backups, restored from
+	// their images instead of a dump.
+	engine string
+	images map[string]proxmox.RestoreImage
+
+	// node is the node_map node the guest is restored on, if it is not
+	// the node commands run on.
+	node string
 }
 
 type vmRuntimeState struct {
@@ -56,16 +97,94 @@ type vmRuntimeState struct {
 	running bool
 }
 
+// exporterOptions lists the exporter-specific keys, in addition to the
+// common ones.
+var exporterOptions = []string{
+	"storage", "newid", "force_vm_restore", "start_on_restore",
+	"restore_concurrency", "restore_conflict", "restore_order",
+	"restore_reuse_dump", "restore_set", "restore_summary_file",
+	"restore_mode", "vmid", "restore_name", "restore_tags",
+	"restore_net_bwlimit", "storage_map", "restore_preflight", "strict",
+	"restore_confirm_drift", "restore_report", "restore_report_format",
+	"restore_extra_args_qemu", "restore_extra_args_lxc", "staging_storage",
+	"restore_verify_upload", "restore_upload", "pbs_storage", "restore_stop",
+	"restore_node_concurrency", "restore_checkpoint_file", "restore_checkpoint_window",
+	"restore_target_vmid", "node_map",
+}
+
+// restoreManagedArgs are the qmrestore and pct restore options set by the
+// exporter, which restore_extra_args_* may not override.
+var restoreManagedArgs = []string{"--force", "--storage", "--pool"}
+
 type restoreOptions struct {
 	startOnRestore bool
 	forceVMRestore bool
+	stopPolicy     string
 	newID          int
+	autoVMID       bool
 	storage        string
+	storageMap     map[string]string
+	nodeMap        map[string]string
+	diskMoves      []diskMove
 	pool           string
+	setOptions     []setOption
+	extraArgs      map[string][]string
+	stagingStorage string
+	pbsStorage     string
+	conflictPolicy string
+	restoreOrder   []int
+	concurrency    int
+	perNodeLimit   int
+	checkpointFile string
+	checkpointAge  time.Duration
+	reuseDump      bool
+	verifyUpload   bool
+	uploadAPI      bool
+	summaryFile    string
+	reportPath     string
+	reportFormat   string
+	mode           string
+	netBWLimit     int64
+	preflight      bool
+	strict         bool
+	confirmDrift   bool
+	vmids          map[int]bool
+	names          []string
+	tags           []string
+}
+
+type setOption struct {
+	key   string
+	value string
 }
 
 const protocolName = "proxmox+backup"
 
+const (
+	restoreModeRestore  = "restore"
+	restoreModeValidate = "validate"
+	restoreModePBS      = "pbs"
+)
+
+// Values of restore_upload.
+const (
+	restoreUploadSSH = "ssh"
+	restoreUploadAPI = "api"
+)
+
+// Values of restore_stop.
+const (
+	restoreStopAuto   = "auto"
+	restoreStopAlways = "always"
+	restoreStopNever  = "never"
+)
+
+const (
+	conflictPolicyAll    = "all"
+	conflictPolicyLatest = "latest"
+	conflictPolicyFail   = "fail"
+)
+
 func init() {
 	if err := exporter.Register(protocolName, 0, NewProxmoxExporter); err != nil {
 		panic(err)
@@ -73,25 +192,68 @@ func init() {
 }
 
 func NewProxmoxExporter(ctx context.Context, opts *connectors.Options, name string, config map[string]string) (exporter.Exporter, error) {
-	cfg, err := proxmox.ParseConfig(config)
+	return newProxmoxExporter(ctx, opts, config, proxmox.NewClient)
+}
+
+// NewProxmoxExporterWithRunner builds the exporter on top of runner instead of
+// a connection to a node, see the runner package.
+func NewProxmoxExporterWithRunner(ctx context.Context, opts *connectors.Options, name string, config map[string]string, runner proxmox.Runner) (exporter.Exporter, error) {
+	return newProxmoxExporter(ctx, opts, config, func(cfg *proxmox.Config, logger *slog.Logger) (*proxmox.Client, error) {
+		return proxmox.NewClientWithRunner(cfg, logger, runner)
+	})
+}
+
+func newProxmoxExporter(ctx context.Context, opts *connectors.Options, config map[string]string, newClient func(*proxmox.Config, *slog.Logger) (*proxmox.Client, error)) (exporter.Exporter, error) {
+	config, err := proxmox.MergeLocationQuery(config)
 	if err != nil {
 		return nil, err
 	}
 
-	restoreOpts, err := parseRestoreOptions(config)
-	if err != nil {
+	cfg, cfgErr := proxmox.ParseConfig(config)
+	restoreOpts, optsErr := parseRestoreOptions(config)
+	unknown, unknownErr := proxmox.CheckOptions(config, exporterOptions)
+	if err := errors.Join(cfgErr, optsErr, unknownErr); err != nil {
 		return nil, err
 	}
 
-	client, err := proxmox.NewClient(cfg)
+	var stderr io.Writer
+	if opts != nil {
+		stderr = opts.Stderr
+	}
+	logger := proxmox.NewLogger(stderr, cfg.LogLevel).With("connector", "exporter")
+	for _, msg := range unknown {
+		logger.Warn(msg)
+	}
+	if restoreOpts.uploadAPI && cfg.Control != proxmox.ControlHTTPS {
+		return nil, fmt.Errorf("restore_upload=api requires control=https")
+	}
+	if restoreOpts.nodeMap != nil {
+		if cfg.Control == proxmox.ControlCLI {
+			return nil, fmt.Errorf("node_map requires control=api or https")
+		}
+		// A node of the source cluster would not resolve here.
+		if mapped, ok := restoreOpts.nodeMap[cfg.Node]; ok {
+			logger.Info("node mapped by node_map", "node", cfg.Node, "target", mapped)
+			cfg.Node = mapped
+		}
+	}
+
+	client, err := newClient(cfg, logger)
 	if err != nil {
 		return nil, err
 	}
+	if err := client.DetectCluster(ctx); err != nil {
+		logger.Warn("unable to detect cluster name, using the host as origin", "error", err)
+	}
 
 	return &ProxmoxExporter{
 		cfg:         cfg,
 		client:      client,
 		restoreOpts: restoreOpts,
+		logger:      logger,
+		bwLimiter:   proxmox.NewRateLimiter(restoreOpts.netBWLimit),
+		nodeLimiter: newNodeLimiter(restoreOpts.perNodeLimit),
+		vmLocks:     make(map[int]*sync.Mutex),
 	}, nil
 }
 
@@ -104,12 +266,130 @@ func (p *ProxmoxExporter) Ping(ctx context.Context) error {
 	return p.client.Ping(ctx)
 }
 
-func (p *ProxmoxExporter) Export(ctx context.Context, records <-chan *connectors.Record, results chan<- *connectors.Result) error {
+func (p *ProxmoxExporter) Export(ctx context.Context, records <-chan *connectors.Record, results chan<- *connectors.Result) (err error) {
 	defer close(results)
 
+	ctx, span := p.client.Tracer().Start(ctx, "export", "origin", p.cfg.Origin())
+	defer func() { span.End(err) }()
+
+	if p.restoreOpts.mode == restoreModeValidate {
+		return p.validate(ctx, records, results)
+	}
+
+	summary := proxmox.NewRunSummary("restore", p.cfg.Origin())
+	p.client.StartRun(summary)
+	defer func() {
+		summary.Finish(err)
+		p.reportSummary(summary)
+	}()
+
+	metrics := p.client.Metrics()
+	runStart := time.Now()
+	metrics.Set(proxmox.MetricRunStart, float64(runStart.Unix()), "operation", "restore")
+	if p.cfg.MetricsFile != "" {
+		stop := metrics.StartTextfile(ctx, p.cfg.MetricsFile)
+		defer func() {
+			metrics.Set(proxmox.MetricRunDuration, time.Since(runStart).Seconds(), "operation", "restore")
+			if err := stop(); err != nil {
+				p.logger.Warn("unable to write metrics file", "path", p.cfg.MetricsFile, "error", err)
+			}
+		}()
+	}
+
+	if p.cfg.JobLock {
+		lock, err := p.client.AcquireJobLock(ctx, "export")
+		if err != nil {
+			for record := range records {
+				results <- record.Error(err)
+			}
+			return err
+		}
+		defer func() {
+			_ = lock.Release(context.Background())
+		}()
+	}
+
+	if err := errors.Join(p.checkPermissions(ctx), p.checkNodeMap(ctx), p.resolveStagingDir(ctx), p.resolvePBSStorage(ctx)); err != nil {
+		for record := range records {
+			results <- record.Error(err)
+		}
+		return err
+	}
+
+	if p.restoreOpts.checkpointFile != "" {
+		if p.checkpoint, err = loadRestoreCheckpoint(p.restoreOpts.checkpointFile, p.cfg.Origin(), p.restoreOpts.checkpointAge); err != nil {
+			for record := range records {
+				results <- record.Error(err)
+			}
+			return err
+		}
+	}
+	if p.restoreOpts.autoVMID {
+		p.targetIDs = newVMIDAllocator(ctx, p.client, p.logger)
+	}
+
 	sidecars := make(map[string]vmConfigSidecar)
 	poolSidecars := make(map[string]string)
+	metaSidecars := make(map[string]proxmox.DumpMetadata)
 	pendingRestores := make([]pendingRestore, 0)
+	stagedPaths := make(map[string]bool)
+	segments := newSegmentedDumps()
+	if strings.Contains(p.cfg.DumpNameTemplate, "{node}") {
+		if node, err := p.client.LocalNode(ctx); err != nil {
+			p.logger.Warn("unable to resolve the node of dump_name_template", "error", err)
+		} else {
+			p.dumpNode = node
+		}
+	}
+	images := newImageSets()
+
+	var (
+		seq            int
+		pendingMu      sync.Mutex
+		stagingWg      sync.WaitGroup
+		stagingSem     = make(chan struct{}, p.restoreOpts.concurrency)
+		deferred       []pendingRestore
+		deferredImages []deferredImage
+		conflictFailed = make(map[int]int)
+	)
+
+	stage := func(pending pendingRestore) {
+		stagingSem <- struct{}{}
+		stagingWg.Add(1)
+		go func() {
+			defer stagingWg.Done()
+			defer func() { <-stagingSem }()
+
+			if err := p.stageDump(ctx, pending.dumpPath, pending.record); err != nil {
+				summary.Add(failedGuestSummary(pending, err))
+				results <- pending.record.Error(err)
+				return
+			}
+			if err := closeRecord(pending.record); err != nil {
+				summary.Add(failedGuestSummary(pending, err))
+				results <- resultFromRecord(pending.record, err)
+				return
+			}
+
+			pendingMu.Lock()
+			pendingRestores = append(pendingRestores, pending)
+			pendingMu.Unlock()
+		}()
+	}
+
+	stageImage := func(image deferredImage) {
+		primary := images.add(image.key, image.name, image.record, seq)
+		if primary {
+			seq++
+		}
+		stagingSem <- struct{}{}
+		stagingWg.Add(1)
+		go func() {
+			defer stagingWg.Done()
+			defer func() { <-stagingSem }()
+			p.stageImage(ctx, image.record, image.name, image.key, primary, images, results)
+		}()
+	}
 
 	for record := range records {
 		if err := ctx.Err(); err != nil {
@@ -141,6 +421,71 @@ func (p *ProxmoxExporter) Export(ctx context.Context, records <-chan *connectors
 			results <- resultFromRecord(record, nil)
 			continue
 		}
+		if proxmox.IsDumpMetadataFilename(base) {
+			if err := p.collectMetadataSidecar(record, base, metaSidecars); err != nil {
+				_ = closeRecord(record)
+				results <- resultFromRecord(record, err)
+				continue
+			}
+			results <- resultFromRecord(record, nil)
+			continue
+		}
+
+		if archiveName, index, ok := proxmox.ParsePartFilename(base); ok {
+			if !p.selectedArchive(record.Pathname, archiveName) {
+				results <- resultFromRecord(record, closeRecord(record))
+				continue
+			}
+			key := segmentKey(record.Pathname, archiveName)
+			stagingSem <- struct{}{}
+			stagingWg.Add(1)
+			go func(record *connectors.Record) {
+				defer stagingWg.Done()
+				defer func() { <-stagingSem }()
+				results <- p.stagePart(ctx, record, key, index, segments)
+			}(record)
+			continue
+		}
+		if proxmox.IsPartsManifestFilename(base) {
+			data, err := readRecordBytes(record)
+			if err != nil {
+				results <- resultFromRecord(record, err)
+				continue
+			}
+			manifest, err := proxmox.DecodePartsManifest(data)
+			if err != nil {
+				results <- resultFromRecord(record, err)
+				continue
+			}
+			if !p.selectedArchive(record.Pathname, manifest.Archive) {
+				p.logger.Info("archive not selected, skipping", "archive", manifest.Archive)
+				results <- resultFromRecord(record, nil)
+				continue
+			}
+			segments.setManifest(segmentKey(record.Pathname, manifest.Archive), manifest, record, seq)
+			seq++
+			continue
+		}
+
+		if archiveName, key, ok := proxmox.ParseBindMountFilename(base); ok {
+			// Bind mount contents live on the host, not in a PVE storage.
+			p.logger.Info("bind mount archive is not restored, skipping", "archive", archiveName, "mountpoint", key)
+			results <- resultFromRecord(record, closeRecord(record))
+			continue
+		}
+		if name, ok := proxmox.ParseImageFilename(base); ok {
+			if !p.selectedArchive(record.Pathname, name.Archive) {
+				results <- resultFromRecord(record, closeRecord(record))
+				continue
+			}
+			image := deferredImage{record: record, name: name, key: segmentKey(record.Pathname, name.Archive)}
+			if p.deferStaging() {
+				deferredImages = append(deferredImages, image)
+				continue
+			}
+			stageImage(image)
+			continue
+		}
 
 		vmType, vmid, err := proxmox.ParseDumpFilename(base)
 		if err != nil {
@@ -152,75 +497,580 @@ func (p *ProxmoxExporter) Export(ctx context.Context, records <-chan *connectors
 			continue
 		}
 
-		dumpName := proxmox.BuildRestoreDumpFilename(base, vmType, vmid, time.Now())
-		dumpPath := path.Join(p.cfg.DumpDir, dumpName)
-		if err := p.writeDump(ctx, dumpPath, record.Reader); err != nil {
-			results <- record.Error(err)
+		if !p.selectedArchive(record.Pathname, base) {
+			p.logger.Info("archive not selected, skipping", "vmid", vmid, "archive", base)
+			results <- resultFromRecord(record, closeRecord(record))
 			continue
 		}
 
-		if err := closeRecord(record); err != nil {
-			results <- resultFromRecord(record, err)
-			continue
+		createdAt, ok := proxmox.ParseDumpTimestamp(base)
+		if !ok {
+			createdAt = record.FileInfo.LmodTime
 		}
 
-		pendingRestores = append(pendingRestores, pendingRestore{
-			record:   record,
-			vmType:   vmType,
-			vmid:     vmid,
-			dumpBase: base,
-			dumpPath: dumpPath,
-		})
-	}
+		// Reusable dumps need a name that is stable across runs.
+		stagedAt := time.Now()
+		if p.restoreOpts.reuseDump {
+			stagedAt = createdAt
+		}
+		dumpPath := p.allocateDumpPath(record, base, vmType, vmid, stagedAt, stagedPaths)
+
+		pending := pendingRestore{
+			seq:       seq,
+			record:    record,
+			vmType:    vmType,
+			vmid:      vmid,
+			dumpBase:  base,
+			dumpPath:  dumpPath,
+			createdAt: createdAt,
+		}
+		seq++
 
-	for _, pending := range pendingRestores {
+		// Tags and disk sizes live in the sidecars, which follow their
+		// archive in the snapshot: wait for every sidecar before staging
+		// anything.
+		if p.deferStaging() {
+			deferred = append(deferred, pending)
+			continue
+		}
+		stage(pending)
+	}
+	selected := deferred[:0]
+	for _, pending := range deferred {
 		if err := ctx.Err(); err != nil {
+			results <- pending.record.Error(err)
+			continue
+		}
+		if !p.selectedByTags(pending.dumpBase, sidecars) {
+			p.logger.Info("archive not selected by restore_tags, skipping", "vmid", pending.vmid, "archive", pending.dumpBase)
+			results <- resultFromRecord(pending.record, closeRecord(pending.record))
+			continue
+		}
+		if err := p.targetIDs.failure(pending.vmid); err != nil {
+			p.logger.Warn("restore preflight failed", "vmid", pending.vmid, "archive", pending.dumpBase, "error", err)
+			_ = closeRecord(pending.record)
+			summary.Add(failedGuestSummary(pending, err))
+			results <- resultFromRecord(pending.record, err)
+			continue
+		}
+		if meta, ok := metaSidecars[pending.dumpBase]; ok && !meta.CreatedAt.IsZero() {
+			pending.createdAt = meta.CreatedAt
+		}
+		selected = append(selected, pending)
+	}
+	// Conflicting dumps are dropped before being staged, so that
+	// restore_conflict=latest and fail never upload an archive for nothing.
+	selected = p.resolveConflicts(ctx, selected, false, conflictFailed, results, summary)
+	for _, pending := range selected {
+		var err error
+		if p.restoreOpts.preflight {
+			err = p.preflight(ctx, pending.dumpBase, pending.vmType, p.targetVMID(pending), true, sidecars, metaSidecars)
+		}
+		if err != nil {
+			p.logger.Warn("restore preflight failed", "vmid", pending.vmid, "archive", pending.dumpBase, "error", err)
+			_ = closeRecord(pending.record)
+			summary.Add(failedGuestSummary(pending, err))
 			results <- resultFromRecord(pending.record, err)
 			continue
 		}
+		stage(pending)
+	}
+	preflightErrs := make(map[string]error)
+	for _, image := range deferredImages {
+		if !p.selectedByTags(image.name.Archive, sidecars) {
+			results <- resultFromRecord(image.record, closeRecord(image.record))
+			continue
+		}
+		err, checked := preflightErrs[image.key]
+		if !checked {
+			err = p.targetIDs.failure(image.name.VMID)
+			if err == nil && p.restoreOpts.preflight {
+				target := p.targetVMID(pendingRestore{vmid: image.name.VMID})
+				err = p.preflight(ctx, image.name.Archive, image.name.Type, target, !image.name.Incremental, sidecars, metaSidecars)
+			}
+			preflightErrs[image.key] = err
+			if err != nil {
+				p.logger.Warn("restore preflight failed", "vmid", image.name.VMID, "archive", image.name.Archive, "error", err)
+				summary.Add(failedGuestSummary(pendingRestore{vmType: image.name.Type, vmid: image.name.VMID, dumpBase: image.name.Archive}, err))
+			}
+		}
+		if err != nil {
+			_ = closeRecord(image.record)
+			results <- resultFromRecord(image.record, err)
+			continue
+		}
+		stageImage(image)
+	}
+	stagingWg.Wait()
+	if len(p.restoreOpts.tags) > 0 {
+		p.dropUnselectedSegments(segments, sidecars, results)
+	}
+	if p.restoreOpts.preflight || p.targetIDs != nil {
+		p.preflightSegments(ctx, segments, sidecars, metaSidecars, results, summary)
+	}
+	pendingRestores = append(pendingRestores, p.assembleSegmentedDumps(ctx, segments, stagedPaths, results, summary)...)
+	pendingRestores = append(pendingRestores, p.imageRestores(ctx, images, results, summary)...)
+
+	// Staging completes out of order; restore snapshot order before
+	// applying conflict and ordering rules.
+	sort.Slice(pendingRestores, func(i, j int) bool {
+		return pendingRestores[i].seq < pendingRestores[j].seq
+	})
+	pendingRestores = p.dropUnallocated(ctx, pendingRestores, results, summary)
+	pendingRestores = p.resolveConflicts(ctx, pendingRestores, true, conflictFailed, results, summary)
+	p.sortRestores(pendingRestores, sidecars)
+	p.logger.Info("restore started", "dumps", len(pendingRestores), "concurrency", p.restoreOpts.concurrency, "node_concurrency", p.restoreOpts.perNodeLimit)
+
+	var pendingCount atomic.Int64
+	pendingCount.Store(int64(len(pendingRestores)))
+	metrics.Set(proxmox.MetricRunGuestsPending, float64(len(pendingRestores)), "operation", "restore")
+
+	// Dumps sharing a target VMID form a chain restored sequentially by a
+	// single worker; distinct chains run in parallel.
+	chains := make([][]pendingRestore, 0)
+	chainIndex := make(map[int]int)
+	for _, pending := range pendingRestores {
+		target := p.targetVMID(pending)
+		idx, ok := chainIndex[target]
+		if !ok {
+			idx = len(chains)
+			chainIndex[target] = idx
+			chains = append(chains, nil)
+		}
+		chains[idx] = append(chains[idx], pending)
+	}
 
-		configData, err := p.resolveConfigForDump(pending, sidecars)
-		if err == nil {
-			poolName, poolErr := p.resolvePoolForDump(pending, poolSidecars)
-			if poolErr != nil {
-				err = poolErr
-			} else {
-				targetVMID := pending.vmid
-				if p.restoreOpts.newID != 0 {
-					targetVMID = p.restoreOpts.newID
+	restoreSem := make(chan struct{}, p.restoreOpts.concurrency)
+	var restoreWg sync.WaitGroup
+	for _, chain := range chains {
+		restoreSem <- struct{}{}
+		restoreWg.Add(1)
+		go func(chain []pendingRestore) {
+			defer restoreWg.Done()
+			defer func() { <-restoreSem }()
+
+			for _, pending := range chain {
+				startedAt := time.Now()
+				var outcome restoreOutcome
+				release, err := p.acquireNodeSlot(ctx)
+				if err == nil {
+					outcome, err = p.restorePending(ctx, pending, sidecars, poolSidecars, metaSidecars)
+					release()
 				}
+				if err == nil && p.checkpoint != nil {
+					// A guest that fails its verification is restored again
+					// by the next run. restore_mode=pbs restores no guest.
+					if p.restoreOpts.mode != restoreModePBS && outcome.verification != proxmox.GuestStatusOK {
+						p.logger.Warn("restored guest not recorded in the restore checkpoint", "vmid", p.targetVMID(pending), "archive", pending.dumpBase, "verification", outcome.verification)
+					} else {
+						key := restoreCheckpointKey(pending.record.Pathname, pending.dumpBase, p.checkpointTarget(pending))
+						if cpErr := p.checkpoint.markCompleted(key); cpErr != nil {
+							p.logger.Warn("unable to update restore checkpoint", "error", cpErr)
+						}
+					}
+				}
+				p.observeRestore(summary, pending, startedAt, outcome, err)
+				metrics.Set(proxmox.MetricRunGuestsPending, float64(pendingCount.Add(-1)), "operation", "restore")
+				results <- resultFromRecord(pending.record, err)
+			}
+		}(chain)
+	}
+	restoreWg.Wait()
+
+	p.logger.Info("restore finished", "dumps", len(pendingRestores))
+	if p.checkpoint != nil {
+		for _, guest := range p.checkpoint.skippedGuests() {
+			summary.Add(guest)
+		}
+		if len(summary.Failed) == 0 && ctx.Err() == nil {
+			return p.checkpoint.clear()
+		}
+	}
+	return nil
+}
+
+// restorePending restores one staged archive.
+func (p *ProxmoxExporter) restorePending(ctx context.Context, pending pendingRestore, sidecars map[string]vmConfigSidecar, poolSidecars map[string]string, metaSidecars map[string]proxmox.DumpMetadata) (restoreOutcome, error) {
+	var outcome restoreOutcome
+	if err := ctx.Err(); err != nil {
+		return outcome, err
+	}
+	if err := p.targetIDs.failure(pending.vmid); err != nil {
+		return outcome, err
+	}
+
+	configData, err := p.resolveConfigForDump(pending, sidecars)
+	if err != nil {
+		return outcome, err
+	}
+
+	poolName, err := p.resolvePoolForDump(pending, poolSidecars)
+	if err != nil {
+		return outcome, err
+	}
+
+	if err := p.checkMetadata(pending, metaSidecars); err != nil {
+		return outcome, err
+	}
+	// The archive name and its metadata agree on the engine once checked.
+	engine := proxmox.ArchiveEngine(pending.dumpBase)
+	if err := proxmox.CheckRestoreEngine(engine, pending.vmType); err != nil {
+		return outcome, err
+	}
+	if p.restoreOpts.mode == restoreModePBS {
+		if engine != proxmox.EngineVzdump {
+			return outcome, fmt.Errorf("restore_mode=pbs only imports vzdump archives, not %s images", engine)
+		}
+		if err := p.importToPBS(ctx, pending); err != nil {
+			return outcome, err
+		}
+		if p.cfg.Cleanup {
+			return outcome, p.removeStaged(ctx, pending)
+		}
+		return outcome, nil
+	}
+	p.warnHypervisorVersions(ctx, pending, metaSidecars)
+	if err := p.checkCompatibility(ctx, pending, configData); err != nil {
+		return outcome, err
+	}
+	pending.node, err = p.targetNode(ctx, pending, metaSidecars)
+	if err != nil {
+		return outcome, err
+	}
+	outcome.drift, err = p.configDrift(ctx, pending, configData)
+	if err != nil {
+		return outcome, err
+	}
+
+	switch engine {
+	case proxmox.EngineVzdump:
+		settings := p.guestSettings(pending, configData, metaSidecars)
+		err = p.restoreDump(ctx, pending.dumpPath, pending.vmType, p.targetVMID(pending), configData, settings, pending.node, poolName)
+	default:
+		err = p.restoreImages(ctx, pending, configData, poolName)
+	}
+	// The drift check read the config of the target before it was
+	// overwritten.
+	p.client.InvalidateVMConfig(pending.vmType, p.targetVMID(pending))
+	if err != nil {
+		return outcome, err
+	}
+	if p.restoreOpts.reportPath != "" || p.checkpoint != nil {
+		p.verifyRestore(ctx, pending, &outcome)
+	}
 
-				err = p.restoreDump(ctx, pending.dumpPath, pending.vmType, targetVMID, configData, poolName)
+	if p.cfg.Cleanup {
+		return outcome, p.removeStaged(ctx, pending)
+	}
+	return outcome, nil
+}
+
+// observeRestore updates the run metrics and summary once a dump has been
+// restored.
+func (p *ProxmoxExporter) observeRestore(summary *proxmox.RunSummary, pending pendingRestore, startedAt time.Time, outcome restoreOutcome, err error) {
+	metrics := p.client.Metrics()
+	target := p.targetVMID(pending)
+	duration := time.Since(startedAt).Seconds()
+
+	guest := proxmox.GuestSummary{
+		VMID:        target,
+		Type:        pending.vmType,
+		Archive:     pending.dumpBase,
+		Status:      proxmox.GuestStatusOK,
+		Bytes:       pending.record.FileInfo.Lsize,
+		Duration:    duration,
+		ConfigDrift: outcome.drift,
+
+		SourceVMID:   pending.vmid,
+		Node:         outcome.node,
+		Storages:     outcome.storages,
+		Verification: outcome.verification,
+	}
+	if err != nil {
+		guest.Status = proxmox.GuestStatusFailed
+		guest.Error = err.Error()
+		guest.ErrorClass = proxmox.ErrorClass(err)
+	}
+	summary.Add(guest)
+
+	vmidLabel := strconv.Itoa(target)
+	metrics.Add(proxmox.MetricGuests, 1, "operation", "restore", "status", guest.Status)
+	metrics.Set(proxmox.MetricGuestDuration, duration, "operation", "restore", "vmid", vmidLabel)
+	metrics.Set(proxmox.MetricGuestBytes, float64(guest.Bytes), "operation", "restore", "vmid", vmidLabel)
+}
+
+func failedGuestSummary(pending pendingRestore, err error) proxmox.GuestSummary {
+	return proxmox.GuestSummary{
+		VMID:       pending.vmid,
+		Type:       pending.vmType,
+		Archive:    pending.dumpBase,
+		Status:     proxmox.GuestStatusFailed,
+		Error:      err.Error(),
+		ErrorClass: proxmox.ErrorClass(err),
+	}
+}
+
+// reportSummary logs the run summary and, when restore_summary_file is set,
+// writes it there as JSON.
+func (p *ProxmoxExporter) reportSummary(summary *proxmox.RunSummary) {
+	p.logger.Info(summary.Operation+" summary", "status", summary.Status, "guests", len(summary.Guests),
+		"skipped", summary.Skipped, "failed", summary.Failed, "bytes", summary.Bytes)
+	p.client.FinishRun(summary)
+
+	p.writeReport(summary)
+
+	if p.restoreOpts.summaryFile == "" {
+		return
+	}
+	data, err := summary.Encode()
+	if err == nil {
+		err = os.WriteFile(p.restoreOpts.summaryFile, append(data, '\n'), 0644)
+	}
+	if err != nil {
+		p.logger.Warn("unable to write restore summary", "path", p.restoreOpts.summaryFile, "error", err)
+	}
+}
+
+// deferStaging reports whether archives wait for every sidecar of the
+// snapshot before being staged.
+func (p *ProxmoxExporter) deferStaging() bool {
+	return len(p.restoreOpts.tags) > 0 || p.restoreOpts.preflight || p.restoreOpts.conflictPolicy != conflictPolicyAll
+}
+
+func (p *ProxmoxExporter) targetVMID(pending pendingRestore) int {
+	if p.restoreOpts.newID != 0 {
+		return p.restoreOpts.newID
+	}
+	if p.targetIDs != nil {
+		return p.targetIDs.target(pending.vmid)
+	}
+	return pending.vmid
+}
+
+// dropUnallocated fails the dumps of restore_target_vmid=auto whose guest
+// got no target VMID, so they are not grouped together as targeting VMID 0
+// by resolveConflicts. Their staged copy is removed when cleanup is enabled.
+func (p *ProxmoxExporter) dropUnallocated(ctx context.Context, pendingRestores []pendingRestore, results chan<- *connectors.Result, summary *proxmox.RunSummary) []pendingRestore {
+	kept := pendingRestores[:0]
+	for _, pending := range pendingRestores {
+		err := p.targetIDs.failure(pending.vmid)
+		if err == nil {
+			kept = append(kept, pending)
+			continue
+		}
+		p.logger.Warn("restore failed", "vmid", pending.vmid, "archive", pending.dumpBase, "error", err)
+		if p.cfg.Cleanup {
+			if removeErr := p.removeStaged(ctx, pending); removeErr != nil {
+				p.logger.Warn("unable to remove staged dump", "path", pending.dumpPath, "error", removeErr)
 			}
 		}
+		summary.Add(failedGuestSummary(pending, err))
+		results <- resultFromRecord(pending.record, err)
+	}
+	return kept
+}
 
-		if err == nil && p.cfg.Cleanup {
-			if removeErr := p.client.Remove(ctx, pending.dumpPath); removeErr != nil {
+// conflictDrops applies the configured policy to dumps sharing the same
+// target VMID and returns the index of every dump it drops, with a nil error
+// for a superseded dump. Under the "all" policy each group is reordered in
+// place, oldest first. failed counts, per target VMID, the archives already
+// refused by restore_conflict=fail, so that archives resolved later in the
+// run are refused as well.
+func (p *ProxmoxExporter) conflictDrops(pendingRestores []pendingRestore, failed map[int]int) map[int]error {
+	groups := make(map[int][]int)
+	for i, pending := range pendingRestores {
+		target := p.targetVMID(pending)
+		groups[target] = append(groups[target], i)
+	}
+
+	dropped := make(map[int]error)
+	for target, indexes := range groups {
+		if len(indexes) < 2 && failed[target] == 0 {
+			continue
+		}
+
+		members := make([]pendingRestore, len(indexes))
+		for i, idx := range indexes {
+			members[i] = pendingRestores[idx]
+		}
+		sort.SliceStable(members, func(i, j int) bool {
+			return members[i].createdAt.Before(members[j].createdAt)
+		})
+
+		switch p.restoreOpts.conflictPolicy {
+		case conflictPolicyLatest:
+			for _, idx := range indexes {
+				if pendingRestores[idx].dumpPath != members[len(members)-1].dumpPath {
+					dropped[idx] = nil
+				}
+			}
+		case conflictPolicyFail:
+			failed[target] += len(indexes)
+			for _, idx := range indexes {
+				dropped[idx] = fmt.Errorf("refusing restore: %d archives target vmid %d (restore_conflict=fail)", failed[target], target)
+			}
+		default:
+			// Restore every archive, oldest first, so the newest one wins.
+			for i, idx := range indexes {
+				pendingRestores[idx] = members[i]
+			}
+		}
+	}
+	return dropped
+}
+
+// resolveConflicts drops the dumps refused by restore_conflict and answers
+// them on results directly. Dumps not staged yet are only closed; the staged
+// copy of the others is removed when cleanup is enabled.
+func (p *ProxmoxExporter) resolveConflicts(ctx context.Context, pendingRestores []pendingRestore, staged bool, failed map[int]int, results chan<- *connectors.Result, summary *proxmox.RunSummary) []pendingRestore {
+	dropped := p.conflictDrops(pendingRestores, failed)
+	if len(dropped) == 0 {
+		return pendingRestores
+	}
+
+	kept := make([]pendingRestore, 0, len(pendingRestores)-len(dropped))
+	for i, pending := range pendingRestores {
+		err, isDropped := dropped[i]
+		if !isDropped {
+			kept = append(kept, pending)
+			continue
+		}
+		p.logger.Warn("restore dropped by restore_conflict", "vmid", pending.vmid, "archive", pending.dumpBase, "policy", p.restoreOpts.conflictPolicy)
+		if !staged {
+			_ = closeRecord(pending.record)
+		} else if p.cfg.Cleanup {
+			if removeErr := p.removeStaged(ctx, pending); removeErr != nil && err == nil {
 				err = removeErr
 			}
 		}
+		guest := proxmox.GuestSummary{
+			VMID:    p.targetVMID(pending),
+			Type:    pending.vmType,
+			Archive: pending.dumpBase,
+			Status:  proxmox.GuestStatusSkipped,
+			Error:   fmt.Sprintf("superseded by a newer archive (restore_conflict=%s)", p.restoreOpts.conflictPolicy),
+		}
+		if err != nil {
+			guest.Status = proxmox.GuestStatusFailed
+			guest.Error = err.Error()
+		}
+		summary.Add(guest)
+		select {
+		case results <- resultFromRecord(pending.record, err):
+		case <-ctx.Done():
+		}
+	}
+	return kept
+}
+
+// sortRestores orders dumps so that guests listed in restore_order come
+// first, then guests carrying a Proxmox "startup: order=" setting (lowest
+// first), then everything else in snapshot order. Since each guest is started
+// right after its own restore, this also drives the start sequence.
+func (p *ProxmoxExporter) sortRestores(pendingRestores []pendingRestore, sidecars map[string]vmConfigSidecar) {
+	explicit := make(map[int]int, len(p.restoreOpts.restoreOrder))
+	for i, vmid := range p.restoreOpts.restoreOrder {
+		if _, ok := explicit[vmid]; !ok {
+			explicit[vmid] = i
+		}
+	}
 
-		results <- resultFromRecord(pending.record, err)
+	type orderKey struct {
+		rank  int
+		order int
+	}
+	keys := make(map[string]orderKey, len(pendingRestores))
+	for _, pending := range pendingRestores {
+		key := orderKey{rank: 2}
+		if idx, ok := explicit[pending.vmid]; ok {
+			key = orderKey{rank: 0, order: idx}
+		} else if sidecar, ok := sidecars[pending.dumpBase]; ok {
+			if order, ok := parseStartupOrderFromConfig(sidecar.data); ok {
+				key = orderKey{rank: 1, order: order}
+			}
+		}
+		keys[pending.dumpPath] = key
 	}
 
-	return nil
+	sort.SliceStable(pendingRestores, func(i, j int) bool {
+		a, b := keys[pendingRestores[i].dumpPath], keys[pendingRestores[j].dumpPath]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		return a.order < b.order
+	})
 }
 
 func (p *ProxmoxExporter) Close(ctx context.Context) error {
 	return p.client.Close()
 }
 
-func (p *ProxmoxExporter) writeDump(ctx context.Context, dumpPath string, reader io.Reader) error {
+// allocateDumpPath picks a dump path not yet used in this run. Several
+// archives of the same VMID may be staged within the same second; bump the
+// timestamp so they never share a dump path.
+func (p *ProxmoxExporter) allocateDumpPath(record *connectors.Record, base, vmType string, vmid int, stagedAt time.Time, stagedPaths map[string]bool) string {
+	// The guest directory of the snapshot is /backup/<type>/<vmid>_<name>.
+	_, vmName, _ := strings.Cut(path.Base(path.Dir(record.Pathname)), "_")
+	name := proxmox.DumpName{Type: vmType, VMID: vmid, Name: vmName, Node: p.dumpNode}
+
+	dumpPath := path.Join(p.stagingDir, proxmox.BuildRestoreDumpFilename(p.cfg, base, name, stagedAt))
+	for stagedPaths[dumpPath] {
+		stagedAt = stagedAt.Add(time.Second)
+		dumpPath = path.Join(p.stagingDir, proxmox.BuildRestoreDumpFilename(p.cfg, base, name, stagedAt))
+	}
+	stagedPaths[dumpPath] = true
+	return dumpPath
+}
+
+// resolveStagingDir sets the directory dumps are staged in. With
+// staging_storage, dumps go to the backup directory of that storage, so a
+// shared storage lets a restore run from any node reuse them.
+func (p *ProxmoxExporter) resolveStagingDir(ctx context.Context) error {
+	p.stagingDir = p.cfg.DumpDir
+	id := p.restoreOpts.stagingStorage
+	if id == "" {
+		return nil
+	}
+	storage, err := p.client.Storage(ctx, id)
+	if err != nil {
+		return fmt.Errorf("invalid staging_storage: %w", err)
+	}
+	dir, err := storage.BackupDir()
+	if err != nil {
+		return fmt.Errorf("invalid staging_storage: %w", err)
+	}
+	if !storage.IsShared() {
+		p.logger.Warn("staging_storage is not shared, staged dumps are only visible from this node", "storage", id)
+	}
+	p.stagingDir = dir
+	return nil
+}
+
+func (p *ProxmoxExporter) writeDump(ctx context.Context, dumpPath string, reader io.Reader) (err error) {
+	ctx, span := p.client.Tracer().Start(ctx, "write_dump", "path", dumpPath)
+	defer func() { span.End(err) }()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	writer, err := p.client.Create(ctx, dumpPath)
 	if err != nil {
 		return err
 	}
+	writer = proxmox.NewStallWriter(writer, "dump write", p.cfg.StallTimeout, cancel)
+	writer = proxmox.NewRateLimitedWriter(ctx, writer, p.bwLimiter)
 
+	// A partial dump must not be left for a later run to restore.
 	if _, err := io.Copy(writer, reader); err != nil {
 		_ = writer.Close()
+		_ = p.client.Remove(context.Background(), dumpPath)
 		return err
 	}
-	return writer.Close()
+	if err := writer.Close(); err != nil {
+		_ = p.client.Remove(context.Background(), dumpPath)
+		return err
+	}
+	return nil
 }
 
 func (p *ProxmoxExporter) collectConfigSidecar(record *connectors.Record, sidecarBase string, sidecars map[string]vmConfigSidecar) error {
@@ -274,26 +1124,35 @@ func (p *ProxmoxExporter) resolvePoolForDump(pending pendingRestore, sidecars ma
 	return strings.TrimSpace(poolName), nil
 }
 
-func (p *ProxmoxExporter) restoreDump(ctx context.Context, dumpPath, vmType string, vmid int, configData []byte, poolName string) error {
-	state, err := p.vmState(ctx, vmType, vmid)
-	if err != nil {
+// lockVM serializes every state-changing operation on a given VMID within
+// this exporter, so two restores can never race on the same guest.
+func (p *ProxmoxExporter) lockVM(vmid int) func() {
+	p.vmLocksMu.Lock()
+	mu, ok := p.vmLocks[vmid]
+	if !ok {
+		mu = &sync.Mutex{}
+		p.vmLocks[vmid] = mu
+	}
+	p.vmLocksMu.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (p *ProxmoxExporter) restoreDump(ctx context.Context, dumpPath, vmType string, vmid int, configData []byte, settings *proxmox.GuestSettings, node, poolName string) (err error) {
+	ctx, span := p.client.Tracer().Start(ctx, "restore_dump", "vmid", vmid, "type", vmType, "dump", dumpPath)
+	defer func() { span.End(err) }()
+
+	if err := p.checkStorageContent(ctx, vmType, configData); err != nil {
 		return err
 	}
 
-	if state.exists && state.running {
-		if !p.restoreOpts.forceVMRestore {
-			return fmt.Errorf("refusing restore for %s %d: VM/CT is running (stop it first or user force_vm_restore)", vmType, vmid)
-		}
-		if err := p.stopVM(ctx, vmType, vmid); err != nil {
-			return err
-		}
-		state, err = p.vmState(ctx, vmType, vmid)
-		if err != nil {
-			return err
-		}
-		if state.running {
-			return fmt.Errorf("refusing restore for %s %d: VM/CT is still running after stop request", vmType, vmid)
-		}
+	unlock := p.lockVM(vmid)
+	defer unlock()
+
+	state, err := p.prepareTarget(ctx, node, vmType, vmid)
+	if err != nil {
+		return err
 	}
 
 	opts, err := p.resolveRestoreOptions(ctx, vmType, state.exists, configData, poolName)
@@ -301,22 +1160,84 @@ func (p *ProxmoxExporter) restoreDump(ctx context.Context, dumpPath, vmType stri
 		return err
 	}
 
-	if err := p.runRestoreDump(ctx, dumpPath, vmType, vmid, opts); err != nil {
+	if node == "" {
+		p.logger.Info("restoring guest", "vmid", vmid, "type", vmType, "dump", dumpPath, "storage", opts.storage, "pool", opts.pool)
+		err = p.runRestoreDump(ctx, dumpPath, vmType, vmid, opts)
+	} else {
+		p.logger.Info("restoring guest", "vmid", vmid, "type", vmType, "dump", dumpPath, "node", node, "storage", opts.storage, "pool", opts.pool)
+		err = p.restoreDumpOn(ctx, node, dumpPath, vmType, vmid, opts)
+	}
+	if err != nil {
+		return err
+	}
+	if err := p.moveDisks(ctx, node, vmType, vmid, opts.diskMoves); err != nil {
+		return err
+	}
+	if err := p.restoreGuestSettings(ctx, node, vmType, vmid, settings); err != nil {
 		return err
 	}
 
-	if p.restoreOpts.startOnRestore {
-		if err := p.startVM(ctx, vmType, vmid); err != nil {
-			return err
-		}
+	if err := p.applySetOptions(ctx, node, vmType, vmid, opts.setOptions); err != nil {
+		return err
+	}
+	if err := p.placeGuest(ctx, node, vmType, vmid); err != nil {
+		return err
 	}
 
+	p.logger.Info("guest restored", "vmid", vmid, "type", vmType)
 	return nil
 }
 
+// prepareTarget returns the state of the restore target, stopping it first
+// as restore_stop says: with auto when it runs and force_vm_restore allows
+// it, with always whenever it exists, and never with never. The target
+// lives on node, or on the node commands run on when node is empty.
+func (p *ProxmoxExporter) prepareTarget(ctx context.Context, node, vmType string, vmid int) (vmRuntimeState, error) {
+	state, err := p.vmStateOn(ctx, node, vmType, vmid)
+	if err != nil {
+		return state, err
+	}
+	if !state.exists {
+		return state, nil
+	}
+
+	switch p.restoreOpts.stopPolicy {
+	case restoreStopNever:
+		if state.running {
+			return state, fmt.Errorf("refusing restore for %s %d: VM/CT is running and restore_stop=never", vmType, vmid)
+		}
+		return state, nil
+	case restoreStopAuto:
+		if !state.running {
+			return state, nil
+		}
+		if !p.restoreOpts.forceVMRestore {
+			return state, fmt.Errorf("refusing restore for %s %d: VM/CT is running (stop it first or user force_vm_restore)", vmType, vmid)
+		}
+	}
+	if err := p.stopGuest(ctx, node, vmType, vmid); err != nil {
+		return state, err
+	}
+	state, err = p.vmStateOn(ctx, node, vmType, vmid)
+	if err != nil {
+		return state, err
+	}
+	if state.running {
+		return state, fmt.Errorf("refusing restore for %s %d: VM/CT is still running after stop request", vmType, vmid)
+	}
+	return state, nil
+}
+
 func (p *ProxmoxExporter) resolveRestoreOptions(ctx context.Context, vmType string, targetExists bool, configData []byte, poolName string) (restoreOptions, error) {
 	opts := p.restoreOpts
 
+	if opts.storageMap != nil {
+		if len(configData) == 0 {
+			return restoreOptions{}, fmt.Errorf("storage_map needs the config sidecar of the archive to map its disks")
+		}
+		opts.storage, opts.diskMoves = planStorageMap(vmType, configData, opts.storageMap)
+	}
+
 	if !targetExists {
 		if opts.storage == "" {
 			opts.storage = parseStorageFromConfig(vmType, configData)
@@ -365,12 +1286,42 @@ func (p *ProxmoxExporter) runRestoreDump(ctx context.Context, dumpPath, vmType s
 	if opts.pool != "" {
 		args = append(args, "--pool", opts.pool)
 	}
+	args = append(args, p.restoreOpts.extraArgs[vmType]...)
 
 	_, stderr, err := p.client.Run(ctx, cmd, args...)
 	if err != nil {
-		return fmt.Errorf("restore failed: %w: %s", err, strings.TrimSpace(stderr))
+		return proxmox.NewCommandError("restore failed", err, stderr)
+	}
+
+	return nil
+}
+
+func (p *ProxmoxExporter) applySetOptions(ctx context.Context, node, vmType string, vmid int, setOptions []setOption) error {
+	if len(setOptions) == 0 {
+		return nil
+	}
+	if node != "" {
+		options := make([][2]string, 0, len(setOptions))
+		for _, opt := range setOptions {
+			options = append(options, [2]string{opt.key, opt.value})
+		}
+		return p.client.SetGuestConfigOn(ctx, node, vmType, vmid, options)
+	}
+
+	cmd, err := vmCommand(vmType)
+	if err != nil {
+		return err
 	}
 
+	args := []string{"set", strconv.Itoa(vmid)}
+	for _, opt := range setOptions {
+		args = append(args, "--"+opt.key, opt.value)
+	}
+
+	stdout, stderr, err := p.client.Run(ctx, cmd, args...)
+	if err != nil {
+		return proxmox.NewCommandError(fmt.Sprintf("set failed for %s %d", vmType, vmid), err, preferredOutput(stdout, stderr))
+	}
 	return nil
 }
 
@@ -387,7 +1338,7 @@ func (p *ProxmoxExporter) vmState(ctx context.Context, vmType string, vmid int)
 		if isMissingVMError(output) {
 			return vmRuntimeState{exists: false, running: false}, nil
 		}
-		return vmRuntimeState{}, fmt.Errorf("status failed for %s %d: %w: %s", vmType, vmid, err, output)
+		return vmRuntimeState{}, proxmox.NewCommandError(fmt.Sprintf("status failed for %s %d", vmType, vmid), err, output)
 	}
 
 	status := parseStatusValue(stdout + "\n" + stderr)
@@ -414,7 +1365,12 @@ func (p *ProxmoxExporter) startVM(ctx context.Context, vmType string, vmid int)
 		if isIgnorableStartError(output) {
 			return nil
 		}
-		return fmt.Errorf("start failed for %s %d: %w: %s", vmType, vmid, err, output)
+		// Messages may still be worded differently across versions; trust
+		// the guest state over the error text.
+		if state, stateErr := p.vmState(ctx, vmType, vmid); stateErr == nil && state.running {
+			return nil
+		}
+		return proxmox.NewCommandError(fmt.Sprintf("start failed for %s %d", vmType, vmid), err, output)
 	}
 
 	return nil
@@ -433,7 +1389,10 @@ func (p *ProxmoxExporter) stopVM(ctx context.Context, vmType string, vmid int) e
 		if isIgnorableStopError(output) {
 			return nil
 		}
-		return fmt.Errorf("stop failed for %s %d: %w: %s", vmType, vmid, err, output)
+		if state, stateErr := p.vmState(ctx, vmType, vmid); stateErr == nil && !state.running {
+			return nil
+		}
+		return proxmox.NewCommandError(fmt.Sprintf("stop failed for %s %d", vmType, vmid), err, output)
 	}
 
 	return p.waitUntilVMStopped(ctx, vmType, vmid)
@@ -510,8 +1469,183 @@ func parseRestoreOptions(config map[string]string) (restoreOptions, error) {
 	}
 	opts.forceVMRestore = forceVMRestore
 
+	opts.stopPolicy = strings.ToLower(strings.TrimSpace(config["restore_stop"]))
+	switch opts.stopPolicy {
+	case "":
+		opts.stopPolicy = restoreStopAuto
+	case restoreStopAuto, restoreStopAlways, restoreStopNever:
+	default:
+		return restoreOptions{}, fmt.Errorf("invalid restore_stop value: %s", opts.stopPolicy)
+	}
+
 	opts.storage = strings.TrimSpace(config["storage"])
+	storageMap, err := parseStorageMap(config["storage_map"])
+	if err != nil {
+		return restoreOptions{}, fmt.Errorf("invalid storage_map value: %w", err)
+	}
+	if storageMap != nil && opts.storage != "" {
+		return restoreOptions{}, fmt.Errorf("storage and storage_map cannot be used together")
+	}
+	opts.storageMap = storageMap
+	if opts.nodeMap, err = parseNameMap(config["node_map"], "node"); err != nil {
+		return restoreOptions{}, fmt.Errorf("invalid node_map value: %w", err)
+	}
 	opts.pool = strings.TrimSpace(config["pool"])
+	opts.stagingStorage = strings.TrimSpace(config["staging_storage"])
+
+	setOptions, err := parseSetOptions(config["restore_set"])
+	if err != nil {
+		return restoreOptions{}, err
+	}
+	opts.setOptions = setOptions
+
+	opts.extraArgs = make(map[string][]string)
+	for _, vmType := range []string{"qemu", "lxc"} {
+		option := "restore_extra_args_" + vmType
+		extraArgs, err := proxmox.ParseExtraArgs(option, config[option], restoreManagedArgs)
+		if err != nil {
+			return restoreOptions{}, err
+		}
+		opts.extraArgs[vmType] = extraArgs
+	}
+
+	opts.conflictPolicy = strings.ToLower(strings.TrimSpace(config["restore_conflict"]))
+	switch opts.conflictPolicy {
+	case "":
+		opts.conflictPolicy = conflictPolicyAll
+	case conflictPolicyAll, conflictPolicyLatest, conflictPolicyFail:
+	default:
+		return restoreOptions{}, fmt.Errorf("invalid restore_conflict value: %s", opts.conflictPolicy)
+	}
+
+	opts.concurrency = 1
+	if raw := strings.TrimSpace(config["restore_concurrency"]); raw != "" {
+		concurrency, err := strconv.Atoi(raw)
+		if err != nil || concurrency <= 0 {
+			return restoreOptions{}, fmt.Errorf("invalid restore_concurrency value: %s", raw)
+		}
+		opts.concurrency = concurrency
+	}
+	if raw := strings.TrimSpace(config["restore_node_concurrency"]); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 0 {
+			return restoreOptions{}, fmt.Errorf("invalid restore_node_concurrency value: %s", raw)
+		}
+		opts.perNodeLimit = limit
+	}
+
+	opts.checkpointFile = strings.TrimSpace(config["restore_checkpoint_file"])
+	opts.checkpointAge = defaultRestoreCheckpointWindow
+	if raw := strings.TrimSpace(config["restore_checkpoint_window"]); raw != "" {
+		hours, err := strconv.Atoi(raw)
+		if err != nil || hours <= 0 {
+			return restoreOptions{}, fmt.Errorf("invalid restore_checkpoint_window value: %s", raw)
+		}
+		opts.checkpointAge = time.Duration(hours) * time.Hour
+	}
+
+	opts.summaryFile = strings.TrimSpace(config["restore_summary_file"])
+	opts.reportPath = strings.TrimSpace(config["restore_report"])
+	opts.reportFormat = strings.ToLower(strings.TrimSpace(config["restore_report_format"]))
+	switch opts.reportFormat {
+	case "":
+		opts.reportFormat = proxmox.ReportFormatJSON
+	case proxmox.ReportFormatJSON, proxmox.ReportFormatHTML:
+	default:
+		return restoreOptions{}, fmt.Errorf("invalid restore_report_format value: %s", opts.reportFormat)
+	}
+
+	if raw := strings.TrimSpace(config["restore_net_bwlimit"]); raw != "" {
+		limit, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || limit < 0 {
+			return restoreOptions{}, fmt.Errorf("invalid restore_net_bwlimit value: %s", raw)
+		}
+		opts.netBWLimit = limit
+	}
+
+	opts.mode = strings.ToLower(strings.TrimSpace(config["restore_mode"]))
+	switch opts.mode {
+	case "":
+		opts.mode = restoreModeRestore
+	case restoreModeRestore, restoreModeValidate:
+	case restoreModePBS:
+		opts.pbsStorage = strings.TrimSpace(config["pbs_storage"])
+		if opts.pbsStorage == "" {
+			return restoreOptions{}, fmt.Errorf("restore_mode=pbs requires pbs_storage")
+		}
+	default:
+		return restoreOptions{}, fmt.Errorf("invalid restore_mode value: %s", opts.mode)
+	}
+
+	strict, err := parseBoolOption(config["strict"])
+	if err != nil {
+		return restoreOptions{}, err
+	}
+	opts.strict = strict
+
+	confirmDrift, err := parseBoolOption(config["restore_confirm_drift"])
+	if err != nil {
+		return restoreOptions{}, err
+	}
+	opts.confirmDrift = confirmDrift
+
+	if raw, ok := config["restore_preflight"]; ok {
+		preflight, err := parseBoolOption(raw)
+		if err != nil {
+			return restoreOptions{}, err
+		}
+		opts.preflight = preflight
+	}
+	// Nothing is written to the guest storages checked by the preflight
+	// when archives go to PBS.
+	if opts.mode == restoreModePBS {
+		opts.preflight = false
+	}
+
+	opts.reuseDump = true
+	if raw, ok := config["restore_reuse_dump"]; ok {
+		reuseDump, err := parseBoolOption(raw)
+		if err != nil {
+			return restoreOptions{}, err
+		}
+		opts.reuseDump = reuseDump
+	}
+
+	verifyUpload, err := parseBoolOption(config["restore_verify_upload"])
+	if err != nil {
+		return restoreOptions{}, err
+	}
+	opts.verifyUpload = verifyUpload
+
+	switch upload := strings.ToLower(strings.TrimSpace(config["restore_upload"])); upload {
+	case "", restoreUploadSSH:
+	case restoreUploadAPI:
+		if opts.stagingStorage == "" {
+			return restoreOptions{}, fmt.Errorf("restore_upload=api requires staging_storage")
+		}
+		opts.uploadAPI = true
+	default:
+		return restoreOptions{}, fmt.Errorf("invalid restore_upload value: %s", upload)
+	}
+
+	restoreOrder, err := parseVMIDList(config["restore_order"])
+	if err != nil {
+		return restoreOptions{}, fmt.Errorf("invalid restore_order value: %w", err)
+	}
+	opts.restoreOrder = restoreOrder
+
+	vmids, err := parseVMIDRanges(config["vmid"])
+	if err != nil {
+		return restoreOptions{}, fmt.Errorf("invalid vmid value: %w", err)
+	}
+	opts.vmids = vmids
+
+	names, err := parseNamePatterns(config["restore_name"])
+	if err != nil {
+		return restoreOptions{}, fmt.Errorf("invalid restore_name value: %w", err)
+	}
+	opts.names = names
+	opts.tags = parseTagList(config["restore_tags"])
 
 	newIDRaw, hasNewID := config["newid"]
 	if hasNewID {
@@ -528,9 +1662,120 @@ func parseRestoreOptions(config map[string]string) (restoreOptions, error) {
 		}
 	}
 
+	switch raw := strings.TrimSpace(config["restore_target_vmid"]); raw {
+	case "":
+	case "auto":
+		if opts.newID != 0 {
+			return restoreOptions{}, fmt.Errorf("restore_target_vmid=auto conflicts with newid")
+		}
+		opts.autoVMID = true
+	default:
+		return restoreOptions{}, fmt.Errorf("invalid restore_target_vmid value: %s", raw)
+	}
+
 	return opts, nil
 }
 
+func parseVMIDList(value string) ([]int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	var vmids []int
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		vmid, err := strconv.Atoi(item)
+		if err != nil || vmid <= 0 {
+			return nil, fmt.Errorf("invalid vmid: %s", item)
+		}
+		vmids = append(vmids, vmid)
+	}
+	return vmids, nil
+}
+
+// parseVMIDRanges parses a "100,101,200-205" selection. It returns nil when
+// value is empty, meaning every VMID is selected.
+func parseVMIDRanges(value string) (map[int]bool, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	vmids := make(map[int]bool)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		low, high, isRange := strings.Cut(item, "-")
+		if !isRange {
+			high = low
+		}
+		first, err := strconv.Atoi(strings.TrimSpace(low))
+		if err != nil || first <= 0 {
+			return nil, fmt.Errorf("invalid vmid: %s", item)
+		}
+		last, err := strconv.Atoi(strings.TrimSpace(high))
+		if err != nil || last < first {
+			return nil, fmt.Errorf("invalid vmid range: %s", item)
+		}
+		if last-first >= maxVMIDRange {
+			return nil, fmt.Errorf("vmid range too large: %s", item)
+		}
+		for vmid := first; vmid <= last; vmid++ {
+			vmids[vmid] = true
+		}
+	}
+	if len(vmids) == 0 {
+		return nil, fmt.Errorf("empty vmid selection")
+	}
+	return vmids, nil
+}
+
+// maxVMIDRange bounds a single range so a typo cannot allocate millions of
+// entries; Proxmox VMIDs go up to 999999999 but real clusters use far less.
+const maxVMIDRange = 100000
+
+var setOptionKeyRegex = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// parseSetOptions parses a "key=value;key=value" list of qm/pct set options.
+// Entries are separated by semicolons so that values such as net0 can keep
+// their own comma-separated properties.
+func parseSetOptions(value string) ([]setOption, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	var setOptions []setOption
+	seen := make(map[string]struct{})
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		val = strings.TrimSpace(val)
+		if !ok || val == "" {
+			return nil, fmt.Errorf("invalid restore_set entry: %s", entry)
+		}
+		if !setOptionKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("invalid restore_set option name: %s", key)
+		}
+		if _, dup := seen[key]; dup {
+			return nil, fmt.Errorf("duplicate restore_set option: %s", key)
+		}
+		seen[key] = struct{}{}
+		setOptions = append(setOptions, setOption{key: key, value: val})
+	}
+	return setOptions, nil
+}
+
 func isMissingVMError(output string) bool {
 	if output == "" {
 		return false
@@ -598,6 +1843,33 @@ func parseStorageFromConfig(vmType string, configData []byte) string {
 	return ""
 }
 
+func parseStartupOrderFromConfig(configData []byte) (int, bool) {
+	for _, line := range strings.Split(string(configData), "\n") {
+		line = strings.TrimSpace(line)
+		// Only the current config matters, snapshot sections come after it.
+		if strings.HasPrefix(line, "[") {
+			break
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(strings.ToLower(key)) != "startup" {
+			continue
+		}
+		for _, prop := range strings.Split(value, ",") {
+			name, raw, ok := strings.Cut(strings.TrimSpace(prop), "=")
+			if !ok || name != "order" {
+				continue
+			}
+			order, err := strconv.Atoi(strings.TrimSpace(raw))
+			if err != nil {
+				return 0, false
+			}
+			return order, true
+		}
+	}
+	return 0, false
+}
+
 func parseStorageFromVolumeSpec(spec string) string {
 	spec = strings.TrimSpace(spec)
 	if spec == "" {
@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/gillesdubois/plakar-integration-proxmox/exporter"
+	"github.com/gillesdubois/plakar-integration-proxmox/proxmoxtest"
+)
+
+const (
+	testDump   = "/backup/qemu/100_web/vzdump-qemu-100-2026_01_02-03_04_05.vma"
+	testConfig = testDump + "_qemu.conf"
+)
+
+func fileRecord(pathname string, data []byte) *connectors.Record {
+	info := objects.FileInfo{Lname: path.Base(pathname), Lsize: int64(len(data)), Lmode: 0o644}
+	return connectors.NewRecord(pathname, "", info, nil, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+}
+
+// runExport feeds records to an exporter restoring onto node and returns
+// the error of each record, by path.
+func runExport(t *testing.T, node *proxmoxtest.Node, config map[string]string, records ...*connectors.Record) (map[string]error, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	config["location"] = "proxmox://pve1"
+	config["mode"] = "local"
+	exp, err := exporter.NewProxmoxExporterWithRunner(ctx, nil, "proxmox", config, node)
+	if err != nil {
+		t.Fatalf("NewProxmoxExporterWithRunner: %v", err)
+	}
+	defer exp.Close(ctx)
+
+	in := make(chan *connectors.Record, len(records))
+	for _, record := range records {
+		in <- record
+	}
+	close(in)
+	out := make(chan *connectors.Result, len(records))
+	done := make(chan map[string]error)
+	go func() {
+		errs := make(map[string]error)
+		for result := range out {
+			errs[result.Record.Pathname] = result.Err
+		}
+		done <- errs
+	}()
+	err = exp.Export(ctx, in, out)
+	return <-done, err
+}
+
+func TestExportPairsSidecars(t *testing.T) {
+	// The sidecar gives the restore storage of a new guest.
+	config := []byte("name: web\nscsi0: local-lvm:vm-100-disk-0,size=1G\n")
+	tests := []struct {
+		name    string
+		records func() []*connectors.Record
+	}{
+		{"sidecar after dump", func() []*connectors.Record {
+			return []*connectors.Record{fileRecord(testDump, []byte("vma-100")), fileRecord(testConfig, config)}
+		}},
+		{"sidecar before dump", func() []*connectors.Record {
+			return []*connectors.Record{fileRecord(testConfig, config), fileRecord(testDump, []byte("vma-100"))}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := proxmoxtest.NewNode("pve1")
+			errs, err := runExport(t, node, map[string]string{}, tt.records()...)
+			if err != nil {
+				t.Fatalf("Export: %v", err)
+			}
+			for pathname, err := range errs {
+				if err != nil {
+					t.Errorf("%s: %v", pathname, err)
+				}
+			}
+			restores := node.Restores()
+			if len(restores) != 1 {
+				t.Fatalf("restores = %d, want 1", len(restores))
+			}
+			if restores[0].VMID != 100 || restores[0].Type != "qemu" || string(restores[0].Data) != "vma-100" {
+				t.Errorf("restore = %+v, want qemu 100 from the dump", restores[0])
+			}
+			if !slices.Contains(restores[0].Args, "local-lvm") {
+				t.Errorf("restore args = %v, want the storage of the config sidecar", restores[0].Args)
+			}
+		})
+	}
+}
+
+func TestExportRestoreTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		vmid   int
+	}{
+		{"source vmid", map[string]string{}, 100},
+		{"newid", map[string]string{"newid": "200"}, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := proxmoxtest.NewNode("pve1")
+			if _, err := runExport(t, node, tt.config, fileRecord(testDump, []byte("vma-100"))); err != nil {
+				t.Fatalf("Export: %v", err)
+			}
+			if _, ok := node.Guest(tt.vmid); !ok {
+				t.Errorf("guest %d not restored", tt.vmid)
+			}
+		})
+	}
+}
+
+func TestExportCleanup(t *testing.T) {
+	tests := []struct {
+		name    string
+		cleanup string
+		kept    bool
+	}{
+		{"cleanup", "true", false},
+		{"no cleanup", "false", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := proxmoxtest.NewNode("pve1")
+			if _, err := runExport(t, node, map[string]string{"cleanup": tt.cleanup}, fileRecord(testDump, []byte("vma-100"))); err != nil {
+				t.Fatalf("Export: %v", err)
+			}
+			var staged []string
+			for _, name := range node.Files() {
+				if strings.HasPrefix(name, node.DumpDir+"/vzdump-") {
+					staged = append(staged, name)
+				}
+			}
+			if kept := len(staged) > 0; kept != tt.kept {
+				t.Errorf("staged dumps left on the node = %v, want kept=%v", staged, tt.kept)
+			}
+		})
+	}
+}
+
+func TestExportFailures(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*proxmoxtest.Node)
+		want  string
+	}{
+		{
+			name: "qmrestore fails",
+			setup: func(n *proxmoxtest.Node) {
+				n.Failures = map[string]string{"qmrestore": "restore failed - storage full"}
+			},
+			want: "storage full",
+		},
+		{
+			name: "running target",
+			setup: func(n *proxmoxtest.Node) {
+				n.AddGuest(proxmoxtest.Guest{VMID: 100, Type: "qemu", Name: "web", Running: true, Config: "name: web\n"})
+			},
+			want: "running",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := proxmoxtest.NewNode("pve1")
+			tt.setup(node)
+			errs, _ := runExport(t, node, map[string]string{}, fileRecord(testDump, []byte("vma-100")))
+			if err := errs[testDump]; err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("dump result = %v, want it to mention %q", err, tt.want)
+			}
+			if restores := node.Restores(); len(restores) != 0 {
+				t.Errorf("restores = %+v, want none", restores)
+			}
+		})
+	}
+}
+
+func TestExportRecordReadFails(t *testing.T) {
+	node := proxmoxtest.NewNode("pve1")
+	info := objects.FileInfo{Lname: path.Base(testDump), Lsize: 7, Lmode: 0o644}
+	record := connectors.NewRecord(testDump, "", info, nil, func() (io.ReadCloser, error) {
+		return io.NopCloser(io.MultiReader(strings.NewReader("vma"), iotest.ErrReader(errors.New("snapshot read failed")))), nil
+	})
+
+	errs, _ := runExport(t, node, map[string]string{"cleanup": "true"}, record)
+	if err := errs[testDump]; err == nil || !strings.Contains(err.Error(), "snapshot read failed") {
+		t.Errorf("dump result = %v, want the read error", err)
+	}
+	if restores := node.Restores(); len(restores) != 0 {
+		t.Errorf("restores = %+v, want none", restores)
+	}
+	for _, name := range node.Files() {
+		if strings.HasPrefix(name, node.DumpDir+"/") {
+			t.Errorf("partial dump %s left on the node", name)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// imageSet tracks the disk images of a disk engine backup staged on the
+// node. They are restored together, and the result of the first image
+// record is held until then.
+type imageSet struct {
+	name   proxmox.ImageName
+	record *connectors.Record
+	seq    int
+	images map[string]proxmox.RestoreImage
+	failed error
+}
+
+type imageSets struct {
+	mu    sync.Mutex
+	sets  map[string]*imageSet
+	order []string
+}
+
+func newImageSets() *imageSets {
+	return &imageSets{sets: make(map[string]*imageSet)}
+}
+
+// add registers an image record and reports whether it is the first one of
+// its set.
+func (s *imageSets) add(key string, name proxmox.ImageName, record *connectors.Record, seq int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sets[key]; ok {
+		return false
+	}
+	name.Disk = ""
+	name.Incremental = false
+	s.sets[key] = &imageSet{name: name, record: record, seq: seq, images: make(map[string]proxmox.RestoreImage)}
+	s.order = append(s.order, key)
+	return true
+}
+
+func (s *imageSets) staged(key, disk string, image proxmox.RestoreImage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sets[key].images[disk] = image
+}
+
+func (s *imageSets) markFailed(key string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if set := s.sets[key]; set.failed == nil {
+		set.failed = err
+	}
+}
+
+type deferredImage struct {
+	record *connectors.Record
+	name   proxmox.ImageName
+	key    string
+}
+
+// stageImage writes an image record to the dump directory, or to the
+// import directory of staging_storage with restore_upload=api. Only the first
+// image of a set is answered later, with the restore result. Images this
+// plugin cannot restore are not staged.
+func (p *ProxmoxExporter) stageImage(ctx context.Context, record *connectors.Record, name proxmox.ImageName, key string, primary bool, sets *imageSets, results chan<- *connectors.Result) {
+	imagePath := path.Join(p.stagingDir, path.Base(record.Pathname))
+	err := proxmox.CheckRestoreEngine(name.Engine, name.Type)
+	if err == nil {
+		if p.restoreOpts.uploadAPI {
+			imagePath, err = p.client.UploadImage(ctx, p.restoreOpts.stagingStorage, path.Base(record.Pathname), record.Reader)
+		} else {
+			err = p.stageDump(ctx, imagePath, record)
+		}
+	}
+	if err == nil {
+		err = closeRecord(record)
+	} else {
+		_ = closeRecord(record)
+	}
+	if err != nil {
+		sets.markFailed(key, fmt.Errorf("failed to stage %s: %w", path.Base(record.Pathname), err))
+	} else {
+		sets.staged(key, name.Disk, proxmox.RestoreImage{Path: imagePath, Incremental: name.Incremental})
+	}
+	if !primary {
+		results <- resultFromRecord(record, err)
+	}
+}
+
+// imageRestores returns the pending restores of the staged image sets.
+// Sets with a failed image are answered and removed.
+func (p *ProxmoxExporter) imageRestores(ctx context.Context, sets *imageSets, results chan<- *connectors.Result, summary *proxmox.RunSummary) []pendingRestore {
+	pendingRestores := make([]pendingRestore, 0)
+	for _, key := range sets.order {
+		set := sets.sets[key]
+		createdAt, ok := proxmox.ParseDumpTimestamp(set.name.Archive)
+		if !ok {
+			createdAt = set.record.FileInfo.LmodTime
+		}
+		pending := pendingRestore{
+			seq:       set.seq,
+			record:    set.record,
+			vmType:    set.name.Type,
+			vmid:      set.name.VMID,
+			dumpBase:  set.name.Archive,
+			dumpPath:  path.Join(p.stagingDir, set.name.Archive),
+			createdAt: createdAt,
+			engine:    set.name.Engine,
+			images:    set.images,
+		}
+		if set.failed != nil {
+			summary.Add(failedGuestSummary(pending, set.failed))
+			_ = p.removeStaged(ctx, pending)
+			results <- resultFromRecord(set.record, set.failed)
+			continue
+		}
+		pendingRestores = append(pendingRestores, pending)
+	}
+	return pendingRestores
+}
+
+// removeStaged removes the staged dump, or the staged images, of a pending
+// restore.
+func (p *ProxmoxExporter) removeStaged(ctx context.Context, pending pendingRestore) error {
+	if pending.engine == "" {
+		return p.removeStagedDump(ctx, pending.dumpPath)
+	}
+	var firstErr error
+	for _, image := range pending.images {
+		if err := p.removeStagedDump(ctx, image.Path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// restoreImages restores a disk engine backup: the images are written into
+// the guest volumes by the engine, then the config sidecar becomes the guest
+// config.
+func (p *ProxmoxExporter) restoreImages(ctx context.Context, pending pendingRestore, configData []byte, poolName string) (err error) {
+	vmid := p.targetVMID(pending)
+	ctx, span := p.client.Tracer().Start(ctx, "restore_images", "vmid", vmid, "type", pending.vmType, "engine", pending.engine)
+	defer func() { span.End(err) }()
+
+	if len(configData) == 0 {
+		return fmt.Errorf("no config sidecar for %s: engine=%s archives need it to restore their disks", pending.dumpBase, pending.engine)
+	}
+	for _, storage := range imageStorages(pending.vmType, configData, p.restoreOpts.storage, p.restoreOpts.storageMap) {
+		if err := p.client.CheckImageStorage(ctx, pending.engine, storage); err != nil {
+			return err
+		}
+	}
+	if err := p.checkStorageContent(ctx, pending.vmType, configData); err != nil {
+		return err
+	}
+
+	unlock := p.lockVM(vmid)
+	defer unlock()
+
+	if pending.node != "" {
+		return fmt.Errorf("node_map cannot restore engine=%s archives on node %s: their disks are written from the node commands run on", pending.engine, pending.node)
+	}
+	state, err := p.prepareTarget(ctx, "", pending.vmType, vmid)
+	if err != nil {
+		return err
+	}
+	opts, err := p.resolveRestoreOptions(ctx, pending.vmType, state.exists, configData, poolName)
+	if err != nil {
+		return err
+	}
+
+	p.logger.Info("restoring guest", "vmid", vmid, "type", pending.vmType, "engine", pending.engine, "images", len(pending.images), "storage", p.restoreOpts.storage, "pool", opts.pool)
+	err = p.client.RestoreImages(ctx, proxmox.ImageRestore{
+		Engine:     pending.engine,
+		VMType:     pending.vmType,
+		SourceVMID: pending.vmid,
+		TargetVMID: vmid,
+		Config:     configData,
+		Images:     pending.images,
+		Storage:    p.restoreOpts.storage,
+		StorageMap: p.restoreOpts.storageMap,
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.pool != "" {
+		if err := p.client.AddToPool(ctx, opts.pool, vmid); err != nil {
+			return err
+		}
+	}
+	if err := p.applySetOptions(ctx, "", pending.vmType, vmid, opts.setOptions); err != nil {
+		return err
+	}
+	if err := p.placeGuest(ctx, "", pending.vmType, vmid); err != nil {
+		return err
+	}
+
+	p.logger.Info("guest restored", "vmid", vmid, "type", pending.vmType)
+	return nil
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+func (p *ProxmoxExporter) collectMetadataSidecar(record *connectors.Record, sidecarBase string, sidecars map[string]proxmox.DumpMetadata) error {
+	dumpBase, err := proxmox.ParseDumpMetadataFilename(sidecarBase)
+	if err != nil {
+		return err
+	}
+
+	data, err := readRecordBytes(record)
+	if err != nil {
+		return err
+	}
+	meta, err := proxmox.DecodeDumpMetadata(data)
+	if err != nil {
+		return err
+	}
+	sidecars[dumpBase] = meta
+	return nil
+}
+
+// checkMetadata refuses to restore an archive whose metadata sidecar
+// describes another guest.
+func (p *ProxmoxExporter) checkMetadata(pending pendingRestore, sidecars map[string]proxmox.DumpMetadata) error {
+	meta, ok := sidecars[pending.dumpBase]
+	if !ok {
+		return nil
+	}
+	return proxmox.CheckDumpMetadata(meta, pending.dumpBase)
+}
+
+// warnHypervisorVersions warns when the archive was taken on a node newer
+// than the restore target: machine types or archive formats it relies on may
+// be unknown there. The restore is still attempted.
+func (p *ProxmoxExporter) warnHypervisorVersions(ctx context.Context, pending pendingRestore, sidecars map[string]proxmox.DumpMetadata) {
+	meta, ok := sidecars[pending.dumpBase]
+	if !ok {
+		return
+	}
+	target, err := p.client.HypervisorVersions(ctx)
+	if err != nil {
+		p.logger.Warn("unable to read hypervisor versions", "error", err)
+		return
+	}
+
+	check := func(component, recorded, current string) {
+		if recorded == "" || current == "" || proxmox.CompareVersions(recorded, current) <= 0 {
+			return
+		}
+		p.logger.Warn("archive was created on a newer hypervisor",
+			"vmid", pending.vmid,
+			"archive", pending.dumpBase,
+			"component", component,
+			"archive_version", recorded,
+			"target_version", current,
+		)
+	}
+	check("pve-manager", meta.Hypervisor.PVEManager, target.PVEManager)
+	if pending.vmType == "qemu" {
+		check("pve-qemu-kvm", meta.Hypervisor.QEMU, target.QEMU)
+	} else {
+		check("lxc-pve", meta.Hypervisor.LXC, target.LXC)
+	}
+}
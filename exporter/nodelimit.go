@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"sync"
+)
+
+// nodeLimiter caps the restores running at once on each node, on top of
+// restore_concurrency: qmrestore is IO-heavy and two restores on one node
+// often thrash its storage while other nodes sit idle.
+type nodeLimiter struct {
+	limit int
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// newNodeLimiter returns a limiter of limit restores per node, or nil for
+// no per-node limit.
+func newNodeLimiter(limit int) *nodeLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &nodeLimiter{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+// acquire blocks until node has a free slot and returns the function
+// releasing it. A nil limiter never blocks.
+func (l *nodeLimiter) acquire(ctx context.Context, node string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	slots, ok := l.slots[node]
+	if !ok {
+		slots = make(chan struct{}, l.limit)
+		l.slots[node] = slots
+	}
+	l.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// acquireNodeSlot takes a restore slot on the node restores currently run
+// on, which changes when the run fails over to another endpoint.
+func (p *ProxmoxExporter) acquireNodeSlot(ctx context.Context) (func(), error) {
+	if p.nodeLimiter == nil {
+		return func() {}, nil
+	}
+	node, err := p.client.LocalNode(ctx)
+	if err != nil {
+		p.logger.Warn("unable to resolve the restore node, limiting restores per endpoint", "error", err)
+		node = p.cfg.Host
+	}
+	release, err := p.nodeLimiter.acquire(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	return release, nil
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// checkNodeMap verifies that every node_map destination is a node of the
+// target cluster, so a typo fails the run before anything is restored.
+// Guests are restored on their destination from the staged archives,
+// which must then sit on a shared staging_storage.
+func (p *ProxmoxExporter) checkNodeMap(ctx context.Context) error {
+	if len(p.restoreOpts.nodeMap) == 0 {
+		return nil
+	}
+	nodes, err := p.client.ClusterNodes(ctx)
+	if err != nil {
+		return err
+	}
+	local, err := p.client.LocalNode(ctx)
+	if err != nil {
+		return err
+	}
+	remote := false
+	for src, dst := range p.restoreOpts.nodeMap {
+		if !slices.Contains(nodes, dst) {
+			return fmt.Errorf("node_map maps %s to %s, which is not a node of the cluster", src, dst)
+		}
+		remote = remote || dst != local
+	}
+	if !remote {
+		return nil
+	}
+	if p.restoreOpts.stagingStorage == "" {
+		return fmt.Errorf("node_map restores guests on other nodes, which needs a shared staging_storage")
+	}
+	storage, err := p.client.Storage(ctx, p.restoreOpts.stagingStorage)
+	if err != nil {
+		return err
+	}
+	if !storage.IsShared() {
+		return fmt.Errorf("node_map restores guests on other nodes, which needs a shared staging_storage, and %s is not shared", storage.ID)
+	}
+	return nil
+}
+
+// targetNode returns the node_map node of the node the archive was taken
+// on, according to its metadata sidecar, or an empty string to restore the
+// guest on the node commands run on.
+func (p *ProxmoxExporter) targetNode(ctx context.Context, pending pendingRestore, metaSidecars map[string]proxmox.DumpMetadata) (string, error) {
+	meta, ok := metaSidecars[pending.dumpBase]
+	if !ok || meta.Node == "" {
+		return "", nil
+	}
+	node := p.restoreOpts.nodeMap[meta.Node]
+	if node == "" {
+		return "", nil
+	}
+	local, err := p.client.LocalNode(ctx)
+	if err != nil {
+		return "", err
+	}
+	if node == local {
+		return "", nil
+	}
+	return node, nil
+}
+
+// vmStateOn is vmState for a guest of node, which qm and pct cannot reach.
+// An empty node is the node commands run on.
+func (p *ProxmoxExporter) vmStateOn(ctx context.Context, node, vmType string, vmid int) (vmRuntimeState, error) {
+	if node == "" {
+		return p.vmState(ctx, vmType, vmid)
+	}
+	status, err := p.client.GuestStatusOn(ctx, node, vmType, vmid)
+	if err != nil {
+		if isMissingVMError(err.Error()) {
+			return vmRuntimeState{}, nil
+		}
+		return vmRuntimeState{}, err
+	}
+	switch status {
+	case "running", "paused", "suspended":
+		return vmRuntimeState{exists: true, running: true}, nil
+	case "stopped":
+		return vmRuntimeState{exists: true}, nil
+	default:
+		return vmRuntimeState{}, fmt.Errorf("unable to parse status for %s %d on node %s: %s", vmType, vmid, node, status)
+	}
+}
+
+// stopGuest stops a guest of node, or of the node commands run on when
+// node is empty.
+func (p *ProxmoxExporter) stopGuest(ctx context.Context, node, vmType string, vmid int) error {
+	if node == "" {
+		return p.stopVM(ctx, vmType, vmid)
+	}
+	return p.client.StopGuestOn(ctx, node, vmType, vmid)
+}
+
+// placeGuest starts a restored guest when start_on_restore is set, on its
+// node_map node if any.
+func (p *ProxmoxExporter) placeGuest(ctx context.Context, node, vmType string, vmid int) error {
+	if !p.restoreOpts.startOnRestore {
+		return nil
+	}
+	if node == "" {
+		return p.startVM(ctx, vmType, vmid)
+	}
+	return p.client.StartGuestOn(ctx, node, vmType, vmid)
+}
+
+// readGuestConfig reads the config of a guest of node, or of the node
+// commands run on when node is empty.
+func (p *ProxmoxExporter) readGuestConfig(ctx context.Context, node, vmType string, vmid int) ([]byte, error) {
+	switch {
+	case node != "":
+		return p.client.ReadGuestConfigOn(ctx, node, vmType, vmid)
+	case vmType == "qemu":
+		return p.client.ReadQEMUConfig(ctx, vmid)
+	default:
+		return p.client.ReadLXCConfig(ctx, vmid)
+	}
+}
+
+// restoreDumpOn restores a staged dump on its node_map node through the
+// API, from the backup volume of staging_storage holding it.
+func (p *ProxmoxExporter) restoreDumpOn(ctx context.Context, node, dumpPath, vmType string, vmid int, opts restoreOptions) error {
+	return p.client.RestoreGuestOn(ctx, proxmox.GuestRestoreOn{
+		Node:    node,
+		Type:    vmType,
+		VMID:    vmid,
+		Archive: p.restoreOpts.stagingStorage + ":backup/" + path.Base(dumpPath),
+		Storage: opts.storage,
+		Pool:    opts.pool,
+		Extra:   p.restoreOpts.extraArgs[vmType],
+	})
+}
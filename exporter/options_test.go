@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseVMIDRanges(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []int
+		count int // checked instead of want for large ranges
+		err   string
+	}{
+		{value: "", want: nil},
+		{value: "  ", want: nil},
+		{value: "100", want: []int{100}},
+		{value: "100,101, 200-202", want: []int{100, 101, 200, 201, 202}},
+		{value: "100-102,101-103", want: []int{100, 101, 102, 103}},
+		{value: "100,100", want: []int{100}},
+		{value: "105-105", want: []int{105}},
+		{value: " 100 - 101 ", want: []int{100, 101}},
+		{value: "100,,101,", want: []int{100, 101}},
+		{value: ",", err: "empty vmid selection"},
+		{value: "0", err: "invalid vmid: 0"},
+		{value: "0-5", err: "invalid vmid: 0-5"},
+		{value: "-5", err: "invalid vmid: -5"},
+		{value: "abc", err: "invalid vmid: abc"},
+		{value: "100,x", err: "invalid vmid: x"},
+		{value: "105-100", err: "invalid vmid range: 105-100"},
+		{value: "100-", err: "invalid vmid range: 100-"},
+		{value: "100-abc", err: "invalid vmid range: 100-abc"},
+		{value: "1-100000", count: maxVMIDRange},
+		{value: "1-100001", err: "vmid range too large: 1-100001"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			vmids, err := parseVMIDRanges(tt.value)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.count != 0 {
+				if len(vmids) != tt.count {
+					t.Errorf("got %d vmids, want %d", len(vmids), tt.count)
+				}
+				return
+			}
+			if tt.want == nil {
+				if vmids != nil {
+					t.Errorf("got %v, want every vmid selected", vmids)
+				}
+				return
+			}
+			got := make([]int, 0, len(vmids))
+			for vmid := range vmids {
+				got = append(got, vmid)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
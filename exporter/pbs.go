@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// resolvePBSStorage looks up pbs_storage once per run, so a typo fails the
+// run before any archive is staged.
+func (p *ProxmoxExporter) resolvePBSStorage(ctx context.Context) error {
+	if p.restoreOpts.mode != restoreModePBS {
+		return nil
+	}
+	storage, err := p.client.Storage(ctx, p.restoreOpts.pbsStorage)
+	if err != nil {
+		return fmt.Errorf("invalid pbs_storage: %w", err)
+	}
+	if _, err := storage.PBSRepository(); err != nil {
+		return fmt.Errorf("invalid pbs_storage: %w", err)
+	}
+	p.pbsStorage = storage
+	return nil
+}
+
+// importToPBS implements restore_mode=pbs: the staged archive becomes a
+// backup of the target VMID in the PBS datastore instead of a guest.
+func (p *ProxmoxExporter) importToPBS(ctx context.Context, pending pendingRestore) (err error) {
+	vmid := p.targetVMID(pending)
+	ctx, span := p.client.Tracer().Start(ctx, "import_pbs", "vmid", vmid, "type", pending.vmType, "dump", pending.dumpPath)
+	defer func() { span.End(err) }()
+
+	p.logger.Info("importing archive into PBS", "vmid", vmid, "type", pending.vmType, "dump", pending.dumpPath, "storage", p.pbsStorage.ID)
+	err = p.client.ImportToPBS(ctx, proxmox.PBSImport{
+		Dump:       pending.dumpPath,
+		Type:       pending.vmType,
+		VMID:       vmid,
+		BackupTime: pending.createdAt,
+		Storage:    p.pbsStorage,
+	})
+	if err != nil {
+		return err
+	}
+	p.logger.Info("archive imported into PBS", "vmid", vmid, "type", pending.vmType)
+	return nil
+}
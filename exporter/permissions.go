@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"slices"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// checkPermissions verifies, with check_permissions=true, that the account
+// holds the privileges restores need: creating guests and allocating space
+// on the storages given in the options. Storages only known from the
+// archives are not checked.
+func (p *ProxmoxExporter) checkPermissions(ctx context.Context) error {
+	if !p.cfg.CheckPermissions {
+		return nil
+	}
+	opts := p.restoreOpts
+
+	node, err := p.client.LocalNode(ctx)
+	if err != nil {
+		return err
+	}
+	required := []proxmox.Permission{{Path: "/nodes/" + node, Privilege: proxmox.PrivSysConsole, Reason: "run commands on the node"}}
+	var storages []string
+	if opts.stagingStorage != "" {
+		storages = append(storages, opts.stagingStorage)
+	}
+	if opts.mode != restoreModePBS {
+		required = append(required, proxmox.Permission{Path: "/vms", Privilege: proxmox.PrivVMAllocate, Reason: "restore guests"})
+		if opts.startOnRestore {
+			required = append(required, proxmox.Permission{Path: "/vms", Privilege: proxmox.PrivVMPowerMgmt, Reason: "start_on_restore"})
+		}
+		if opts.storage != "" {
+			storages = append(storages, opts.storage)
+		}
+		for _, storage := range opts.storageMap {
+			storages = append(storages, storage)
+		}
+	}
+	slices.Sort(storages)
+	for _, storage := range slices.Compact(storages) {
+		required = append(required, proxmox.Permission{Path: "/storage/" + storage, Privilege: proxmox.PrivDatastoreAllocateSpace, Reason: "write to the storage"})
+	}
+
+	ctx, span := p.client.Tracer().Start(ctx, "check_permissions", "checks", len(required))
+	err = p.client.CheckPermissions(ctx, required)
+	span.End(err)
+	return err
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// storageDemand is what the restore of one archive needs from a storage.
+type storageDemand struct {
+	storage string
+	size    int64
+}
+
+// preflight checks, before an archive is staged, that every storage its
+// disks are restored into exists on the node, is active, allows guest
+// disks and has room for the disk sizes recorded in the snapshot. Sizes
+// are not checked for incremental images, whose volumes already exist.
+func (p *ProxmoxExporter) preflight(ctx context.Context, archive, vmType string, vmid int, checkSize bool, sidecars map[string]vmConfigSidecar, metaSidecars map[string]proxmox.DumpMetadata) error {
+	demands, err := p.storageDemands(ctx, archive, vmType, vmid, sidecars, metaSidecars)
+	if err != nil || len(demands) == 0 {
+		return err
+	}
+
+	node, err := p.client.LocalNode(ctx)
+	if err != nil {
+		return err
+	}
+	storages, err := p.client.NodeStorages(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	content := diskContent(vmType)
+	for _, demand := range demands {
+		storage, ok := storages[demand.storage]
+		switch {
+		case !ok:
+			return fmt.Errorf("restore storage %s does not exist on node %s", demand.storage, node)
+		case storage.Enabled == 0 || storage.Active == 0:
+			return fmt.Errorf("restore storage %s is not active on node %s", demand.storage, node)
+		case !storage.AllowsContent(content):
+			return fmt.Errorf("restore storage %s does not allow %s content (allowed: %s)", demand.storage, content, storage.Content)
+		case checkSize && storage.Avail < demand.size:
+			return fmt.Errorf("restore storage %s has %d bytes available, the disks of %s %d need %d", demand.storage, storage.Avail, vmType, vmid, demand.size)
+		}
+	}
+	return nil
+}
+
+// storageDemands returns the storages the disks of archive are restored
+// into and the space they take there, following the same rules as the
+// restore itself. vzdump archives restored with a single --storage put
+// every disk there first, including the ones storage_map moves elsewhere
+// afterwards.
+func (p *ProxmoxExporter) storageDemands(ctx context.Context, archive, vmType string, vmid int, sidecars map[string]vmConfigSidecar, metaSidecars map[string]proxmox.DumpMetadata) ([]storageDemand, error) {
+	configData := sidecars[archive].data
+	var disks []proxmox.DiskLayout
+	if meta, ok := metaSidecars[archive]; ok && len(meta.Disks) > 0 {
+		disks = meta.Disks
+	} else {
+		disks = proxmox.DiskLayouts(vmType, configData)
+	}
+
+	var demands []storageDemand
+	add := func(storage string, size int64) {
+		for i := range demands {
+			if demands[i].storage == storage {
+				demands[i].size += size
+				return
+			}
+		}
+		demands = append(demands, storageDemand{storage: storage, size: size})
+	}
+
+	opts := p.restoreOpts
+	if proxmox.ArchiveEngine(archive) == proxmox.EngineVzdump {
+		storage := opts.storage
+		var moves []diskMove
+		switch {
+		case opts.storageMap != nil && len(configData) > 0:
+			storage, moves = planStorageMap(vmType, configData, opts.storageMap)
+		case storage == "":
+			state, err := p.vmState(ctx, vmType, vmid)
+			if err != nil {
+				return nil, err
+			}
+			if !state.exists {
+				storage = parseStorageFromConfig(vmType, configData)
+			}
+		}
+		if storage != "" {
+			add(storage, 0)
+		}
+		for _, disk := range disks {
+			if !disk.Backed || disk.Storage == "" {
+				continue
+			}
+			if storage != "" {
+				add(storage, disk.Size)
+			} else {
+				add(disk.Storage, disk.Size)
+			}
+			for _, move := range moves {
+				if move.disk == disk.Key {
+					add(move.storage, disk.Size)
+				}
+			}
+		}
+		return demands, nil
+	}
+
+	for _, disk := range disks {
+		if !disk.Backed || disk.Storage == "" {
+			continue
+		}
+		storage := disk.Storage
+		if opts.storage != "" {
+			storage = opts.storage
+		} else if mapped, ok := opts.storageMap[disk.Storage]; ok {
+			storage = mapped
+		}
+		add(storage, disk.Size)
+	}
+	return demands, nil
+}
+
+// preflightSegments drops the segmented dumps failing the preflight checks,
+// or whose guest got no target VMID, removing their staged parts. Parts are staged before the sidecars are
+// known, so unlike plain archives they are uploaded anyway.
+func (p *ProxmoxExporter) preflightSegments(ctx context.Context, segments *segmentedDumps, sidecars map[string]vmConfigSidecar, metaSidecars map[string]proxmox.DumpMetadata, results chan<- *connectors.Result, summary *proxmox.RunSummary) {
+	order := segments.order[:0]
+	for _, key := range segments.order {
+		dump := segments.dumps[key]
+		if dump.manifest == nil {
+			order = append(order, key)
+			continue
+		}
+		vmType, vmid, err := proxmox.ParseDumpFilename(dump.manifest.Archive)
+		if err == nil {
+			err = p.targetIDs.failure(vmid)
+		}
+		if err == nil && p.restoreOpts.preflight {
+			err = p.preflight(ctx, dump.manifest.Archive, vmType, p.targetVMID(pendingRestore{vmid: vmid}), true, sidecars, metaSidecars)
+		}
+		if err == nil {
+			order = append(order, key)
+			continue
+		}
+		p.logger.Warn("restore preflight failed", "archive", dump.manifest.Archive, "error", err)
+		p.removeParts(dump.parts)
+		delete(segments.dumps, key)
+		summary.Add(failedGuestSummary(pendingRestore{vmType: vmType, vmid: vmid, dumpBase: dump.manifest.Archive}, err))
+		results <- resultFromRecord(dump.record, err)
+	}
+	segments.order = order
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"slices"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// restoreOutcome is what a restore reports on the guest besides its
+// status.
+type restoreOutcome struct {
+	drift        []string
+	node         string
+	storages     []string
+	verification string
+}
+
+// verifyRestore checks a restored guest for the restore report: it must
+// exist, run when start_on_restore is set, and have a readable config,
+// whose disks give the storages the guest was restored into. Guests
+// restored on their node_map node are checked there.
+func (p *ProxmoxExporter) verifyRestore(ctx context.Context, pending pendingRestore, outcome *restoreOutcome) {
+	vmid := p.targetVMID(pending)
+	if node, err := p.client.LocalNode(ctx); err == nil {
+		outcome.node = node
+	}
+	if pending.node != "" && pending.node != outcome.node {
+		p.verifyRestoreOn(ctx, pending, outcome)
+		return
+	}
+
+	state, err := p.vmState(ctx, pending.vmType, vmid)
+	switch {
+	case err != nil:
+		outcome.verification = "failed: " + err.Error()
+		return
+	case !state.exists:
+		outcome.verification = "failed: guest does not exist after restore"
+		return
+	case p.restoreOpts.startOnRestore && !state.running:
+		outcome.verification = "failed: guest is not running"
+		return
+	}
+
+	var config []byte
+	if pending.vmType == "qemu" {
+		config, err = p.client.ReadQEMUConfig(ctx, vmid)
+	} else {
+		config, err = p.client.ReadLXCConfig(ctx, vmid)
+	}
+	if err != nil {
+		outcome.verification = "failed: " + err.Error()
+		return
+	}
+	outcome.storages = restoredStorages(pending.vmType, config)
+	outcome.verification = proxmox.GuestStatusOK
+}
+
+// verifyRestoreOn is verifyRestore for a guest restored on another node,
+// which qm and pct cannot reach.
+func (p *ProxmoxExporter) verifyRestoreOn(ctx context.Context, pending pendingRestore, outcome *restoreOutcome) {
+	vmid := p.targetVMID(pending)
+	outcome.node = pending.node
+
+	status, err := p.client.GuestStatusOn(ctx, pending.node, pending.vmType, vmid)
+	switch {
+	case err != nil:
+		outcome.verification = "failed: " + err.Error()
+		return
+	case p.restoreOpts.startOnRestore && status != "running":
+		outcome.verification = "failed: guest is not running"
+		return
+	}
+
+	config, err := p.client.ReadGuestConfigOn(ctx, pending.node, pending.vmType, vmid)
+	if err != nil {
+		outcome.verification = "failed: " + err.Error()
+		return
+	}
+	outcome.storages = restoredStorages(pending.vmType, config)
+	outcome.verification = proxmox.GuestStatusOK
+}
+
+// restoredStorages returns the storages holding the disks of config.
+func restoredStorages(vmType string, config []byte) []string {
+	var storages []string
+	for _, disk := range proxmox.ParseGuestDisks(vmType, config) {
+		if disk.Backed() && !slices.Contains(storages, disk.Storage) {
+			storages = append(storages, disk.Storage)
+		}
+	}
+	return storages
+}
+
+// writeReport writes the restore report of a finished run to the
+// restore_report path, or into dump_dir on the node.
+func (p *ProxmoxExporter) writeReport(summary *proxmox.RunSummary) {
+	reportPath := p.restoreOpts.reportPath
+	if reportPath == "" {
+		return
+	}
+	reportPath, err := p.client.WriteReport(summary, reportPath, p.restoreOpts.reportFormat)
+	if err != nil {
+		p.logger.Warn("unable to write restore report", "path", reportPath, "error", err)
+		return
+	}
+	p.logger.Info("restore report written", "path", reportPath)
+}
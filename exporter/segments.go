@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// segmentedDump tracks the parts of a segmented archive staged on the node
+// and the manifest describing how to reassemble them.
+type segmentedDump struct {
+	manifest *proxmox.PartsManifest
+	record   *connectors.Record
+	seq      int
+	parts    map[int]string
+	failed   bool
+}
+
+type segmentedDumps struct {
+	mu    sync.Mutex
+	dumps map[string]*segmentedDump
+	order []string
+}
+
+func newSegmentedDumps() *segmentedDumps {
+	return &segmentedDumps{dumps: make(map[string]*segmentedDump)}
+}
+
+func (s *segmentedDumps) get(key string) *segmentedDump {
+	dump, ok := s.dumps[key]
+	if !ok {
+		dump = &segmentedDump{parts: make(map[int]string)}
+		s.dumps[key] = dump
+		s.order = append(s.order, key)
+	}
+	return dump
+}
+
+func (s *segmentedDumps) addPart(key string, index int, partPath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(key).parts[index] = partPath
+}
+
+func (s *segmentedDumps) markFailed(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(key).failed = true
+}
+
+func (s *segmentedDumps) setManifest(key string, manifest proxmox.PartsManifest, record *connectors.Record, seq int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dump := s.get(key)
+	dump.manifest = &manifest
+	dump.record = record
+	dump.seq = seq
+}
+
+func segmentKey(pathname, archiveName string) string {
+	return path.Join(path.Dir(pathname), archiveName)
+}
+
+// stagePart writes a single part record to the dump directory. Parts are
+// acknowledged individually; reassembly errors are reported on the manifest.
+func (p *ProxmoxExporter) stagePart(ctx context.Context, record *connectors.Record, key string, index int, segments *segmentedDumps) *connectors.Result {
+	partPath := path.Join(p.stagingDir, path.Base(record.Pathname))
+	if err := p.writeDump(ctx, partPath, record.Reader); err != nil {
+		_ = p.client.Remove(context.Background(), partPath)
+		segments.markFailed(key)
+		return record.Error(err)
+	}
+	if err := closeRecord(record); err != nil {
+		segments.markFailed(key)
+		return resultFromRecord(record, err)
+	}
+	segments.addPart(key, index, partPath)
+	return resultFromRecord(record, nil)
+}
+
+// assembleSegmentedDumps concatenates staged parts into dump files and
+// returns the resulting pending restores. Parts without a manifest are
+// removed.
+func (p *ProxmoxExporter) assembleSegmentedDumps(ctx context.Context, segments *segmentedDumps, stagedPaths map[string]bool, results chan<- *connectors.Result, summary *proxmox.RunSummary) []pendingRestore {
+	pendingRestores := make([]pendingRestore, 0)
+
+	for _, key := range segments.order {
+		dump := segments.dumps[key]
+		if dump.manifest == nil {
+			p.removeParts(dump.parts)
+			continue
+		}
+
+		pending, err := p.assembleSegmentedDump(ctx, dump, stagedPaths)
+		p.removeParts(dump.parts)
+		if err != nil {
+			vmType, vmid, _ := proxmox.ParseDumpFilename(dump.manifest.Archive)
+			summary.Add(failedGuestSummary(pendingRestore{vmType: vmType, vmid: vmid, dumpBase: dump.manifest.Archive}, err))
+			results <- resultFromRecord(dump.record, err)
+			continue
+		}
+		pendingRestores = append(pendingRestores, pending)
+	}
+
+	return pendingRestores
+}
+
+func (p *ProxmoxExporter) assembleSegmentedDump(ctx context.Context, dump *segmentedDump, stagedPaths map[string]bool) (pendingRestore, error) {
+	manifest := dump.manifest
+	if dump.failed {
+		return pendingRestore{}, fmt.Errorf("failed to stage parts of %s", manifest.Archive)
+	}
+
+	vmType, vmid, err := proxmox.ParseDumpFilename(manifest.Archive)
+	if err != nil {
+		return pendingRestore{}, err
+	}
+
+	partPaths := make([]string, 0, len(manifest.Parts))
+	for _, part := range manifest.Parts {
+		partPath, ok := dump.parts[part.Index]
+		if !ok {
+			return pendingRestore{}, fmt.Errorf("missing part %d of %s: %w", part.Index, manifest.Archive, proxmox.ErrArchiveCorrupt)
+		}
+		partPaths = append(partPaths, partPath)
+	}
+
+	p.logger.Debug("reassembling segmented dump", "archive", manifest.Archive, "parts", len(partPaths))
+	dumpPath := p.allocateDumpPath(dump.record, manifest.Archive, vmType, vmid, time.Now(), stagedPaths)
+	args := append([]string{"-c", `cat -- "$@" > "$0"`, dumpPath}, partPaths...)
+	if stdout, stderr, err := p.client.Run(ctx, "sh", args...); err != nil {
+		_ = p.client.Remove(context.Background(), dumpPath)
+		return pendingRestore{}, proxmox.NewCommandError("failed to reassemble "+manifest.Archive, err, preferredOutput(stdout, stderr))
+	}
+
+	createdAt, ok := proxmox.ParseDumpTimestamp(manifest.Archive)
+	if !ok {
+		createdAt = dump.record.FileInfo.LmodTime
+	}
+
+	return pendingRestore{
+		seq:       dump.seq,
+		record:    dump.record,
+		vmType:    vmType,
+		vmid:      vmid,
+		dumpBase:  manifest.Archive,
+		dumpPath:  dumpPath,
+		createdAt: createdAt,
+	}, nil
+}
+
+func (p *ProxmoxExporter) removeParts(parts map[int]string) {
+	indexes := make([]int, 0, len(parts))
+	for index := range parts {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		_ = p.client.Remove(context.Background(), parts[index])
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// parseNamePatterns parses the comma-separated glob list of restore_name.
+func parseNamePatterns(value string) ([]string, error) {
+	var patterns []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if _, err := path.Match(item, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", item, err)
+		}
+		patterns = append(patterns, item)
+	}
+	return patterns, nil
+}
+
+// parseTagList splits a tag list the way Proxmox does: tags may be
+// separated by commas, semicolons or spaces. Tags are compared in lower
+// case.
+func parseTagList(value string) []string {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' '
+	})
+	tags := make([]string, 0, len(fields))
+	for _, field := range fields {
+		tags = append(tags, strings.ToLower(field))
+	}
+	return tags
+}
+
+// selectedArchive reports whether the archive at pathname passes the vmid
+// and restore_name filters and was not restored yet according to
+// restore_checkpoint_file. All of them only need the snapshot path.
+func (p *ProxmoxExporter) selectedArchive(pathname, archiveBase string) bool {
+	_, vmid, err := proxmox.ParseArchiveName(archiveBase)
+	if err != nil {
+		return true
+	}
+	if p.restoreOpts.vmids != nil && !p.restoreOpts.vmids[vmid] {
+		return false
+	}
+	if len(p.restoreOpts.names) > 0 && !matchAny(p.restoreOpts.names, guestNameFromPath(pathname, vmid)) {
+		return false
+	}
+	if p.checkpoint != nil && p.checkpoint.skip(pathname, archiveBase, p.checkpointTarget(pendingRestore{vmid: vmid})) {
+		return false
+	}
+	return true
+}
+
+// selectedByTags reports whether the guest of dumpBase carries one of the
+// restore_tags tags, according to its config sidecar. Guests without a
+// sidecar have no tags.
+func (p *ProxmoxExporter) selectedByTags(dumpBase string, sidecars map[string]vmConfigSidecar) bool {
+	if len(p.restoreOpts.tags) == 0 {
+		return true
+	}
+	sidecar, ok := sidecars[dumpBase]
+	if !ok {
+		return false
+	}
+	for _, tag := range parseTagList(configValue(sidecar.data, "tags")) {
+		for _, wanted := range p.restoreOpts.tags {
+			if tag == wanted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dropUnselectedSegments forgets the segmented dumps rejected by
+// restore_tags, removing their staged parts. Parts are staged before the
+// sidecars are known, so unlike plain archives they are uploaded anyway.
+func (p *ProxmoxExporter) dropUnselectedSegments(segments *segmentedDumps, sidecars map[string]vmConfigSidecar, results chan<- *connectors.Result) {
+	order := segments.order[:0]
+	for _, key := range segments.order {
+		dump := segments.dumps[key]
+		if dump.manifest == nil || p.selectedByTags(dump.manifest.Archive, sidecars) {
+			order = append(order, key)
+			continue
+		}
+		p.logger.Info("archive not selected by restore_tags, skipping", "archive", dump.manifest.Archive)
+		p.removeParts(dump.parts)
+		delete(segments.dumps, key)
+		results <- resultFromRecord(dump.record, nil)
+	}
+	segments.order = order
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// guestNameFromPath returns the guest name recorded in the snapshot
+// directory of an archive, /backup/<type>/<vmid>_<name>/.
+func guestNameFromPath(pathname string, vmid int) string {
+	dir := path.Base(path.Dir(pathname))
+	name, ok := strings.CutPrefix(dir, strconv.Itoa(vmid)+"_")
+	if !ok {
+		return ""
+	}
+	return name
+}
+
+// configValue returns the value of key in the current section of a
+// Proxmox guest config.
+func configValue(data []byte, key string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			break
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(k) == key {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// guestSettings returns the settings recorded for the archive of pending:
+// from its metadata sidecar, or from its config sidecar for archives
+// backed up before metadata 1.4.
+func (p *ProxmoxExporter) guestSettings(pending pendingRestore, configData []byte, metaSidecars map[string]proxmox.DumpMetadata) *proxmox.GuestSettings {
+	if meta, ok := metaSidecars[pending.dumpBase]; ok && meta.Settings != nil {
+		return meta.Settings
+	}
+	return proxmox.ParseGuestSettings(configData)
+}
+
+// restoreGuestSettings checks that a restored guest kept its recorded
+// settings, and sets again the ones the restore lost.
+func (p *ProxmoxExporter) restoreGuestSettings(ctx context.Context, node, vmType string, vmid int, settings *proxmox.GuestSettings) error {
+	if settings == nil {
+		return nil
+	}
+
+	// The cached config predates the restore.
+	p.client.InvalidateVMConfig(vmType, vmid)
+	config, err := p.readGuestConfig(ctx, node, vmType, vmid)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]string)
+	if restored := proxmox.ParseGuestSettings(config); restored != nil {
+		for _, option := range restored.Options() {
+			current[option[0]] = option[1]
+		}
+	}
+
+	var lost []setOption
+	var keys []string
+	for _, option := range settings.Options() {
+		// Containers have no boot order.
+		if vmType == "lxc" && option[0] == "boot" {
+			continue
+		}
+		if current[option[0]] != option[1] {
+			lost = append(lost, setOption{key: option[0], value: option[1]})
+			keys = append(keys, option[0])
+		}
+	}
+	if len(lost) == 0 {
+		return nil
+	}
+	p.logger.Warn("guest settings lost by the restore, setting them again", "vmid", vmid, "settings", keys)
+	return p.applySetOptions(ctx, node, vmType, vmid, lost)
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+)
+
+const stagedMarkerSuffix = ".staged.json"
+
+// stagedMarker is written next to a staged dump once its upload completed.
+// It records which snapshot entry the dump came from and its checksum, so a
+// retried restore can reuse the dump instead of uploading it again.
+type stagedMarker struct {
+	Source  string    `json:"source"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256"`
+}
+
+func stagedMarkerPath(dumpPath string) string {
+	return dumpPath + stagedMarkerSuffix
+}
+
+func newStagedMarker(record *connectors.Record, checksum string) stagedMarker {
+	return stagedMarker{
+		Source:  record.Pathname,
+		Size:    record.FileInfo.Lsize,
+		ModTime: record.FileInfo.LmodTime.UTC(),
+		SHA256:  checksum,
+	}
+}
+
+// stageDump uploads the record to dumpPath unless an identical dump is
+// already there. A dump failing restore_verify_upload is removed.
+func (p *ProxmoxExporter) stageDump(ctx context.Context, dumpPath string, record *connectors.Record) error {
+	reuse, verify := p.restoreOpts.reuseDump, p.restoreOpts.verifyUpload
+	if !reuse && !verify {
+		return p.writeDump(ctx, dumpPath, record.Reader)
+	}
+
+	if reuse {
+		if p.stagedDumpMatches(ctx, dumpPath, record) {
+			p.logger.Info("identical dump already staged, skipping upload", "path", dumpPath)
+			return nil
+		}
+		// Drop any stale marker first so an interrupted upload is never reused.
+		_ = p.client.Remove(ctx, stagedMarkerPath(dumpPath))
+	}
+
+	hasher := sha256.New()
+	if err := p.writeDump(ctx, dumpPath, io.TeeReader(record.Reader, hasher)); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+
+	if verify {
+		nodeSum, err := p.client.Checksum(ctx, dumpPath)
+		if err != nil {
+			return fmt.Errorf("unable to verify staged dump: %w", err)
+		}
+		if !strings.EqualFold(nodeSum, sum) {
+			if err := p.client.Remove(context.Background(), dumpPath); err != nil {
+				p.logger.Warn("unable to remove corrupted staged dump", "path", dumpPath, "error", err)
+			}
+			return fmt.Errorf("staged dump %s is corrupted: sha256 %s on the node, %s sent", dumpPath, nodeSum, sum)
+		}
+		p.logger.Debug("staged dump verified", "path", dumpPath, "sha256", sum)
+	}
+	if !reuse {
+		return nil
+	}
+
+	marker, err := json.Marshal(newStagedMarker(record, sum))
+	if err != nil {
+		return fmt.Errorf("unable to encode staged dump marker: %w", err)
+	}
+	if err := p.writeDump(ctx, stagedMarkerPath(dumpPath), strings.NewReader(string(marker))); err != nil {
+		return fmt.Errorf("unable to write staged dump marker: %w", err)
+	}
+	return nil
+}
+
+// stagedDumpMatches checks that dumpPath holds the same archive as record:
+// the marker must describe the same snapshot entry, and the file on the node
+// must have the expected size and checksum.
+func (p *ProxmoxExporter) stagedDumpMatches(ctx context.Context, dumpPath string, record *connectors.Record) bool {
+	fileInfo, err := p.client.Stat(ctx, dumpPath)
+	if err != nil || fileInfo.Size() != record.FileInfo.Lsize {
+		return false
+	}
+
+	reader, err := p.client.Open(ctx, stagedMarkerPath(dumpPath))
+	if err != nil {
+		return false
+	}
+	data, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil {
+		return false
+	}
+
+	var marker stagedMarker
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return false
+	}
+	expected := newStagedMarker(record, marker.SHA256)
+	if marker.Source != expected.Source || marker.Size != expected.Size || !marker.ModTime.Equal(expected.ModTime) || marker.SHA256 == "" {
+		return false
+	}
+
+	sum, err := p.client.Checksum(ctx, dumpPath)
+	return err == nil && strings.EqualFold(sum, marker.SHA256)
+}
+
+func (p *ProxmoxExporter) removeStagedDump(ctx context.Context, dumpPath string) error {
+	if p.restoreOpts.reuseDump {
+		_ = p.client.Remove(ctx, stagedMarkerPath(dumpPath))
+	}
+	return p.client.Remove(ctx, dumpPath)
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// diskMove is a disk to move to another storage once the guest is
+// restored.
+type diskMove struct {
+	disk    string
+	storage string
+}
+
+// parseStorageMap parses the "src1:dst1,src2:dst2" list of storage_map.
+func parseStorageMap(value string) (map[string]string, error) {
+	return parseNameMap(value, "storage")
+}
+
+// parseNameMap parses a "src1:dst1,src2:dst2" list of kind names.
+func parseNameMap(value, kind string) (map[string]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	mapping := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		src, dst, ok := strings.Cut(item, ":")
+		src = strings.TrimSpace(src)
+		dst = strings.TrimSpace(dst)
+		if !ok || src == "" || dst == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected source:destination", item)
+		}
+		if _, ok := mapping[src]; ok {
+			return nil, fmt.Errorf("%s %s is mapped twice", kind, src)
+		}
+		mapping[src] = dst
+	}
+	return mapping, nil
+}
+
+// planStorageMap returns the storage to pass to qmrestore or pct restore
+// and the disks to move afterwards so that every disk of the config ends
+// up on its mapped storage. Unmapped storages are kept. A vzdump restore
+// takes a single target storage, so the first disk sets it and the disks
+// mapped elsewhere are moved once the guest is restored.
+func planStorageMap(vmType string, configData []byte, mapping map[string]string) (string, []diskMove) {
+	var storage string
+	var moves []diskMove
+	for _, disk := range proxmox.ParseGuestDisks(vmType, configData) {
+		if !disk.Backed() {
+			continue
+		}
+		dst, ok := mapping[disk.Storage]
+		if !ok {
+			dst = disk.Storage
+		}
+		if storage == "" {
+			storage = dst
+		}
+		if dst != storage {
+			moves = append(moves, diskMove{disk: disk.Key, storage: dst})
+		}
+	}
+	return storage, moves
+}
+
+// imageStorages returns the storages the disks of a disk engine backup are
+// restored into, for the storage checks done before the target changes.
+func imageStorages(vmType string, configData []byte, storage string, mapping map[string]string) []string {
+	if storage != "" {
+		return []string{storage}
+	}
+	var storages []string
+	seen := make(map[string]bool)
+	for _, disk := range proxmox.ParseGuestDisks(vmType, configData) {
+		dst, ok := mapping[disk.Storage]
+		if !ok || !disk.Backed() || seen[dst] {
+			continue
+		}
+		seen[dst] = true
+		storages = append(storages, dst)
+	}
+	return storages
+}
+
+// moveDisks moves the disks of a restored guest to their mapped storage,
+// dropping the source volume.
+func (p *ProxmoxExporter) moveDisks(ctx context.Context, node, vmType string, vmid int, moves []diskMove) error {
+	vmidStr := strconv.Itoa(vmid)
+	for _, move := range moves {
+		if node != "" {
+			p.logger.Info("moving disk to mapped storage", "vmid", vmid, "node", node, "disk", move.disk, "storage", move.storage)
+			if err := p.client.MoveDiskOn(ctx, node, vmType, vmid, move.disk, move.storage); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var cmd string
+		var args []string
+		switch vmType {
+		case "qemu":
+			cmd = "qm"
+			args = []string{"disk", "move", vmidStr, move.disk, move.storage, "--delete", "1"}
+		case "lxc":
+			cmd = "pct"
+			args = []string{"move-volume", vmidStr, move.disk, move.storage, "--delete", "1"}
+		default:
+			return fmt.Errorf("unsupported backup type: %s", vmType)
+		}
+
+		p.logger.Info("moving disk to mapped storage", "vmid", vmid, "disk", move.disk, "storage", move.storage)
+		stdout, stderr, err := p.client.Run(ctx, cmd, args...)
+		if err != nil {
+			return proxmox.NewCommandError(fmt.Sprintf("moving disk %s of %s %d to %s failed", move.disk, vmType, vmid, move.storage), err, preferredOutput(stdout, stderr))
+		}
+	}
+	return nil
+}
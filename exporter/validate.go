@@ -0,0 +1,350 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+var poolNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validation tracks what the validate mode has seen, for the checks that
+// span several records.
+type validation struct {
+	archives  map[string]string // path of the archive -> vm type
+	parts     map[string]map[int]int64
+	manifests []validatedManifest
+	sidecars  []validatedSidecar
+}
+
+type validatedManifest struct {
+	record   *connectors.Record
+	key      string
+	manifest proxmox.PartsManifest
+}
+
+type validatedSidecar struct {
+	record  *connectors.Record
+	archive string
+	vmType  string
+	err     error
+}
+
+// validate implements restore_mode=validate: every record is read and
+// checked, and a verdict is reported per record, but nothing is written to
+// the node.
+func (p *ProxmoxExporter) validate(ctx context.Context, records <-chan *connectors.Record, results chan<- *connectors.Result) (err error) {
+	summary := proxmox.NewRunSummary("validate", p.cfg.Origin())
+	p.client.StartRun(summary)
+	defer func() {
+		summary.Finish(err)
+		p.reportSummary(summary)
+	}()
+
+	v := &validation{
+		archives: make(map[string]string),
+		parts:    make(map[string]map[int]int64),
+	}
+
+	for record := range records {
+		if err := ctx.Err(); err != nil {
+			results <- record.Error(err)
+			continue
+		}
+		if record.Err != nil || record.IsXattr || !record.FileInfo.Lmode.IsRegular() {
+			results <- record.Ok()
+			continue
+		}
+
+		base := path.Base(record.Pathname)
+		switch {
+		case proxmox.IsConfigSidecarFilename(base):
+			dumpBase, vmType, err := proxmox.ParseConfigSidecarFilename(base)
+			if err == nil {
+				err = validateConfigSidecar(record)
+			}
+			v.sidecars = append(v.sidecars, validatedSidecar{record: record, archive: path.Join(path.Dir(record.Pathname), dumpBase), vmType: vmType, err: err})
+		case proxmox.IsPoolSidecarFilename(base):
+			dumpBase, err := proxmox.ParsePoolSidecarFilename(base)
+			if err == nil {
+				err = validatePoolSidecar(record)
+			}
+			v.sidecars = append(v.sidecars, validatedSidecar{record: record, archive: path.Join(path.Dir(record.Pathname), dumpBase), err: err})
+		case proxmox.IsDumpMetadataFilename(base):
+			dumpBase, err := proxmox.ParseDumpMetadataFilename(base)
+			var vmType string
+			if err == nil {
+				vmType, err = validateMetadataSidecar(record, dumpBase)
+			}
+			v.sidecars = append(v.sidecars, validatedSidecar{record: record, archive: path.Join(path.Dir(record.Pathname), dumpBase), vmType: vmType, err: err})
+		case proxmox.IsPartsManifestFilename(base):
+			data, err := readRecordBytes(record)
+			if err == nil {
+				var manifest proxmox.PartsManifest
+				if manifest, err = proxmox.DecodePartsManifest(data); err == nil {
+					v.manifests = append(v.manifests, validatedManifest{record: record, key: segmentKey(record.Pathname, manifest.Archive), manifest: manifest})
+					continue
+				}
+			}
+			p.reportVerdict(results, record, err)
+		default:
+			if _, _, ok := proxmox.ParseBindMountFilename(base); ok {
+				// Bind mount contents are not a guest dump: only their
+				// size is checked.
+				_, _, err := readRecordSize(record)
+				p.reportVerdict(results, record, err)
+				continue
+			}
+			if archiveName, index, ok := proxmox.ParsePartFilename(base); ok {
+				size, _, err := readRecordSize(record)
+				key := segmentKey(record.Pathname, archiveName)
+				if v.parts[key] == nil {
+					v.parts[key] = make(map[int]int64)
+				}
+				v.parts[key][index] = size
+				p.reportVerdict(results, record, err)
+				continue
+			}
+
+			if name, ok := proxmox.ParseImageFilename(base); ok {
+				_, _, err := readRecordSize(record)
+				if err == nil {
+					err = proxmox.CheckRestoreEngine(name.Engine, name.Type)
+				}
+				if err == nil {
+					v.archives[path.Join(path.Dir(record.Pathname), name.Archive)] = name.Type
+				}
+				p.reportVerdict(results, record, err)
+				continue
+			}
+
+			vmType, vmid, err := proxmox.ParseDumpFilename(base)
+			if err != nil {
+				if strings.HasPrefix(base, "vzdump-") {
+					p.reportVerdict(results, record, err)
+				} else {
+					results <- record.Ok()
+				}
+				continue
+			}
+
+			startedAt := time.Now()
+			size, err := validateArchive(record, vmType, vmid)
+			if err == nil {
+				v.archives[record.Pathname] = vmType
+			}
+			guest := proxmox.GuestSummary{
+				VMID:     vmid,
+				Type:     vmType,
+				Archive:  base,
+				Status:   proxmox.GuestStatusOK,
+				Bytes:    size,
+				Duration: time.Since(startedAt).Seconds(),
+			}
+			if err != nil {
+				guest.Status = proxmox.GuestStatusFailed
+				guest.Error = err.Error()
+				guest.ErrorClass = proxmox.ErrorClass(err)
+			}
+			summary.Add(guest)
+			p.reportVerdict(results, record, err)
+		}
+	}
+
+	for _, m := range v.manifests {
+		err := validatePartsManifest(m.manifest, v.parts[m.key])
+		if err == nil {
+			v.archives[m.key] = ""
+		}
+		p.reportVerdict(results, m.record, err)
+	}
+	for _, sidecar := range v.sidecars {
+		err := sidecar.err
+		if err == nil {
+			archiveType, ok := v.archives[sidecar.archive]
+			switch {
+			case !ok:
+				err = fmt.Errorf("no valid archive %s for this sidecar", path.Base(sidecar.archive))
+			case sidecar.vmType != "" && archiveType != "" && sidecar.vmType != archiveType:
+				err = fmt.Errorf("sidecar type %s does not match archive type %s", sidecar.vmType, archiveType)
+			}
+		}
+		p.reportVerdict(results, sidecar.record, err)
+	}
+	return nil
+}
+
+func (p *ProxmoxExporter) reportVerdict(results chan<- *connectors.Result, record *connectors.Record, err error) {
+	if err != nil {
+		p.logger.Warn("record invalid", "path", record.Pathname, "error", err)
+	} else {
+		p.logger.Info("record valid", "path", record.Pathname)
+	}
+	results <- resultFromRecord(record, err)
+}
+
+// validateArchive reads a dump record in full and checks its size, its
+// location in the snapshot and that its compression matches its name.
+func validateArchive(record *connectors.Record, vmType string, vmid int) (int64, error) {
+	dir := path.Dir(record.Pathname)
+	if strings.HasPrefix(record.Pathname, "/backup/") {
+		if path.Base(path.Dir(dir)) != vmType {
+			return 0, fmt.Errorf("archive type %s does not match its directory %s", vmType, dir)
+		}
+		if !strings.HasPrefix(path.Base(dir), strconv.Itoa(vmid)+"_") {
+			return 0, fmt.Errorf("archive vmid %d does not match its directory %s", vmid, dir)
+		}
+	}
+
+	size, header, err := readRecordSize(record)
+	if err != nil {
+		return size, err
+	}
+
+	name := strings.ToLower(path.Base(record.Pathname))
+	expected := ""
+	for _, suffix := range []string{".gz", ".zst", ".lzo"} {
+		if strings.HasSuffix(name, suffix) {
+			expected = suffix
+		}
+	}
+	if actual := proxmox.DetectCompressionSuffix(header); actual != expected {
+		return size, fmt.Errorf("archive content is %s but its name says %s: %w", compressionLabel(actual), compressionLabel(expected), proxmox.ErrArchiveCorrupt)
+	}
+	return size, nil
+}
+
+func compressionLabel(suffix string) string {
+	if suffix == "" {
+		return "uncompressed"
+	}
+	return strings.TrimPrefix(suffix, ".")
+}
+
+// readRecordSize drains record, returning the number of bytes read and the
+// first bytes of the content. A size differing from the record metadata
+// is an error.
+func readRecordSize(record *connectors.Record) (int64, []byte, error) {
+	if record.Reader == nil {
+		return 0, nil, fmt.Errorf("missing record reader for %s", record.Pathname)
+	}
+
+	header := make([]byte, 16)
+	n, err := io.ReadFull(record.Reader, header)
+	header = header[:n]
+	size := int64(n)
+	if err == nil {
+		var rest int64
+		rest, err = io.Copy(io.Discard, record.Reader)
+		size += rest
+	} else if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if closeErr := closeRecord(record); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return size, header, err
+	}
+	if size != record.FileInfo.Lsize {
+		return size, header, fmt.Errorf("read %d bytes, expected %d: %w", size, record.FileInfo.Lsize, proxmox.ErrArchiveCorrupt)
+	}
+	return size, header, nil
+}
+
+func validateConfigSidecar(record *connectors.Record) error {
+	data, err := readRecordBytes(record)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return fmt.Errorf("empty config sidecar")
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			// Snapshot sections repeat the same format; the current
+			// configuration is enough to tell a valid file.
+			break
+		}
+		if key, _, ok := strings.Cut(text, ":"); !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("invalid config sidecar line %d: %q", line, text)
+		}
+	}
+	return scanner.Err()
+}
+
+func validatePoolSidecar(record *connectors.Record) error {
+	data, err := readRecordBytes(record)
+	if err != nil {
+		return err
+	}
+	if pool := strings.TrimSpace(string(data)); !poolNameRegex.MatchString(pool) {
+		return fmt.Errorf("invalid pool name in sidecar: %q", pool)
+	}
+	return nil
+}
+
+// validateMetadataSidecar decodes a metadata sidecar and returns the guest
+// type it records, checked against the archive by the caller.
+func validateMetadataSidecar(record *connectors.Record, dumpBase string) (string, error) {
+	data, err := readRecordBytes(record)
+	if err != nil {
+		return "", err
+	}
+	meta, err := proxmox.DecodeDumpMetadata(data)
+	if err != nil {
+		return "", err
+	}
+	if err := proxmox.CheckDumpMetadata(meta, dumpBase); err != nil {
+		return "", err
+	}
+	return meta.Type, nil
+}
+
+func validatePartsManifest(manifest proxmox.PartsManifest, parts map[int]int64) error {
+	for _, part := range manifest.Parts {
+		size, ok := parts[part.Index]
+		if !ok {
+			return fmt.Errorf("missing part %d of %s: %w", part.Index, manifest.Archive, proxmox.ErrArchiveCorrupt)
+		}
+		if size != part.Size {
+			return fmt.Errorf("part %d of %s is %d bytes, expected %d: %w", part.Index, manifest.Archive, size, part.Size, proxmox.ErrArchiveCorrupt)
+		}
+	}
+	if len(parts) != len(manifest.Parts) {
+		return fmt.Errorf("%s has %d parts, manifest lists %d: %w", manifest.Archive, len(parts), len(manifest.Parts), proxmox.ErrArchiveCorrupt)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// vmidAllocator hands out the target VMIDs of restore_target_vmid=auto: an
+// unused VMID per source guest, shared by every archive of that guest.
+// VMIDs are allocated on first use, with the context of the run.
+type vmidAllocator struct {
+	ctx    context.Context
+	client *proxmox.Client
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	targets  map[int]int
+	errs     map[int]error
+	reserved map[int]bool
+}
+
+func newVMIDAllocator(ctx context.Context, client *proxmox.Client, logger *slog.Logger) *vmidAllocator {
+	return &vmidAllocator{
+		ctx:      ctx,
+		client:   client,
+		logger:   logger,
+		targets:  make(map[int]int),
+		errs:     make(map[int]error),
+		reserved: make(map[int]bool),
+	}
+}
+
+// target returns the VMID source is restored to, allocating it on first
+// call, or 0 when no VMID could be allocated.
+func (a *vmidAllocator) target(source int) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if target, ok := a.targets[source]; ok {
+		return target
+	}
+	if _, failed := a.errs[source]; failed {
+		return 0
+	}
+
+	target, err := a.client.NextFreeVMID(a.ctx, a.reserved)
+	if err != nil {
+		a.errs[source] = fmt.Errorf("unable to allocate a target VMID for %d: %w", source, err)
+		return 0
+	}
+	a.reserved[target] = true
+	a.targets[source] = target
+	a.logger.Info("allocated target VMID", "source_vmid", source, "vmid", target)
+	return target
+}
+
+// failure returns why no VMID could be allocated to source, if so.
+func (a *vmidAllocator) failure(source int) error {
+	if a == nil {
+		return nil
+	}
+	a.target(source)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.errs[source]
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+	"github.com/gillesdubois/plakar-integration-proxmox/proxmoxtest"
+)
+
+// BenchmarkWriteDump measures the upload of a dump to the node through
+// writeDump, over a loopback SSH server.
+func BenchmarkWriteDump(b *testing.B) {
+	addr, stop, err := proxmoxtest.StartSSHServer()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer stop()
+
+	cfg := &proxmox.Config{Host: addr, Hosts: []string{addr}, ConnMethod: proxmox.ConnMethodPassword, ConnUsername: "bench", ConnPassword: "bench"}
+	runner, err := proxmox.NewSSHRunner(cfg, proxmox.NewLogger(io.Discard, 0))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer runner.Close()
+
+	ctx := context.Background()
+	exp, err := NewProxmoxExporterWithRunner(ctx, nil, "proxmox", map[string]string{"location": "proxmox://" + addr, "mode": "local"}, runner)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer exp.Close(ctx)
+	p := exp.(*ProxmoxExporter)
+
+	payload := make([]byte, 8<<20)
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.writeDump(ctx, "/dev/null", bytes.NewReader(payload)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+// Extended attributes set on the records of a guest, so snapshots can be
+// searched by guest without parsing the record names.
+const (
+	attrVMID = "user.proxmox.vmid"
+	attrType = "user.proxmox.type"
+	attrName = "user.proxmox.name"
+	attrNode = "user.proxmox.node"
+	attrPool = "user.proxmox.pool"
+	attrTags = "user.proxmox.tags"
+)
+
+type guestAttribute struct {
+	name  string
+	value string
+}
+
+// guestAttributes holds the attributes of each guest directory of the
+// snapshot tree.
+type guestAttributes struct {
+	mu    sync.Mutex
+	byDir map[string][]guestAttribute
+}
+
+func (g *guestAttributes) set(dir string, attrs []guestAttribute) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.byDir == nil {
+		g.byDir = make(map[string][]guestAttribute)
+	}
+	g.byDir[dir] = attrs
+}
+
+func (g *guestAttributes) get(dir string) []guestAttribute {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.byDir[dir]
+}
+
+// registerGuestAttributes looks up the attributes of a guest, set on every
+// record emitted under its directory from now on. Node, pool and tags
+// are left out when they cannot be read.
+func (p *ProxmoxImporter) registerGuestAttributes(ctx context.Context, vmType string, vmid int, vmName string) {
+	attrs := []guestAttribute{
+		{attrVMID, strconv.Itoa(vmid)},
+		{attrType, vmType},
+		{attrName, vmName},
+	}
+	if node, err := p.client.VMNode(ctx, vmid); err == nil && node != "" {
+		attrs = append(attrs, guestAttribute{attrNode, node})
+	}
+	if pool, err := p.client.VMPool(ctx, vmid); err == nil && pool != "" {
+		attrs = append(attrs, guestAttribute{attrPool, pool})
+	}
+	if tags, err := p.client.VMTags(ctx, vmid); err == nil && len(tags) > 0 {
+		attrs = append(attrs, guestAttribute{attrTags, strings.Join(tags, ";")})
+	}
+	p.attrs.set(buildBackupSnapshotPath(vmType, vmid, vmName, ""), attrs)
+}
+
+// emitWithAttributes sends record followed by the extended attribute
+// records of its guest, when it belongs to one.
+func (p *ProxmoxImporter) emitWithAttributes(ctx context.Context, records chan<- *connectors.Record, record *connectors.Record, dir string) error {
+	attrs := p.attrs.get(dir)
+	for _, attr := range attrs {
+		record.ExtendedAttributes = append(record.ExtendedAttributes, attr.name)
+	}
+
+	select {
+	case <-ctx.Done():
+		_ = record.Close()
+		return ctx.Err()
+	case records <- record:
+	}
+
+	for _, attr := range attrs {
+		value := []byte(attr.value)
+		xattr := connectors.NewXattr(record.Pathname, attr.name, objects.AttributeExtended, func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(value)), nil
+		})
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case records <- xattr:
+		}
+	}
+	return nil
+}
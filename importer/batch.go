@@ -0,0 +1,287 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// vzdumpBatch holds the archives of a multi-guest vzdump job until their
+// guest is imported.
+type vzdumpBatch struct {
+	// archives maps each guest of a job to its archive, or to "" when
+	// the job did not produce one and the guest is dumped on its own.
+	archives map[int]string
+
+	// jobs maps the guests of the vzdump --all jobs of vzdump_all, one per
+	// node, to their job, still reporting the pending guests. Job
+	// failures are the errors of their guest.
+	jobs    map[int]*proxmox.VzdumpJob
+	started []*proxmox.VzdumpJob
+	pending map[int]bool
+	errs    map[int]error
+}
+
+// take returns the batch archive of vmid, if any, and forgets it. A guest
+// of a vzdump_all job is waited for until its job is done with it.
+func (b *vzdumpBatch) take(vmid int) (string, bool, error) {
+	job := b.jobs[vmid]
+	for b.pending[vmid] {
+		done, ok := <-job.Archives()
+		if !ok {
+			for pending := range b.pending {
+				if b.jobs[pending] == job {
+					b.errs[pending] = fmt.Errorf("vzdump job ended without backing up vmid %d: %w", pending, job.Wait())
+					delete(b.pending, pending)
+				}
+			}
+			break
+		}
+		delete(b.pending, done.VMID)
+		if done.Err != nil {
+			b.errs[done.VMID] = done.Err
+			continue
+		}
+		b.archives[done.VMID] = done.Archive
+	}
+	if err, ok := b.errs[vmid]; ok {
+		delete(b.errs, vmid)
+		return "", false, err
+	}
+
+	archive, ok := b.archives[vmid]
+	if ok {
+		delete(b.archives, vmid)
+	}
+	return archive, ok && archive != "", nil
+}
+
+// holds reports whether the archive of vmid is already produced, or being
+// produced, by a multi-guest job.
+func (b *vzdumpBatch) holds(vmid int) bool {
+	return b.archives[vmid] != "" || b.pending[vmid]
+}
+
+// startVzdumpAll starts the vzdump --all jobs of vzdump_all, one on each
+// node hosting selected guests, and returns vmids in import order: the
+// guests of the jobs first, node by node in the order vzdump dumps them,
+// then the guests they leave out. Guests excluded from the selection, or
+// dumped by an interrupted run, are excluded from the jobs, as are guests
+// with backup_mountpoints overrides or a running backup (when
+// running_backup is wait or skip), which are dumped on their own. The
+// archives of another node are read from dump_dir, which must then be on a
+// shared storage; otherwise its guests are dumped on their own too.
+func (p *ProxmoxImporter) startVzdumpAll(ctx context.Context, vmids []int, progress *checkpoint) ([]int, error) {
+	localNode, err := p.client.LocalNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	all, err := p.client.ListAllVMIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	jobVMIDs := make(map[string][]int)
+	exclude := make(map[string][]int)
+	var nodes []string
+	for _, vmid := range all {
+		node, err := p.client.VMNode(ctx, vmid)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+		if !p.joinsVzdumpAll(ctx, vmids, vmid, progress) {
+			exclude[node] = append(exclude[node], vmid)
+			continue
+		}
+		jobVMIDs[node] = append(jobVMIDs[node], vmid)
+	}
+	slices.Sort(nodes)
+
+	var order []int
+	for _, node := range nodes {
+		guests := jobVMIDs[node]
+		if len(guests) == 0 {
+			continue
+		}
+		jobNode := ""
+		if node != localNode {
+			if shared, err := p.sharedDumpDir(ctx, node); err != nil || !shared {
+				p.logger.Warn("dump_dir is not on a storage shared with the node, dumping its guests on their own", "node", node, "error", err)
+				continue
+			}
+			jobNode = node
+		}
+
+		p.logger.Info("starting vzdump job for all guests of the node", "node", node, "guests", len(guests), "exclude", exclude[node])
+		job, err := p.client.BackupAll(ctx, jobNode, guests, exclude[node])
+		if err != nil {
+			return nil, err
+		}
+		if p.batch.jobs == nil {
+			p.batch.jobs = make(map[int]*proxmox.VzdumpJob)
+			p.batch.pending = make(map[int]bool)
+			p.batch.errs = make(map[int]error)
+		}
+		if p.batch.archives == nil {
+			p.batch.archives = make(map[int]string)
+		}
+		p.batch.started = append(p.batch.started, job)
+		for _, vmid := range guests {
+			p.batch.jobs[vmid] = job
+			p.batch.pending[vmid] = true
+		}
+		order = append(order, guests...)
+	}
+	for _, vmid := range vmids {
+		if !slices.Contains(order, vmid) {
+			order = append(order, vmid)
+		}
+	}
+	return order, nil
+}
+
+// joinsVzdumpAll reports whether vmid is backed up by the vzdump_all job
+// of its node.
+func (p *ProxmoxImporter) joinsVzdumpAll(ctx context.Context, vmids []int, vmid int, progress *checkpoint) bool {
+	if !slices.Contains(vmids, vmid) || progress != nil && progress.isCompleted(vmid) {
+		return false
+	}
+	if len(p.backupOpts.mountpoints[vmid]) > 0 {
+		return false
+	}
+	if p.backupOpts.runningBackup != runningBackupIgnore {
+		if running, err := p.client.RunningBackup(ctx, vmid); err != nil || running != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// sharedDumpDir reports whether dump_dir is the backup directory of a
+// shared storage of node, where the archives it writes are visible from
+// the node commands run on.
+func (p *ProxmoxImporter) sharedDumpDir(ctx context.Context, node string) (bool, error) {
+	id, err := p.client.DumpDirStorage(ctx, node)
+	if err != nil || id == "" {
+		return false, err
+	}
+	storage, err := p.client.Storage(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	return storage.IsShared(), nil
+}
+
+// prepareBatch dumps the next vzdump_batch guests of queue with a single
+// vzdump job, unless its first guest already went through one. Guests
+// that cannot share the job (hosted on another node, with
+// backup_mountpoints overrides, already being backed up, or dumped by
+// an interrupted run) are left to their own vzdump, as are guests of a
+// job that produced no archive for them.
+func (p *ProxmoxImporter) prepareBatch(ctx context.Context, queue []int, progress *checkpoint) {
+	if p.backupOpts.vzdumpBatch < 2 || p.backupOpts.engine != proxmox.EngineVzdump || len(queue) == 0 {
+		return
+	}
+	if _, ok := p.batch.archives[queue[0]]; ok {
+		return
+	}
+	localNode, err := p.client.LocalNode(ctx)
+	if err != nil {
+		p.logger.Warn("unable to resolve the local node, dumping guests one by one", "error", err)
+		return
+	}
+
+	var vmids []int
+	for _, vmid := range queue {
+		if len(vmids) == p.backupOpts.vzdumpBatch {
+			break
+		}
+		if _, ok := p.batch.archives[vmid]; ok {
+			continue
+		}
+		if progress != nil && progress.isCompleted(vmid) {
+			continue
+		}
+		if len(p.backupOpts.mountpoints[vmid]) > 0 {
+			continue
+		}
+		if node, err := p.client.VMNode(ctx, vmid); err != nil || node != localNode {
+			continue
+		}
+		if p.backupOpts.runningBackup != runningBackupIgnore {
+			if running, err := p.client.RunningBackup(ctx, vmid); err != nil || running != nil {
+				continue
+			}
+		}
+		vmids = append(vmids, vmid)
+	}
+	if len(vmids) < 2 {
+		return
+	}
+
+	p.logger.Info("dumping guests with a single vzdump job", "guests", vmids)
+	archives, err := p.client.BackupVMs(ctx, vmids)
+	if err != nil {
+		p.logger.Warn("vzdump job finished with errors, failed guests are dumped on their own", "archives", len(archives), "error", err)
+	}
+	if p.batch.archives == nil {
+		p.batch.archives = make(map[int]string)
+	}
+	for _, vmid := range vmids {
+		p.batch.archives[vmid] = archives[vmid]
+	}
+}
+
+// discardBatch removes the batch archives of guests never imported, e.g.
+// after a failed run. Jobs still backing up guests are stopped; the
+// guests they did not report are checked for a leftover backup lock.
+func (p *ProxmoxImporter) discardBatch() {
+	for _, job := range p.batch.started {
+		for vmid, pending := range p.batch.pending {
+			if pending && p.batch.jobs[vmid] == job {
+				_ = job.Abort()
+				break
+			}
+		}
+		for done := range job.Archives() {
+			if done.Err == nil {
+				p.batch.archives[done.VMID] = done.Archive
+			}
+		}
+		if err := job.Wait(); err != nil {
+			p.logger.Warn("vzdump job finished with errors", "error", err)
+		}
+	}
+	p.batch.started = nil
+	clear(p.batch.jobs)
+	clear(p.batch.pending)
+	for vmid, archive := range p.batch.archives {
+		if archive != "" && p.cfg.Cleanup {
+			if err := p.client.Remove(context.Background(), archive); err != nil {
+				p.logger.Warn("unable to remove batch archive", "vmid", vmid, "path", archive, "error", err)
+			}
+		}
+		delete(p.batch.archives, vmid)
+	}
+}
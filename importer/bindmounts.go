@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+const (
+	bindMountsIgnore = "ignore"
+	bindMountsWarn   = "warn"
+	bindMountsBackup = "backup"
+)
+
+// parseBindMountOptions parses bind_mounts and bind_mount_paths.
+func parseBindMountOptions(config map[string]string, opts *backupOptions) error {
+	opts.bindMounts = bindMountsWarn
+	if value := strings.ToLower(strings.TrimSpace(config["bind_mounts"])); value != "" {
+		switch value {
+		case bindMountsIgnore, bindMountsWarn, bindMountsBackup:
+			opts.bindMounts = value
+		default:
+			return fmt.Errorf("invalid bind_mounts value: %s", value)
+		}
+	}
+
+	for _, hostPath := range strings.Split(config["bind_mount_paths"], ",") {
+		hostPath = strings.TrimSpace(hostPath)
+		if hostPath == "" {
+			continue
+		}
+		if !path.IsAbs(hostPath) {
+			return fmt.Errorf("invalid bind_mount_paths entry: %s", hostPath)
+		}
+		opts.bindMountPaths = append(opts.bindMountPaths, path.Clean(hostPath))
+	}
+	if opts.bindMounts == bindMountsBackup && len(opts.bindMountPaths) == 0 {
+		return fmt.Errorf("bind_mounts=backup requires bind_mount_paths")
+	}
+	return nil
+}
+
+// emitBindMounts applies the bind_mounts policy to the bind mounts of a
+// container: listed host paths are archived with tar next to the guest
+// archive, the others are skipped, with a warning unless ignored. It
+// returns the bind mounts for the metadata sidecar.
+func (p *ProxmoxImporter) emitBindMounts(ctx context.Context, records chan<- *connectors.Record, vmid int, vmName, archiveName string, modTime time.Time, configData []byte) ([]proxmox.BindMount, error) {
+	mounts := proxmox.BindMounts(configData)
+	for i, mount := range mounts {
+		if p.backupOpts.bindMounts != bindMountsBackup || !slices.Contains(p.backupOpts.bindMountPaths, path.Clean(mount.HostPath)) {
+			if p.backupOpts.bindMounts != bindMountsIgnore {
+				p.logger.Warn("bind mount not backed up", "vmid", vmid, "mountpoint", mount.Key, "host_path", mount.HostPath)
+			}
+			continue
+		}
+
+		name := proxmox.BuildBindMountFilename(archiveName, mount.Key)
+		target := path.Join(p.cfg.DumpDir, name)
+		p.logger.Info("backing up bind mount", "vmid", vmid, "mountpoint", mount.Key, "host_path", mount.HostPath)
+		if err := p.client.ArchiveBindMount(ctx, mount.HostPath, target); err != nil {
+			_ = p.client.Remove(context.Background(), target)
+			return nil, fmt.Errorf("bind mount %s of container %d: %w", mount.Key, vmid, err)
+		}
+		backupRecord, err := p.buildBackupRecord(ctx, "lxc", vmid, vmName, target)
+		if err != nil {
+			return nil, err
+		}
+		backupRecord.record.FileInfo.LmodTime = modTime
+		if err := p.emitRecord(ctx, records, backupRecord.record); err != nil {
+			return nil, err
+		}
+		if p.cfg.Cleanup {
+			if err := p.client.Remove(ctx, target); err != nil {
+				return nil, err
+			}
+		}
+		mounts[i].File = name
+	}
+	return mounts, nil
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultCheckpointWindow = 24 * time.Hour
+
+// checkpoint records the guests of a run whose dump is done, with the
+// archive kept on the node for each of them, so that an interrupted run
+// can be retried without redoing their vzdump. Nothing tells the importer
+// whether plakar committed the snapshot of the interrupted run, so a
+// retry imports the kept archives again rather than skipping their
+// guests; they are removed once a run succeeds.
+type checkpoint struct {
+	path string
+
+	// stale holds the archives of a checkpoint too old, or of another
+	// selection, that this one replaces.
+	stale map[int]string
+
+	Origin    string         `json:"origin"`
+	Selection string         `json:"selection"`
+	StartedAt time.Time      `json:"started_at"`
+	Archives  map[int]string `json:"archives"`
+}
+
+// loadCheckpoint returns the checkpoint stored at path when it belongs to the
+// same origin and selection and was started within window; otherwise it
+// returns a fresh checkpoint for this run.
+func loadCheckpoint(path, origin, selection string, window time.Duration) (*checkpoint, error) {
+	fresh := &checkpoint{
+		path:      path,
+		Origin:    origin,
+		Selection: selection,
+		StartedAt: time.Now(),
+		Archives:  make(map[int]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fresh, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read checkpoint %s: %w", path, err)
+	}
+
+	var previous checkpoint
+	if err := json.Unmarshal(data, &previous); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	if previous.Origin != origin || previous.Selection != selection || time.Since(previous.StartedAt) > window {
+		fresh.stale = previous.Archives
+		return fresh, nil
+	}
+
+	previous.path = path
+	if previous.Archives == nil {
+		previous.Archives = make(map[int]string)
+	}
+	return &previous, nil
+}
+
+// archive returns the archive an interrupted run kept for vmid, if any.
+func (c *checkpoint) archive(vmid int) string {
+	if c == nil {
+		return ""
+	}
+	return c.Archives[vmid]
+}
+
+// isCompleted reports whether an interrupted run already dumped vmid.
+func (c *checkpoint) isCompleted(vmid int) bool {
+	return c.archive(vmid) != ""
+}
+
+// markCompleted records the archive kept on the node for vmid.
+func (c *checkpoint) markCompleted(vmid int, archivePath string) error {
+	if c.Archives[vmid] == archivePath {
+		return nil
+	}
+	c.Archives[vmid] = archivePath
+	return c.save()
+}
+
+func (c *checkpoint) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to write checkpoint: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to write checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to write checkpoint: %w", err)
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// clear removes the checkpoint once the whole run succeeded.
+func (c *checkpoint) clear() error {
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
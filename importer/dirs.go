@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+// dirRecords remembers which directories of the snapshot tree have been
+// emitted, so each one is sent once, before the first file it contains.
+type dirRecords struct {
+	mu      sync.Mutex
+	emitted map[string]bool
+	modTime time.Time
+}
+
+// missing marks the not yet emitted ancestors of pathname as emitted and
+// returns them, outermost first.
+func (d *dirRecords) missing(pathname string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.emitted == nil {
+		d.emitted = make(map[string]bool)
+		d.modTime = time.Now()
+	}
+
+	var dirs []string
+	for dir := path.Dir(pathname); ; dir = path.Dir(dir) {
+		if d.emitted[dir] {
+			break
+		}
+		d.emitted[dir] = true
+		dirs = append(dirs, dir)
+		if dir == "/" || dir == "." {
+			break
+		}
+	}
+	for i, j := 0, len(dirs)-1; i < j; i, j = i+1, j-1 {
+		dirs[i], dirs[j] = dirs[j], dirs[i]
+	}
+	return dirs
+}
+
+// emitParentDirs sends a directory record for every ancestor of pathname
+// not emitted yet (/, /backup, /backup/<type>, /backup/<type>/<vmid>_<name>).
+func (p *ProxmoxImporter) emitParentDirs(ctx context.Context, records chan<- *connectors.Record, pathname string) error {
+	for _, dir := range p.dirs.missing(pathname) {
+		record := &connectors.Record{
+			Pathname: dir,
+			FileInfo: objects.FileInfo{
+				Lname:    path.Base(dir),
+				Lmode:    fs.ModeDir | 0700,
+				LmodTime: p.dirs.modTime,
+				Ldev:     1,
+				Lnlink:   2,
+			},
+		}
+		if err := p.emitWithAttributes(ctx, records, record, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
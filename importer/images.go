@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// importGuestImages backs a guest up with a disk engine: one record per
+// disk image, followed by the usual sidecars under the archive name shared
+// by the images. revertMountpoints is called once the storage snapshot is
+// taken. Streamed images are read by plakar straight from the storage, and
+// the snapshot is released once they were all read.
+func (p *ProxmoxImporter) importGuestImages(ctx context.Context, records chan<- *connectors.Record, outcome guestOutcome, vmid int, revertMountpoints func()) (guestOutcome, error) {
+	backup, err := p.client.BackupImages(ctx, p.backupOpts.engine, vmid, p.backupOpts.engineIncremental)
+	revertMountpoints()
+	if err != nil {
+		return outcome, err
+	}
+	outcome.archive = backup.Archive
+
+	stored := false
+	emitted := 0
+	defer func() {
+		for _, image := range backup.Images[emitted:] {
+			_ = image.Close()
+		}
+		p.client.ReleaseImageBackup(backup, stored)
+		if p.cfg.Cleanup {
+			p.client.RemoveImageFiles(backup)
+		}
+	}()
+
+	modTime := backup.CreatedAt
+	for _, image := range backup.Images {
+		record, err := p.buildImageRecord(ctx, outcome.vmType, vmid, outcome.vmName, backup, image)
+		if err != nil {
+			return outcome, err
+		}
+		if image.Path != "" {
+			modTime = record.FileInfo.LmodTime
+			outcome.size += record.FileInfo.Lsize
+			p.logger.Info("disk image ready", "vmid", vmid, "image", record.FileInfo.Lname, "size", record.FileInfo.Lsize)
+		} else {
+			p.logger.Info("streaming disk image", "vmid", vmid, "image", record.FileInfo.Lname)
+		}
+		emitted++
+		if err := p.emitRecord(ctx, records, record); err != nil {
+			return outcome, err
+		}
+	}
+
+	if err := p.emitSidecars(ctx, records, outcome.vmType, vmid, outcome.vmName, backup.Archive, modTime); err != nil {
+		return outcome, err
+	}
+	if err := backup.Wait(ctx); err != nil {
+		return outcome, err
+	}
+	for _, image := range backup.Images {
+		if image.Path == "" {
+			outcome.size += image.Size()
+		}
+	}
+	stored = true
+	return outcome, nil
+}
+
+// buildImageRecord returns the record of a disk image. Streamed images have
+// no size until plakar read them.
+func (p *ProxmoxImporter) buildImageRecord(ctx context.Context, vmType string, vmid int, vmName string, backup *proxmox.ImageBackup, image *proxmox.BackupImage) (*connectors.Record, error) {
+	if image.Path != "" {
+		backupRecord, err := p.buildBackupRecord(ctx, vmType, vmid, vmName, image.Path)
+		if err != nil {
+			return nil, err
+		}
+		return backupRecord.record, nil
+	}
+	if isInvalidArchiveName(image.Name) {
+		return nil, fmt.Errorf("invalid archive name for vmid %d: %q", vmid, image.Name)
+	}
+	return &connectors.Record{
+		Pathname: buildBackupSnapshotPath(vmType, vmid, vmName, image.Name),
+		FileInfo: objects.FileInfo{
+			Lname:    image.Name,
+			Lsize:    -1,
+			Lmode:    0600,
+			LmodTime: backup.CreatedAt,
+			Ldev:     1,
+		},
+		Reader: proxmox.NewRateLimitedReader(ctx, image, p.bwLimiter),
+	}, nil
+}
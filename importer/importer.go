@@ -19,9 +19,15 @@ package importer
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
 	"path"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -34,20 +40,110 @@ import (
 )
 
 type ProxmoxImporter struct {
-	cfg       *proxmox.Config
-	client    *proxmox.Client
-	selection selection
+	cfg        *proxmox.Config
+	client     *proxmox.Client
+	selection  selection
+	backupOpts backupOptions
+	logger     *slog.Logger
+	dirs       dirRecords
+	bwLimiter  *proxmox.RateLimiter
+	hostname   string
+	batch      vzdumpBatch
+	attrs      guestAttributes
+
+	// progress is the checkpoint of checkpoint_file, nil without one.
+	progress *checkpoint
+
+	// dumpLoc is the time zone vzdump names archives in, once resolved.
+	dumpLoc *time.Location
+
+	// backReferences holds the backup_writeback lines of the imported
+	// guests, written by Close once the snapshot is committed.
+	backReferences map[int]string
+}
+
+// importerOptions lists the importer-specific keys, in addition to the
+// common ones.
+var importerOptions = []string{
+	"vmid", "all", "running_backup", "running_backup_timeout",
+	"checkpoint_file", "checkpoint_window", "stall_retries", "segment_size",
+	"backup_order", "backup_priority_map", "audit_log", "run_summary",
+	"backup_net_bwlimit", "backup_writeback", "engine", "engine_incremental",
+	"backup_report", "backup_report_format", "backup_mountpoints",
+	"bind_mounts", "bind_mount_paths", "vzdump_batch", "vzdump_all",
+	"exclude", "backup_max_load", "backup_max_iowait", "backup_load_wait",
+	"backup_throttle_iowait", "backup_throttle_bwlimit", "archive_guest_name",
+	"pbs_host", "tag_paths", "lockwait",
+}
+
+type backupOptions struct {
+	runningBackup        string
+	runningBackupTimeout time.Duration
+	checkpointFile       string
+	checkpointWindow     time.Duration
+	segmentSize          int64
+	stallRetries         int
+	lockWait             time.Duration
+	netBWLimit           int64
+	writeback            bool
+	order                string
+	priorities           map[int]int
+	mountpoints          map[int]map[string]bool
+	bindMounts           string
+	bindMountPaths       []string
+	vzdumpBatch          int
+	vzdumpAll            bool
+	maxLoad              float64
+	maxIOWait            float64
+	loadWait             time.Duration
+	throttleIOWait       float64
+	throttleBWLimit      int64
+	archiveGuestName     bool
+	tagPaths             bool
+	auditLog             bool
+	runSummary           bool
+	reportPath           string
+	reportFormat         string
+	engine               string
+	engineIncremental    bool
 }
 
 type selection struct {
-	vmid *int
-	pool string
-	all  bool
+	vmid    *int
+	pool    string
+	all     bool
+	exclude []int
+
+	// pbsHost backs up the configuration of a Proxmox Backup Server host
+	// instead of guests.
+	pbsHost bool
 }
 
 const protocolName = "proxmox+backup"
 const backupSnapshotRoot = "/backup"
 
+// runSnapshotRoot holds records describing the run itself rather than a
+// guest.
+const runSnapshotRoot = "/_run"
+
+// tagSnapshotRoot holds, with tag_paths=true, a view of the guest
+// directories grouped by Proxmox tag.
+const tagSnapshotRoot = "/tags"
+
+const (
+	runningBackupIgnore = "ignore"
+	runningBackupWait   = "wait"
+	runningBackupSkip   = "skip"
+)
+
+const defaultRunningBackupTimeout = 60 * time.Minute
+
+const (
+	backupOrderVMID        = "vmid"
+	backupOrderSize        = "size"
+	backupOrderPriorityMap = "priority_map"
+)
+
 func init() {
 	if err := importer.Register(protocolName, 0, NewProxmoxImporter); err != nil {
 		panic(err)
@@ -55,25 +151,83 @@ func init() {
 }
 
 func NewProxmoxImporter(ctx context.Context, opts *connectors.Options, name string, config map[string]string) (importer.Importer, error) {
-	cfg, err := proxmox.ParseConfig(config)
+	return newProxmoxImporter(ctx, opts, config, proxmox.NewClient)
+}
+
+// NewProxmoxImporterWithRunner builds the importer on top of runner instead of
+// a connection to a node, see the runner package.
+func NewProxmoxImporterWithRunner(ctx context.Context, opts *connectors.Options, name string, config map[string]string, runner proxmox.Runner) (importer.Importer, error) {
+	return newProxmoxImporter(ctx, opts, config, func(cfg *proxmox.Config, logger *slog.Logger) (*proxmox.Client, error) {
+		return proxmox.NewClientWithRunner(cfg, logger, runner)
+	})
+}
+
+func newProxmoxImporter(ctx context.Context, opts *connectors.Options, config map[string]string, newClient func(*proxmox.Config, *slog.Logger) (*proxmox.Client, error)) (importer.Importer, error) {
+	config, err := proxmox.MergeLocationQuery(config)
 	if err != nil {
 		return nil, err
 	}
 
-	selection, err := parseSelection(config)
-	if err != nil {
+	cfg, cfgErr := proxmox.ParseConfig(config)
+	selection, selectionErr := parseSelection(config)
+	backupOpts, optsErr := parseBackupOptions(config)
+	unknown, unknownErr := proxmox.CheckOptions(config, importerOptions)
+	if err := errors.Join(cfgErr, selectionErr, optsErr, unknownErr); err != nil {
 		return nil, err
 	}
+	if backupOpts.vzdumpAll && (!selection.all || backupOpts.engine != proxmox.EngineVzdump) {
+		return nil, fmt.Errorf("vzdump_all requires all=true and engine=vzdump")
+	}
+
+	var (
+		stderr   io.Writer
+		hostname string
+	)
+	if opts != nil {
+		stderr = opts.Stderr
+		hostname = opts.Hostname
+	}
+	logger := proxmox.NewLogger(stderr, cfg.LogLevel).With("connector", "importer")
+	for _, msg := range unknown {
+		logger.Warn(msg)
+	}
+
+	cfg.LockWait = backupOpts.lockWait
 
-	client, err := proxmox.NewClient(cfg)
+	// Nothing may be written to a PBS host, which has no PVE tools either.
+	if selection.pbsHost {
+		if backupOpts.reportPath == proxmox.ReportInDumpDir {
+			return nil, fmt.Errorf("pbs_host cannot write backup_report into dump_dir, use a local path")
+		}
+		if cfg.Notify != proxmox.NotifyNever {
+			return nil, fmt.Errorf("pve_notify cannot be used with pbs_host")
+		}
+	}
+
+	client, err := newClient(cfg, logger)
 	if err != nil {
 		return nil, err
 	}
+	if !selection.pbsHost {
+		if err := client.DetectCluster(ctx); err != nil {
+			logger.Warn("unable to detect cluster name, using the host as origin", "error", err)
+		}
+	}
+
+	// backup_throttle_iowait changes the limit along the run.
+	bwLimiter := proxmox.NewRateLimiter(backupOpts.netBWLimit)
+	if backupOpts.throttleIOWait > 0 {
+		bwLimiter = proxmox.NewAdjustableRateLimiter(backupOpts.netBWLimit)
+	}
 
 	return &ProxmoxImporter{
-		cfg:       cfg,
-		client:    client,
-		selection: selection,
+		cfg:        cfg,
+		client:     client,
+		selection:  selection,
+		backupOpts: backupOpts,
+		logger:     logger,
+		bwLimiter:  bwLimiter,
+		hostname:   hostname,
 	}, nil
 }
 
@@ -83,104 +237,539 @@ func (p *ProxmoxImporter) Root() string          { return "/" }
 func (p *ProxmoxImporter) Flags() location.Flags { return location.FLAG_STREAM }
 
 func (p *ProxmoxImporter) Ping(ctx context.Context) error {
+	if p.selection.pbsHost {
+		return p.client.PingPBS(ctx)
+	}
 	return p.client.Ping(ctx)
 }
 
-func (p *ProxmoxImporter) Import(ctx context.Context, records chan<- *connectors.Record, _ <-chan *connectors.Result) error {
+func (p *ProxmoxImporter) Import(ctx context.Context, records chan<- *connectors.Record, _ <-chan *connectors.Result) (err error) {
 	defer close(records)
+	defer func() {
+		if err != nil {
+			// No snapshot is committed for a failed run.
+			clear(p.backReferences)
+		}
+	}()
+
+	ctx, span := p.client.Tracer().Start(ctx, "import", "origin", p.cfg.Origin(), "selection", p.selection.String())
+	defer func() { span.End(err) }()
+
+	metrics := p.client.Metrics()
+	runStart := time.Now()
+	metrics.Set(proxmox.MetricRunStart, float64(runStart.Unix()), "operation", "backup")
+	if p.cfg.MetricsFile != "" {
+		stop := metrics.StartTextfile(ctx, p.cfg.MetricsFile)
+		defer func() {
+			metrics.Set(proxmox.MetricRunDuration, time.Since(runStart).Seconds(), "operation", "backup")
+			if err := stop(); err != nil {
+				p.logger.Warn("unable to write metrics file", "path", p.cfg.MetricsFile, "error", err)
+			}
+		}()
+	}
+
+	// Registered before the audit record so the summary is the last record.
+	summary := proxmox.NewRunSummary("backup", p.cfg.Origin())
+	p.client.StartRun(summary)
+	defer func() {
+		if !p.backupOpts.runSummary {
+			summary.Finish(err)
+		}
+		p.client.FinishRun(summary)
+		p.writeReport(summary)
+	}()
+	if p.backupOpts.runSummary {
+		defer func() {
+			summary.Finish(err)
+			if emitErr := p.emitSummaryRecord(ctx, records, summary); emitErr != nil {
+				p.logger.Warn("unable to store run summary", "error", emitErr)
+			}
+		}()
+	}
+
+	if p.backupOpts.auditLog {
+		audit := p.client.EnableAudit()
+		startedAt := time.Now()
+		defer func() {
+			if err := p.emitAuditRecord(ctx, records, audit, startedAt); err != nil {
+				p.logger.Warn("unable to store audit record", "error", err)
+			}
+		}()
+	}
+
+	if p.selection.pbsHost {
+		return p.importPBSHost(ctx, records, summary)
+	}
+
+	if p.cfg.JobLock {
+		lock, err := p.client.AcquireJobLock(ctx, "import")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = lock.Release(context.Background())
+		}()
+	}
 
-	vmids, err := p.resolveVMIDs(ctx)
+	if p.backupOpts.engine == proxmox.EngineVzdump {
+		if err := p.client.CheckCompressionSupport(ctx); err != nil {
+			return err
+		}
+		if err := p.client.CheckBackupPaths(ctx); err != nil {
+			return err
+		}
+		if usage, err := p.client.DiskFree(ctx, p.cfg.DumpDir); err != nil {
+			p.logger.Debug("unable to read dump_dir usage", "error", err)
+		} else {
+			p.logger.Info("dump_dir usage", "dir", p.cfg.DumpDir, "avail", usage.Avail, "total", usage.Total)
+		}
+	}
+
+	resolveCtx, resolveSpan := p.client.Tracer().Start(ctx, "resolve_vmids")
+	vmids, err := p.resolveVMIDs(resolveCtx)
+	resolveSpan.SetAttribute("guests", len(vmids))
+	resolveSpan.End(err)
 	if err != nil {
 		return err
 	}
 	if len(vmids) == 0 {
 		return fmt.Errorf("no VM/CT found for selection")
 	}
+	if err := p.checkPermissions(ctx, vmids); err != nil {
+		return err
+	}
+	if p.backupOpts.engine == proxmox.EngineVzdump {
+		p.checkBackupTmpDir(ctx, vmids)
+	}
+	if err := p.orderVMIDs(ctx, vmids); err != nil {
+		return err
+	}
+	p.logger.Info("backup started", "selection", p.selection.String(), "guests", len(vmids), "order", p.backupOpts.order)
 
-	for _, vmid := range vmids {
-		if err := ctx.Err(); err != nil {
-			return err
-		}
+	pending := len(vmids)
+	metrics.Set(proxmox.MetricRunGuestsPending, float64(pending), "operation", "backup")
 
-		vmType, err := p.client.VMType(ctx, vmid)
+	var progress *checkpoint
+	if p.backupOpts.checkpointFile != "" {
+		progress, err = loadCheckpoint(p.backupOpts.checkpointFile, p.cfg.Origin(), p.selection.String(), p.backupOpts.checkpointWindow)
 		if err != nil {
 			return err
 		}
+		p.removeKeptArchives(ctx, progress.stale)
+		p.progress = progress
+	}
 
-		vmName, err := p.client.VMName(ctx, vmid)
+	if p.backupOpts.throttleIOWait > 0 {
+		stop, err := p.startIOThrottle(ctx)
 		if err != nil {
 			return err
 		}
+		defer stop()
+	}
 
-		backupRecord, err := p.buildBackupRecord(ctx, vmType, vmid, vmName)
-		if err != nil {
+	defer p.discardBatch()
+	if p.backupOpts.vzdumpAll {
+		if vmids, err = p.startVzdumpAll(ctx, vmids, progress); err != nil {
 			return err
 		}
-
-		archivePath := backupRecord.archivePath
-		archiveName := path.Base(archivePath)
-		if isInvalidArchiveName(archiveName) {
-			_ = backupRecord.record.Close()
-			return fmt.Errorf("invalid archive name for vmid %d: %q", vmid, archiveName)
+	}
+	for i, vmid := range vmids {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-
-		if err := p.emitRecord(ctx, records, backupRecord.record); err != nil {
+		if vmid, err = p.scheduleGuest(ctx, vmids, i, progress); err != nil {
 			return err
 		}
-
-		if vmType == "qemu" || vmType == "lxc" {
-			if err := p.emitVMConfigRecord(ctx, records, vmType, vmid, vmName, archiveName); err != nil {
-				return err
-			}
-			if err := p.emitVMPoolRecord(ctx, records, vmType, vmid, vmName, archiveName); err != nil {
+		p.prepareBatch(ctx, vmids[i:], progress)
+		startedAt := time.Now()
+		guestCtx, guestSpan := p.client.Tracer().Start(ctx, "backup_guest", "vmid", vmid)
+		outcome, err := p.importGuest(guestCtx, records, vmid)
+		guestSpan.SetAttribute("archive", outcome.archive)
+		guestSpan.SetAttribute("bytes", outcome.size)
+		guestSpan.SetAttribute("skipped", outcome.skipped)
+		guestSpan.End(err)
+		p.observeGuest(summary, vmid, startedAt, outcome, err)
+		if err != nil {
+			return err
+		}
+		if p.backupOpts.writeback && !outcome.skipped {
+			p.queueBackReference(vmid, outcome, startedAt)
+		}
+		if p.backupOpts.tagPaths && !outcome.skipped {
+			if err := p.emitTagLinks(ctx, records, outcome.vmType, vmid, outcome.vmName); err != nil {
 				return err
 			}
 		}
+		pending--
+		metrics.Set(proxmox.MetricRunGuestsPending, float64(pending), "operation", "backup")
 
-		if p.cfg.Cleanup && archivePath != "" && path.IsAbs(archivePath) {
-			if err := p.client.Remove(ctx, archivePath); err != nil {
+		if progress != nil && outcome.kept != "" {
+			if err := progress.markCompleted(vmid, outcome.kept); err != nil {
 				return err
 			}
 		}
 	}
 
+	p.logger.Info("backup finished", "guests", len(vmids))
+	if err := p.client.ConfirmImageBases(ctx); err != nil {
+		return err
+	}
+	if progress != nil {
+		p.removeKeptArchives(ctx, progress.Archives)
+		return progress.clear()
+	}
 	return nil
 }
 
+// removeKeptArchives removes the archives kept on the node for a
+// checkpoint, when cleanup is enabled.
+func (p *ProxmoxImporter) removeKeptArchives(ctx context.Context, archives map[int]string) {
+	if !p.cfg.Cleanup {
+		return
+	}
+	for vmid, archive := range archives {
+		if err := p.client.Remove(ctx, archive); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			p.logger.Warn("unable to remove archive kept for the checkpoint", "vmid", vmid, "path", archive, "error", err)
+		}
+	}
+}
+
+// guestOutcome describes what importGuest did for a single guest.
+type guestOutcome struct {
+	vmType   string
+	vmName   string
+	archive  string
+	size     int64
+	checksum string
+	skipped  bool
+	// kept is the archive left on the node for checkpoint_file, to be
+	// removed once the run succeeds.
+	kept   string
+	reason string
+}
+
+func (p *ProxmoxImporter) importGuest(ctx context.Context, records chan<- *connectors.Record, vmid int) (guestOutcome, error) {
+	var outcome guestOutcome
+
+	vmType, err := p.client.VMType(ctx, vmid)
+	if err != nil {
+		return outcome, err
+	}
+	outcome.vmType = vmType
+
+	vmName, err := p.client.VMName(ctx, vmid)
+	if err != nil {
+		return outcome, err
+	}
+	outcome.vmName = vmName
+	p.registerGuestAttributes(ctx, vmType, vmid, vmName)
+
+	p.logger.Info("backing up guest", "vmid", vmid, "type", vmType, "name", vmName)
+	revertMountpoints, err := p.overrideMountpoints(ctx, vmType, vmid)
+	if err != nil {
+		return outcome, err
+	}
+	if p.backupOpts.engine != proxmox.EngineVzdump {
+		return p.importGuestImages(ctx, records, outcome, vmid, revertMountpoints)
+	}
+	archivePath, owned, err := p.backupArchive(ctx, vmid)
+	revertMountpoints()
+	if err != nil {
+		if errors.Is(err, errBackupSkipped) {
+			p.logger.Warn("guest skipped", "vmid", vmid, "reason", err)
+			outcome.skipped = true
+			outcome.reason = err.Error()
+			skipped := connectors.NewError(buildBackupSnapshotPath(vmType, vmid, vmName, ""), err)
+			return outcome, p.emitRecord(ctx, records, skipped)
+		}
+		return outcome, err
+	}
+
+	archiveName := path.Base(archivePath)
+	if isInvalidArchiveName(archiveName) {
+		return outcome, fmt.Errorf("invalid archive name for vmid %d: %q", vmid, archiveName)
+	}
+	if p.cfg.DumpNameTemplate != "" || p.cfg.RewritesDumpTimestamps() {
+		node, err := p.client.VMNode(ctx, vmid)
+		if err != nil {
+			return outcome, fmt.Errorf("unable to resolve the node of vmid %d for the dump name: %w", vmid, err)
+		}
+		archiveName = proxmox.RenameDump(p.cfg, archiveName, vmName, node, p.dumpLocation(ctx))
+	}
+	if p.backupOpts.archiveGuestName {
+		archiveName = proxmox.DumpFilenameWithGuestName(archiveName, vmName)
+	}
+	outcome.archive = archiveName
+
+	fileInfo, err := p.client.Stat(ctx, archivePath)
+	if err != nil {
+		return outcome, err
+	}
+	outcome.size = fileInfo.Size()
+	if p.backupOpts.reportPath != "" {
+		outcome.checksum = p.archiveChecksum(ctx, archivePath)
+	}
+
+	segmented := p.backupOpts.segmentSize > 0 && fileInfo.Size() > p.backupOpts.segmentSize
+	p.logger.Info("archive ready", "vmid", vmid, "archive", archiveName, "size", fileInfo.Size(), "segmented", segmented)
+	// With checkpoint_file, owned archives stay on the node until the run
+	// succeeds, so a retry can import them again without vzdump.
+	keep := owned && p.progress != nil && path.IsAbs(archivePath)
+	if keep {
+		outcome.kept = archivePath
+	}
+	if segmented {
+		removeWhenDone := owned && !keep && p.cfg.Cleanup && path.IsAbs(archivePath)
+		if err := p.emitSegmentedArchive(ctx, records, vmType, vmid, vmName, archivePath, archiveName, fileInfo, removeWhenDone); err != nil {
+			return outcome, err
+		}
+	} else {
+		backupRecord, err := p.buildBackupRecord(ctx, vmType, vmid, vmName, archivePath)
+		if err != nil {
+			return outcome, err
+		}
+		backupRecord.record.Pathname = buildBackupSnapshotPath(vmType, vmid, vmName, archiveName)
+		backupRecord.record.FileInfo.Lname = archiveName
+		if err := p.emitRecord(ctx, records, backupRecord.record); err != nil {
+			return outcome, err
+		}
+	}
+
+	if err := p.emitSidecars(ctx, records, vmType, vmid, vmName, archiveName, fileInfo.ModTime()); err != nil {
+		return outcome, err
+	}
+
+	// Segmented archives are removed once their last part has been read.
+	if !segmented && owned && !keep && p.cfg.Cleanup && archivePath != "" && path.IsAbs(archivePath) {
+		if err := p.client.Remove(ctx, archivePath); err != nil {
+			return outcome, err
+		}
+	}
+
+	return outcome, nil
+}
+
+// dumpLocation returns the time zone of the node vzdump runs on, or the
+// local one when it cannot be read.
+func (p *ProxmoxImporter) dumpLocation(ctx context.Context) *time.Location {
+	if p.dumpLoc != nil {
+		return p.dumpLoc
+	}
+	p.dumpLoc = time.Local
+	node, err := p.client.LocalNode(ctx)
+	if err == nil {
+		var loc *time.Location
+		if loc, err = p.client.NodeLocation(ctx, node); err == nil {
+			p.dumpLoc = loc
+		}
+	}
+	if err != nil {
+		p.logger.Warn("unable to read the node time zone, using the local one for dump names", "error", err)
+	}
+	return p.dumpLoc
+}
+
+// queueBackReference prepares the line recording the archive just imported
+// in the guest description, so the PVE UI shows where the latest off-node
+// backup lives. It is written by writeBackReferences.
+func (p *ProxmoxImporter) queueBackReference(vmid int, outcome guestOutcome, startedAt time.Time) {
+	ref := fmt.Sprintf("last backup %s, archive %s", startedAt.UTC().Format(time.RFC3339), outcome.archive)
+	if p.hostname != "" {
+		ref += ", plakar host " + p.hostname
+	}
+	ref += ", origin " + p.cfg.Origin()
+
+	if p.backReferences == nil {
+		p.backReferences = make(map[int]string)
+	}
+	p.backReferences[vmid] = ref
+}
+
+// writeBackReferences writes the queued backup references to the guest
+// descriptions. Failures are only logged: the backup itself succeeded.
+func (p *ProxmoxImporter) writeBackReferences(ctx context.Context) {
+	for _, vmid := range slices.Sorted(maps.Keys(p.backReferences)) {
+		if err := p.client.SetBackupReference(ctx, vmid, p.backReferences[vmid]); err != nil {
+			p.logger.Warn("unable to write the backup reference to the guest description", "vmid", vmid, "error", err)
+		}
+	}
+	clear(p.backReferences)
+}
+
+// observeGuest updates the run metrics and summary once a guest has been
+// handled.
+func (p *ProxmoxImporter) observeGuest(summary *proxmox.RunSummary, vmid int, startedAt time.Time, outcome guestOutcome, err error) {
+	metrics := p.client.Metrics()
+	vmidLabel := strconv.Itoa(vmid)
+	duration := time.Since(startedAt).Seconds()
+
+	guest := proxmox.GuestSummary{
+		VMID:     vmid,
+		Type:     outcome.vmType,
+		Name:     outcome.vmName,
+		Archive:  outcome.archive,
+		Status:   proxmox.GuestStatusOK,
+		Bytes:    outcome.size,
+		Checksum: outcome.checksum,
+		Duration: duration,
+	}
+	switch {
+	case err != nil:
+		guest.Status = proxmox.GuestStatusFailed
+		guest.Error = err.Error()
+		guest.ErrorClass = proxmox.ErrorClass(err)
+	case outcome.skipped:
+		guest.Status = proxmox.GuestStatusSkipped
+		guest.Error = outcome.reason
+	}
+	summary.Add(guest)
+
+	metrics.Add(proxmox.MetricGuests, 1, "operation", "backup", "status", guest.Status)
+	metrics.Set(proxmox.MetricGuestDuration, duration, "operation", "backup", "vmid", vmidLabel)
+	if outcome.size > 0 {
+		metrics.Set(proxmox.MetricGuestBytes, float64(outcome.size), "operation", "backup", "vmid", vmidLabel)
+	}
+}
+
+func (s selection) String() string {
+	var value string
+	switch {
+	case s.vmid != nil:
+		return fmt.Sprintf("vmid=%d", *s.vmid)
+	case s.pool != "":
+		value = "pool=" + s.pool
+	case s.all:
+		value = "all"
+	case s.pbsHost:
+		return "pbs_host"
+	default:
+		return ""
+	}
+	if len(s.exclude) > 0 {
+		ids := make([]string, len(s.exclude))
+		for i, vmid := range s.exclude {
+			ids[i] = strconv.Itoa(vmid)
+		}
+		value += ",exclude=" + strings.Join(ids, ",")
+	}
+	return value
+}
+
+// Close is called by plakar once the snapshot of a successful Import is
+// committed, which is when the backup_writeback references are written.
 func (p *ProxmoxImporter) Close(ctx context.Context) error {
+	p.writeBackReferences(ctx)
 	return p.client.Close()
 }
 
 func (p *ProxmoxImporter) resolveVMIDs(ctx context.Context) ([]int, error) {
+	var (
+		vmids []int
+		err   error
+	)
 	switch {
 	case p.selection.vmid != nil:
 		return []int{*p.selection.vmid}, nil
 	case p.selection.pool != "":
-		return p.client.ListPoolVMIDs(ctx, p.selection.pool)
+		vmids, err = p.client.ListPoolVMIDs(ctx, p.selection.pool)
 	case p.selection.all:
-		return p.client.ListAllVMIDs(ctx)
+		vmids, err = p.client.ListAllVMIDs(ctx)
 	default:
-		return nil, fmt.Errorf("missing backup selection: vmid, pool or all")
+		return nil, fmt.Errorf("missing backup selection: vmid, pool, all or pbs_host")
+	}
+	if err != nil {
+		return nil, err
 	}
+	return slices.DeleteFunc(vmids, func(vmid int) bool {
+		return slices.Contains(p.selection.exclude, vmid)
+	}), nil
 }
 
-type backupRecord struct {
-	archivePath string
-	record      *connectors.Record
+// orderVMIDs sorts vmids in place according to backup_order. Ties keep
+// ascending VMID order.
+func (p *ProxmoxImporter) orderVMIDs(ctx context.Context, vmids []int) error {
+	sort.Ints(vmids)
+
+	switch p.backupOpts.order {
+	case backupOrderSize:
+		sizes := make(map[int]int64, len(vmids))
+		for _, vmid := range vmids {
+			size, err := p.client.VMDiskSize(ctx, vmid)
+			if err != nil {
+				return err
+			}
+			sizes[vmid] = size
+		}
+		sort.SliceStable(vmids, func(i, j int) bool {
+			return sizes[vmids[i]] < sizes[vmids[j]]
+		})
+	case backupOrderPriorityMap:
+		sort.SliceStable(vmids, func(i, j int) bool {
+			return p.backupOpts.priorities[vmids[i]] > p.backupOpts.priorities[vmids[j]]
+		})
+	}
+	return nil
 }
 
-func (p *ProxmoxImporter) buildBackupRecord(ctx context.Context, vmType string, vmid int, vmName string) (*backupRecord, error) {
+var errBackupSkipped = errors.New("backup skipped")
+
+// backupArchive produces the archive to import for vmid: the one an
+// interrupted run kept for the checkpoint when it is still there, or a new
+// dump. When a foreign vzdump job is already backing up the guest,
+// running_backup decides whether to skip it, or to wait and import that
+// job's output (which is then not owned by us and never cleaned up).
+func (p *ProxmoxImporter) backupArchive(ctx context.Context, vmid int) (string, bool, error) {
+	if archive := p.progress.archive(vmid); archive != "" {
+		if _, err := p.client.Stat(ctx, archive); err == nil {
+			p.logger.Info("importing the archive kept by an interrupted run", "vmid", vmid, "archive", archive, "checkpoint", p.backupOpts.checkpointFile)
+			return archive, true, nil
+		}
+		p.logger.Warn("archive kept by an interrupted run is gone, backing up again", "vmid", vmid, "archive", archive)
+	}
+	if archive, ok, err := p.batch.take(vmid); err != nil || ok {
+		return archive, ok, err
+	}
+	if p.backupOpts.runningBackup != runningBackupIgnore {
+		running, err := p.client.RunningBackup(ctx, vmid)
+		if err != nil {
+			return "", false, err
+		}
+		if running != nil {
+			if p.backupOpts.runningBackup == runningBackupSkip {
+				return "", false, fmt.Errorf("%w: vzdump task %s is already running for vmid %d", errBackupSkipped, running.UPID, vmid)
+			}
+			if err := p.client.WaitRunningBackup(ctx, vmid, p.backupOpts.runningBackupTimeout); err != nil {
+				return "", false, err
+			}
+			archivePath, err := p.client.FindDumpSince(ctx, vmid, running.StartedAt)
+			if err != nil {
+				return "", false, err
+			}
+			if archivePath != "" {
+				return archivePath, false, nil
+			}
+		}
+	}
+
 	archivePath, err := p.client.BackupVM(ctx, vmid)
 	if err != nil {
-		return nil, err
+		return "", false, err
 	}
+	return archivePath, true, nil
+}
 
+type backupRecord struct {
+	archivePath string
+	record      *connectors.Record
+}
+
+func (p *ProxmoxImporter) buildBackupRecord(ctx context.Context, vmType string, vmid int, vmName, archivePath string) (*backupRecord, error) {
 	fileInfo, err := p.client.Stat(ctx, archivePath)
 	if err != nil {
 		return nil, err
 	}
 
-	reader, err := p.client.Open(ctx, archivePath)
+	reader, err := p.openArchive(ctx, archivePath, fileInfo.Size())
 	if err != nil {
 		return nil, err
 	}
@@ -207,7 +796,30 @@ func (p *ProxmoxImporter) buildBackupRecord(ctx context.Context, vmType string,
 	}, nil
 }
 
-func (p *ProxmoxImporter) emitVMConfigRecord(ctx context.Context, records chan<- *connectors.Record, vmType string, vmid int, vmName, archiveName string) error {
+// emitSidecars emits the config, pool and metadata sidecars of an archive.
+func (p *ProxmoxImporter) emitSidecars(ctx context.Context, records chan<- *connectors.Record, vmType string, vmid int, vmName, archiveName string, modTime time.Time) error {
+	if vmType != "qemu" && vmType != "lxc" {
+		return nil
+	}
+	configData, err := p.emitVMConfigRecord(ctx, records, vmType, vmid, vmName, archiveName, modTime)
+	if err != nil {
+		return err
+	}
+	if err := p.emitVMPoolRecord(ctx, records, vmType, vmid, vmName, archiveName, modTime); err != nil {
+		return err
+	}
+	var bindMounts []proxmox.BindMount
+	if vmType == "lxc" {
+		if bindMounts, err = p.emitBindMounts(ctx, records, vmid, vmName, archiveName, modTime, configData); err != nil {
+			return err
+		}
+	}
+	return p.emitMetadataRecord(ctx, records, vmType, vmid, vmName, archiveName, modTime, configData, bindMounts)
+}
+
+// emitVMConfigRecord emits the guest config sidecar and returns the config.
+// Sidecars are captured with their archive and carry its node-side mtime.
+func (p *ProxmoxImporter) emitVMConfigRecord(ctx context.Context, records chan<- *connectors.Record, vmType string, vmid int, vmName, archiveName string, modTime time.Time) ([]byte, error) {
 	var (
 		configData []byte
 		configName string
@@ -222,10 +834,10 @@ func (p *ProxmoxImporter) emitVMConfigRecord(ctx context.Context, records chan<-
 		configData, err = p.client.ReadLXCConfig(ctx, vmid)
 		configName = proxmox.BuildLXCConfigSidecarFilename(archiveName)
 	default:
-		return nil
+		return nil, nil
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	record := &connectors.Record{
@@ -234,16 +846,16 @@ func (p *ProxmoxImporter) emitVMConfigRecord(ctx context.Context, records chan<-
 			Lname:    configName,
 			Lsize:    int64(len(configData)),
 			Lmode:    0600,
-			LmodTime: time.Now(),
+			LmodTime: modTime,
 			Ldev:     1,
 		},
 		Reader: io.NopCloser(bytes.NewReader(configData)),
 	}
 
-	return p.emitRecord(ctx, records, record)
+	return configData, p.emitRecord(ctx, records, record)
 }
 
-func (p *ProxmoxImporter) emitVMPoolRecord(ctx context.Context, records chan<- *connectors.Record, vmType string, vmid int, vmName, archiveName string) error {
+func (p *ProxmoxImporter) emitVMPoolRecord(ctx context.Context, records chan<- *connectors.Record, vmType string, vmid int, vmName, archiveName string, modTime time.Time) error {
 	poolName, err := p.client.VMPool(ctx, vmid)
 	if err != nil {
 		return err
@@ -262,7 +874,7 @@ func (p *ProxmoxImporter) emitVMPoolRecord(ctx context.Context, records chan<- *
 			Lname:    poolSidecarName,
 			Lsize:    int64(len(poolData)),
 			Lmode:    0600,
-			LmodTime: time.Now(),
+			LmodTime: modTime,
 			Ldev:     1,
 		},
 		Reader: io.NopCloser(bytes.NewReader(poolData)),
@@ -271,7 +883,67 @@ func (p *ProxmoxImporter) emitVMPoolRecord(ctx context.Context, records chan<- *
 	return p.emitRecord(ctx, records, record)
 }
 
+// emitMetadataRecord emits the DumpMetadata sidecar. Hypervisor versions
+// are best effort: a node where pveversion fails still gets its backup.
+func (p *ProxmoxImporter) emitMetadataRecord(ctx context.Context, records chan<- *connectors.Record, vmType string, vmid int, vmName, archiveName string, modTime time.Time, configData []byte, bindMounts []proxmox.BindMount) error {
+	node, err := p.client.VMNode(ctx, vmid)
+	if err != nil {
+		return err
+	}
+	createdAt, ok := proxmox.ParseDumpTimestamp(archiveName)
+	if !ok {
+		createdAt = modTime
+	}
+
+	versions, err := p.client.HypervisorVersions(ctx)
+	if err != nil {
+		p.logger.Warn("unable to read hypervisor versions", "vmid", vmid, "error", err)
+	}
+
+	metaData, err := proxmox.EncodeDumpMetadata(proxmox.DumpMetadata{
+		Version:     proxmox.DumpMetadataVersion,
+		Minor:       proxmox.DumpMetadataMinor,
+		Archive:     archiveName,
+		Engine:      proxmox.ArchiveEngine(archiveName),
+		VMID:        vmid,
+		Type:        vmType,
+		Name:        vmName,
+		Node:        node,
+		Cluster:     p.cfg.Cluster,
+		CreatedAt:   createdAt.UTC(),
+		Hypervisor:  versions,
+		Disks:       applyMountpoints(proxmox.DiskLayouts(vmType, configData), p.backupOpts.mountpoints[vmid]),
+		Mountpoints: p.backupOpts.mountpoints[vmid],
+		BindMounts:  bindMounts,
+		Settings:    proxmox.ParseGuestSettings(configData),
+	})
+	if err != nil {
+		return err
+	}
+
+	metaName := proxmox.BuildDumpMetadataFilename(archiveName)
+	return p.emitRecord(ctx, records, &connectors.Record{
+		Pathname: buildBackupSnapshotPath(vmType, vmid, vmName, metaName),
+		FileInfo: objects.FileInfo{
+			Lname:    metaName,
+			Lsize:    int64(len(metaData)),
+			Lmode:    0600,
+			LmodTime: modTime,
+			Ldev:     1,
+		},
+		Reader: io.NopCloser(bytes.NewReader(metaData)),
+	})
+}
+
 func (p *ProxmoxImporter) emitRecord(ctx context.Context, records chan<- *connectors.Record, record *connectors.Record) error {
+	if record.Err == nil && record.FileInfo.Lmode.IsRegular() {
+		if err := p.emitParentDirs(ctx, records, record.Pathname); err != nil {
+			_ = record.Close()
+			return err
+		}
+		return p.emitWithAttributes(ctx, records, record, path.Dir(record.Pathname))
+	}
+
 	select {
 	case <-ctx.Done():
 		_ = record.Close()
@@ -328,6 +1000,229 @@ func sanitizeSnapshotDirComponent(value string) string {
 	return strings.Trim(b.String(), "._-")
 }
 
+func parseBackupOptions(config map[string]string) (backupOptions, error) {
+	opts := backupOptions{
+		runningBackup:        runningBackupIgnore,
+		runningBackupTimeout: defaultRunningBackupTimeout,
+	}
+
+	if value := strings.ToLower(strings.TrimSpace(config["running_backup"])); value != "" {
+		switch value {
+		case runningBackupIgnore, runningBackupWait, runningBackupSkip:
+			opts.runningBackup = value
+		default:
+			return opts, fmt.Errorf("invalid running_backup value: %s", value)
+		}
+	}
+
+	if raw := strings.TrimSpace(config["running_backup_timeout"]); raw != "" {
+		minutes, err := strconv.Atoi(raw)
+		if err != nil || minutes <= 0 {
+			return opts, fmt.Errorf("invalid running_backup_timeout value: %s", raw)
+		}
+		opts.runningBackupTimeout = time.Duration(minutes) * time.Minute
+	}
+
+	if raw := strings.TrimSpace(config["segment_size"]); raw != "" {
+		mib, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || mib < 0 {
+			return opts, fmt.Errorf("invalid segment_size value: %s", raw)
+		}
+		opts.segmentSize = mib << 20
+	}
+
+	if raw := strings.TrimSpace(config["lockwait"]); raw != "" {
+		minutes, err := strconv.Atoi(raw)
+		if err != nil || minutes < 0 {
+			return opts, fmt.Errorf("invalid lockwait value: %s", raw)
+		}
+		opts.lockWait = time.Duration(minutes) * time.Minute
+	}
+
+	if raw := strings.TrimSpace(config["stall_retries"]); raw != "" {
+		retries, err := strconv.Atoi(raw)
+		if err != nil || retries < 0 {
+			return opts, fmt.Errorf("invalid stall_retries value: %s", raw)
+		}
+		opts.stallRetries = retries
+	}
+
+	if raw := strings.TrimSpace(config["backup_writeback"]); raw != "" {
+		writeback, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid backup_writeback value: %s", raw)
+		}
+		opts.writeback = writeback
+	}
+
+	opts.engine = proxmox.EngineVzdump
+	if value := strings.ToLower(strings.TrimSpace(config["engine"])); value != "" {
+		if !slices.Contains(proxmox.BackupEngines, value) {
+			return opts, fmt.Errorf("invalid engine value: %s", value)
+		}
+		opts.engine = value
+	}
+
+	if raw := strings.TrimSpace(config["engine_incremental"]); raw != "" {
+		incremental, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid engine_incremental value: %s", raw)
+		}
+		if incremental && !slices.Contains(proxmox.IncrementalEngines, opts.engine) {
+			return opts, fmt.Errorf("engine_incremental is not supported by engine=%s", opts.engine)
+		}
+		opts.engineIncremental = incremental
+	}
+
+	if raw := strings.TrimSpace(config["backup_net_bwlimit"]); raw != "" {
+		limit, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || limit < 0 {
+			return opts, fmt.Errorf("invalid backup_net_bwlimit value: %s", raw)
+		}
+		opts.netBWLimit = limit
+	}
+
+	opts.order = backupOrderVMID
+	if value := strings.ToLower(strings.TrimSpace(config["backup_order"])); value != "" {
+		switch value {
+		case backupOrderVMID, backupOrderSize, backupOrderPriorityMap:
+			opts.order = value
+		default:
+			return opts, fmt.Errorf("invalid backup_order value: %s", value)
+		}
+	}
+
+	priorities, err := parsePriorityMap(config["backup_priority_map"])
+	if err != nil {
+		return opts, err
+	}
+	if opts.order == backupOrderPriorityMap && len(priorities) == 0 {
+		return opts, fmt.Errorf("backup_order=priority_map requires backup_priority_map")
+	}
+	opts.priorities = priorities
+
+	mountpoints, err := parseMountpointMap(config["backup_mountpoints"])
+	if err != nil {
+		return opts, err
+	}
+	opts.mountpoints = mountpoints
+
+	if err := parseBindMountOptions(config, &opts); err != nil {
+		return opts, err
+	}
+
+	if raw := strings.TrimSpace(config["vzdump_batch"]); raw != "" {
+		batch, err := strconv.Atoi(raw)
+		if err != nil || batch < 0 {
+			return opts, fmt.Errorf("invalid vzdump_batch value: %s", raw)
+		}
+		opts.vzdumpBatch = batch
+	}
+
+	if raw := strings.TrimSpace(config["vzdump_all"]); raw != "" {
+		vzdumpAll, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid vzdump_all value: %s", raw)
+		}
+		opts.vzdumpAll = vzdumpAll
+	}
+	if opts.vzdumpAll && opts.vzdumpBatch > 0 {
+		return opts, fmt.Errorf("vzdump_all and vzdump_batch cannot be used together")
+	}
+
+	if raw := strings.TrimSpace(config["archive_guest_name"]); raw != "" {
+		archiveGuestName, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid archive_guest_name value: %s", raw)
+		}
+		opts.archiveGuestName = archiveGuestName
+	}
+	if opts.archiveGuestName && strings.TrimSpace(config["dump_name_template"]) != "" {
+		return opts, fmt.Errorf("archive_guest_name and dump_name_template cannot be used together")
+	}
+
+	if raw := strings.TrimSpace(config["tag_paths"]); raw != "" {
+		tagPaths, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid tag_paths value: %s", raw)
+		}
+		opts.tagPaths = tagPaths
+	}
+
+	if err := parseLoadOptions(config, &opts); err != nil {
+		return opts, err
+	}
+
+	if raw := strings.TrimSpace(config["audit_log"]); raw != "" {
+		auditLog, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid audit_log value: %s", raw)
+		}
+		opts.auditLog = auditLog
+	}
+
+	opts.runSummary = true
+	if raw := strings.TrimSpace(config["run_summary"]); raw != "" {
+		runSummary, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid run_summary value: %s", raw)
+		}
+		opts.runSummary = runSummary
+	}
+
+	opts.reportPath = strings.TrimSpace(config["backup_report"])
+	opts.reportFormat = strings.ToLower(strings.TrimSpace(config["backup_report_format"]))
+	switch opts.reportFormat {
+	case "":
+		opts.reportFormat = proxmox.ReportFormatJSON
+	case proxmox.ReportFormatJSON, proxmox.ReportFormatHTML:
+	default:
+		return opts, fmt.Errorf("invalid backup_report_format value: %s", opts.reportFormat)
+	}
+
+	opts.checkpointFile = strings.TrimSpace(config["checkpoint_file"])
+	opts.checkpointWindow = defaultCheckpointWindow
+	if raw := strings.TrimSpace(config["checkpoint_window"]); raw != "" {
+		hours, err := strconv.Atoi(raw)
+		if err != nil || hours <= 0 {
+			return opts, fmt.Errorf("invalid checkpoint_window value: %s", raw)
+		}
+		opts.checkpointWindow = time.Duration(hours) * time.Hour
+	}
+
+	return opts, nil
+}
+
+// parsePriorityMap parses a comma-separated list of vmid:priority pairs.
+func parsePriorityMap(value string) (map[int]int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	priorities := make(map[int]int)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		vmidStr, priorityStr, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid backup_priority_map entry: %s", entry)
+		}
+		vmid, err := strconv.Atoi(strings.TrimSpace(vmidStr))
+		if err != nil || vmid <= 0 {
+			return nil, fmt.Errorf("invalid backup_priority_map entry: %s", entry)
+		}
+		priority, err := strconv.Atoi(strings.TrimSpace(priorityStr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup_priority_map entry: %s", entry)
+		}
+		priorities[vmid] = priority
+	}
+	return priorities, nil
+}
+
 func parseSelection(config map[string]string) (selection, error) {
 	var sel selection
 
@@ -356,6 +1251,14 @@ func parseSelection(config map[string]string) (selection, error) {
 		}
 	}
 
+	if raw := strings.TrimSpace(config["pbs_host"]); raw != "" {
+		pbsHost, err := strconv.ParseBool(raw)
+		if err != nil {
+			return sel, fmt.Errorf("invalid pbs_host value: %s", raw)
+		}
+		sel.pbsHost = pbsHost
+	}
+
 	setCount := 0
 	if sel.vmid != nil {
 		setCount++
@@ -366,12 +1269,27 @@ func parseSelection(config map[string]string) (selection, error) {
 	if sel.all {
 		setCount++
 	}
-
-	if setCount == 0 {
-		return sel, nil
+	if sel.pbsHost {
+		setCount++
 	}
+
 	if setCount > 1 {
-		return sel, fmt.Errorf("backup selection must specify only one of vmid, pool or all")
+		return sel, fmt.Errorf("backup selection must specify only one of vmid, pool, all or pbs_host")
+	}
+
+	for _, entry := range strings.Split(config["exclude"], ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		vmid, err := strconv.Atoi(entry)
+		if err != nil || vmid <= 0 {
+			return sel, fmt.Errorf("invalid exclude entry: %s", entry)
+		}
+		sel.exclude = append(sel.exclude, vmid)
+	}
+	if len(sel.exclude) > 0 && sel.pool == "" && !sel.all {
+		return sel, fmt.Errorf("exclude requires pool or all")
 	}
 
 	return sel, nil
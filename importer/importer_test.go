@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/gillesdubois/plakar-integration-proxmox/importer"
+	"github.com/gillesdubois/plakar-integration-proxmox/proxmoxtest"
+)
+
+// importedFile is a regular file record emitted by the importer.
+type importedFile struct {
+	path string
+	data []byte
+	err  error
+}
+
+func newTestNode() *proxmoxtest.Node {
+	return proxmoxtest.NewNode("pve1",
+		proxmoxtest.Guest{VMID: 100, Type: "qemu", Name: "web", Pool: "prod", Archive: []byte("vma-100"), Config: "name: web\nmemory: 512\n"},
+		proxmoxtest.Guest{VMID: 101, Type: "lxc", Name: "db", Pool: "prod", Archive: []byte("tar-101"), Config: "hostname: db\n"},
+		proxmoxtest.Guest{VMID: 102, Type: "qemu", Name: "lab", Archive: []byte("vma-102"), Config: "name: lab\n"},
+	)
+}
+
+// runImport runs an import against node and returns the regular files it
+// emitted, read to the end.
+func runImport(t *testing.T, node *proxmoxtest.Node, config map[string]string) ([]importedFile, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	config["location"] = "proxmox://pve1"
+	config["mode"] = "local"
+	imp, err := importer.NewProxmoxImporterWithRunner(ctx, nil, "proxmox", config, node)
+	if err != nil {
+		t.Fatalf("NewProxmoxImporterWithRunner: %v", err)
+	}
+	defer imp.Close(ctx)
+
+	records := make(chan *connectors.Record, 16)
+	results := make(chan *connectors.Result, 16)
+	done := make(chan []importedFile)
+	go func() {
+		var files []importedFile
+		for record := range records {
+			if record.IsXattr || record.Err != nil || !record.FileInfo.Lmode.IsRegular() {
+				continue
+			}
+			data, err := io.ReadAll(record.Reader)
+			record.Close()
+			files = append(files, importedFile{path: record.Pathname, data: data, err: err})
+		}
+		done <- files
+	}()
+	err = imp.Import(ctx, records, results)
+	return <-done, err
+}
+
+// dumpsOf returns the VMIDs of the vzdump archives among files.
+func dumpsOf(files []importedFile) []string {
+	var vmids []string
+	for _, file := range files {
+		base := path.Base(file.path)
+		if ext := path.Ext(base); !strings.HasPrefix(base, "vzdump-") || ext != ".vma" && ext != ".tar" {
+			continue
+		}
+		vmids = append(vmids, strings.Split(base, "-")[2])
+	}
+	slices.Sort(vmids)
+	return vmids
+}
+
+func TestImportSelection(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		want   []string
+	}{
+		{"vmid", map[string]string{"vmid": "101"}, []string{"101"}},
+		{"pool", map[string]string{"pool": "prod"}, []string{"100", "101"}},
+		{"pool with exclude", map[string]string{"pool": "prod", "exclude": "100"}, []string{"101"}},
+		{"all", map[string]string{"all": "true"}, []string{"100", "101", "102"}},
+		{"all with exclude", map[string]string{"all": "true", "exclude": "101,102"}, []string{"100"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := runImport(t, newTestNode(), tt.config)
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			if got := dumpsOf(files); !slices.Equal(got, tt.want) {
+				t.Errorf("dumped guests = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportArchiveContent(t *testing.T) {
+	files, err := runImport(t, newTestNode(), map[string]string{"vmid": "100"})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	var dump, config *importedFile
+	for i, file := range files {
+		switch {
+		case strings.HasSuffix(file.path, ".vma"):
+			dump = &files[i]
+		case strings.HasSuffix(file.path, ".vma_qemu.conf"):
+			config = &files[i]
+		}
+	}
+	if dump == nil || config == nil {
+		t.Fatalf("missing dump or config sidecar in %v", files)
+	}
+	if got := string(dump.data); got != "vma-100" {
+		t.Errorf("dump content = %q, want %q", got, "vma-100")
+	}
+	if got := string(config.data); !strings.Contains(got, "name: web") {
+		t.Errorf("config sidecar = %q, want the guest config", got)
+	}
+	if want := "/backup/qemu/100_web/"; !strings.HasPrefix(dump.path, want) {
+		t.Errorf("dump path = %s, want it under %s", dump.path, want)
+	}
+}
+
+func TestImportCleanup(t *testing.T) {
+	tests := []struct {
+		name    string
+		cleanup string
+		kept    bool
+	}{
+		{"cleanup", "true", false},
+		{"no cleanup", "false", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := newTestNode()
+			if _, err := runImport(t, node, map[string]string{"vmid": "100", "cleanup": tt.cleanup}); err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			var dumps []string
+			for _, name := range node.Files() {
+				if strings.HasPrefix(name, node.DumpDir+"/vzdump-") {
+					dumps = append(dumps, name)
+				}
+			}
+			if kept := len(dumps) > 0; kept != tt.kept {
+				t.Errorf("dumps left on the node = %v, want kept=%v", dumps, tt.kept)
+			}
+		})
+	}
+}
+
+func TestImportFailures(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*proxmoxtest.Node)
+		want  string
+	}{
+		{
+			name: "vzdump fails",
+			setup: func(n *proxmoxtest.Node) {
+				n.Failures = map[string]string{"vzdump": "ERROR: Backup of VM 100 failed - no space left on device"}
+			},
+			want: "no space left on device",
+		},
+		{
+			name:  "guest locked",
+			setup: func(n *proxmoxtest.Node) { g, _ := n.Guest(100); g.Lock = "backup"; n.AddGuest(g) },
+			want:  "locked",
+		},
+		{
+			name:  "read fails mid-stream",
+			setup: func(n *proxmoxtest.Node) { n.StreamFailAfter = 3 },
+			want:  proxmoxtest.ErrStreamFailed.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := newTestNode()
+			tt.setup(node)
+			files, err := runImport(t, node, map[string]string{"vmid": "100"})
+			for _, file := range files {
+				if file.err != nil {
+					err = errors.Join(err, file.err)
+				}
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Import error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"context"
+	"fmt"
+)
+
+// InventoryEntry is a guest a backup of the current selection would
+// import, and where its records would be stored.
+type InventoryEntry struct {
+	Node string `json:"node"`
+	VMID int    `json:"vmid"`
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+	Pool string `json:"pool,omitempty"`
+	Path string `json:"path"`
+
+	// EstimatedSize is the provisioned disk size of the guest, an upper
+	// bound of what its archive will hold.
+	EstimatedSize int64 `json:"estimated_size"`
+}
+
+// Inventory lists the guests Import would back up, in backup order,
+// without dumping anything. It only queries the cluster inventory, so UIs
+// can show what a source contains before committing to a backup.
+func (p *ProxmoxImporter) Inventory(ctx context.Context) ([]InventoryEntry, error) {
+	vmids, err := p.resolveVMIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.orderVMIDs(ctx, vmids); err != nil {
+		return nil, err
+	}
+
+	entries := make([]InventoryEntry, 0, len(vmids))
+	for _, vmid := range vmids {
+		entry, err := p.inventoryEntry(ctx, vmid)
+		if err != nil {
+			return nil, fmt.Errorf("vmid %d: %w", vmid, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (p *ProxmoxImporter) inventoryEntry(ctx context.Context, vmid int) (InventoryEntry, error) {
+	entry := InventoryEntry{VMID: vmid}
+	var err error
+	if entry.Type, err = p.client.VMType(ctx, vmid); err != nil {
+		return entry, err
+	}
+	if entry.Name, err = p.client.VMName(ctx, vmid); err != nil {
+		return entry, err
+	}
+	if entry.Node, err = p.client.VMNode(ctx, vmid); err != nil {
+		return entry, err
+	}
+	if entry.Pool, err = p.client.VMPool(ctx, vmid); err != nil {
+		return entry, err
+	}
+	if entry.EstimatedSize, err = p.client.VMDiskSize(ctx, vmid); err != nil {
+		return entry, err
+	}
+	entry.Path = buildBackupSnapshotPath(entry.Type, vmid, entry.Name, "")
+	return entry, nil
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nodeLoadPollInterval is how often the nodes are checked again while all
+// of them are above the load thresholds.
+const nodeLoadPollInterval = 30 * time.Second
+
+const defaultLoadWait = 30 * time.Minute
+
+// ioThrottleInterval is how often backup_throttle_iowait samples the IO wait
+// of the node.
+const ioThrottleInterval = 10 * time.Second
+
+// defaultThrottleBWLimit is the backup_throttle_bwlimit default, in KiB/s.
+const defaultThrottleBWLimit = 10 * 1024
+
+func parseLoadOptions(config map[string]string, opts *backupOptions) error {
+	if raw := strings.TrimSpace(config["backup_max_load"]); raw != "" {
+		load, err := strconv.ParseFloat(raw, 64)
+		if err != nil || load <= 0 {
+			return fmt.Errorf("invalid backup_max_load value: %s", raw)
+		}
+		opts.maxLoad = load
+	}
+
+	if raw := strings.TrimSpace(config["backup_max_iowait"]); raw != "" {
+		iowait, err := strconv.ParseFloat(raw, 64)
+		if err != nil || iowait <= 0 || iowait > 100 {
+			return fmt.Errorf("invalid backup_max_iowait value: %s", raw)
+		}
+		opts.maxIOWait = iowait
+	}
+
+	opts.loadWait = defaultLoadWait
+	if raw := strings.TrimSpace(config["backup_load_wait"]); raw != "" {
+		minutes, err := strconv.Atoi(raw)
+		if err != nil || minutes < 0 {
+			return fmt.Errorf("invalid backup_load_wait value: %s", raw)
+		}
+		opts.loadWait = time.Duration(minutes) * time.Minute
+	}
+
+	if raw := strings.TrimSpace(config["backup_throttle_iowait"]); raw != "" {
+		iowait, err := strconv.ParseFloat(raw, 64)
+		if err != nil || iowait <= 0 || iowait > 100 {
+			return fmt.Errorf("invalid backup_throttle_iowait value: %s", raw)
+		}
+		opts.throttleIOWait = iowait
+	}
+
+	opts.throttleBWLimit = defaultThrottleBWLimit
+	if raw := strings.TrimSpace(config["backup_throttle_bwlimit"]); raw != "" {
+		limit, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("invalid backup_throttle_bwlimit value: %s", raw)
+		}
+		opts.throttleBWLimit = limit
+	}
+	if opts.netBWLimit > 0 {
+		opts.throttleBWLimit = min(opts.throttleBWLimit, opts.netBWLimit)
+	}
+	return nil
+}
+
+// scheduleGuest returns the guest to back up at position i of vmids. When
+// the node hosting vmids[i] is above backup_max_load or backup_max_iowait,
+// the next guest hosted on a calmer node is moved up to i instead. When
+// every node is busy, it waits for one to calm down for up to
+// backup_load_wait, then goes on with vmids[i] anyway.
+func (p *ProxmoxImporter) scheduleGuest(ctx context.Context, vmids []int, i int, progress *checkpoint) (int, error) {
+	if p.backupOpts.maxLoad == 0 && p.backupOpts.maxIOWait == 0 {
+		return vmids[i], nil
+	}
+
+	deadline := time.Now().Add(p.backupOpts.loadWait)
+	for {
+		busy := make(map[string]bool)
+		for j := i; j < len(vmids); j++ {
+			vmid := vmids[j]
+			if j > i && progress != nil && progress.isCompleted(vmid) {
+				continue
+			}
+			// Already dumped by a multi-guest job: importing it adds no
+			// load to the node.
+			if !p.batch.holds(vmid) {
+				node, err := p.client.VMNode(ctx, vmid)
+				if err != nil {
+					return 0, err
+				}
+				if _, ok := busy[node]; !ok {
+					busy[node] = p.nodeBusy(ctx, node)
+				}
+				if busy[node] {
+					continue
+				}
+			}
+
+			if j > i {
+				p.logger.Info("node above load thresholds, backing up another guest first", "vmid", vmids[i], "next", vmid)
+				copy(vmids[i+1:j+1], vmids[i:j])
+				vmids[i] = vmid
+			}
+			return vmid, nil
+		}
+
+		if time.Now().Add(nodeLoadPollInterval).After(deadline) {
+			if p.backupOpts.loadWait > 0 {
+				p.logger.Warn("node still above load thresholds, backing up anyway", "vmid", vmids[i], "waited", p.backupOpts.loadWait)
+			}
+			return vmids[i], nil
+		}
+		p.logger.Info("every node above load thresholds, waiting", "vmid", vmids[i], "retry_in", nodeLoadPollInterval)
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(nodeLoadPollInterval):
+		}
+	}
+}
+
+// nodeBusy reports whether node is above the load thresholds. A node whose
+// status cannot be read is not held back.
+func (p *ProxmoxImporter) nodeBusy(ctx context.Context, node string) bool {
+	load, err := p.client.NodeLoad(ctx, node)
+	if err != nil {
+		p.logger.Warn("unable to read node load", "node", node, "error", err)
+		return false
+	}
+	if p.backupOpts.maxLoad > 0 && load.Load > p.backupOpts.maxLoad {
+		p.logger.Info("node above load threshold", "node", node, "load", load.Load, "max", p.backupOpts.maxLoad)
+		return true
+	}
+	if p.backupOpts.maxIOWait > 0 && load.IOWait > p.backupOpts.maxIOWait {
+		p.logger.Info("node above IO wait threshold", "node", node, "iowait", load.IOWait, "max", p.backupOpts.maxIOWait)
+		return true
+	}
+	return false
+}
+
+// startIOThrottle samples the IO wait of the node archives are read from
+// every ioThrottleInterval, and slows archive reads down to
+// backup_throttle_bwlimit while it is above backup_throttle_iowait. The
+// returned function stops it and restores backup_net_bwlimit.
+func (p *ProxmoxImporter) startIOThrottle(ctx context.Context) (func(), error) {
+	node, err := p.client.LocalNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer p.bwLimiter.SetLimit(p.backupOpts.netBWLimit)
+
+		ticker := time.NewTicker(ioThrottleInterval)
+		defer ticker.Stop()
+		throttled := false
+		for {
+			load, err := p.client.NodeLoad(ctx, node)
+			switch {
+			case err != nil:
+				if ctx.Err() == nil {
+					p.logger.Warn("unable to read node load", "node", node, "error", err)
+				}
+			case load.IOWait > p.backupOpts.throttleIOWait && !throttled:
+				throttled = true
+				p.bwLimiter.SetLimit(p.backupOpts.throttleBWLimit)
+				p.logger.Warn("node IO wait above threshold, throttling archive reads", "node", node, "iowait", load.IOWait, "bwlimit", p.backupOpts.throttleBWLimit)
+			case load.IOWait <= p.backupOpts.throttleIOWait && throttled:
+				throttled = false
+				p.bwLimiter.SetLimit(p.backupOpts.netBWLimit)
+				p.logger.Info("node IO wait back below threshold, resuming full speed", "node", node, "iowait", load.IOWait)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}, nil
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+var mountpointKeyRegex = regexp.MustCompile(`^mp\d+$`)
+
+// parseMountpointMap parses backup_mountpoints, comma-separated
+// <vmid>:<mpN>=<bool> entries.
+func parseMountpointMap(value string) (map[int]map[string]bool, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	mountpoints := make(map[int]map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		vmidStr, toggle, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid backup_mountpoints entry: %s", entry)
+		}
+		vmid, err := strconv.Atoi(strings.TrimSpace(vmidStr))
+		if err != nil || vmid <= 0 {
+			return nil, fmt.Errorf("invalid backup_mountpoints entry: %s", entry)
+		}
+		key, raw, ok := strings.Cut(toggle, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !mountpointKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("invalid backup_mountpoints entry: %s", entry)
+		}
+		backed, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup_mountpoints entry: %s", entry)
+		}
+		if mountpoints[vmid] == nil {
+			mountpoints[vmid] = make(map[string]bool)
+		}
+		mountpoints[vmid][key] = backed
+	}
+	return mountpoints, nil
+}
+
+// overrideMountpoints flips the backup flag of the container mount points
+// listed in backup_mountpoints before the guest is dumped. The returned
+// function puts the original flags back and must be called once the dump
+// exists. Mount point changes of a running container are only pending
+// until its next start, so running containers are refused.
+func (p *ProxmoxImporter) overrideMountpoints(ctx context.Context, vmType string, vmid int) (func(), error) {
+	overrides := p.backupOpts.mountpoints[vmid]
+	if len(overrides) == 0 {
+		return func() {}, nil
+	}
+	if vmType != "lxc" {
+		return nil, fmt.Errorf("backup_mountpoints: vmid %d is not a container", vmid)
+	}
+
+	config, err := p.client.ReadLXCConfig(ctx, vmid)
+	if err != nil {
+		return nil, err
+	}
+	disks := make(map[string]proxmox.GuestDisk)
+	for _, disk := range proxmox.ParseGuestDisks(vmType, config) {
+		disks[disk.Key] = disk
+	}
+
+	var changed []proxmox.GuestDisk
+	revert := func() {
+		for _, disk := range changed {
+			if err := p.client.SetGuestDisk(context.Background(), vmid, disk); err != nil {
+				p.logger.Warn("unable to restore the mount point backup flag", "vmid", vmid, "mountpoint", disk.Key, "error", err)
+			}
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(overrides)) {
+		backed := overrides[key]
+		disk, ok := disks[key]
+		switch {
+		case !ok:
+			revert()
+			return nil, fmt.Errorf("backup_mountpoints: container %d has no mount point %s", vmid, key)
+		case backed && disk.Storage == "":
+			revert()
+			return nil, fmt.Errorf("backup_mountpoints: %s of container %d is a bind mount or device, vzdump cannot back it up", key, vmid)
+		case disk.Backed() == backed:
+			continue
+		}
+		if len(changed) == 0 {
+			running, err := p.client.GuestRunning(ctx, vmid)
+			if err != nil {
+				return nil, err
+			}
+			if running {
+				return nil, fmt.Errorf("backup_mountpoints: container %d is running, its mount point changes would only apply at its next start", vmid)
+			}
+		}
+		p.logger.Info("overriding mount point backup flag", "vmid", vmid, "mountpoint", key, "backup", backed)
+		if err := p.client.SetGuestDisk(ctx, vmid, disk.WithBackup(backed)); err != nil {
+			revert()
+			return nil, err
+		}
+		changed = append(changed, disk)
+	}
+	return revert, nil
+}
+
+// applyMountpoints marks the disks of the metadata with the backup flags
+// backup_mountpoints set for the dump.
+func applyMountpoints(layouts []proxmox.DiskLayout, overrides map[string]bool) []proxmox.DiskLayout {
+	for i, layout := range layouts {
+		if backed, ok := overrides[layout.Key]; ok {
+			layouts[i].Backed = backed
+		}
+	}
+	return layouts
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// pbsSnapshotRoot holds the configuration of Proxmox Backup Server hosts,
+// backed up with pbs_host=true.
+const pbsSnapshotRoot = "/pbs"
+
+// importPBSHost implements pbs_host=true: the location is a Proxmox Backup
+// Server host, and its configuration is stored as a tar of
+// /etc/proxmox-backup under /pbs/<host>/, so the PBS instance protecting a
+// site can itself be rebuilt. No guest is backed up and no job lock is
+// taken, since nothing is written to the host.
+func (p *ProxmoxImporter) importPBSHost(ctx context.Context, records chan<- *connectors.Record, summary *proxmox.RunSummary) (err error) {
+	ctx, span := p.client.Tracer().Start(ctx, "backup_pbs_host", "host", p.cfg.Host)
+	defer func() { span.End(err) }()
+
+	startedAt := time.Now()
+	name := "proxmox-backup-etc-" + p.cfg.FormatDumpTimestamp(startedAt) + ".tar"
+	guest := proxmox.GuestSummary{Type: "pbs", Name: p.cfg.Host, Archive: name, Status: proxmox.GuestStatusOK}
+	defer func() {
+		guest.Duration = time.Since(startedAt).Seconds()
+		if err != nil {
+			guest.Status = proxmox.GuestStatusFailed
+			guest.Error = err.Error()
+			guest.ErrorClass = proxmox.ErrorClass(err)
+		}
+		summary.Add(guest)
+	}()
+
+	p.logger.Info("backing up PBS host configuration", "host", p.cfg.Host, "dir", proxmox.PBSConfigDir)
+	data, err := p.client.ArchivePBSConfig(ctx)
+	if err != nil {
+		return err
+	}
+	guest.Bytes = int64(len(data))
+
+	host := sanitizeSnapshotDirComponent(p.cfg.Host)
+	if host == "" {
+		host = "local"
+	}
+	if err := p.emitRecord(ctx, records, &connectors.Record{
+		Pathname: path.Join(pbsSnapshotRoot, host, name),
+		FileInfo: objects.FileInfo{
+			Lname:    name,
+			Lsize:    int64(len(data)),
+			Lmode:    0600,
+			LmodTime: startedAt,
+			Ldev:     1,
+		},
+		Reader: io.NopCloser(bytes.NewReader(data)),
+	}); err != nil {
+		return err
+	}
+	p.logger.Info("PBS host configuration backed up", "host", p.cfg.Host, "archive", name, "size", len(data))
+	return nil
+}
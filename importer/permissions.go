@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// checkPermissions verifies, with check_permissions=true, that the account
+// holds the privileges the backup of vmids needs, so a missing one is
+// reported before the run rather than deep inside vzdump.
+func (p *ProxmoxImporter) checkPermissions(ctx context.Context, vmids []int) error {
+	if !p.cfg.CheckPermissions {
+		return nil
+	}
+	var required []proxmox.Permission
+	for _, vmid := range vmids {
+		path := fmt.Sprintf("/vms/%d", vmid)
+		required = append(required,
+			proxmox.Permission{Path: path, Privilege: proxmox.PrivVMAudit, Reason: "read the guest config"},
+			proxmox.Permission{Path: path, Privilege: proxmox.PrivVMBackup, Reason: "back up the guest"},
+		)
+		if p.backupOpts.writeback {
+			required = append(required, proxmox.Permission{Path: path, Privilege: proxmox.PrivVMConfigOptions, Reason: "backup_writeback"})
+		}
+	}
+
+	node, err := p.client.LocalNode(ctx)
+	if err != nil {
+		return err
+	}
+	required = append(required, proxmox.Permission{Path: "/nodes/" + node, Privilege: proxmox.PrivSysConsole, Reason: "run commands on the node"})
+	opts := p.backupOpts
+	if opts.runningBackup != runningBackupIgnore || opts.maxLoad > 0 || opts.maxIOWait > 0 || opts.throttleIOWait > 0 {
+		required = append(required, proxmox.Permission{Path: "/nodes/" + node, Privilege: proxmox.PrivSysAudit, Reason: "read the node tasks and load"})
+	}
+	storage, err := p.client.DumpDirStorage(ctx, node)
+	if err != nil {
+		return err
+	}
+	if storage != "" {
+		required = append(required, proxmox.Permission{Path: "/storage/" + storage, Privilege: proxmox.PrivDatastoreAllocateSpace, Reason: "write archives to dump_dir"})
+	} else {
+		p.logger.Warn("dump_dir belongs to no storage, its privileges are not checked", "dump_dir", p.cfg.DumpDir)
+	}
+
+	ctx, span := p.client.Tracer().Start(ctx, "check_permissions", "checks", len(required))
+	err = p.client.CheckPermissions(ctx, required)
+	span.End(err)
+	return err
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"context"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// archiveChecksum returns the sha256 of an archive on the node for the
+// backup report. It is empty when the node cannot compute it.
+func (p *ProxmoxImporter) archiveChecksum(ctx context.Context, archivePath string) string {
+	sum, err := p.client.Checksum(ctx, archivePath)
+	if err != nil {
+		p.logger.Warn("unable to checksum archive", "archive", archivePath, "error", err)
+		return ""
+	}
+	return sum
+}
+
+// writeReport writes the backup report of a finished run to the
+// backup_report path, or into dump_dir on the node.
+func (p *ProxmoxImporter) writeReport(summary *proxmox.RunSummary) {
+	reportPath := p.backupOpts.reportPath
+	if reportPath == "" {
+		return
+	}
+	reportPath, err := p.client.WriteReport(summary, reportPath, p.backupOpts.reportFormat)
+	if err != nil {
+		p.logger.Warn("unable to write backup report", "path", reportPath, "error", err)
+		return
+	}
+	p.logger.Info("backup report written", "path", reportPath)
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+const auditRecordName = "audit.json"
+
+const summaryRecordName = "summary.json"
+
+type auditReport struct {
+	Origin     string               `json:"origin"`
+	Selection  string               `json:"selection"`
+	StartedAt  time.Time            `json:"started_at"`
+	FinishedAt time.Time            `json:"finished_at"`
+	Commands   []proxmox.AuditEntry `json:"commands"`
+}
+
+func (p *ProxmoxImporter) emitAuditRecord(ctx context.Context, records chan<- *connectors.Record, audit *proxmox.AuditLog, startedAt time.Time) error {
+	finishedAt := time.Now()
+	data, err := json.MarshalIndent(auditReport{
+		Origin:     p.cfg.Origin(),
+		Selection:  p.selection.String(),
+		StartedAt:  startedAt.UTC(),
+		FinishedAt: finishedAt.UTC(),
+		Commands:   audit.Entries(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return p.emitRunRecord(ctx, records, auditRecordName, data)
+}
+
+func (p *ProxmoxImporter) emitSummaryRecord(ctx context.Context, records chan<- *connectors.Record, summary *proxmox.RunSummary) error {
+	data, err := summary.Encode()
+	if err != nil {
+		return err
+	}
+	return p.emitRunRecord(ctx, records, summaryRecordName, data)
+}
+
+func (p *ProxmoxImporter) emitRunRecord(ctx context.Context, records chan<- *connectors.Record, name string, data []byte) error {
+	return p.emitRecord(ctx, records, &connectors.Record{
+		Pathname: path.Join(runSnapshotRoot, name),
+		FileInfo: objects.FileInfo{
+			Lname:    name,
+			Lsize:    int64(len(data)),
+			Lmode:    0600,
+			LmodTime: time.Now(),
+			Ldev:     1,
+		},
+		Reader: io.NopCloser(bytes.NewReader(data)),
+	})
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// emitSegmentedArchive emits the archive as fixed-size part records followed
+// by a parts manifest sidecar. Parts are opened lazily, each one reading its
+// own byte range of the archive on the node, so a failure only costs the
+// part being transferred. When removeWhenDone is set, the archive is removed
+// once every part reader has been closed.
+func (p *ProxmoxImporter) emitSegmentedArchive(ctx context.Context, records chan<- *connectors.Record, vmType string, vmid int, vmName, archivePath, archiveName string, fileInfo os.FileInfo, removeWhenDone bool) error {
+	manifest := proxmox.PlanParts(archiveName, fileInfo.Size(), p.backupOpts.segmentSize)
+
+	manifestData, err := proxmox.EncodePartsManifest(manifest)
+	if err != nil {
+		return err
+	}
+
+	tracker := &partTracker{remaining: len(manifest.Parts)}
+	if removeWhenDone {
+		tracker.done = func() {
+			_ = p.client.Remove(context.Background(), archivePath)
+		}
+	}
+
+	for i, part := range manifest.Parts {
+		part := part
+		record := &connectors.Record{
+			Pathname: buildBackupSnapshotPath(vmType, vmid, vmName, part.Name),
+			FileInfo: objects.FileInfo{
+				Lname:    part.Name,
+				Lsize:    part.Size,
+				Lmode:    0600,
+				LmodTime: fileInfo.ModTime(),
+				Ldev:     1,
+			},
+			Reader: &partReader{
+				ReadCloser: connectors.NewLazyReader(func() (io.ReadCloser, error) {
+					return p.openArchiveRange(ctx, archivePath, part.Offset, part.Size)
+				}),
+				tracker: tracker,
+			},
+		}
+
+		if err := p.emitRecord(ctx, records, record); err != nil {
+			// Parts never handed over will never be closed by plakar.
+			tracker.release(len(manifest.Parts) - i - 1)
+			return err
+		}
+	}
+
+	manifestName := proxmox.BuildPartsManifestFilename(archiveName)
+	return p.emitRecord(ctx, records, &connectors.Record{
+		Pathname: buildBackupSnapshotPath(vmType, vmid, vmName, manifestName),
+		FileInfo: objects.FileInfo{
+			Lname:    manifestName,
+			Lsize:    int64(len(manifestData)),
+			Lmode:    0600,
+			LmodTime: fileInfo.ModTime(),
+			Ldev:     1,
+		},
+		Reader: io.NopCloser(bytes.NewReader(manifestData)),
+	})
+}
+
+type partTracker struct {
+	mu        sync.Mutex
+	remaining int
+	done      func()
+}
+
+func (t *partTracker) release(n int) {
+	if n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	t.remaining -= n
+	finished := t.remaining == 0
+	t.mu.Unlock()
+
+	if finished && t.done != nil {
+		t.done()
+	}
+}
+
+type partReader struct {
+	io.ReadCloser
+	tracker *partTracker
+	once    sync.Once
+}
+
+func (r *partReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(func() {
+		r.tracker.release(1)
+	})
+	return err
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// sizeCheckedReader fails the read at EOF when the number of bytes
+// transferred differs from the size recorded in the record metadata, for
+// instance when the dump was truncated or rewritten on the node while it
+// was being read. The record is then reported as failed instead of being
+// stored with a size that does not match its content.
+type sizeCheckedReader struct {
+	io.ReadCloser
+	path     string
+	expected int64
+	read     int64
+}
+
+func newSizeCheckedReader(reader io.ReadCloser, path string, expected int64) io.ReadCloser {
+	return &sizeCheckedReader{ReadCloser: reader, path: path, expected: expected}
+}
+
+func (r *sizeCheckedReader) Read(buf []byte) (int, error) {
+	n, err := r.ReadCloser.Read(buf)
+	r.read += int64(n)
+	if r.read > r.expected {
+		return n, fmt.Errorf("%s: read %d bytes, expected %d: %w", r.path, r.read, r.expected, proxmox.ErrArchiveCorrupt)
+	}
+	if err == io.EOF && r.read != r.expected {
+		return n, fmt.Errorf("%s: read %d bytes, expected %d: %w", r.path, r.read, r.expected, proxmox.ErrArchiveCorrupt)
+	}
+	return n, err
+}
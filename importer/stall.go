@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+// openArchive opens a whole archive, under the stall watchdog when
+// stall_timeout is set.
+func (p *ProxmoxImporter) openArchive(ctx context.Context, archivePath string, size int64) (io.ReadCloser, error) {
+	if p.cfg.StallTimeout <= 0 {
+		reader, err := p.client.Open(ctx, archivePath)
+		if err != nil {
+			return nil, err
+		}
+		return p.archiveReader(ctx, reader, archivePath, size), nil
+	}
+	return p.openArchiveRange(ctx, archivePath, 0, size)
+}
+
+// openArchiveRange opens length bytes of archivePath at offset. When
+// stall_timeout is set, the transfer runs under a stall watchdog and a
+// stalled read is resumed from the current offset up to stall_retries times.
+func (p *ProxmoxImporter) openArchiveRange(ctx context.Context, archivePath string, offset, length int64) (io.ReadCloser, error) {
+	if p.cfg.StallTimeout <= 0 {
+		reader, err := p.client.OpenRange(ctx, archivePath, offset, length)
+		if err != nil {
+			return nil, err
+		}
+		return p.archiveReader(ctx, reader, archivePath, length), nil
+	}
+	r := &resumingReader{
+		p:         p,
+		ctx:       ctx,
+		path:      archivePath,
+		offset:    offset,
+		remaining: length,
+		retries:   p.backupOpts.stallRetries,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return p.archiveReader(ctx, r, archivePath, length), nil
+}
+
+// archiveReader applies backup_net_bwlimit and the size check to an opened
+// archive range.
+func (p *ProxmoxImporter) archiveReader(ctx context.Context, reader io.ReadCloser, archivePath string, size int64) io.ReadCloser {
+	return newSizeCheckedReader(proxmox.NewRateLimitedReader(ctx, reader, p.bwLimiter), archivePath, size)
+}
+
+type resumingReader struct {
+	p         *ProxmoxImporter
+	ctx       context.Context
+	path      string
+	offset    int64
+	remaining int64
+	retries   int
+
+	current io.ReadCloser
+	cancel  context.CancelFunc
+}
+
+func (r *resumingReader) open() error {
+	ctx, cancel := context.WithCancel(r.ctx)
+	reader, err := r.p.client.OpenRange(ctx, r.path, r.offset, r.remaining)
+	if err != nil {
+		cancel()
+		return err
+	}
+	r.current = proxmox.NewStallReader(reader, "archive read", r.p.cfg.StallTimeout, cancel)
+	r.cancel = cancel
+	return nil
+}
+
+func (r *resumingReader) Read(buf []byte) (int, error) {
+	if r.current == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.current.Read(buf)
+	r.offset += int64(n)
+	r.remaining -= int64(n)
+	if err == nil || !errors.Is(err, proxmox.ErrStalled) || r.retries <= 0 {
+		return n, err
+	}
+
+	r.retries--
+	r.p.logger.Warn("archive read stalled, resuming", "archive", r.path, "offset", r.offset, "error", err)
+	r.p.client.Metrics().Add(proxmox.MetricRetries, 1, "reason", "stalled")
+	r.closeCurrent()
+	if n > 0 {
+		return n, nil
+	}
+	return r.Read(buf)
+}
+
+func (r *resumingReader) Close() error {
+	return r.closeCurrent()
+}
+
+func (r *resumingReader) closeCurrent() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.cancel()
+	r.current = nil
+	return err
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+// emitTagLinks adds, for each tag of the guest, a symlink
+// /tags/<tag>/<type>/<vmid>_<vmname> to its directory under /backup, so
+// snapshots can be browsed and matched by tag. Tags are lower-cased, as
+// Proxmox compares them, and reduced like guest names. A guest whose tags
+// cannot be read gets no link.
+func (p *ProxmoxImporter) emitTagLinks(ctx context.Context, records chan<- *connectors.Record, vmType string, vmid int, vmName string) error {
+	tags, err := p.client.VMTags(ctx, vmid)
+	if err != nil {
+		p.logger.Warn("unable to read guest tags, no tag path added", "vmid", vmid, "error", err)
+		return nil
+	}
+
+	guestDir := buildBackupSnapshotPath(vmType, vmid, vmName, "")
+	target := "../../.." + guestDir
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = sanitizeSnapshotDirComponent(strings.ToLower(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+
+		link := path.Join(tagSnapshotRoot, tag, vmType, buildBackupSnapshotDir(vmid, vmName))
+		if err := p.emitParentDirs(ctx, records, link); err != nil {
+			return err
+		}
+		record := &connectors.Record{
+			Pathname: link,
+			Target:   target,
+			FileInfo: objects.FileInfo{
+				Lname:    path.Base(link),
+				Lsize:    int64(len(target)),
+				Lmode:    fs.ModeSymlink | 0777,
+				LmodTime: p.dirs.modTime,
+				Ldev:     1,
+				Lnlink:   1,
+			},
+		}
+		if err := p.emitWithAttributes(ctx, records, record, guestDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package importer
+
+import (
+	"context"
+)
+
+// checkBackupTmpDir warns when backup_tmpdir has less room than the disks
+// of a container vzdump may copy there before archiving: in suspend mode,
+// and in snapshot mode, which vzdump turns into suspend when a volume of
+// the container cannot be snapshotted. Stop mode archives in place. Disk
+// sizes are provisioned sizes, so this is a warning rather than an error.
+func (p *ProxmoxImporter) checkBackupTmpDir(ctx context.Context, vmids []int) {
+	if p.cfg.BackupTmpDir == "" || p.cfg.BackupMode == "stop" {
+		return
+	}
+	usage, err := p.client.DiskFree(ctx, p.cfg.BackupTmpDir)
+	if err != nil {
+		p.logger.Warn("unable to read backup_tmpdir usage", "dir", p.cfg.BackupTmpDir, "error", err)
+		return
+	}
+	p.logger.Info("backup_tmpdir usage", "dir", p.cfg.BackupTmpDir, "avail", usage.Avail, "total", usage.Total)
+	for _, vmid := range vmids {
+		vmType, err := p.client.VMType(ctx, vmid)
+		if err != nil || vmType != "lxc" {
+			continue
+		}
+		size, err := p.client.VMDiskSize(ctx, vmid)
+		if err != nil {
+			p.logger.Warn("unable to read the disk size of the container for backup_tmpdir", "vmid", vmid, "error", err)
+			continue
+		}
+		if size > usage.Avail {
+			p.logger.Warn("backup_tmpdir may be too small for the suspend copy of the container", "vmid", vmid, "mode", p.cfg.BackupMode, "dir", p.cfg.BackupTmpDir, "avail", usage.Avail, "disk_size", size)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package nodehelper implements the protocol of proxmox-helper, the small
+// binary the plugin can push to a node to answer file metadata requests,
+// run commands and transfer files over a single SSH session instead of one
+// session per operation.
+//
+// Each message is a JSON header on its own line, followed by Len bytes of
+// raw payload (command output, file data). The helper first writes a
+// response carrying its Version, then handles requests concurrently: their
+// responses may come back in any order and are matched by ID.
+package nodehelper
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Version is the protocol version spoken by the helper.
+const Version = 2
+
+// MaxPayload bounds the payload of a message.
+const MaxPayload = 64 << 20
+
+// Operations of a Request.
+const (
+	OpStat   = "stat"
+	OpList   = "list"
+	OpSHA256 = "sha256"
+	OpDF     = "df"
+
+	// OpRun runs Path with Args and Env; the response payload is the
+	// standard output of the command.
+	OpRun = "run"
+	// OpCancel stops the command started by request Target.
+	OpCancel = "cancel"
+	// OpRead returns up to Length bytes of Path from Offset.
+	OpRead = "read"
+	// OpWrite writes the payload to Path at Offset, or creates (truncates)
+	// Path when Create is set.
+	OpWrite  = "write"
+	OpRemove = "remove"
+)
+
+type Request struct {
+	ID     int      `json:"id"`
+	Op     string   `json:"op"`
+	Path   string   `json:"path"`
+	Args   []string `json:"args,omitempty"`
+	Env    []string `json:"env,omitempty"`
+	Offset int64    `json:"offset,omitempty"`
+	Length int64    `json:"length,omitempty"`
+	Create bool     `json:"create,omitempty"`
+	Target int      `json:"target,omitempty"`
+	Len    int      `json:"len,omitempty"`
+}
+
+// Entry describes a file, as returned by stat and list.
+type Entry struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Dir     bool   `json:"dir,omitempty"`
+}
+
+type Response struct {
+	ID      int    `json:"id"`
+	Version int    `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	// NotExist is set along with Error when the path does not exist.
+	NotExist bool `json:"not_exist,omitempty"`
+
+	Entry   *Entry  `json:"entry,omitempty"`
+	Entries []Entry `json:"entries,omitempty"`
+	SHA256  string  `json:"sha256,omitempty"`
+
+	// Total, Free and Avail are the df sizes in bytes; Avail is the space
+	// available to unprivileged users.
+	Total int64 `json:"total,omitempty"`
+	Free  int64 `json:"free,omitempty"`
+	Avail int64 `json:"avail,omitempty"`
+
+	// Exit and Stderr are the exit status and standard error of a run.
+	Exit   int    `json:"exit,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+
+	// EOF is set when a read reached the end of the file.
+	EOF bool `json:"eof,omitempty"`
+
+	Len int `json:"len,omitempty"`
+}
+
+// WriteMessage writes header, whose Len must be len(payload), and payload.
+func WriteMessage(w io.Writer, header any, payload []byte) error {
+	line, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	_, err = w.Write(payload)
+	return err
+}
+
+func ReadRequest(r *bufio.Reader) (Request, []byte, error) {
+	var req Request
+	if err := readHeader(r, &req); err != nil {
+		return Request{}, nil, err
+	}
+	payload, err := readPayload(r, req.Len)
+	return req, payload, err
+}
+
+func ReadResponse(r *bufio.Reader) (Response, []byte, error) {
+	var resp Response
+	if err := readHeader(r, &resp); err != nil {
+		return Response{}, nil, err
+	}
+	payload, err := readPayload(r, resp.Len)
+	return resp, payload, err
+}
+
+func readHeader(r *bufio.Reader, v any) error {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if err := json.Unmarshal(line, v); err != nil {
+		return fmt.Errorf("invalid message header: %w", err)
+	}
+	return nil
+}
+
+func readPayload(r *bufio.Reader, n int) ([]byte, error) {
+	if n < 0 || n > MaxPayload {
+		return nil, fmt.Errorf("invalid payload length %d", n)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
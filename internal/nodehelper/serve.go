@@ -0,0 +1,272 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package nodehelper
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// commandNotFound is the exit status of a shell for an unknown command,
+// reported the same way so callers see what an SSH session would give.
+const commandNotFound = 127
+
+// killGrace is how long a cancelled command has to exit after SIGTERM
+// before its process group is killed.
+const killGrace = 30 * time.Second
+
+type server struct {
+	writeMu sync.Mutex
+	out     *bufio.Writer
+	err     error
+
+	runMu   sync.Mutex
+	running map[int]*exec.Cmd
+}
+
+// Serve answers the requests read from r on w until r is closed, then
+// waits for the requests in progress.
+func Serve(r io.Reader, w io.Writer) error {
+	s := &server{out: bufio.NewWriter(w), running: make(map[int]*exec.Cmd)}
+	s.send(Response{Version: Version}, nil)
+
+	var wg sync.WaitGroup
+	in := bufio.NewReader(r)
+	for {
+		req, payload, err := ReadRequest(in)
+		if err != nil {
+			wg.Wait()
+			if err == io.EOF {
+				return s.writeErr()
+			}
+			return err
+		}
+		if req.Op == OpCancel {
+			s.cancel(req.Target)
+			s.send(Response{ID: req.ID}, nil)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, out := s.handle(req, payload)
+			resp.ID = req.ID
+			s.send(resp, out)
+		}()
+	}
+}
+
+func (s *server) send(resp Response, payload []byte) {
+	resp.Len = len(payload)
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.err != nil {
+		return
+	}
+	if err := WriteMessage(s.out, resp, payload); err != nil {
+		s.err = err
+		return
+	}
+	s.err = s.out.Flush()
+}
+
+func (s *server) writeErr() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.err
+}
+
+func (s *server) handle(req Request, payload []byte) (Response, []byte) {
+	var (
+		resp Response
+		out  []byte
+		err  error
+	)
+	switch req.Op {
+	case OpStat:
+		var info fs.FileInfo
+		if info, err = os.Stat(req.Path); err == nil {
+			entry := newEntry(info)
+			resp.Entry = &entry
+		}
+	case OpList:
+		resp.Entries, err = list(req.Path)
+	case OpSHA256:
+		resp.SHA256, err = checksum(req.Path)
+	case OpDF:
+		resp.Total, resp.Free, resp.Avail, err = diskFree(req.Path)
+	case OpRun:
+		out, resp.Stderr, resp.Exit, err = s.run(req)
+	case OpRead:
+		out, resp.EOF, err = readAt(req.Path, req.Offset, req.Length)
+	case OpWrite:
+		err = writeAt(req.Path, req.Offset, req.Create, payload)
+	case OpRemove:
+		if err = os.Remove(req.Path); errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+	default:
+		err = fmt.Errorf("unknown operation %q", req.Op)
+	}
+	if err == nil && len(out) > MaxPayload {
+		err = fmt.Errorf("output of %d bytes exceeds %d", len(out), MaxPayload)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		resp.NotExist = errors.Is(err, fs.ErrNotExist)
+		out = nil
+	}
+	return resp, out
+}
+
+func (s *server) run(req Request) ([]byte, string, int, error) {
+	cmd := exec.Command(req.Path, req.Args...)
+	cmd.Env = append(os.Environ(), req.Env...)
+	setProcessGroup(cmd)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+			return nil, req.Path + ": command not found\n", commandNotFound, nil
+		}
+		return nil, "", 0, err
+	}
+	s.runMu.Lock()
+	s.running[req.ID] = cmd
+	s.runMu.Unlock()
+
+	err := cmd.Wait()
+
+	s.runMu.Lock()
+	delete(s.running, req.ID)
+	s.runMu.Unlock()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		status := exitErr.ExitCode()
+		if status < 0 {
+			// Killed by a signal, reported like a shell does.
+			status = 128 + exitSignal(exitErr)
+		}
+		return stdout.Bytes(), stderr.String(), status, nil
+	}
+	return stdout.Bytes(), stderr.String(), 0, err
+}
+
+func (s *server) cancel(id int) {
+	s.runMu.Lock()
+	cmd := s.running[id]
+	s.runMu.Unlock()
+	if cmd == nil {
+		return
+	}
+	stopGroup(cmd, false)
+	time.AfterFunc(killGrace, func() {
+		s.runMu.Lock()
+		defer s.runMu.Unlock()
+		if s.running[id] == cmd {
+			stopGroup(cmd, true)
+		}
+	})
+}
+
+func newEntry(info fs.FileInfo) Entry {
+	return Entry{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		ModTime: info.ModTime().Unix(),
+		Dir:     info.IsDir(),
+	}
+}
+
+func list(dir string) ([]Entry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		// Follow symlinks, like stat does.
+		info, err := os.Stat(filepath.Join(dir, dirEntry.Name()))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, newEntry(info))
+	}
+	return entries, nil
+}
+
+func checksum(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func readAt(name string, offset, length int64) ([]byte, bool, error) {
+	if length <= 0 || length > MaxPayload {
+		return nil, false, fmt.Errorf("invalid read length %d", length)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, length)
+	n, err := f.ReadAt(buf, offset)
+	if err == io.EOF {
+		return buf[:n], true, nil
+	}
+	return buf[:n], false, err
+}
+
+func writeAt(name string, offset int64, create bool, data []byte) error {
+	flags := os.O_WRONLY
+	if create {
+		flags |= os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(name, flags, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, offset); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
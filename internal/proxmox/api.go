@@ -0,0 +1,252 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAPIPort is the port of pveproxy.
+const DefaultAPIPort = "8006"
+
+// apiTimeout bounds a single API call.
+const apiTimeout = 2 * time.Minute
+
+// apiTaskPollInterval is how often the status of a task started through
+// the API is polled.
+const apiTaskPollInterval = time.Second
+
+// apiRunner sends the pvesh command lines of control=https to the HTTPS
+// API of the node with an API token instead of running pvesh over SSH, so
+// the SSH account only streams data. Other commands go to the wrapped
+// runner.
+type apiRunner struct {
+	Runner
+	base   string
+	token  string
+	client *http.Client
+	// pvesh is the bin_pvesh override, which callers outside the
+	// binary runner may already have applied.
+	pvesh string
+}
+
+func newAPIRunner(runner Runner, cfg *Config) *apiRunner {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.APIFingerprint != "" {
+		// PVE nodes usually serve a self-signed certificate: trust the
+		// pinned one instead of the system roots.
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true,
+			VerifyConnection: func(state tls.ConnectionState) error {
+				if len(state.PeerCertificates) == 0 {
+					return errors.New("no server certificate")
+				}
+				sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+				if hex.EncodeToString(sum[:]) != cfg.APIFingerprint {
+					return fmt.Errorf("server certificate does not match api_fingerprint")
+				}
+				return nil
+			},
+		}
+	}
+	return &apiRunner{
+		Runner: runner,
+		base:   strings.TrimRight(cfg.APIURL, "/") + "/api2/json",
+		token:  cfg.APIToken,
+		client: &http.Client{Timeout: apiTimeout, Transport: transport},
+		pvesh:  cfg.Binary("pvesh"),
+	}
+}
+
+func (r *apiRunner) Run(ctx context.Context, name string, args ...string) (string, string, error) {
+	if name != "pvesh" && name != r.pvesh {
+		return r.Runner.Run(ctx, name, args...)
+	}
+	stdout, stderr, err := r.call(ctx, args)
+	if err != nil || args[0] == "get" || args[0] == "ls" {
+		return stdout, stderr, err
+	}
+	// Like pvesh, calls starting a worker task return once it is done.
+	var upid string
+	if json.Unmarshal([]byte(stdout), &upid) != nil || !strings.HasPrefix(upid, "UPID:") {
+		return stdout, stderr, err
+	}
+	if stderr, err := r.waitTask(ctx, upid); err != nil {
+		return "", stderr, err
+	}
+	return stdout, "", nil
+}
+
+// waitTask polls the status of a worker task until it stops, and fails
+// unless it exited with OK.
+func (r *apiRunner) waitTask(ctx context.Context, upid string) (string, error) {
+	fields := strings.Split(upid, ":")
+	if len(fields) < 2 || fields[1] == "" {
+		return "", fmt.Errorf("invalid task id %q", upid)
+	}
+	endpoint := "/nodes/" + fields[1] + "/tasks/" + url.PathEscape(upid) + "/status"
+	for {
+		stdout, stderr, err := r.call(ctx, []string{"get", endpoint})
+		if err != nil {
+			return stderr, err
+		}
+		var status struct {
+			Status     string `json:"status"`
+			ExitStatus string `json:"exitstatus"`
+		}
+		if err := json.Unmarshal([]byte(stdout), &status); err != nil {
+			return "", fmt.Errorf("failed to parse task status: %w", err)
+		}
+		if status.Status == "stopped" {
+			if status.ExitStatus != "OK" {
+				return "task " + upid + " failed: " + status.ExitStatus, fmt.Errorf("task failed: %s", status.ExitStatus)
+			}
+			return "", nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(apiTaskPollInterval):
+		}
+	}
+}
+
+// call maps "get|set|create|delete <path> [--key value]..." to a request.
+// Like pvesh, failures are reported as "<status> <message>" on stderr,
+// with 596 for connection errors.
+func (r *apiRunner) call(ctx context.Context, args []string) (string, string, error) {
+	if len(args) < 2 {
+		return "", "api: missing method or path", errors.New("invalid pvesh call")
+	}
+	var method string
+	switch args[0] {
+	case "get", "ls":
+		method = http.MethodGet
+	case "set":
+		method = http.MethodPut
+	case "create":
+		method = http.MethodPost
+	case "delete":
+		method = http.MethodDelete
+	default:
+		return "", "api: unsupported method " + args[0], errors.New("invalid pvesh call")
+	}
+
+	params := url.Values{}
+	rest := args[2:]
+	for i := 0; i < len(rest); i++ {
+		key, ok := strings.CutPrefix(rest[i], "--")
+		if !ok || i+1 >= len(rest) {
+			return "", "api: invalid argument " + rest[i], errors.New("invalid pvesh call")
+		}
+		i++
+		if key == "output-format" {
+			continue
+		}
+		params.Add(key, rest[i])
+	}
+
+	endpoint := r.base + args[1]
+	var body io.Reader
+	if method == http.MethodGet || method == http.MethodDelete {
+		if len(params) > 0 {
+			endpoint += "?" + params.Encode()
+		}
+	} else {
+		body = strings.NewReader(params.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return "", err.Error(), err
+	}
+	req.Header.Set("Authorization", "PVEAPIToken="+r.token)
+	req.Header.Set("User-Agent", "plakar-integration-proxmox")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return "", "596 Connection timed out: " + err.Error(), err
+		}
+		return "", "596 Connection error: " + err.Error(), err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "596 Connection error: " + err.Error(), err
+	}
+
+	var response struct {
+		Data   json.RawMessage   `json:"data"`
+		Errors map[string]string `json:"errors"`
+	}
+	_ = json.Unmarshal(data, &response)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var stderr bytes.Buffer
+		stderr.WriteString(resp.Status)
+		for key, message := range response.Errors {
+			fmt.Fprintf(&stderr, "\n%s: %s", key, strings.TrimSpace(message))
+		}
+		return "", stderr.String(), fmt.Errorf("API request failed with status %d", resp.StatusCode)
+	}
+	if len(response.Data) == 0 {
+		return "null", "", nil
+	}
+	return string(response.Data), "", nil
+}
+
+// validAPIURL reports whether raw is an https URL with a host and, if any,
+// a valid port.
+func validAPIURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Scheme != "https" || parsed.Hostname() == "" || parsed.User != nil {
+		return false
+	}
+	if port := parsed.Port(); port != "" {
+		n, err := strconv.Atoi(port)
+		return err == nil && n > 0 && n <= 65535
+	}
+	return !strings.HasSuffix(parsed.Host, ":")
+}
+
+// apiURL returns the default api_url of control=https: pveproxy on the
+// first host of the location.
+func apiURL(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	// The zone of scoped addresses is escaped in URLs.
+	host = strings.Replace(strings.Trim(host, "[]"), "%", "%25", 1)
+	return "https://" + net.JoinHostPort(host, DefaultAPIPort)
+}
@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const auditStderrLimit = 4096
+
+const redactedValue = "[REDACTED]"
+
+// secretKeyPattern matches option names that look like a credential.
+const secretKeyPattern = `(?:password|passwd|secret|token|api[_-]?key)`
+
+// secretArgRegex matches key=value and --key value pairs whose key looks
+// like a credential.
+var secretArgRegex = regexp.MustCompile(`(?i)(` + secretKeyPattern + `[a-z_-]*(?:=|'?\s+'?))[^\s']+`)
+
+// secretKeyRegex matches option keys that hold a credential, such as
+// conn_password or api_token.
+var secretKeyRegex = regexp.MustCompile(`(?i)` + secretKeyPattern)
+
+// secretEnvKeyRegex matches env variable names whose value must never be
+// stored, such as PBS_PASSWORD.
+var secretEnvKeyRegex = regexp.MustCompile(`(?i)(pass|secret|token|key)`)
+
+type AuditEntry struct {
+	Time       time.Time     `json:"time"`
+	Command    string        `json:"command"`
+	Duration   time.Duration `json:"duration_ns"`
+	ExitStatus int           `json:"exit_status"`
+	Error      string        `json:"error,omitempty"`
+	Stderr     string        `json:"stderr,omitempty"`
+}
+
+// AuditLog collects every command run through the client during a job.
+// Known secrets and credential-looking arguments are redacted before an
+// entry is stored.
+type AuditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+	secrets []string
+}
+
+func newAuditLog(secrets []string) *AuditLog {
+	kept := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret != "" {
+			kept = append(kept, secret)
+		}
+	}
+	return &AuditLog{secrets: kept}
+}
+
+func (a *AuditLog) Entries() []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AuditEntry(nil), a.entries...)
+}
+
+func (a *AuditLog) record(command string, start time.Time, err error, stderr string) {
+	entry := AuditEntry{
+		Time:       start.UTC(),
+		Command:    a.redact(command),
+		Duration:   time.Since(start),
+		ExitStatus: exitStatus(err),
+		Stderr:     a.redact(truncate(strings.TrimSpace(stderr), auditStderrLimit)),
+	}
+	if err != nil {
+		entry.Error = a.redact(err.Error())
+	}
+
+	a.mu.Lock()
+	a.entries = append(a.entries, entry)
+	a.mu.Unlock()
+}
+
+func (a *AuditLog) redact(value string) string {
+	for _, secret := range a.secrets {
+		value = strings.ReplaceAll(value, secret, redactedValue)
+	}
+	return secretArgRegex.ReplaceAllString(value, "${1}"+redactedValue)
+}
+
+// EnableAudit starts recording every command run by the client and returns
+// the log they are recorded into.
+func (c *Client) EnableAudit() *AuditLog {
+	secrets := []string{c.cfg.ConnPassword}
+	for _, entry := range c.cfg.Env {
+		if key, value, _ := strings.Cut(entry, "="); secretEnvKeyRegex.MatchString(key) {
+			secrets = append(secrets, value)
+		}
+	}
+	audit := newAuditLog(secrets)
+	c.runner = &auditRunner{Runner: c.runner, audit: audit}
+	return audit
+}
+
+type auditRunner struct {
+	Runner
+	audit *AuditLog
+}
+
+func (r *auditRunner) Run(ctx context.Context, name string, args ...string) (string, string, error) {
+	start := time.Now()
+	stdout, stderr, err := r.Runner.Run(ctx, name, args...)
+	r.audit.record(shellCommand(name, args...), start, err, stderr)
+	return stdout, stderr, err
+}
+
+func (r *auditRunner) Stream(ctx context.Context, name string, args ...string) (*CommandStream, error) {
+	command := shellCommand(name, args...)
+	start := time.Now()
+	stream, err := r.Runner.Stream(ctx, name, args...)
+	if err != nil {
+		r.audit.record(command, start, err, "")
+		return nil, err
+	}
+
+	stderr := &boundedBuffer{limit: auditStderrLimit}
+	if stream.Stderr != nil {
+		stream.Stderr = io.TeeReader(stream.Stderr, stderr)
+	}
+
+	var once sync.Once
+	finish := stream.finish
+	stream.finish = func() error {
+		var err error
+		if finish != nil {
+			err = finish()
+		}
+		once.Do(func() { r.audit.record(command, start, err, stderr.String()) })
+		return err
+	}
+	abort := stream.abort
+	stream.abort = func() error {
+		var err error
+		if abort != nil {
+			err = abort()
+		}
+		once.Do(func() { r.audit.record(command, start, errors.New("aborted"), stderr.String()) })
+		return err
+	}
+	return stream, nil
+}
+
+type boundedBuffer struct {
+	mu    sync.Mutex
+	buf   strings.Builder
+	limit int
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *boundedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var sshErr *ssh.ExitError
+	if errors.As(err, &sshErr) {
+		return sshErr.ExitStatus()
+	}
+	var muxErr *remoteExitError
+	if errors.As(err, &muxErr) {
+		return muxErr.ExitStatus()
+	}
+	var execErr *exec.ExitError
+	if errors.As(err, &execErr) {
+		return execErr.ExitCode()
+	}
+	return -1
+}
+
+func truncate(value string, limit int) string {
+	if len(value) <= limit {
+		return value
+	}
+	return value[:limit] + "..."
+}
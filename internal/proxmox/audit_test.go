@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import "testing"
+
+func TestAuditLogRedact(t *testing.T) {
+	audit := newAuditLog([]string{"hunter2", "", "s3cr3t-env"})
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "plain", value: "qm list", want: "qm list"},
+		{name: "known secret", value: "sshpass -p hunter2 ssh pve", want: "sshpass -p [REDACTED] ssh pve"},
+		{name: "env secret", value: "env PBS_PASSWORD=s3cr3t-env vzdump 100", want: "env PBS_PASSWORD=[REDACTED] vzdump 100"},
+		{name: "key=value", value: "pvesh create /access/ticket --password=abc", want: "pvesh create /access/ticket --password=[REDACTED]"},
+		{name: "flag value", value: "pvesm add pbs --password abc --server pbs", want: "pvesm add pbs --password [REDACTED] --server pbs"},
+		{name: "quoted flag value", value: "pvesm add pbs --password 'abc' --server pbs", want: "pvesm add pbs --password '[REDACTED]' --server pbs"},
+		{name: "token", value: "curl api_token=PVEAPIToken", want: "curl api_token=[REDACTED]"},
+		{name: "api key", value: "notify --api-key xyz", want: "notify --api-key [REDACTED]"},
+		{name: "case", value: "X_SECRET=abc", want: "X_SECRET=[REDACTED]"},
+		{name: "passwd", value: "usermod passwd=abc", want: "usermod passwd=[REDACTED]"},
+		{name: "unrelated key", value: "vzdump 100 --mode=snapshot", want: "vzdump 100 --mode=snapshot"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := audit.redact(tt.value); got != tt.want {
+				t.Errorf("redact(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAuditLogDropsEmptySecrets(t *testing.T) {
+	audit := newAuditLog([]string{"", "x", ""})
+	if len(audit.secrets) != 1 || audit.secrets[0] != "x" {
+		t.Errorf("secrets = %q, want [x]", audit.secrets)
+	}
+	if got := audit.redact("a b"); got != "a b" {
+		t.Errorf("redact with an empty secret = %q, want unchanged", got)
+	}
+}
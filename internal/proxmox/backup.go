@@ -19,6 +19,7 @@ package proxmox
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"path"
@@ -31,48 +32,200 @@ import (
 const qemuConfigDir = "/etc/pve/qemu-server"
 const lxcConfigDir = "/etc/pve/lxc"
 
+const guestLockRetryInterval = 15 * time.Second
+
 func (c *Client) BackupVM(ctx context.Context, vmid int) (string, error) {
-	args := []string{strconv.Itoa(vmid), "--dumpdir", c.cfg.DumpDir, "--mode", c.cfg.BackupMode, "--compress", c.cfg.BackupCompression}
-	if c.cfg.Node != "" {
-		args = append(args, "--node", c.cfg.Node)
-	}
+	ctx, span := c.tracer.Start(ctx, "vzdump", "vmid", vmid, "mode", c.cfg.BackupMode)
+	archivePath, err := c.backupVM(ctx, vmid)
+	span.SetAttribute("archive", archivePath)
+	span.End(err)
+	return archivePath, err
+}
 
-	stdout, stderr, err := c.runner.Run(ctx, "vzdump", args...)
-	if err != nil {
-		return "", fmt.Errorf("vzdump failed: %w: %s", err, strings.TrimSpace(stderr))
+func (c *Client) backupVM(ctx context.Context, vmid int) (string, error) {
+	args := []string{strconv.Itoa(vmid), "--dumpdir", c.cfg.DumpDir, "--mode", c.cfg.BackupMode, "--compress", c.vzdumpCompression()}
+	args = c.appendVzdumpArgs(ctx, args)
+
+	deadline := time.Now().Add(c.cfg.LockWait)
+	var stdout, stderr string
+	for {
+		var err error
+		stdout, stderr, err = c.runner.Run(ctx, "vzdump", args...)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			c.confirmGuestUnlocked(vmid)
+			return "", ctx.Err()
+		}
+		if !errors.Is(ClassifyOutput(stdout+"\n"+stderr), ErrGuestLocked) || time.Now().Add(guestLockRetryInterval).After(deadline) {
+			return "", NewCommandError("vzdump failed", err, stderr)
+		}
+
+		c.logger.Warn("guest is locked, retrying vzdump", "vmid", vmid, "retry_in", guestLockRetryInterval)
+		c.metrics.Add(MetricRetries, 1, "reason", "guest_locked")
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(guestLockRetryInterval):
+		}
 	}
 
 	archive := parseArchivePath(stdout + "\n" + stderr)
-	if archive != "" {
-		return archive, nil
+	if archive == "" {
+		var err error
+		if archive, err = c.findLatestDump(ctx, vmid); err != nil {
+			return "", err
+		}
+		if archive == "" {
+			return "", fmt.Errorf("unable to determine vzdump output file")
+		}
 	}
 
-	fallback, err := c.findLatestDump(ctx, vmid)
-	if err != nil {
-		return "", err
+	if c.cfg.BackupCompression == CompressionZstdRsyncable {
+		return c.compressRsyncable(ctx, archive)
+	}
+	return archive, nil
+}
+
+// BackupVMs dumps several guests of the node with a single vzdump job,
+// which snapshots and locks them in turn instead of starting one job per
+// guest. It returns the archive of every guest dumped; vzdump goes on
+// after a failed guest, so the archives are returned along with the job
+// error, including when ctx is cancelled.
+func (c *Client) BackupVMs(ctx context.Context, vmids []int) (map[int]string, error) {
+	ctx, span := c.tracer.Start(ctx, "vzdump_batch", "guests", len(vmids), "mode", c.cfg.BackupMode)
+	archives, err := c.backupVMs(ctx, vmids)
+	span.SetAttribute("archives", len(archives))
+	span.End(err)
+	return archives, err
+}
+
+func (c *Client) backupVMs(ctx context.Context, vmids []int) (map[int]string, error) {
+	args := make([]string, 0, len(vmids)+6)
+	for _, vmid := range vmids {
+		args = append(args, strconv.Itoa(vmid))
+	}
+	args = append(args, "--dumpdir", c.cfg.DumpDir, "--mode", c.cfg.BackupMode, "--compress", c.vzdumpCompression())
+	args = c.appendVzdumpArgs(ctx, args)
+
+	stdout, stderr, runErr := c.runner.Run(ctx, "vzdump", args...)
+	cancelled := ctx.Err() != nil
+	if cancelled {
+		for _, vmid := range vmids {
+			c.confirmGuestUnlocked(vmid)
+		}
+	}
+
+	// The archives of a cancelled job are still returned, uncompressed,
+	// so the caller can remove them.
+	statCtx := context.WithoutCancel(ctx)
+	archives := make(map[int]string)
+	var errs []error
+	for _, match := range archiveRegex.FindAllStringSubmatch(stdout+"\n"+stderr, -1) {
+		archive := strings.TrimSpace(match[1])
+		_, vmid, err := ParseDumpFilename(archive)
+		if err != nil {
+			continue
+		}
+		if _, err := c.runner.Stat(statCtx, archive); err != nil {
+			// Left behind by a guest that failed after its archive was
+			// created.
+			continue
+		}
+		if !cancelled && c.cfg.BackupCompression == CompressionZstdRsyncable {
+			if archive, err = c.compressRsyncable(ctx, archive); err != nil {
+				errs = append(errs, fmt.Errorf("vmid %d: %w", vmid, err))
+				continue
+			}
+		}
+		archives[vmid] = archive
+	}
+
+	if cancelled {
+		return archives, ctx.Err()
+	}
+	if runErr != nil {
+		errs = append([]error{NewCommandError("vzdump failed", runErr, stderr)}, errs...)
+	}
+	return archives, errors.Join(errs...)
+}
+
+// vzdumpCompression returns the --compress value passed to vzdump.
+func (c *Client) vzdumpCompression() string {
+	if c.cfg.BackupCompression == CompressionZstdRsyncable {
+		return "0"
+	}
+	return c.cfg.BackupCompression
+}
+
+// minZstdManager is the first pve-manager whose vzdump accepts
+// --compress zstd.
+const minZstdManager = "6.2"
+
+// CheckCompressionSupport checks that the vzdump of the node accepts
+// backup_compression. A node whose version cannot be read is not refused.
+func (c *Client) CheckCompressionSupport(ctx context.Context) error {
+	if c.vzdumpCompression() != "zstd" {
+		return nil
+	}
+	versions, err := c.HypervisorVersions(ctx)
+	if err != nil || versions.PVEManager == "" {
+		c.logger.Warn("unable to check that vzdump supports zstd compression", "error", err)
+		return nil
 	}
-	if fallback == "" {
-		return "", fmt.Errorf("unable to determine vzdump output file")
+	if CompareVersions(versions.PVEManager, minZstdManager) < 0 {
+		return fmt.Errorf("backup_compression=zstd needs pve-manager %s or later, the node runs %s", minZstdManager, versions.PVEManager)
 	}
-	return fallback, nil
+	return nil
+}
+
+// compressRsyncable compresses an uncompressed dump in place with
+// zstd --rsyncable. The rsyncable framing resets the compressor at
+// content-defined points, so the compressed output of two dumps of the same
+// guest only differs around the changed blocks and still deduplicates.
+func (c *Client) compressRsyncable(ctx context.Context, archive string) (string, error) {
+	compressed := archive + ".zst"
+	if _, stderr, err := c.runner.Run(ctx, "zstd", "-q", "--rsyncable", "--rm", "-T1", "-o", compressed, "--", archive); err != nil {
+		_ = c.Remove(context.Background(), compressed)
+		_ = c.Remove(context.Background(), archive)
+		return "", NewCommandError("zstd --rsyncable failed", err, stderr)
+	}
+	return compressed, nil
 }
 
 func (c *Client) BackupVMStream(ctx context.Context, vmid int) (string, io.ReadCloser, *int64, error) {
+	ctx, span := c.tracer.Start(ctx, "vzdump_stream", "vmid", vmid, "mode", c.cfg.BackupMode)
+	archivePath, reader, size, err := c.backupVMStream(ctx, vmid, span)
+	if err != nil {
+		span.End(err)
+	}
+	return archivePath, reader, size, err
+}
+
+// backupVMStream starts vzdump --stdout. The span ends when the returned
+// reader is closed.
+func (c *Client) backupVMStream(ctx context.Context, vmid int, span *Span) (string, io.ReadCloser, *int64, error) {
 	vmType, err := c.VMType(ctx, vmid)
 	if err != nil {
 		return "", nil, nil, err
 	}
+	// The name and node only decorate the archive name, and are unknown
+	// when VMType had to probe the guest.
+	res, _ := c.vmResourceByID(ctx, vmid)
 
 	baseExt, err := dumpBaseExtension(vmType)
 	if err != nil {
 		return "", nil, nil, err
 	}
 
-	args := []string{strconv.Itoa(vmid), "--stdout", "--mode", c.cfg.BackupMode, "--compress", c.cfg.BackupCompression}
-	if c.cfg.Node != "" {
-		args = append(args, "--node", c.cfg.Node)
+	if c.cfg.BackupCompression == CompressionZstdRsyncable {
+		return "", nil, nil, fmt.Errorf("backup_compression=%s is not supported when streaming", CompressionZstdRsyncable)
 	}
 
+	args := []string{strconv.Itoa(vmid), "--stdout", "--mode", c.cfg.BackupMode, "--compress", c.cfg.BackupCompression}
+	args = c.appendVzdumpArgs(ctx, args)
+
 	stream, err := c.runner.Stream(ctx, "vzdump", args...)
 	if err != nil {
 		return "", nil, nil, fmt.Errorf("vzdump stream failed: %w", err)
@@ -81,9 +234,10 @@ func (c *Client) BackupVMStream(ctx context.Context, vmid int) (string, io.ReadC
 	stderrBuf := &bytes.Buffer{}
 	doneCh := make(chan struct{})
 
+	progress := newVzdumpProgress(c, vmid)
 	go func() {
 		defer close(doneCh)
-		_, _ = io.Copy(stderrBuf, stream.Stderr)
+		progress.copy(stderrBuf, stream.Stderr)
 	}()
 
 	header, err := readStreamHeader(stream.Stdout, 16)
@@ -91,7 +245,7 @@ func (c *Client) BackupVMStream(ctx context.Context, vmid int) (string, io.ReadC
 		_ = stream.Abort()
 		_ = stream.Finish()
 		<-doneCh
-		return "", nil, nil, fmt.Errorf("unable to read vzdump stream header: %w: %s", err, strings.TrimSpace(stderrBuf.String()))
+		return "", nil, nil, NewCommandError("unable to read vzdump stream header", err, stderrBuf.String())
 	}
 	if len(header) == 0 {
 		_ = stream.Abort()
@@ -100,9 +254,10 @@ func (c *Client) BackupVMStream(ctx context.Context, vmid int) (string, io.ReadC
 		return "", nil, nil, fmt.Errorf("empty vzdump stream header: %s", strings.TrimSpace(stderrBuf.String()))
 	}
 
-	compressionSuffix := detectCompressionSuffix(header)
-	timestamp := time.Now().Format("2006_01_02-15_04_05")
-	archivePath := BuildDumpFilename(c.cfg, vmType, vmid, timestamp, baseExt, compressionSuffix)
+	compressionSuffix := DetectCompressionSuffix(header)
+	timestamp := c.cfg.FormatDumpTimestamp(time.Now())
+	name := DumpName{Type: vmType, VMID: vmid, Name: res.Name, Node: res.Node, Timestamp: timestamp}
+	archivePath := BuildDumpFilename(c.cfg, name, baseExt, compressionSuffix)
 
 	stdout := io.MultiReader(bytes.NewReader(header), stream.Stdout)
 
@@ -110,14 +265,81 @@ func (c *Client) BackupVMStream(ctx context.Context, vmid int) (string, io.ReadC
 	reader := &countingReadCloser{
 		count: &size,
 		reader: &streamReadCloser{
-			stdout:     stdout,
-			finish:     stream.Finish,
+			stdout: stdout,
+			finish: func() error {
+				err := stream.Finish()
+				if err != nil && ctx.Err() != nil {
+					c.confirmGuestUnlocked(vmid)
+				}
+				span.SetAttribute("archive", archivePath)
+				span.SetAttribute("bytes", size)
+				span.End(err)
+				return err
+			},
 			stderr:     stderrBuf,
 			stderrDone: doneCh,
 		},
 	}
 
-	return archivePath, reader, &size, nil
+	abort := func() { _ = stream.Abort() }
+	return archivePath, NewStallReader(reader, "vzdump stream", c.cfg.StallTimeout, abort), &size, nil
+}
+
+// confirmGuestUnlocked waits for a cancelled vzdump to release the guest
+// lock and logs a warning when it does not, since the next backup of the
+// guest would otherwise fail until the lock is cleared by hand.
+func (c *Client) confirmGuestUnlocked(vmid int) {
+	ctx, cancel := context.WithTimeout(context.Background(), cancelGrace)
+	defer cancel()
+
+	for {
+		c.invalidateResourceCache()
+		res, err := c.vmResourceByID(ctx, vmid)
+		if err == nil && res.Lock == "" {
+			c.logger.Info("guest lock released after cancellation", "vmid", vmid)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			c.logger.Warn("guest still locked after cancelling vzdump", "vmid", vmid, "lock", res.Lock)
+			return
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func (c *Client) appendVzdumpArgs(ctx context.Context, args []string) []string {
+	if c.cfg.Node != "" {
+		args = append(args, "--node", c.cfg.Node)
+	}
+	return c.appendVzdumpOptions(ctx, args)
+}
+
+// appendVzdumpOptions appends the vzdump options of the configuration but
+// --node, for the vzdump endpoint of a node.
+func (c *Client) appendVzdumpOptions(ctx context.Context, args []string) []string {
+	if c.cfg.LockWait > 0 {
+		args = append(args, "--lockwait", strconv.Itoa(int(c.cfg.LockWait/time.Minute)))
+	}
+	if c.cfg.BackupTmpDir != "" {
+		args = append(args, "--tmpdir", c.cfg.BackupTmpDir)
+	}
+	args = append(args, c.vzdumpNotificationArgs(ctx)...)
+	return append(args, c.cfg.BackupExtraArgs...)
+}
+
+// guestLockedRegex matches the "VM is locked (backup)" and "CT 100 is
+// locked" messages of qm, pct and vzdump.
+var guestLockedRegex = regexp.MustCompile(`\b(vm|ct)( \d+)? is locked`)
+
+// isGuestLockedError reports whether output says a guest or the vzdump
+// global lock is held. A lock timeout reads "can't lock file ... - got
+// timeout", which the file lock pattern covers.
+func isGuestLockedError(output string) bool {
+	normalized := strings.ToLower(output)
+	return guestLockedRegex.MatchString(normalized) ||
+		strings.Contains(normalized, "can't lock file") ||
+		strings.Contains(normalized, "can't acquire lock")
 }
 
 func (c *Client) ReadQEMUConfig(ctx context.Context, vmid int) ([]byte, error) {
@@ -139,7 +361,23 @@ func VMConfigPath(vmType string, vmid int) (string, error) {
 	}
 }
 
+// readVMConfig returns the config of a guest, read from the node once per
+// run.
 func (c *Client) readVMConfig(ctx context.Context, vmType string, vmid int) ([]byte, error) {
+	if configData, ok := c.guestConfigs.get(vmType, vmid); ok {
+		return configData, nil
+	}
+	configData, err := c.readVMConfigFile(ctx, vmType, vmid)
+	if err != nil {
+		return nil, err
+	}
+	c.guestConfigs.put(vmType, vmid, configData)
+	return configData, nil
+}
+
+// readVMConfigFile reads the config of a guest from the node, past the
+// config cache.
+func (c *Client) readVMConfigFile(ctx context.Context, vmType string, vmid int) ([]byte, error) {
 	configPath, err := VMConfigPath(vmType, vmid)
 	if err != nil {
 		return nil, err
@@ -155,7 +393,6 @@ func (c *Client) readVMConfig(ctx context.Context, vmType string, vmid int) ([]b
 	if err != nil {
 		return nil, fmt.Errorf("unable to read %s config content %s: %w", vmType, configPath, err)
 	}
-
 	return configData, nil
 }
 
@@ -195,7 +432,9 @@ func readStreamHeader(reader io.Reader, size int) ([]byte, error) {
 	return buf[:n], nil
 }
 
-func detectCompressionSuffix(header []byte) string {
+// DetectCompressionSuffix returns the archive suffix (.gz, .zst, .lzo)
+// matching the compression magic at the start of header, or "".
+func DetectCompressionSuffix(header []byte) string {
 	if len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b {
 		return ".gz"
 	}
@@ -210,6 +449,9 @@ func detectCompressionSuffix(header []byte) string {
 }
 
 type streamReadCloser struct {
+	// op prefixes the error of a failed command, "vzdump failed" when
+	// empty.
+	op         string
 	stdout     io.Reader
 	finish     func() error
 	stderr     *bytes.Buffer
@@ -251,7 +493,11 @@ func (r *streamReadCloser) finalize() error {
 		<-r.stderrDone
 	}
 	if err != nil {
-		r.finishErr = fmt.Errorf("vzdump failed: %w: %s", err, strings.TrimSpace(r.stderr.String()))
+		op := r.op
+		if op == "" {
+			op = "vzdump failed"
+		}
+		r.finishErr = NewCommandError(op, err, r.stderr.String())
 	}
 	return r.finishErr
 }
@@ -274,9 +520,9 @@ func (c *countingReadCloser) Close() error {
 }
 
 func (c *Client) findLatestDump(ctx context.Context, vmid int) (string, error) {
-	stdout, stderr, err := c.runner.Run(ctx, "ls", "-1", "--", c.cfg.DumpDir)
+	infos, err := c.ListDir(ctx, c.cfg.DumpDir)
 	if err != nil {
-		return "", fmt.Errorf("fallback listing failed: %w: %s", err, strings.TrimSpace(stderr))
+		return "", fmt.Errorf("fallback listing failed: %w", err)
 	}
 
 	var (
@@ -284,23 +530,13 @@ func (c *Client) findLatestDump(ctx context.Context, vmid int) (string, error) {
 		bestTime time.Time
 	)
 
-	for _, name := range strings.Split(strings.TrimSpace(stdout), "\n") {
-		name = strings.TrimSpace(name)
-		if name == "" {
-			continue
-		}
-		if !isArchiveForVM(name, vmid) {
-			continue
-		}
-
-		fullPath := path.Join(c.cfg.DumpDir, name)
-		info, err := c.runner.Stat(ctx, fullPath)
-		if err != nil {
+	for _, info := range infos {
+		if info.IsDir() || !isArchiveForVM(info.Name(), vmid) {
 			continue
 		}
 		modTime := info.ModTime()
 		if bestPath == "" || modTime.After(bestTime) {
-			bestPath = fullPath
+			bestPath = path.Join(c.cfg.DumpDir, info.Name())
 			bestTime = modTime
 		}
 	}
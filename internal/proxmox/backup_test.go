@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// benchmarkPayload is the amount of data moved per benchmark iteration.
+const benchmarkPayload = 8 << 20
+
+func BenchmarkCountingReadCloser(b *testing.B) {
+	payload := make([]byte, benchmarkPayload)
+	b.SetBytes(int64(len(payload)))
+	for i := 0; i < b.N; i++ {
+		var count int64
+		reader := &countingReadCloser{reader: io.NopCloser(bytes.NewReader(payload)), count: &count}
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamReadCloser(b *testing.B) {
+	payload := make([]byte, benchmarkPayload)
+	b.SetBytes(int64(len(payload)))
+	for i := 0; i < b.N; i++ {
+		done := make(chan struct{})
+		close(done)
+		reader := &streamReadCloser{
+			stdout:     bytes.NewReader(payload),
+			finish:     func() error { return nil },
+			stderr:     &bytes.Buffer{},
+			stderrDone: done,
+		}
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			b.Fatal(err)
+		}
+		_ = reader.Close()
+	}
+}
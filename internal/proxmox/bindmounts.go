@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+)
+
+var bindMountFilenameRegex = regexp.MustCompile(`^(.+)_bind_(mp\d+)\.tar$`)
+
+// BindMount is a container mount point backed by a host path rather than
+// a PVE storage, which vzdump leaves out of the archive.
+type BindMount struct {
+	Key       string `json:"key"`
+	HostPath  string `json:"host_path"`
+	MountPath string `json:"mount_path,omitempty"`
+
+	// File is the record holding a tar of the host path, when the bind
+	// mount was backed up next to the archive.
+	File string `json:"file,omitempty"`
+}
+
+// BindMounts returns the bind mounts of a container config.
+func BindMounts(config []byte) []BindMount {
+	var mounts []BindMount
+	for _, disk := range ParseGuestDisks("lxc", config) {
+		if disk.Storage != "" || !filepath.IsAbs(disk.Volume) {
+			continue
+		}
+		mount := BindMount{Key: disk.Key, HostPath: disk.Volume}
+		mount.MountPath, _ = disk.Option("mp")
+		mounts = append(mounts, mount)
+	}
+	return mounts
+}
+
+// BuildBindMountFilename returns the name of the tar holding the bind
+// mount key of an archive.
+func BuildBindMountFilename(archiveName, key string) string {
+	return archiveName + "_bind_" + key + ".tar"
+}
+
+// ParseBindMountFilename returns the archive and mount point key of a
+// bind mount tar.
+func ParseBindMountFilename(name string) (string, string, bool) {
+	matches := bindMountFilenameRegex.FindStringSubmatch(filepath.Base(name))
+	if len(matches) != 3 {
+		return "", "", false
+	}
+	return matches[1], matches[2], true
+}
+
+// ArchiveBindMount writes a tar of hostPath to target on the node,
+// keeping numeric owners and permissions.
+func (c *Client) ArchiveBindMount(ctx context.Context, hostPath, target string) error {
+	_, stderr, err := c.runner.Run(ctx, "tar", "-C", hostPath, "--numeric-owner", "-cpf", target, ".")
+	if err != nil {
+		return NewCommandError("tar of bind mount failed", err, stderr)
+	}
+	return nil
+}
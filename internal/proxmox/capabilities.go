@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// NodeCapabilities is what the node can run guests on.
+type NodeCapabilities struct {
+	// Arch is the machine hardware name of the node, as uname -m prints
+	// it (x86_64, aarch64).
+	Arch string
+
+	// Machines lists the QEMU machine versions, e.g. pc-q35-8.1.
+	Machines []string
+
+	// CPUModels lists the QEMU CPU models, custom ones included.
+	CPUModels []string
+}
+
+type machineCapability struct {
+	ID string `json:"id"`
+}
+
+type cpuCapability struct {
+	Name string `json:"name"`
+}
+
+// NodeCapabilities returns the capabilities of the local node, queried once
+// per client.
+func (c *Client) NodeCapabilities(ctx context.Context) (NodeCapabilities, error) {
+	c.capabilitiesMu.Lock()
+	defer c.capabilitiesMu.Unlock()
+
+	if c.capabilities != nil {
+		return *c.capabilities, nil
+	}
+
+	if c.cliControl() {
+		return NodeCapabilities{}, fmt.Errorf("node capabilities are %w", errNeedsAPI)
+	}
+	node, err := c.LocalNode(ctx)
+	if err != nil {
+		return NodeCapabilities{}, err
+	}
+	stdout, stderr, err := c.runner.Run(ctx, "uname", "-m")
+	if err != nil {
+		return NodeCapabilities{}, NewCommandError("uname failed", err, stderr)
+	}
+	caps := NodeCapabilities{Arch: strings.TrimSpace(stdout)}
+
+	stdout, err = c.runPvesh(ctx, "pvesh get qemu machines failed", "get", "/nodes/"+node+"/capabilities/qemu/machines", "--output-format", "json")
+	if err != nil {
+		return NodeCapabilities{}, err
+	}
+	var machines []machineCapability
+	if err := json.Unmarshal([]byte(stdout), &machines); err != nil {
+		return NodeCapabilities{}, fmt.Errorf("failed to parse qemu machines: %w", err)
+	}
+	for _, machine := range machines {
+		caps.Machines = append(caps.Machines, machine.ID)
+	}
+
+	stdout, err = c.runPvesh(ctx, "pvesh get qemu cpu models failed", "get", "/nodes/"+node+"/capabilities/qemu/cpu", "--output-format", "json")
+	if err != nil {
+		return NodeCapabilities{}, err
+	}
+	var models []cpuCapability
+	if err := json.Unmarshal([]byte(stdout), &models); err != nil {
+		return NodeCapabilities{}, fmt.Errorf("failed to parse qemu cpu models: %w", err)
+	}
+	for _, model := range models {
+		caps.CPUModels = append(caps.CPUModels, model.Name)
+	}
+
+	c.capabilities = &caps
+	return caps, nil
+}
@@ -18,32 +18,126 @@ package proxmox
 
 import (
 	"context"
-	"fmt"
 	"io"
+	"log/slog"
 	"os"
-	"strings"
 	"sync"
 	"time"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/nodehelper"
 )
 
 type Client struct {
-	cfg    *Config
-	runner Runner
+	cfg     *Config
+	runner  Runner
+	logger  *slog.Logger
+	metrics *Metrics
+	tracer  *Tracer
+	webhook *webhook
 
 	resourceCacheMu sync.Mutex
 	resourceCache   []vmResource
 	resourceCacheAt time.Time
+
+	// resourceBackoff and resourceRetryAt space the queries of
+	// /cluster/resources while it fails and the stale cache is served.
+	resourceBackoff time.Duration
+	resourceRetryAt time.Time
+
+	// versions and versionsErr cache the result of pveversion -v, so a
+	// node without it is not asked again for every guest.
+	versionsMu  sync.Mutex
+	versions    *HypervisorVersions
+	versionsErr error
+
+	// legacyNotifyOnce warns once that backup_notification=never cannot
+	// silence an old node.
+	legacyNotifyOnce sync.Once
+
+	storageMu sync.Mutex
+	storages  map[string]StorageInfo
+
+	capabilitiesMu sync.Mutex
+	capabilities   *NodeCapabilities
+
+	helperMu       sync.Mutex
+	helper         *helperSession
+	helperDisabled bool
+
+	pveshThrottle *pveshThrottle
+
+	guestConfigs guestConfigCache
+
+	// pendingBases holds the snapshots of the incremental images of the
+	// run, until ConfirmImageBases records them.
+	imageBasesMu sync.Mutex
+	pendingBases map[string]string
 }
 
-func NewClient(cfg *Config) (*Client, error) {
-	runner, err := NewRunner(cfg)
+func NewClient(cfg *Config, logger *slog.Logger) (*Client, error) {
+	if logger == nil {
+		logger = NewLogger(nil, cfg.LogLevel)
+	}
+	var tracer *Tracer
+	if cfg.TraceFile != "" {
+		var err error
+		if tracer, err = NewFileTracer(cfg.TraceFile); err != nil {
+			return nil, err
+		}
+	}
+
+	runner, err := NewRunner(cfg, logger)
 	if err != nil {
+		_ = tracer.Close()
 		return nil, err
 	}
-	return &Client{cfg: cfg, runner: runner}, nil
+	return newClient(cfg, logger, runner, tracer), nil
+}
+
+// NewClientWithRunner builds a client on top of a caller-provided runner,
+// typically a test double.
+func NewClientWithRunner(cfg *Config, logger *slog.Logger, runner Runner) (*Client, error) {
+	if logger == nil {
+		logger = NewLogger(nil, cfg.LogLevel)
+	}
+	var tracer *Tracer
+	if cfg.TraceFile != "" {
+		var err error
+		if tracer, err = NewFileTracer(cfg.TraceFile); err != nil {
+			return nil, err
+		}
+	}
+	return newClient(cfg, logger, WrapRunner(runner, cfg, logger), tracer), nil
+}
+
+func newClient(cfg *Config, logger *slog.Logger, runner Runner, tracer *Tracer) *Client {
+	metrics := NewMetrics()
+	return &Client{
+		cfg:     cfg,
+		runner:  newMeteredRunner(runner, metrics),
+		logger:  logger,
+		metrics: metrics,
+		tracer:  tracer,
+
+		pveshThrottle: newPveshThrottle(cfg.PveshRate),
+	}
+}
+
+func (c *Client) Logger() *slog.Logger {
+	return c.logger
+}
+
+func (c *Client) Metrics() *Metrics {
+	return c.metrics
+}
+
+func (c *Client) Tracer() *Tracer {
+	return c.tracer
 }
 
 func (c *Client) Close() error {
+	c.closeNodeHelper()
+	_ = c.tracer.Close()
 	if c.runner != nil {
 		return c.runner.Close()
 	}
@@ -51,6 +145,13 @@ func (c *Client) Close() error {
 }
 
 func (c *Client) Ping(ctx context.Context) error {
+	if c.cliControl() {
+		_, stderr, err := c.runner.Run(ctx, "pveversion")
+		if err != nil {
+			return NewCommandError("pveversion unavailable", err, stderr)
+		}
+		return nil
+	}
 	_, err := c.runPvesh(ctx, "pvesh unavailable", "get", "/version", "--output-format", "json")
 	return err
 }
@@ -59,11 +160,21 @@ func (c *Client) Open(ctx context.Context, filepath string) (io.ReadCloser, erro
 	return c.runner.Open(ctx, filepath)
 }
 
+func (c *Client) OpenRange(ctx context.Context, filepath string, offset, length int64) (io.ReadCloser, error) {
+	return c.runner.OpenRange(ctx, filepath, offset, length)
+}
+
 func (c *Client) Create(ctx context.Context, filepath string) (io.WriteCloser, error) {
 	return c.runner.Create(ctx, filepath)
 }
 
 func (c *Client) Stat(ctx context.Context, filepath string) (os.FileInfo, error) {
+	if resp, ok := c.helperRequest(ctx, nodehelper.OpStat, filepath); ok {
+		if resp.Error != "" || resp.Entry == nil {
+			return nil, helperError("stat", filepath, resp)
+		}
+		return helperFileInfo(*resp.Entry), nil
+	}
 	return c.runner.Stat(ctx, filepath)
 }
 
@@ -74,11 +185,3 @@ func (c *Client) Remove(ctx context.Context, filepath string) error {
 func (c *Client) Run(ctx context.Context, name string, args ...string) (string, string, error) {
 	return c.runner.Run(ctx, name, args...)
 }
-
-func (c *Client) runPvesh(ctx context.Context, errPrefix string, args ...string) (string, error) {
-	stdout, stderr, err := c.runner.Run(ctx, "pvesh", args...)
-	if err != nil {
-		return "", fmt.Errorf("%s: %w: %s", errPrefix, err, strings.TrimSpace(stderr))
-	}
-	return stdout, nil
-}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+type clusterStatusEntry struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Local int    `json:"local,omitempty"`
+}
+
+// ClusterName returns the name of the cluster the node belongs to, or an
+// empty string for a standalone node.
+func (c *Client) ClusterName(ctx context.Context) (string, error) {
+	if c.cliControl() {
+		return c.cliClusterName(ctx)
+	}
+	stdout, err := c.runPvesh(ctx, "pvesh get cluster status failed", "get", "/cluster/status", "--output-format", "json")
+	if err != nil {
+		return "", err
+	}
+
+	var entries []clusterStatusEntry
+	if err := json.Unmarshal([]byte(stdout), &entries); err != nil {
+		return "", fmt.Errorf("failed to parse cluster status: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Type == "cluster" {
+			return entry.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// LocalNode returns the name of the node commands run on: the configured
+// node, or the one /cluster/status marks as local.
+func (c *Client) LocalNode(ctx context.Context) (string, error) {
+	if c.cfg.Node != "" {
+		return c.cfg.Node, nil
+	}
+	if c.cliControl() {
+		return c.cliLocalNode(ctx)
+	}
+	stdout, err := c.runPvesh(ctx, "pvesh get cluster status failed", "get", "/cluster/status", "--output-format", "json")
+	if err != nil {
+		return "", err
+	}
+
+	var entries []clusterStatusEntry
+	if err := json.Unmarshal([]byte(stdout), &entries); err != nil {
+		return "", fmt.Errorf("failed to parse cluster status: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Type == "node" && entry.Local == 1 {
+			return entry.Name, nil
+		}
+	}
+	return "", fmt.Errorf("unable to determine the local node from cluster status")
+}
+
+// DetectCluster records the cluster name in the configuration so Origin
+// identifies the cluster rather than the node used to reach it.
+func (c *Client) DetectCluster(ctx context.Context) error {
+	name, err := c.ClusterName(ctx)
+	if err != nil {
+		return err
+	}
+	c.cfg.Cluster = name
+	return nil
+}
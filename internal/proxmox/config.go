@@ -17,12 +17,19 @@
 package proxmox
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const DefaultDumpDir = "/var/lib/vz/dump"
@@ -32,6 +39,15 @@ const (
 	ModeRemote = "remote"
 )
 
+// Values of the control option: the inventory and status of the node are
+// read through pvesh, with control=https through the HTTPS API with a
+// token, or with control=cli from qm, pct and /etc/pve.
+const (
+	ControlAPI   = "api"
+	ControlHTTPS = "https"
+	ControlCLI   = "cli"
+)
+
 const (
 	ConnMethodPassword = "password"
 	ConnMethodIdentity = "identity"
@@ -41,49 +57,147 @@ type Config struct {
 	Location *url.URL
 	Host     string
 
+	// Hosts lists the endpoints of the location in failover order; Host is
+	// the first of them.
+	Hosts []string
+
+	// Cluster is the detected cluster name, empty for standalone nodes.
+	Cluster string
+
 	Mode              string
 	ConnMethod        string
 	ConnUsername      string
 	ConnPassword      string
 	ConnIdentityFile  string
+	ConnOTPCommand    string
+	ConnCompression   bool
+	ConnMultiplex     bool
 	DumpDir           string
+	DumpNameTemplate  string
+	TimestampUTC      bool
+	TimestampFormat   string
 	BackupCompression string
 	BackupMode        string
-	Node              string
-	Cleanup           bool
+
+	// BackupExtraArgs holds the backup_extra_args words appended to vzdump.
+	BackupExtraArgs []string
+
+	// BackupNotification is BackupNotificationDefault or
+	// BackupNotificationNever.
+	BackupNotification string
+
+	// BackupTmpDir is passed to vzdump as --tmpdir (backup_tmpdir).
+	BackupTmpDir string
+
+	Node          string
+	Cleanup       bool
+	JobLock       bool
+	LockWait      time.Duration // lockwait, set by the importer
+	StallTimeout  time.Duration
+	LogLevel      slog.Level
+	MetricsFile   string
+	TraceFile     string
+	Notify        string
+	WebhookURL    string
+	WebhookSecret string
+
+	// Binaries maps PVE tool names to the path or wrapper to run instead.
+	Binaries map[string]string
+
+	// Env holds KEY=VALUE pairs exported to every command run on the node.
+	Env []string
+
+	// MockFixtures is the fixture directory of runner=mock:<dir>.
+	MockFixtures string
+
+	// PveshRate caps the pvesh calls started per second (0 for no limit),
+	// and PveshRetries is how many times a call failing with a transient
+	// API error is retried.
+	PveshRate    int
+	PveshRetries int
+
+	// Control is ControlAPI, ControlHTTPS or ControlCLI.
+	Control string
+
+	// APIURL, APIToken (user@realm!tokenid=secret) and APIFingerprint,
+	// the SHA-256 of the pinned certificate in lowercase hex, reach the
+	// API with control=https.
+	APIURL         string
+	APIToken       string
+	APIFingerprint string
+
+	// CheckPermissions checks the privileges of the account before a run.
+	CheckPermissions bool
+
+	// NodeHelper is the local path of the proxmox-helper binary pushed to
+	// the node, empty when file metadata is read with plain commands.
+	NodeHelper string
 }
 
-func ParseConfig(config map[string]string) (*Config, error) {
-	loc, ok := config["location"]
-	if !ok || strings.TrimSpace(loc) == "" {
-		return nil, fmt.Errorf("missing location")
-	}
+// binaryOptions lists the PVE tools whose path can be overridden with a
+// bin_<tool> option.
+var binaryOptions = []string{"vzdump", "qm", "pct", "pvesh", "qmrestore", "pveversion"}
 
-	parsed, err := url.Parse(loc)
-	if err != nil {
-		return nil, fmt.Errorf("invalid location: %w", err)
-	}
+// CompressionZstdRsyncable dumps uncompressed and compresses the archive
+// on the node with zstd --rsyncable, which vzdump cannot do itself.
+const CompressionZstdRsyncable = "zstd-rsyncable"
+
+// Values accepted by vzdump for --compress and --mode, plus
+// CompressionZstdRsyncable.
+var (
+	backupCompressions = []string{"0", "1", "gzip", "lzo", "zstd", CompressionZstdRsyncable}
+	backupModes        = []string{"snapshot", "suspend", "stop"}
+)
 
-	host := parsed.Host
-	if host == "" {
-		host = parsed.Path
+// Friendly spellings of backup_compression and backup_mode values, mapped
+// to the value passed to vzdump.
+var (
+	backupCompressionAliases = map[string]string{
+		"none": "0", "off": "0", "no": "0", "false": "0",
+		"on": "1", "yes": "1", "true": "1",
+		"gz": "gzip", "lzop": "lzo", "zst": "zstd",
 	}
-	if host == "" {
-		return nil, fmt.Errorf("missing host in location")
+	backupModeAliases = map[string]string{
+		"live": "snapshot", "snap": "snapshot",
+		"pause": "suspend", "suspended": "suspend",
+		"shutdown": "stop", "offline": "stop",
 	}
+)
 
-	mode := strings.TrimSpace(config["mode"])
-	if mode == "" {
-		return nil, fmt.Errorf("missing mode")
+// ParseConfig parses the options shared by the importer and the exporter.
+// Every invalid option is reported, not only the first one.
+func ParseConfig(config map[string]string) (*Config, error) {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
 	}
-	if mode != ModeLocal && mode != ModeRemote {
-		return nil, fmt.Errorf("invalid mode: %s", mode)
+
+	cfg := &Config{}
+
+	// locationUser is the user of proxmox://user@host, the default of
+	// conn_username.
+	var locationUser string
+
+	loc := strings.TrimSpace(config["location"])
+	if loc == "" {
+		fail("missing location")
+	} else {
+		parsed, user, hosts, err := parseLocation(loc)
+		if err != nil {
+			fail("invalid location: %w", err)
+		} else {
+			cfg.Location = parsed
+			cfg.Host = hosts[0]
+			cfg.Hosts = hosts
+			locationUser = user
+		}
 	}
 
-	cfg := &Config{
-		Location: parsed,
-		Host:     host,
-		Mode:     mode,
+	cfg.Mode = strings.TrimSpace(config["mode"])
+	if cfg.Mode == "" {
+		fail("missing mode")
+	} else if cfg.Mode != ModeLocal && cfg.Mode != ModeRemote {
+		fail("invalid mode: %s", cfg.Mode)
 	}
 
 	cfg.DumpDir = strings.TrimSpace(config["dump_dir"])
@@ -91,60 +205,447 @@ func ParseConfig(config map[string]string) (*Config, error) {
 		cfg.DumpDir = DefaultDumpDir
 	}
 
+	cfg.DumpNameTemplate = strings.TrimSpace(config["dump_name_template"])
+
 	if cfg.Mode == ModeRemote {
 		cfg.ConnMethod = strings.TrimSpace(config["conn_method"])
 		if cfg.ConnMethod == "" {
-			return nil, fmt.Errorf("missing conn_method")
-		}
-		if cfg.ConnMethod != ConnMethodPassword && cfg.ConnMethod != ConnMethodIdentity {
-			return nil, fmt.Errorf("invalid conn_method: %s", cfg.ConnMethod)
+			fail("missing conn_method")
+		} else if cfg.ConnMethod != ConnMethodPassword && cfg.ConnMethod != ConnMethodIdentity {
+			fail("invalid conn_method: %s", cfg.ConnMethod)
 		}
 
 		cfg.ConnUsername = strings.TrimSpace(config["conn_username"])
 		if cfg.ConnUsername == "" {
-			return nil, fmt.Errorf("missing conn_username")
+			cfg.ConnUsername = locationUser
+		} else if locationUser != "" && locationUser != cfg.ConnUsername {
+			fail("conn_username %s conflicts with the user %s of the location", cfg.ConnUsername, locationUser)
+		}
+		if cfg.ConnUsername == "" {
+			fail("missing conn_username")
 		}
 
 		switch cfg.ConnMethod {
 		case ConnMethodPassword:
 			cfg.ConnPassword = config["conn_password"]
 			if cfg.ConnPassword == "" {
-				return nil, fmt.Errorf("missing conn_password")
+				fail("missing conn_password")
 			}
 		case ConnMethodIdentity:
 			cfg.ConnIdentityFile = strings.TrimSpace(config["conn_identity_file"])
 			if cfg.ConnIdentityFile == "" {
-				return nil, fmt.Errorf("missing conn_identity_file")
-			}
-			cfg.ConnIdentityFile, err = expandPath(cfg.ConnIdentityFile)
-			if err != nil {
-				return nil, err
+				fail("missing conn_identity_file")
+			} else if expanded, err := expandPath(cfg.ConnIdentityFile); err != nil {
+				fail("invalid conn_identity_file: %w", err)
+			} else {
+				cfg.ConnIdentityFile = expanded
 			}
 		}
+		cfg.ConnOTPCommand = strings.TrimSpace(config["conn_otp_command"])
 	}
 
-	cfg.BackupCompression = strings.TrimSpace(config["backup_compression"])
+	cfg.BackupCompression = strings.ToLower(strings.TrimSpace(config["backup_compression"]))
+	if alias, ok := backupCompressionAliases[cfg.BackupCompression]; ok {
+		cfg.BackupCompression = alias
+	}
 	if cfg.BackupCompression == "" {
 		cfg.BackupCompression = "0"
+	} else if !slices.Contains(backupCompressions, cfg.BackupCompression) {
+		fail("invalid backup_compression value: %s (expected one of %s)", cfg.BackupCompression, strings.Join(backupCompressions, ", "))
 	}
 
-	cfg.BackupMode = strings.TrimSpace(config["backup_mode"])
+	cfg.BackupMode = strings.ToLower(strings.TrimSpace(config["backup_mode"]))
+	if alias, ok := backupModeAliases[cfg.BackupMode]; ok {
+		cfg.BackupMode = alias
+	}
 	if cfg.BackupMode == "" {
 		cfg.BackupMode = "snapshot"
+	} else if !slices.Contains(backupModes, cfg.BackupMode) {
+		fail("invalid backup_mode value: %s (expected one of %s)", cfg.BackupMode, strings.Join(backupModes, ", "))
 	}
 
 	cfg.Node = strings.TrimSpace(config["node"])
 
-	cleanup, err := parseBool(config, "cleanup", true)
-	if err != nil {
-		return nil, err
+	var err error
+	if cfg.BackupExtraArgs, err = ParseExtraArgs("backup_extra_args", config["backup_extra_args"], vzdumpManagedArgs); err != nil {
+		errs = append(errs, err)
+	}
+	cfg.BackupNotification = strings.ToLower(strings.TrimSpace(config["backup_notification"]))
+	switch cfg.BackupNotification {
+	case "":
+		cfg.BackupNotification = BackupNotificationDefault
+	case BackupNotificationDefault:
+	case BackupNotificationNever:
+		if option := conflictingNotificationArg(cfg.BackupExtraArgs); option != "" {
+			fail("backup_notification=never conflicts with %s in backup_extra_args", option)
+		}
+	default:
+		fail("invalid backup_notification value: %s (expected default or never)", cfg.BackupNotification)
+	}
+	cfg.BackupTmpDir = strings.TrimSpace(config["backup_tmpdir"])
+	if cfg.BackupTmpDir != "" && !strings.HasPrefix(cfg.BackupTmpDir, "/") {
+		fail("invalid backup_tmpdir value: %s is not an absolute path", cfg.BackupTmpDir)
+	} else if cfg.BackupTmpDir != "" && hasExtraArg(cfg.BackupExtraArgs, "--tmpdir") {
+		fail("backup_tmpdir conflicts with --tmpdir in backup_extra_args")
+	}
+	if cfg.TimestampUTC, err = parseBool(config, "timestamp_utc", false); err != nil {
+		errs = append(errs, err)
+	}
+	cfg.TimestampFormat = strings.TrimSpace(config["timestamp_format"])
+	if cfg.TimestampFormat == "" {
+		cfg.TimestampFormat = TimestampFormatVzdump
+	} else if _, ok := timestampLayouts[cfg.TimestampFormat]; !ok {
+		fail("invalid timestamp_format value: %s (expected %s or %s)", cfg.TimestampFormat, TimestampFormatVzdump, TimestampFormatISO8601)
+	}
+	// Rendered names depend on the timestamp settings parsed above.
+	if err := cfg.ValidateDumpNameTemplate(); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Cleanup, err = parseBool(config, "cleanup", true); err != nil {
+		errs = append(errs, err)
+	}
+
+	if raw := strings.TrimSpace(config["stall_timeout"]); raw != "" {
+		minutes, err := strconv.Atoi(raw)
+		if err != nil || minutes < 0 {
+			fail("invalid stall_timeout value: %s", raw)
+		}
+		cfg.StallTimeout = time.Duration(minutes) * time.Minute
+	}
+
+	if cfg.ConnCompression, err = parseBool(config, "conn_compression", false); err != nil {
+		errs = append(errs, err)
+	}
+
+	cfg.Notify = strings.ToLower(strings.TrimSpace(config["pve_notify"]))
+	switch cfg.Notify {
+	case "":
+		cfg.Notify = NotifyNever
+	case NotifyNever, NotifyFailure, NotifyAlways:
+	default:
+		fail("invalid pve_notify value: %s (expected one of never, failure, always)", cfg.Notify)
+	}
+
+	cfg.WebhookURL = strings.TrimSpace(config["webhook_url"])
+	if cfg.WebhookURL != "" {
+		if u, err := url.Parse(cfg.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("invalid webhook_url value: %s", cfg.WebhookURL)
+		}
+	}
+	cfg.WebhookSecret = config["webhook_secret"]
+
+	if cfg.JobLock, err = parseBool(config, "job_lock", false); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.LogLevel, err = parseLogLevel(config["log_level"]); err != nil {
+		errs = append(errs, err)
+	}
+
+	if metricsFile := strings.TrimSpace(config["metrics_file"]); metricsFile != "" {
+		if cfg.MetricsFile, err = expandPath(metricsFile); err != nil {
+			fail("invalid metrics_file: %w", err)
+		}
+	}
+
+	if traceFile := strings.TrimSpace(config["trace_file"]); traceFile != "" {
+		if cfg.TraceFile, err = expandPath(traceFile); err != nil {
+			fail("invalid trace_file: %w", err)
+		}
+	}
+
+	cfg.Binaries = make(map[string]string)
+	for _, name := range binaryOptions {
+		if value := strings.TrimSpace(config["bin_"+name]); value != "" {
+			cfg.Binaries[name] = value
+		}
+	}
+
+	if cfg.Env, err = parseEnv(config["env"]); err != nil {
+		errs = append(errs, err)
+	}
+
+	if runner := strings.TrimSpace(config["runner"]); runner != "" {
+		dir, ok := strings.CutPrefix(runner, "mock:")
+		if !ok || dir == "" {
+			fail("invalid runner value: %s (expected mock:<fixture-dir>)", runner)
+		} else if cfg.MockFixtures, err = expandPath(dir); err != nil {
+			fail("invalid runner fixture directory: %w", err)
+		}
+	}
+
+	if raw := strings.TrimSpace(config["pvesh_rate"]); raw != "" {
+		rate, err := strconv.Atoi(raw)
+		if err != nil || rate < 0 {
+			fail("invalid pvesh_rate value: %s", raw)
+		}
+		cfg.PveshRate = rate
+	}
+
+	cfg.PveshRetries = DefaultPveshRetries
+	if raw := strings.TrimSpace(config["pvesh_retries"]); raw != "" {
+		retries, err := strconv.Atoi(raw)
+		if err != nil || retries < 0 {
+			fail("invalid pvesh_retries value: %s", raw)
+		}
+		cfg.PveshRetries = retries
+	}
+
+	cfg.Control = strings.TrimSpace(config["control"])
+	if cfg.Control == "" {
+		cfg.Control = ControlAPI
+	} else if cfg.Control != ControlAPI && cfg.Control != ControlHTTPS && cfg.Control != ControlCLI {
+		fail("invalid control value: %s (expected api, https or cli)", cfg.Control)
+	}
+
+	if cfg.Control == ControlHTTPS {
+		cfg.APIToken = strings.TrimSpace(config["api_token"])
+		if cfg.APIToken == "" {
+			fail("missing api_token (required with control=https)")
+		} else if id, secret, ok := strings.Cut(cfg.APIToken, "="); !ok || !strings.Contains(id, "!") || secret == "" {
+			fail("invalid api_token value: expected user@realm!tokenid=secret")
+		}
+		cfg.APIURL = strings.TrimSpace(config["api_url"])
+		if cfg.APIURL == "" && cfg.Host != "" {
+			cfg.APIURL = apiURL(cfg.Host)
+		}
+		if cfg.APIURL == "" {
+			fail("missing api_url (required with control=https when the location has no host)")
+		} else if !validAPIURL(cfg.APIURL) {
+			fail("invalid api_url value: %s (expected https://<host>[:<port>])", cfg.APIURL)
+		}
+		if raw := strings.TrimSpace(config["api_fingerprint"]); raw != "" {
+			cfg.APIFingerprint = strings.ToLower(strings.ReplaceAll(raw, ":", ""))
+			if _, err := hex.DecodeString(cfg.APIFingerprint); err != nil || len(cfg.APIFingerprint) != 64 {
+				fail("invalid api_fingerprint value: %s (expected a SHA-256 fingerprint)", raw)
+			}
+		}
 	}
-	cfg.Cleanup = cleanup
 
+	if cfg.CheckPermissions, err = parseBool(config, "check_permissions", false); err != nil {
+		errs = append(errs, err)
+	} else if cfg.CheckPermissions && cfg.Control != ControlHTTPS {
+		// With control=api, pvesh runs as root@pam whatever the account.
+		fail("check_permissions requires control=https")
+	}
+
+	if helper := strings.TrimSpace(config["node_helper"]); helper != "" {
+		if cfg.NodeHelper, err = expandPath(helper); err != nil {
+			fail("invalid node_helper: %w", err)
+		} else if info, err := os.Stat(cfg.NodeHelper); err != nil {
+			fail("invalid node_helper: %w", err)
+		} else if !info.Mode().IsRegular() {
+			fail("invalid node_helper: %s is not a file", cfg.NodeHelper)
+		}
+	}
+
+	if cfg.ConnMultiplex, err = parseBool(config, "conn_multiplex", false); err != nil {
+		errs = append(errs, err)
+	} else if cfg.ConnMultiplex && (cfg.Mode != ModeRemote || cfg.NodeHelper == "") {
+		fail("conn_multiplex requires mode=remote and node_helper")
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
 	return cfg, nil
 }
 
+var envKeyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseEnv parses a semicolon-separated list of KEY=VALUE pairs. Values may
+// contain commas and equal signs.
+func parseEnv(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	env := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("invalid env entry: %s", entry)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate env variable: %s", key)
+		}
+		seen[key] = true
+		env = append(env, key+"="+val)
+	}
+	return env, nil
+}
+
+// Binary returns the command to run for the PVE tool name.
+func (c *Config) Binary(name string) string {
+	if value, ok := c.Binaries[name]; ok {
+		return value
+	}
+	return name
+}
+
+// MergeLocationQuery returns config completed with the query parameters of
+// its location, so simple jobs can be defined in the location alone
+// (proxmox://pve1/?mode=remote&vmid=100). Options set in config take
+// precedence over the query. Credentials are refused: plakar stores and
+// shows the location as the snapshot source.
+func MergeLocationQuery(config map[string]string) (map[string]string, error) {
+	loc := config["location"]
+	_, rawQuery, ok := strings.Cut(loc, "?")
+	if !ok {
+		return config, nil
+	}
+	rawQuery, _, _ = strings.Cut(rawQuery, "#")
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid location query: %w", err)
+	}
+
+	merged := make(map[string]string, len(config)+len(query))
+	for key, values := range query {
+		if key == "location" {
+			return nil, fmt.Errorf("invalid location query: location cannot be set from the query")
+		}
+		if secretKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("invalid location query: %s is a credential, set it as an option instead", key)
+		}
+		if len(values) > 1 {
+			return nil, fmt.Errorf("invalid location query: %s is set more than once", key)
+		}
+		merged[key] = values[0]
+	}
+	for key, value := range config {
+		merged[key] = value
+	}
+	return merged, nil
+}
+
+// parseLocation parses proxmox://[user@]host[,host...][/...], the hosts
+// being the endpoints of the node or cluster in failover order. A host is
+// a name or IPv4 address with an optional :port, a bracketed IPv6 literal
+// with an optional port ([2001:db8::1]:2222), a bare IPv6 literal without
+// one, or a scoped address ([fe80::1%eth0], the zone possibly escaped as
+// %25). It returns the location reduced to its first host, the user named
+// in it, if any, and the hosts, IPv6 literals always bracketed. A password
+// (user:password@host) is refused.
+func parseLocation(loc string) (*url.URL, string, []string, error) {
+	scheme, rest, ok := strings.Cut(loc, "://")
+	if !ok {
+		scheme, rest = "", loc
+	}
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	authority, tail := rest[:end], rest[end:]
+
+	var (
+		user  string
+		hosts []string
+	)
+	for _, entry := range strings.Split(authority, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if at := strings.LastIndex(entry, "@"); at >= 0 {
+			entryUser, _, hasPassword := strings.Cut(entry[:at], ":")
+			if hasPassword {
+				return nil, "", nil, fmt.Errorf("passwords cannot be given in the location, set conn_password instead")
+			}
+			if unescaped, err := url.PathUnescape(entryUser); err == nil {
+				entryUser = unescaped
+			}
+			if user != "" && entryUser != user {
+				return nil, "", nil, fmt.Errorf("hosts name different users: %s and %s", user, entryUser)
+			}
+			user, entry = entryUser, entry[at+1:]
+		}
+		host, err := normalizeLocationHost(entry)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		hosts = append(hosts, host)
+	}
+	if len(hosts) == 0 {
+		return nil, "", nil, fmt.Errorf("missing host")
+	}
+
+	// url.Parse wants the zone of scoped addresses escaped.
+	first := strings.Replace(hosts[0], "%", "%25", 1)
+	prefix := "//"
+	if scheme != "" {
+		prefix = scheme + "://"
+	}
+	parsed, err := url.Parse(prefix + first + tail)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return parsed, user, hosts, nil
+}
+
+// normalizeLocationHost validates a host of the location and brackets bare
+// IPv6 literals.
+func normalizeLocationHost(host string) (string, error) {
+	host = strings.Replace(host, "%25", "%", 1)
+	if strings.HasPrefix(host, "[") {
+		end := strings.Index(host, "]")
+		if end < 0 {
+			return "", fmt.Errorf("invalid host %s: missing ]", host)
+		}
+		if !isIPv6(host[1:end]) {
+			return "", fmt.Errorf("invalid host %s: not an IPv6 address", host)
+		}
+		if port := host[end+1:]; port != "" {
+			if !strings.HasPrefix(port, ":") || !isPort(port[1:]) {
+				return "", fmt.Errorf("invalid port in host %s", host)
+			}
+		}
+		return host, nil
+	}
+	if strings.Count(host, ":") > 1 {
+		if !isIPv6(host) {
+			return "", fmt.Errorf("invalid host %s: not an IPv6 address (write [address]:port to give a port)", host)
+		}
+		return "[" + host + "]", nil
+	}
+	if name, port, ok := strings.Cut(host, ":"); ok {
+		if name == "" || !isPort(port) {
+			return "", fmt.Errorf("invalid host %s", host)
+		}
+	}
+	return host, nil
+}
+
+// isIPv6 reports whether address, without brackets, is an IPv6 address,
+// possibly scoped.
+func isIPv6(address string) bool {
+	address, _, _ = strings.Cut(address, "%")
+	ip := net.ParseIP(address)
+	return ip != nil && strings.Contains(address, ":")
+}
+
+func isPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// Origin identifies the source of snapshots: the cluster name (with the
+// node when backups are restricted to one), or the host for standalone
+// nodes.
 func (c *Config) Origin() string {
+	if c.Cluster != "" {
+		if c.Node != "" {
+			return c.Cluster + "/" + c.Node
+		}
+		return c.Cluster
+	}
 	if c.Host != "" {
 		return c.Host
 	}
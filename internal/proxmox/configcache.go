@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// guestConfigCache holds the guest configs read during a run. Size
+// estimation, mount point overrides, sidecars, metadata and the disk
+// engines all read the same config; it is fetched from the node once, and
+// dropped whenever the plugin changes it.
+type guestConfigCache struct {
+	mu      sync.Mutex
+	configs map[string][]byte
+}
+
+func guestConfigKey(vmType string, vmid int) string {
+	return vmType + "/" + strconv.Itoa(vmid)
+}
+
+func (g *guestConfigCache) get(vmType string, vmid int) ([]byte, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	config, ok := g.configs[guestConfigKey(vmType, vmid)]
+	return slices.Clone(config), ok
+}
+
+func (g *guestConfigCache) put(vmType string, vmid int, config []byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.configs == nil {
+		g.configs = make(map[string][]byte)
+	}
+	g.configs[guestConfigKey(vmType, vmid)] = slices.Clone(config)
+}
+
+func (g *guestConfigCache) drop(vmType string, vmid int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.configs, guestConfigKey(vmType, vmid))
+}
+
+func (g *guestConfigCache) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.configs = nil
+}
+
+// InvalidateVMConfig drops the cached config of a guest, after a command
+// run outside the client (a restore, qm set...) changed it.
+func (c *Client) InvalidateVMConfig(vmType string, vmid int) {
+	c.guestConfigs.drop(vmType, vmid)
+}
@@ -0,0 +1,539 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With control=cli, the inventory and status of the node are read from
+// the qm, pct, pvesm, pveum and pvenode tools and from the files of
+// /etc/pve instead of pvesh, for hardened nodes where the backup account
+// cannot reach the API. Only the guests of the node the plugin connects to
+// are visible.
+
+// Files of the cluster filesystem read with control=cli.
+const (
+	pveUserConfig     = "/etc/pve/user.cfg"
+	pveStorageConfig  = "/etc/pve/storage.cfg"
+	pveCorosyncConfig = "/etc/pve/corosync.conf"
+)
+
+// errNeedsAPI is returned by the few operations control=cli cannot do.
+var errNeedsAPI = errors.New("not available with control=cli")
+
+func (c *Client) cliControl() bool {
+	return c.cfg.Control == ControlCLI
+}
+
+// readNodeFile reads a whole file of the node.
+func (c *Client) readNodeFile(ctx context.Context, filepath string) ([]byte, error) {
+	reader, err := c.Open(ctx, filepath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", filepath, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", filepath, err)
+	}
+	return data, nil
+}
+
+// cliLocalNode returns the node name, the short host name of the node.
+func (c *Client) cliLocalNode(ctx context.Context) (string, error) {
+	stdout, stderr, err := c.runner.Run(ctx, "hostname")
+	if err != nil {
+		return "", NewCommandError("hostname failed", err, stderr)
+	}
+	node, _, _ := strings.Cut(strings.TrimSpace(stdout), ".")
+	if node == "" {
+		return "", fmt.Errorf("unable to determine the local node: empty hostname")
+	}
+	return node, nil
+}
+
+// cliClusterName reads the cluster name from corosync.conf, which only
+// clustered nodes have.
+func (c *Client) cliClusterName(ctx context.Context) (string, error) {
+	if _, err := c.Stat(ctx, pveCorosyncConfig); err != nil {
+		return "", nil
+	}
+	data, err := c.readNodeFile(ctx, pveCorosyncConfig)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok && strings.TrimSpace(key) == "cluster_name" {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	return "", nil
+}
+
+// cliResources lists the guests of the node from qm list and pct list,
+// completed with the lock, tags and disks of their config and with the
+// pools of user.cfg.
+func (c *Client) cliResources(ctx context.Context) ([]vmResource, error) {
+	node, err := c.LocalNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pools, err := c.cliPools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	poolOf := make(map[int]string)
+	for name, vmids := range pools {
+		for _, vmid := range vmids {
+			poolOf[vmid] = name
+		}
+	}
+
+	stdout, stderr, err := c.runner.Run(ctx, "qm", "list")
+	if err != nil {
+		return nil, NewCommandError("qm list failed", err, stderr)
+	}
+	var resources []vmResource
+	for _, line := range listRows(stdout) {
+		fields := strings.Fields(line)
+		vmid, err := strconv.Atoi(fields[0])
+		if err != nil || len(fields) < 2 {
+			return nil, fmt.Errorf("failed to parse qm list line: %s", line)
+		}
+		resources = append(resources, vmResource{VMID: vmid, Type: "qemu", Node: node, Name: fields[1]})
+	}
+
+	stdout, stderr, err = c.runner.Run(ctx, "pct", "list")
+	if err != nil {
+		return nil, NewCommandError("pct list failed", err, stderr)
+	}
+	for _, row := range parseColumns(stdout) {
+		vmid, err := strconv.Atoi(row["VMID"])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pct list entry: %v", row)
+		}
+		resources = append(resources, vmResource{VMID: vmid, Type: "lxc", Node: node, Name: row["Name"], Lock: row["Lock"]})
+	}
+
+	for i := range resources {
+		res := &resources[i]
+		res.Pool = poolOf[res.VMID]
+		// Read past the config cache: the lock changes during the run.
+		config, err := c.readVMConfigFile(ctx, res.Type, res.VMID)
+		if err != nil {
+			return nil, err
+		}
+		if lock := configOption(config, "lock"); lock != "" {
+			res.Lock = lock
+		}
+		res.Tags = configOption(config, "tags")
+		for _, disk := range DiskLayouts(res.Type, config) {
+			res.MaxDisk += disk.Size
+		}
+	}
+	return resources, nil
+}
+
+// listRows returns the lines of a qm/pct list output after its header.
+func listRows(output string) []string {
+	var rows []string
+	for i, line := range strings.Split(output, "\n") {
+		if i == 0 || strings.TrimSpace(line) == "" {
+			continue
+		}
+		rows = append(rows, line)
+	}
+	return rows
+}
+
+// parseColumns parses a table whose columns are left-aligned under their
+// header, such as pct list, where empty cells are blank.
+func parseColumns(output string) []map[string]string {
+	lines := strings.Split(output, "\n")
+	if len(lines) == 0 {
+		return nil
+	}
+	header := lines[0]
+	var (
+		names  []string
+		starts []int
+	)
+	for i := 0; i < len(header); i++ {
+		if header[i] != ' ' && (i == 0 || header[i-1] == ' ') {
+			end := strings.IndexByte(header[i:], ' ')
+			if end < 0 {
+				end = len(header) - i
+			}
+			names = append(names, header[i:i+end])
+			starts = append(starts, i)
+		}
+	}
+
+	var rows []map[string]string
+	for _, line := range listRows(output) {
+		row := make(map[string]string, len(names))
+		for i, name := range names {
+			if starts[i] >= len(line) {
+				break
+			}
+			end := len(line)
+			if i+1 < len(starts) && starts[i+1] < end {
+				end = starts[i+1]
+			}
+			row[name] = strings.TrimSpace(line[starts[i]:end])
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// configOption returns the value of key in the current section of a guest
+// config.
+func configOption(config []byte, key string) string {
+	for _, line := range strings.Split(string(config), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(name) == key {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// configDescription returns the description of a guest config, stored as
+// the comment lines at its top.
+func configDescription(config []byte) string {
+	var lines []string
+	for _, line := range strings.Split(string(config), "\n") {
+		comment, ok := strings.CutPrefix(line, "#")
+		if !ok {
+			break
+		}
+		if decoded, err := url.PathUnescape(comment); err == nil {
+			comment = decoded
+		}
+		lines = append(lines, comment)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cliPools returns the members of every pool of user.cfg, whose pool lines
+// read pool:<name>:<comment>:<vmid>,...:<storage>,...:
+func (c *Client) cliPools(ctx context.Context) (map[string][]int, error) {
+	data, err := c.readNodeFile(ctx, pveUserConfig)
+	if err != nil {
+		return nil, err
+	}
+	pools := make(map[string][]int)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if len(fields) < 2 || fields[0] != "pool" {
+			continue
+		}
+		var vmids []int
+		if len(fields) > 3 {
+			for _, item := range strings.Split(fields[3], ",") {
+				if vmid, err := strconv.Atoi(strings.TrimSpace(item)); err == nil {
+					vmids = append(vmids, vmid)
+				}
+			}
+		}
+		pools[fields[1]] = vmids
+	}
+	return pools, nil
+}
+
+func (c *Client) cliPoolExists(ctx context.Context, pool string) (bool, error) {
+	pools, err := c.cliPools(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, ok := pools[pool]
+	return ok, nil
+}
+
+// cliPoolVMIDs returns the members of pool hosted on the node.
+func (c *Client) cliPoolVMIDs(ctx context.Context, pool string) ([]int, error) {
+	pools, err := c.cliPools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	members, ok := pools[pool]
+	if !ok {
+		return nil, fmt.Errorf("pool %s does not exist", pool)
+	}
+	resources, err := c.listResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterVMIDs(slices.DeleteFunc(resources, func(res vmResource) bool {
+		return !slices.Contains(members, res.VMID)
+	}), c.cfg.Node), nil
+}
+
+func (c *Client) cliAddToPool(ctx context.Context, pool string, vmid int) error {
+	_, stderr, err := c.runner.Run(ctx, "pveum", "pool", "modify", pool, "--vms", strconv.Itoa(vmid))
+	if err != nil {
+		return NewCommandError("pveum pool modify failed", err, stderr)
+	}
+	return nil
+}
+
+// cliSetConfig sets options of a guest config with qm set or pct set.
+func (c *Client) cliSetConfig(ctx context.Context, vmType string, vmid int, options ...string) error {
+	cmd := "qm"
+	if vmType == "lxc" {
+		cmd = "pct"
+	}
+	_, stderr, err := c.runner.Run(ctx, cmd, append([]string{"set", strconv.Itoa(vmid)}, options...)...)
+	if err != nil {
+		return NewCommandError(fmt.Sprintf("%s set failed for %d", cmd, vmid), err, stderr)
+	}
+	return nil
+}
+
+// cliStorages parses the storage definitions of storage.cfg:
+//
+//	<type>: <id>
+//		<key> <value>
+func (c *Client) cliStorages(ctx context.Context) (map[string]StorageInfo, error) {
+	data, err := c.readNodeFile(ctx, pveStorageConfig)
+	if err != nil {
+		return nil, err
+	}
+	storages := make(map[string]StorageInfo)
+	var current *StorageInfo
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			storageType, id, ok := strings.Cut(line, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid %s line: %s", pveStorageConfig, line)
+			}
+			info := StorageInfo{ID: strings.TrimSpace(id), Type: strings.TrimSpace(storageType)}
+			storages[info.ID] = info
+			current = &info
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "path":
+			current.Path = value
+		case "pool":
+			current.Pool = value
+		case "namespace":
+			current.Namespace = value
+		case "vgname":
+			current.VGName = value
+		case "thinpool":
+			current.ThinPool = value
+		case "content":
+			current.Content = value
+		case "monhost":
+			current.Monhost = value
+		case "username":
+			current.Username = value
+		case "shared":
+			current.Shared, _ = strconv.Atoi(value)
+		case "server":
+			current.Server = value
+		case "port":
+			current.Port, _ = strconv.Atoi(value)
+		case "datastore":
+			current.Datastore = value
+		case "fingerprint":
+			current.Fingerprint = value
+		}
+		storages[current.ID] = *current
+	}
+	return storages, nil
+}
+
+func (c *Client) cliStorage(ctx context.Context, id string) (StorageInfo, error) {
+	storages, err := c.cliStorages(ctx)
+	if err != nil {
+		return StorageInfo{}, err
+	}
+	info, ok := storages[id]
+	if !ok {
+		return StorageInfo{}, fmt.Errorf("storage %s does not exist in %s", id, pveStorageConfig)
+	}
+	return info, nil
+}
+
+// cliNodeStorages reads the status of the storages from pvesm status, in
+// KiB, and their content types from storage.cfg.
+func (c *Client) cliNodeStorages(ctx context.Context) (map[string]NodeStorage, error) {
+	definitions, err := c.cliStorages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr, err := c.runner.Run(ctx, "pvesm", "status")
+	if err != nil {
+		return nil, NewCommandError("pvesm status failed", err, stderr)
+	}
+
+	storages := make(map[string]NodeStorage)
+	for _, line := range listRows(stdout) {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("failed to parse pvesm status line: %s", line)
+		}
+		storage := NodeStorage{
+			ID:      fields[0],
+			Type:    fields[1],
+			Content: definitions[fields[0]].Content,
+		}
+		if fields[2] == "active" {
+			storage.Active = 1
+		}
+		if fields[2] != "disabled" {
+			storage.Enabled = 1
+		}
+		total, _ := strconv.ParseInt(fields[3], 10, 64)
+		avail, _ := strconv.ParseInt(fields[5], 10, 64)
+		storage.Total, storage.Avail = total*1024, avail*1024
+		storages[storage.ID] = storage
+	}
+	return storages, nil
+}
+
+// cliNodeLoad reads the load average from /proc/loadavg, and the CPU usage
+// and IO wait from two samples of /proc/stat one second apart.
+func (c *Client) cliNodeLoad(ctx context.Context) (NodeLoad, error) {
+	data, err := c.readNodeFile(ctx, "/proc/loadavg")
+	if err != nil {
+		return NodeLoad{}, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return NodeLoad{}, fmt.Errorf("failed to parse /proc/loadavg")
+	}
+	var load NodeLoad
+	if load.Load, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return NodeLoad{}, fmt.Errorf("failed to parse /proc/loadavg: %w", err)
+	}
+
+	first, cpus, err := c.cpuSample(ctx)
+	if err != nil {
+		return NodeLoad{}, err
+	}
+	select {
+	case <-ctx.Done():
+		return NodeLoad{}, ctx.Err()
+	case <-time.After(time.Second):
+	}
+	second, _, err := c.cpuSample(ctx)
+	if err != nil {
+		return NodeLoad{}, err
+	}
+
+	var total, idle, iowait float64
+	for i := range second {
+		delta := float64(second[i] - first[i])
+		total += delta
+		switch i {
+		case 3:
+			idle = delta
+		case 4:
+			iowait = delta
+		}
+	}
+	load.CPUs = cpus
+	if total > 0 {
+		load.CPU = (total - idle - iowait) / total * 100
+		load.IOWait = iowait / total * 100
+	}
+	return load, nil
+}
+
+// cpuSample returns the counters of the cpu line of /proc/stat (user,
+// nice, system, idle, iowait...) and the number of CPUs.
+func (c *Client) cpuSample(ctx context.Context) ([]uint64, int, error) {
+	data, err := c.readNodeFile(ctx, "/proc/stat")
+	if err != nil {
+		return nil, 0, err
+	}
+	var (
+		counters []uint64
+		cpus     int
+	)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if fields[0] != "cpu" {
+			cpus++
+			continue
+		}
+		for _, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to parse /proc/stat: %w", err)
+			}
+			counters = append(counters, value)
+		}
+	}
+	if len(counters) < 5 {
+		return nil, 0, fmt.Errorf("failed to parse /proc/stat: no cpu line")
+	}
+	return counters, cpus, nil
+}
+
+// cliNodeLocation reads the time zone of the node from /etc/timezone.
+func (c *Client) cliNodeLocation(ctx context.Context) (*time.Location, error) {
+	data, err := c.readNodeFile(ctx, "/etc/timezone")
+	if err != nil {
+		return nil, err
+	}
+	return time.LoadLocation(strings.TrimSpace(string(data)))
+}
+
+// cliActiveBackupTasks lists the active vzdump tasks of the node with
+// pvenode, which prints them as /nodes/<node>/tasks does.
+func (c *Client) cliActiveBackupTasks(ctx context.Context) ([]nodeTask, error) {
+	stdout, stderr, err := c.runner.Run(ctx, "pvenode", "task", "list", "--source", "active", "--typefilter", "vzdump", "--output-format", "json")
+	if err != nil {
+		return nil, NewCommandError("pvenode task list failed", err, stderr)
+	}
+	var tasks []nodeTask
+	if err := json.Unmarshal([]byte(stdout), &tasks); err != nil {
+		return nil, fmt.Errorf("failed to parse node tasks: %w", err)
+	}
+	return tasks, nil
+}
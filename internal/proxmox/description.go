@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// backupReferencePrefix starts the description line managed by
+// SetBackupReference. Other lines of the description are left untouched.
+const backupReferencePrefix = "plakar: "
+
+// SetBackupReference records ref in the description of a guest, shown in
+// the PVE UI, replacing the line written by a previous run.
+func (c *Client) SetBackupReference(ctx context.Context, vmid int, ref string) error {
+	res, err := c.vmResourceByID(ctx, vmid)
+	if err != nil {
+		return err
+	}
+	if c.cliControl() {
+		configData, err := c.readVMConfigFile(ctx, res.Type, vmid)
+		if err != nil {
+			return err
+		}
+		current := configDescription(configData)
+		description := withBackupReference(current, ref)
+		if description == current {
+			return nil
+		}
+		err = c.cliSetConfig(ctx, res.Type, vmid, "--description", description)
+		c.guestConfigs.drop(res.Type, vmid)
+		return err
+	}
+	endpoint := fmt.Sprintf("/nodes/%s/%s/%d/config", res.Node, res.Type, vmid)
+
+	stdout, err := c.runPvesh(ctx, "failed to read guest config", "get", endpoint, "--output-format", "json")
+	if err != nil {
+		return err
+	}
+	var config struct {
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &config); err != nil {
+		return fmt.Errorf("failed to parse guest config: %w", err)
+	}
+
+	description := withBackupReference(config.Description, ref)
+	if description == config.Description {
+		return nil
+	}
+	_, err = c.runPvesh(ctx, "failed to update guest description", "set", endpoint, "--description", description)
+	c.guestConfigs.drop(res.Type, vmid)
+	return err
+}
+
+func withBackupReference(description, ref string) string {
+	line := backupReferencePrefix + ref
+	lines := strings.Split(strings.TrimRight(description, "\n"), "\n")
+	for i, existing := range lines {
+		if strings.HasPrefix(existing, backupReferencePrefix) {
+			lines[i] = line
+			return strings.Join(lines, "\n")
+		}
+	}
+	if strings.TrimSpace(description) == "" {
+		return line
+	}
+	return strings.Join(append(lines, "", line), "\n")
+}
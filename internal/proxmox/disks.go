@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	qemuDiskKeyRegex = regexp.MustCompile(`^(ide|sata|scsi|virtio)\d+$|^efidisk\d+$|^tpmstate\d+$`)
+	lxcDiskKeyRegex  = regexp.MustCompile(`^rootfs$|^mp\d+$`)
+)
+
+// GuestDisk is a disk or mount point of a guest config, such as
+// "scsi0: local-zfs:vm-100-disk-0,iothread=1,size=32G".
+type GuestDisk struct {
+	Key     string
+	Storage string
+	Volume  string
+
+	// Options is the rest of the config value, after the volume.
+	Options string
+}
+
+// VolID returns the storage:volume identifier of the disk.
+func (d GuestDisk) VolID() string {
+	return d.Storage + ":" + d.Volume
+}
+
+// Option returns the value of a key=value option of the disk.
+func (d GuestDisk) Option(name string) (string, bool) {
+	for _, opt := range strings.Split(d.Options, ",") {
+		key, value, ok := strings.Cut(opt, "=")
+		if ok && strings.TrimSpace(key) == name {
+			return strings.TrimSpace(value), true
+		}
+	}
+	return "", false
+}
+
+// Backed reports whether the disk lives on a PVE storage and is part of
+// backups: CD-ROMs, backup=0 disks and bind mounts or pass-through devices
+// (absolute paths) are left out, as vzdump does.
+func (d GuestDisk) Backed() bool {
+	if d.Storage == "" || strings.EqualFold(d.Storage, "none") {
+		return false
+	}
+	if media, _ := d.Option("media"); media == "cdrom" {
+		return false
+	}
+	if backup, ok := d.Option("backup"); ok && (backup == "0" || backup == "no") {
+		return false
+	}
+	return true
+}
+
+// ParseGuestDisks returns the disks of a guest config, in config order.
+// Only the current config is read, not its snapshot sections. Absolute
+// paths get an empty storage.
+func ParseGuestDisks(vmType string, config []byte) []GuestDisk {
+	keyRegex := qemuDiskKeyRegex
+	if vmType == "lxc" {
+		keyRegex = lxcDiskKeyRegex
+	}
+
+	var disks []GuestDisk
+	for _, line := range strings.Split(string(config), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			break
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if !keyRegex.MatchString(key) {
+			continue
+		}
+
+		volume, options, _ := strings.Cut(strings.TrimSpace(value), ",")
+		disk := GuestDisk{Key: key, Options: options}
+		if storage, name, ok := strings.Cut(volume, ":"); ok && !strings.HasPrefix(volume, "/") {
+			disk.Storage = storage
+			disk.Volume = name
+		} else {
+			disk.Volume = volume
+		}
+		disks = append(disks, disk)
+	}
+	return disks
+}
+
+// DiskLayout is a disk of the guest as recorded in the dump metadata.
+type DiskLayout struct {
+	Key      string `json:"key"`
+	Storage  string `json:"storage,omitempty"`
+	VolID    string `json:"volid"`
+	Format   string `json:"format,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Discard  bool   `json:"discard,omitempty"`
+	IOThread bool   `json:"iothread,omitempty"`
+
+	// Backed is false for disks left out of the backup (backup=0, bind
+	// mounts, pass-through devices).
+	Backed bool `json:"backed"`
+}
+
+// DiskLayouts returns the layout of the disks of a guest config. CD-ROM
+// drives are not disks and are left out.
+func DiskLayouts(vmType string, config []byte) []DiskLayout {
+	var layouts []DiskLayout
+	for _, disk := range ParseGuestDisks(vmType, config) {
+		if media, _ := disk.Option("media"); media == "cdrom" || strings.EqualFold(disk.Volume, "none") {
+			continue
+		}
+		layout := DiskLayout{
+			Key:     disk.Key,
+			Storage: disk.Storage,
+			VolID:   disk.Volume,
+			Format:  diskFormat(vmType, disk),
+			Backed:  disk.Backed(),
+		}
+		if disk.Storage != "" {
+			layout.VolID = disk.VolID()
+		}
+		if raw, ok := disk.Option("size"); ok {
+			layout.Size, _ = ParseDiskSize(raw)
+		}
+		if discard, _ := disk.Option("discard"); discard == "on" {
+			layout.Discard = true
+		}
+		if iothread, _ := disk.Option("iothread"); iothread == "1" || iothread == "on" {
+			layout.IOThread = true
+		}
+		layouts = append(layouts, layout)
+	}
+	return layouts
+}
+
+// diskFormat returns the format option of the disk, or the one implied by
+// its volume: the file extension, subvolumes for container directories,
+// raw otherwise. Paths outside of storages have no format.
+func diskFormat(vmType string, disk GuestDisk) string {
+	if format, ok := disk.Option("format"); ok {
+		return format
+	}
+	if disk.Storage == "" {
+		return ""
+	}
+	switch ext := path.Ext(disk.Volume); ext {
+	case ".qcow2", ".raw", ".vmdk":
+		return strings.TrimPrefix(ext, ".")
+	}
+	if vmType == "lxc" && strings.HasPrefix(path.Base(disk.Volume), "subvol-") {
+		return "subvol"
+	}
+	return "raw"
+}
+
+// ParseDiskSize parses a disk size as written in guest configs: bytes, or
+// a number with a K, M, G or T suffix.
+func ParseDiskSize(raw string) (int64, bool) {
+	raw = strings.TrimSpace(raw)
+	shift := 0
+	if raw != "" {
+		switch raw[len(raw)-1] {
+		case 'K', 'k':
+			shift = 10
+		case 'M', 'm':
+			shift = 20
+		case 'G', 'g':
+			shift = 30
+		case 'T', 't':
+			shift = 40
+		}
+		if shift != 0 {
+			raw = raw[:len(raw)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(raw, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return int64(n * float64(int64(1)<<shift)), true
+}
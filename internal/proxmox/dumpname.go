@@ -17,12 +17,15 @@
 package proxmox
 
 import (
+	"cmp"
 	"fmt"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 const DumpFilenameVersion = 1
@@ -34,6 +37,20 @@ var dumpNameRegex = regexp.MustCompile(`^vzdump(?:-v(\d+))?-(qemu|lxc)-(\d+)-`)
 
 var archiveNameTemplate = `^vzdump(?:-v\d+)?-(qemu|lxc)-%d-.*\.(vma|tar)(\..+)?$`
 var archiveSuffixRegex = regexp.MustCompile(`^\.(vma|tar)(\.[a-z0-9]+)?$`)
+var dumpTimestampRegex = regexp.MustCompile(`-(\d{4}_\d{2}_\d{2}-\d{2}_\d{2}_\d{2}|\d{8}T\d{6})(Z?)`)
+
+const dumpTimestampLayout = "2006_01_02-15_04_05"
+
+// Values of timestamp_format.
+const (
+	TimestampFormatVzdump  = "vzdump"
+	TimestampFormatISO8601 = "iso8601"
+)
+
+var timestampLayouts = map[string]string{
+	TimestampFormatVzdump:  dumpTimestampLayout,
+	TimestampFormatISO8601: "20060102T150405",
+}
 
 func ParseDumpFilename(name string) (string, int, error) {
 	base := filepath.Base(name)
@@ -60,19 +77,186 @@ func ParseDumpFilename(name string) (string, int, error) {
 	return matches[2], vmid, nil
 }
 
+// ParseDumpTimestamp parses the timestamp of a dump name, in either
+// timestamp_format. Timestamps without the Z suffix of timestamp_utc are
+// read in local time.
+func ParseDumpTimestamp(name string) (time.Time, bool) {
+	return parseDumpTimestampIn(name, time.Local)
+}
+
+func parseDumpTimestampIn(name string, loc *time.Location) (time.Time, bool) {
+	matches := dumpTimestampRegex.FindStringSubmatch(filepath.Base(name))
+	if len(matches) != 3 {
+		return time.Time{}, false
+	}
+	layout := timestampLayouts[TimestampFormatVzdump]
+	if strings.Contains(matches[1], "T") {
+		layout = timestampLayouts[TimestampFormatISO8601]
+	}
+	if matches[2] == "Z" {
+		loc = time.UTC
+	}
+	ts, err := time.ParseInLocation(layout, matches[1], loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ts, true
+}
+
+// FormatDumpTimestamp formats t for the dump names the plugin builds, with
+// timestamp_format, in UTC with a Z suffix when timestamp_utc is set.
+func (c *Config) FormatDumpTimestamp(t time.Time) string {
+	layout, ok := timestampLayouts[c.TimestampFormat]
+	if !ok {
+		layout = dumpTimestampLayout
+	}
+	if c.TimestampUTC {
+		return t.UTC().Format(layout) + "Z"
+	}
+	return t.Format(layout)
+}
+
+// RewritesDumpTimestamps reports whether timestamp_utc or timestamp_format
+// change the timestamps of vzdump's names.
+func (c *Config) RewritesDumpTimestamps() bool {
+	return c.TimestampUTC || (c.TimestampFormat != "" && c.TimestampFormat != TimestampFormatVzdump)
+}
+
 func isArchiveForVM(name string, vmid int) bool {
 	pattern := fmt.Sprintf(archiveNameTemplate, vmid)
 	re := regexp.MustCompile(pattern)
 	return re.MatchString(name)
 }
 
-func BuildDumpFilename(_ *Config, vmType string, vmid int, timestamp, baseExt, compressionSuffix string) string {
-	return fmt.Sprintf("vzdump-%s-%d-%s.%s%s", vmType, vmid, timestamp, baseExt, compressionSuffix)
+// DumpFilenameWithGuestName inserts the guest name after the VMID of a
+// vzdump archive name, e.g. vzdump-qemu-100-webserver-2026_01_01-00_00_00.vma.
+// The name is reduced to letters, digits and dashes so the archive still
+// parses; a name left empty leaves archive unchanged.
+func DumpFilenameWithGuestName(archive, vmName string) string {
+	loc := dumpNameRegex.FindStringIndex(archive)
+	name := guestNameComponent(vmName)
+	if loc == nil || name == "" {
+		return archive
+	}
+	return archive[:loc[1]] + name + "-" + archive[loc[1]:]
+}
+
+func guestNameComponent(vmName string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range vmName {
+		if r < 0x80 && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimRight(b.String(), "-")
+}
+
+// DefaultDumpNameTemplate is the dump_name_template of vzdump's own names.
+const DefaultDumpNameTemplate = "vzdump-{type}-{vmid}-{timestamp}"
+
+var dumpNameFieldRegex = regexp.MustCompile(`\{([^{}]*)\}`)
+
+var dumpNameFields = []string{"type", "vmid", "name", "node", "timestamp"}
+
+// DumpName holds the fields of dump_name_template.
+type DumpName struct {
+	Type      string
+	VMID      int
+	Name      string
+	Node      string
+	Timestamp string
+}
+
+// ValidateDumpNameTemplate checks that the names rendered by
+// dump_name_template, with the configured timestamp_format and
+// timestamp_utc, parse back to their type, VMID and timestamp, which
+// restores rely on. The vzdump archives renamed by RenameDump are checked
+// the same way.
+func (c *Config) ValidateDumpNameTemplate() error {
+	template := c.DumpNameTemplate
+	if template == "" {
+		return nil
+	}
+	for _, match := range dumpNameFieldRegex.FindAllStringSubmatch(template, -1) {
+		if !slices.Contains(dumpNameFields, match[1]) {
+			return fmt.Errorf("invalid dump_name_template: unknown field {%s} (expected one of %s)", match[1], strings.Join(dumpNameFields, ", "))
+		}
+	}
+	if strings.ContainsAny(template, `/\`) {
+		return fmt.Errorf("invalid dump_name_template: %s contains a path separator", template)
+	}
+
+	createdAt := time.Date(2001, 2, 3, 4, 5, 6, 0, time.Local)
+	sample := DumpName{Type: "lxc", VMID: 123456, Name: "sample", Node: "pve", Timestamp: c.FormatDumpTimestamp(createdAt)}
+	vzdumpName := fmt.Sprintf("vzdump-%s-%d-%s.tar.zst", sample.Type, sample.VMID, createdAt.Format(dumpTimestampLayout))
+	for _, name := range []string{
+		BuildDumpFilename(c, sample, "tar", ".zst"),
+		RenameDump(c, vzdumpName, sample.Name, sample.Node, time.Local),
+	} {
+		vmType, vmid, err := ParseDumpFilename(name)
+		if err != nil || vmType != sample.Type || vmid != sample.VMID || !isArchiveForVM(name, vmid) {
+			return fmt.Errorf("invalid dump_name_template: %s must start with vzdump-{type}-{vmid}-", template)
+		}
+		if ts, ok := ParseDumpTimestamp(name); !ok || !ts.Equal(createdAt) {
+			return fmt.Errorf("invalid dump_name_template: %s must contain -{timestamp}", template)
+		}
+	}
+	return nil
+}
+
+// renderDumpName fills template with name. Guest and node names are
+// reduced to letters, digits and dashes.
+func renderDumpName(template string, name DumpName) string {
+	if template == "" {
+		template = DefaultDumpNameTemplate
+	}
+	return strings.NewReplacer(
+		"{type}", name.Type,
+		"{vmid}", strconv.Itoa(name.VMID),
+		"{name}", cmp.Or(guestNameComponent(name.Name), "unnamed"),
+		"{node}", cmp.Or(guestNameComponent(name.Node), "unknown"),
+		"{timestamp}", name.Timestamp,
+	).Replace(template)
 }
 
-func BuildRestoreDumpFilename(originalName, vmType string, vmid int, now time.Time) string {
-	suffix := canonicalArchiveSuffix(originalName, vmType)
-	return fmt.Sprintf("vzdump-%s-%d-%s%s", vmType, vmid, now.Format("2006_01_02-15_04_05"), suffix)
+func BuildDumpFilename(cfg *Config, name DumpName, baseExt, compressionSuffix string) string {
+	return fmt.Sprintf("%s.%s%s", renderDumpName(cfg.DumpNameTemplate, name), baseExt, compressionSuffix)
+}
+
+func BuildRestoreDumpFilename(cfg *Config, originalName string, name DumpName, now time.Time) string {
+	suffix := canonicalArchiveSuffix(originalName, name.Type)
+	name.Timestamp = cfg.FormatDumpTimestamp(now)
+	return renderDumpName(cfg.DumpNameTemplate, name) + suffix
+}
+
+// RenameDump applies dump_name_template, timestamp_utc and timestamp_format
+// to the name of an archive produced by vzdump, keeping its type, VMID,
+// time and extension. vzdump names the archive in the local time of the
+// node, loc. Names that do not parse are returned unchanged.
+func RenameDump(cfg *Config, archive, vmName, node string, loc *time.Location) string {
+	vmType, vmid, err := ParseDumpFilename(archive)
+	if err != nil {
+		return archive
+	}
+	matches := dumpTimestampRegex.FindStringSubmatch(archive)
+	if len(matches) != 3 {
+		return archive
+	}
+	timestamp := matches[1] + matches[2]
+	if cfg.RewritesDumpTimestamps() {
+		ts, ok := parseDumpTimestampIn(archive, loc)
+		if !ok {
+			return archive
+		}
+		timestamp = cfg.FormatDumpTimestamp(ts.In(loc))
+	}
+	name := DumpName{Type: vmType, VMID: vmid, Name: vmName, Node: node, Timestamp: timestamp}
+	return renderDumpName(cfg.DumpNameTemplate, name) + canonicalArchiveSuffix(archive, vmType)
 }
 
 func BuildQEMUConfigSidecarFilename(archiveName string) string {
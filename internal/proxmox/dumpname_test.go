@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDumpTimestamp(t *testing.T) {
+	tests := []struct {
+		name string
+		want time.Time
+		ok   bool
+	}{
+		{name: "vzdump-qemu-100-2026_03_01-02_03_04.vma.zst", want: time.Date(2026, 3, 1, 2, 3, 4, 0, time.Local), ok: true},
+		{name: "/backup/qemu/100_web/vzdump-qemu-100-2026_03_01-02_03_04.vma", want: time.Date(2026, 3, 1, 2, 3, 4, 0, time.Local), ok: true},
+		{name: "vzdump-lxc-101-2026_03_01-02_03_04Z.tar.zst", want: time.Date(2026, 3, 1, 2, 3, 4, 0, time.UTC), ok: true},
+		{name: "vzdump-lxc-101-20260301T020304.tar", want: time.Date(2026, 3, 1, 2, 3, 4, 0, time.Local), ok: true},
+		{name: "vzdump-lxc-101-20260301T020304Z.tar", want: time.Date(2026, 3, 1, 2, 3, 4, 0, time.UTC), ok: true},
+		{name: "vzdump-qemu-100-web-pve-2026_03_01-02_03_04.vma", want: time.Date(2026, 3, 1, 2, 3, 4, 0, time.Local), ok: true},
+		{name: "vzdump-qemu-100-2026_13_01-02_03_04.vma", ok: false},
+		{name: "vzdump-qemu-100.vma", ok: false},
+		{name: "", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseDumpTimestamp(tt.name)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameDump(t *testing.T) {
+	// vzdump names its archives in the local time of the node.
+	node := time.FixedZone("node", 2*3600)
+	const archive = "vzdump-qemu-100-2026_03_01-12_00_00.vma.zst"
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		archive string
+		vmName  string
+		want    string
+	}{
+		{name: "default", archive: archive, want: archive},
+		{name: "vzdump format", cfg: Config{TimestampFormat: TimestampFormatVzdump}, archive: archive, want: archive},
+		{name: "utc", cfg: Config{TimestampUTC: true}, archive: archive, want: "vzdump-qemu-100-2026_03_01-10_00_00Z.vma.zst"},
+		{name: "iso8601", cfg: Config{TimestampFormat: TimestampFormatISO8601}, archive: archive, want: "vzdump-qemu-100-20260301T120000.vma.zst"},
+		{name: "iso8601 utc", cfg: Config{TimestampFormat: TimestampFormatISO8601, TimestampUTC: true}, archive: archive, want: "vzdump-qemu-100-20260301T100000Z.vma.zst"},
+		{
+			name:    "template",
+			cfg:     Config{DumpNameTemplate: "vzdump-{type}-{vmid}-{name}-{node}-{timestamp}"},
+			archive: archive,
+			vmName:  "web server",
+			want:    "vzdump-qemu-100-web-server-pve1-2026_03_01-12_00_00.vma.zst",
+		},
+		{name: "container", archive: "vzdump-lxc-101-2026_03_01-12_00_00.tar.gz", cfg: Config{TimestampUTC: true}, want: "vzdump-lxc-101-2026_03_01-10_00_00Z.tar.gz"},
+		{name: "not a dump", cfg: Config{TimestampUTC: true}, archive: "backup.vma.zst", want: "backup.vma.zst"},
+		{name: "no timestamp", cfg: Config{TimestampUTC: true}, archive: "vzdump-qemu-100.vma.zst", want: "vzdump-qemu-100.vma.zst"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenameDump(&tt.cfg, tt.archive, tt.vmName, "pve1", node); got != tt.want {
+				t.Errorf("RenameDump(%q) = %q, want %q", tt.archive, got, tt.want)
+			}
+		})
+	}
+}
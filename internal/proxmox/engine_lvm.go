@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// lvmThinEngine backs up the logical volumes of lvmthin storages by
+// copying a thin snapshot with dd, and restores them into new thin
+// volumes. Thin volumes have no incremental export.
+type lvmThinEngine struct {
+	c *Client
+}
+
+func (e *lvmThinEngine) extension() string { return "raw" }
+func (e *lvmThinEngine) incremental() bool { return false }
+
+func (e *lvmThinEngine) storageTypes() []string { return []string{"lvmthin"} }
+
+func (e *lvmThinEngine) volume(disk GuestDisk, storage StorageInfo) (string, error) {
+	if storage.VGName == "" || storage.ThinPool == "" {
+		return "", fmt.Errorf("storage %s has no volume group or thin pool", storage.ID)
+	}
+	return storage.VGName + "/" + disk.Volume, nil
+}
+
+// snapshotName returns the name of the snapshot volume of vol, following
+// the snap_<volume>_<snapshot> naming of PVE.
+func (e *lvmThinEngine) snapshotName(vol engineVolume, snap string) string {
+	return "snap_" + vol.disk.Volume + "_" + snap
+}
+
+func (e *lvmThinEngine) snapshotLV(vol engineVolume, snap string) string {
+	return vol.storage.VGName + "/" + e.snapshotName(vol, snap)
+}
+
+func (e *lvmThinEngine) run(ctx context.Context, msg, name string, args ...string) error {
+	if _, stderr, err := e.c.runner.Run(ctx, name, args...); err != nil {
+		return NewCommandError(msg, err, stderr)
+	}
+	return nil
+}
+
+// snapshot creates a thin snapshot of each volume in turn and activates
+// it for reading; thin snapshots are created with activation skipped.
+func (e *lvmThinEngine) snapshot(ctx context.Context, job *imageJob) error {
+	for i, vol := range job.vols {
+		if err := e.run(ctx, "lvcreate failed", "lvcreate", "-s", "-n", e.snapshotName(vol, job.snap), vol.name); err != nil {
+			_ = e.removeSnapshot(context.Background(), job, job.vols[:i], job.snap)
+			return err
+		}
+		if err := e.run(ctx, "lvchange failed", "lvchange", "-ay", "-K", e.snapshotLV(vol, job.snap)); err != nil {
+			_ = e.removeSnapshot(context.Background(), job, job.vols[:i+1], job.snap)
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *lvmThinEngine) snapshots(ctx context.Context, vol engineVolume) ([]string, error) {
+	return nil, nil
+}
+
+func (e *lvmThinEngine) export(ctx context.Context, job *imageJob, vol engineVolume, base, out string) error {
+	return e.run(ctx, "dd failed for "+vol.name, "dd", "if=/dev/"+e.snapshotLV(vol, job.snap), "of="+out, "bs=4M", "conv=sparse", "status=none")
+}
+
+func (e *lvmThinEngine) removeSnapshot(ctx context.Context, job *imageJob, vols []engineVolume, snap string) error {
+	for _, vol := range vols {
+		if err := e.run(ctx, "lvremove failed", "lvremove", "-y", e.snapshotLV(vol, snap)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore recreates the target volume in the thin pool with the size of
+// the image, then writes the image into it. Zero blocks are skipped, so
+// they stay unallocated in the pool.
+func (e *lvmThinEngine) restore(ctx context.Context, target imageTarget) (string, error) {
+	vol := target.vol
+	info, err := e.c.Stat(ctx, target.image)
+	if err != nil {
+		return "", err
+	}
+
+	if _, _, err := e.c.runner.Run(ctx, "lvs", "--noheadings", "-o", "lv_name", vol.name); err == nil {
+		if err := e.run(ctx, "lvremove failed for "+vol.name, "lvremove", "-y", vol.name); err != nil {
+			return "", err
+		}
+	}
+	if err := e.run(ctx, "lvcreate failed for "+vol.name, "lvcreate", "-y", "-V", strconv.FormatInt(info.Size(), 10)+"b", "-T", vol.storage.VGName+"/"+vol.storage.ThinPool, "-n", vol.disk.Volume); err != nil {
+		return "", err
+	}
+	if err := e.run(ctx, "dd failed for "+vol.name, "dd", "if="+target.image, "of=/dev/"+vol.name, "bs=4M", "conv=sparse,fsync", "status=none"); err != nil {
+		return "", err
+	}
+	return vol.disk.Volume, nil
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// fileStorageTypes are the storage types keeping images as files, where
+// qcow2 volumes hold their snapshots internally.
+var fileStorageTypes = []string{"dir", "nfs", "cifs", "glusterfs", "cephfs", "btrfs"}
+
+var allocatedVolumeRegex = regexp.MustCompile(`successfully created '([^']+)'`)
+
+// qcow2Engine exports the disks of QEMU guests as standalone qcow2 images
+// with qemu-img, reading them from a guest snapshot. The images can be
+// restored into any storage, or used by other hypervisors.
+type qcow2Engine struct {
+	c *Client
+}
+
+func (e *qcow2Engine) extension() string { return "qcow2" }
+func (e *qcow2Engine) incremental() bool { return false }
+
+func (e *qcow2Engine) storageTypes() []string {
+	return append([]string{"zfspool", "rbd", "lvmthin"}, fileStorageTypes...)
+}
+
+func (e *qcow2Engine) volume(disk GuestDisk, storage StorageInfo) (string, error) {
+	return disk.VolID(), nil
+}
+
+func (e *qcow2Engine) run(ctx context.Context, msg, name string, args ...string) (string, error) {
+	stdout, stderr, err := e.c.runner.Run(ctx, name, args...)
+	if err != nil {
+		return "", NewCommandError(msg, err, stderr)
+	}
+	return stdout, nil
+}
+
+// snapshot takes a guest snapshot, which covers every disk at once
+// whatever their storage.
+func (e *qcow2Engine) snapshot(ctx context.Context, job *imageJob) error {
+	if job.vmType != "qemu" {
+		return fmt.Errorf("engine=qcow2 only backs up QEMU guests, vmid %d is %s", job.vmid, job.vmType)
+	}
+	_, err := e.run(ctx, "qm snapshot failed", "qm", "snapshot", strconv.Itoa(job.vmid), job.snap)
+	return err
+}
+
+func (e *qcow2Engine) snapshots(ctx context.Context, vol engineVolume) ([]string, error) {
+	return nil, nil
+}
+
+// source returns the qemu-img arguments reading vol as of snap, and a
+// function undoing what was needed to expose the snapshot.
+func (e *qcow2Engine) source(ctx context.Context, vol engineVolume, snap string) ([]string, func(), error) {
+	storage := vol.storage
+	switch storage.Type {
+	case "zfspool":
+		// zvol snapshots only get a device node with snapdev=visible.
+		dataset := storage.Pool + "/" + vol.disk.Volume
+		if _, err := e.run(ctx, "zfs set snapdev failed", "zfs", "set", "snapdev=visible", dataset); err != nil {
+			return nil, nil, err
+		}
+		cleanup := func() {
+			_, _ = e.run(context.Background(), "zfs inherit snapdev failed", "zfs", "inherit", "snapdev", dataset)
+		}
+		if _, err := e.run(ctx, "udevadm settle failed", "udevadm", "settle"); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		return []string{"-f", "raw", "/dev/zvol/" + dataset + "@" + snap}, cleanup, nil
+	case "rbd":
+		pool := storage.Pool
+		if pool == "" {
+			pool = "rbd"
+		}
+		if storage.Namespace != "" {
+			pool += "/" + storage.Namespace
+		}
+		spec := "rbd:" + pool + "/" + vol.disk.Volume + "@" + snap
+		if storage.Monhost != "" {
+			user := storage.Username
+			if user == "" {
+				user = "admin"
+			}
+			monhosts := strings.FieldsFunc(storage.Monhost, func(r rune) bool { return r == ' ' || r == ',' || r == ';' })
+			for i, monhost := range monhosts {
+				monhosts[i] = strings.ReplaceAll(monhost, ":", `\:`)
+			}
+			spec += ":mon_host=" + strings.Join(monhosts, `\;`) + ":id=" + user + ":keyring=/etc/pve/priv/ceph/" + storage.ID + ".keyring"
+		}
+		return []string{"-f", "raw", spec}, func() {}, nil
+	case "lvmthin":
+		lv := storage.VGName + "/snap_" + vol.disk.Volume + "_" + snap
+		if _, err := e.run(ctx, "lvchange failed", "lvchange", "-ay", "-K", lv); err != nil {
+			return nil, nil, err
+		}
+		return []string{"-f", "raw", "/dev/" + lv}, func() {
+			_, _ = e.run(context.Background(), "lvchange failed", "lvchange", "-an", lv)
+		}, nil
+	default:
+		if path.Ext(vol.disk.Volume) != ".qcow2" {
+			return nil, nil, fmt.Errorf("volume %s is not a qcow2 file, its snapshots cannot be read by engine=qcow2", vol.name)
+		}
+		file, err := e.run(ctx, "pvesm path failed", "pvesm", "path", vol.name)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{"-f", "qcow2", "-l", "snapshot.name=" + snap, strings.TrimSpace(file)}, func() {}, nil
+	}
+}
+
+func (e *qcow2Engine) export(ctx context.Context, job *imageJob, vol engineVolume, base, out string) error {
+	args, cleanup, err := e.source(ctx, vol, job.snap)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	// The running guest keeps a write lock on its qcow2 files, which
+	// --force-share (-U) lets qemu-img read the snapshot through.
+	args = append(append([]string{"convert", "-U"}, args...), "-O", "qcow2", out)
+	_, err = e.run(ctx, "qemu-img convert failed for "+vol.name, "qemu-img", args...)
+	return err
+}
+
+func (e *qcow2Engine) removeSnapshot(ctx context.Context, job *imageJob, vols []engineVolume, snap string) error {
+	if len(vols) == 0 {
+		return nil
+	}
+	_, err := e.run(ctx, "qm delsnapshot failed", "qm", "delsnapshot", strconv.Itoa(job.vmid), snap)
+	return err
+}
+
+// restore allocates a new volume for the image on the target storage, as
+// a qcow2 file on file storages and a raw volume elsewhere, replacing the
+// volume of the same name, and converts the image into it.
+func (e *qcow2Engine) restore(ctx context.Context, target imageTarget) (string, error) {
+	if target.vmType != "qemu" {
+		return "", fmt.Errorf("engine=qcow2 only restores QEMU guests")
+	}
+	stdout, err := e.run(ctx, "qemu-img info failed", "qemu-img", "info", "--output", "json", target.image)
+	if err != nil {
+		return "", err
+	}
+	var info struct {
+		VirtualSize int64 `json:"virtual-size"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &info); err != nil || info.VirtualSize <= 0 {
+		return "", fmt.Errorf("unable to read the size of image %s: %w", target.image, ErrArchiveCorrupt)
+	}
+
+	storage := target.vol.storage
+	name := path.Base(target.vol.disk.Volume)
+	name = strings.TrimSuffix(name, path.Ext(name))
+	format := "raw"
+	volid := storage.ID + ":" + name
+	if slices.Contains(fileStorageTypes, storage.Type) {
+		format = "qcow2"
+		name += ".qcow2"
+		volid = storage.ID + ":" + strconv.Itoa(target.vmid) + "/" + name
+	}
+
+	if _, stderr, err := e.c.runner.Run(ctx, "pvesm", "free", volid); err != nil {
+		output := strings.ToLower(stderr)
+		if !strings.Contains(output, "does not exist") && !strings.Contains(output, "no such") && !strings.Contains(output, "not found") {
+			return "", NewCommandError("pvesm free failed for "+volid, err, stderr)
+		}
+	}
+	sizeKiB := strconv.FormatInt((info.VirtualSize+1023)/1024, 10)
+	stdout, err = e.run(ctx, "pvesm alloc failed for "+volid, "pvesm", "alloc", storage.ID, strconv.Itoa(target.vmid), name, sizeKiB, "--format", format)
+	if err != nil {
+		return "", err
+	}
+	if matches := allocatedVolumeRegex.FindStringSubmatch(stdout); matches != nil {
+		volid = matches[1]
+	}
+	file, err := e.run(ctx, "pvesm path failed", "pvesm", "path", volid)
+	if err != nil {
+		return "", err
+	}
+	if _, err := e.run(ctx, "qemu-img convert failed for "+volid, "qemu-img", "convert", "-n", "-f", "qcow2", "-O", format, target.image, strings.TrimSpace(file)); err != nil {
+		return "", err
+	}
+	_, volume, _ := strings.Cut(volid, ":")
+	return volume, nil
+}
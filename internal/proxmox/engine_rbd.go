@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// rbdEngine backs up images of rbd storages with rbd export and
+// export-diff, and restores them with rbd import and import-diff.
+type rbdEngine struct {
+	c *Client
+}
+
+type rbdSnapshot struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func (e *rbdEngine) extension() string { return "rbd" }
+func (e *rbdEngine) incremental() bool { return true }
+
+func (e *rbdEngine) storageTypes() []string { return []string{"rbd"} }
+
+func (e *rbdEngine) volume(disk GuestDisk, storage StorageInfo) (string, error) {
+	pool := storage.Pool
+	if pool == "" {
+		pool = "rbd"
+	}
+	if storage.Namespace != "" {
+		pool += "/" + storage.Namespace
+	}
+	return pool + "/" + disk.Volume, nil
+}
+
+// connArgs prefixes args with the options reaching the cluster of
+// storage. External clusters are reached with the monitors and keyring PVE
+// keeps for the storage.
+func (e *rbdEngine) connArgs(storage StorageInfo, args ...string) []string {
+	if storage.Monhost == "" {
+		return args
+	}
+	user := storage.Username
+	if user == "" {
+		user = "admin"
+	}
+	monhost := strings.Join(strings.FieldsFunc(storage.Monhost, func(r rune) bool { return r == ' ' || r == ',' || r == ';' }), ",")
+	return append([]string{"-m", monhost, "--id", user, "--keyring", "/etc/pve/priv/ceph/" + storage.ID + ".keyring"}, args...)
+}
+
+// run runs rbd against the cluster of storage.
+func (e *rbdEngine) run(ctx context.Context, storage StorageInfo, msg string, args ...string) (string, error) {
+	stdout, stderr, err := e.c.runner.Run(ctx, "rbd", e.connArgs(storage, args...)...)
+	if err != nil {
+		return "", NewCommandError(msg, err, stderr)
+	}
+	return stdout, nil
+}
+
+// snapshot snapshots each image in turn: rbd has no snapshot spanning
+// several images, so the disks of a running guest are captured a few
+// milliseconds apart.
+func (e *rbdEngine) snapshot(ctx context.Context, job *imageJob) error {
+	for i, vol := range job.vols {
+		if _, err := e.run(ctx, vol.storage, "rbd snap create failed", "snap", "create", vol.name+"@"+job.snap); err != nil {
+			_ = e.removeSnapshot(context.Background(), job, job.vols[:i], job.snap)
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *rbdEngine) snapshots(ctx context.Context, vol engineVolume) ([]string, error) {
+	stdout, err := e.run(ctx, vol.storage, "rbd snap ls failed", "snap", "ls", "--format", "json", vol.name)
+	if err != nil {
+		return nil, err
+	}
+	var snaps []rbdSnapshot
+	if err := json.Unmarshal([]byte(stdout), &snaps); err != nil {
+		return nil, fmt.Errorf("failed to parse rbd snap ls output: %w", err)
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].ID < snaps[j].ID })
+	var names []string
+	for _, snap := range snaps {
+		if strings.HasPrefix(snap.Name, imageSnapshotPrefix) {
+			names = append(names, snap.Name)
+		}
+	}
+	return names, nil
+}
+
+func (e *rbdEngine) exportArgs(job *imageJob, vol engineVolume, base, out string) []string {
+	if base != "" {
+		return []string{"export-diff", "--no-progress", "--from-snap", base, vol.name + "@" + job.snap, out}
+	}
+	return []string{"export", "--no-progress", vol.name + "@" + job.snap, out}
+}
+
+func (e *rbdEngine) export(ctx context.Context, job *imageJob, vol engineVolume, base, out string) error {
+	args := e.exportArgs(job, vol, base, out)
+	_, err := e.run(ctx, vol.storage, "rbd "+args[0]+" failed for "+vol.name, args...)
+	return err
+}
+
+// stream exports the image to stdout, so it is stored without a copy in
+// dump_dir.
+func (e *rbdEngine) stream(ctx context.Context, job *imageJob, vol engineVolume, base string) (*CommandStream, error) {
+	args := e.exportArgs(job, vol, base, "-")
+	stream, err := e.c.runner.Stream(ctx, "rbd", e.connArgs(vol.storage, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("rbd %s failed for %s: %w", args[0], vol.name, err)
+	}
+	return stream, nil
+}
+
+func (e *rbdEngine) removeSnapshot(ctx context.Context, job *imageJob, vols []engineVolume, snap string) error {
+	for _, vol := range vols {
+		if _, err := e.run(ctx, vol.storage, "rbd snap rm failed", "snap", "rm", "--no-progress", vol.name+"@"+snap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore imports a full image in place of the target image, which is
+// removed first, and recreates the snapshot it was exported from so that
+// incremental images can be applied on top. Incremental images are
+// applied to the existing image with import-diff, which checks that it
+// holds their base snapshot.
+func (e *rbdEngine) restore(ctx context.Context, target imageTarget) (string, error) {
+	vol := target.vol
+	if target.incremental {
+		if _, err := e.run(ctx, vol.storage, "rbd import-diff failed for "+vol.name, "import-diff", "--no-progress", target.image, vol.name); err != nil {
+			return "", err
+		}
+		return vol.disk.Volume, nil
+	}
+
+	exists, err := e.exists(ctx, vol)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		if _, err := e.run(ctx, vol.storage, "rbd snap purge failed for "+vol.name, "snap", "purge", "--no-progress", vol.name); err != nil {
+			return "", err
+		}
+		if _, err := e.run(ctx, vol.storage, "rbd rm failed for "+vol.name, "rm", "--no-progress", vol.name); err != nil {
+			return "", err
+		}
+	}
+	if _, err := e.run(ctx, vol.storage, "rbd import failed for "+vol.name, "import", "--no-progress", target.image, vol.name); err != nil {
+		return "", err
+	}
+	if target.snap != "" {
+		if _, err := e.run(ctx, vol.storage, "rbd snap create failed", "snap", "create", vol.name+"@"+target.snap); err != nil {
+			return "", err
+		}
+	}
+	return vol.disk.Volume, nil
+}
+
+func (e *rbdEngine) exists(ctx context.Context, vol engineVolume) (bool, error) {
+	_, err := e.run(ctx, vol.storage, "rbd info failed for "+vol.name, "info", vol.name)
+	if err == nil {
+		return true, nil
+	}
+	if strings.Contains(err.Error(), "No such file or directory") {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// zfsEngine backs up zvols and subvols of zfspool storages with zfs send,
+// and restores them with zfs receive.
+type zfsEngine struct {
+	c *Client
+}
+
+func (e *zfsEngine) extension() string { return "zfs" }
+func (e *zfsEngine) incremental() bool { return true }
+
+func (e *zfsEngine) storageTypes() []string { return []string{"zfspool"} }
+
+func (e *zfsEngine) volume(disk GuestDisk, storage StorageInfo) (string, error) {
+	if storage.Pool == "" {
+		return "", fmt.Errorf("storage %s has no ZFS pool", storage.ID)
+	}
+	return storage.Pool + "/" + disk.Volume, nil
+}
+
+// snapshot takes the snapshots of every disk in a single zfs call, which
+// makes them atomic.
+func (e *zfsEngine) snapshot(ctx context.Context, job *imageJob) error {
+	args := []string{"snapshot"}
+	for _, vol := range job.vols {
+		args = append(args, vol.name+"@"+job.snap)
+	}
+	if _, stderr, err := e.c.runner.Run(ctx, "zfs", args...); err != nil {
+		return NewCommandError("zfs snapshot failed", err, stderr)
+	}
+	return nil
+}
+
+func (e *zfsEngine) snapshots(ctx context.Context, vol engineVolume) ([]string, error) {
+	stdout, stderr, err := e.c.runner.Run(ctx, "zfs", "list", "-H", "-o", "name", "-t", "snapshot", "-s", "createtxg", "-d", "1", vol.name)
+	if err != nil {
+		return nil, NewCommandError("zfs list failed", err, stderr)
+	}
+	var snaps []string
+	for _, line := range strings.Split(stdout, "\n") {
+		if _, snap, ok := strings.Cut(strings.TrimSpace(line), "@"); ok && strings.HasPrefix(snap, imageSnapshotPrefix) {
+			snaps = append(snaps, snap)
+		}
+	}
+	return snaps, nil
+}
+
+func (e *zfsEngine) sendArgs(job *imageJob, vol engineVolume, base string) []string {
+	var args []string
+	if base != "" {
+		args = append(args, "-i", vol.name+"@"+base)
+	}
+	return append(args, vol.name+"@"+job.snap)
+}
+
+func (e *zfsEngine) export(ctx context.Context, job *imageJob, vol engineVolume, base, out string) error {
+	args := append([]string{"-c", `zfs send "$@" > "$0"`, out}, e.sendArgs(job, vol, base)...)
+	if _, stderr, err := e.c.runner.Run(ctx, "sh", args...); err != nil {
+		return NewCommandError("zfs send failed for "+vol.name, err, stderr)
+	}
+	return nil
+}
+
+// stream sends the image on stdout, so it is stored without a copy in
+// dump_dir.
+func (e *zfsEngine) stream(ctx context.Context, job *imageJob, vol engineVolume, base string) (*CommandStream, error) {
+	stream, err := e.c.runner.Stream(ctx, "zfs", append([]string{"send"}, e.sendArgs(job, vol, base)...)...)
+	if err != nil {
+		return nil, fmt.Errorf("zfs send failed for %s: %w", vol.name, err)
+	}
+	return stream, nil
+}
+
+func (e *zfsEngine) removeSnapshot(ctx context.Context, job *imageJob, vols []engineVolume, snap string) error {
+	for _, vol := range vols {
+		if _, stderr, err := e.c.runner.Run(ctx, "zfs", "destroy", vol.name+"@"+snap); err != nil {
+			return NewCommandError("zfs destroy failed", err, stderr)
+		}
+	}
+	return nil
+}
+
+// restore receives the image into the target dataset, replacing it when it
+// exists. Incremental images need the dataset to still hold their base
+// snapshot, which zfs receive checks.
+func (e *zfsEngine) restore(ctx context.Context, target imageTarget) (string, error) {
+	if _, stderr, err := e.c.runner.Run(ctx, "sh", "-c", `zfs receive -F "$1" < "$0"`, target.image, target.vol.name); err != nil {
+		return "", NewCommandError("zfs receive failed for "+target.vol.name, err, stderr)
+	}
+	return target.vol.disk.Volume, nil
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"errors"
+	"strings"
+)
+
+// Failure classes. Errors returned by the client wrap one of these when the
+// cause could be identified, so callers can use errors.Is to decide whether
+// to retry, skip or abort.
+var (
+	ErrGuestLocked    = errors.New("guest is locked")
+	ErrNoSpace        = errors.New("no space left on storage")
+	ErrArchiveCorrupt = errors.New("archive is corrupt")
+	ErrVMNotFound     = errors.New("VM/CT not found")
+	ErrAuth           = errors.New("authentication failed")
+	ErrStalled        = errors.New("transfer stalled")
+	ErrConnectionLost = errors.New("connection to the node lost")
+)
+
+// CommandError reports a failed command on the node along with its output.
+type CommandError struct {
+	Op     string
+	Err    error
+	Output string
+	Kind   error
+}
+
+// NewCommandError wraps err, classifying it from the command output.
+func NewCommandError(op string, err error, output string) error {
+	output = strings.TrimSpace(output)
+	return &CommandError{
+		Op:     op,
+		Err:    err,
+		Output: output,
+		Kind:   ClassifyOutput(output),
+	}
+}
+
+func (e *CommandError) Error() string {
+	msg := e.Op + ": " + e.Err.Error()
+	if e.Output != "" {
+		msg += ": " + e.Output
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() []error {
+	if e.Kind != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Err}
+}
+
+// ClassifyOutput maps well-known Proxmox error messages to a failure class,
+// or returns nil. The patterns are the messages of vzdump, qmrestore, tar,
+// the decompressors and pvesh, specific enough not to match the output of
+// an unrelated failure.
+func ClassifyOutput(output string) error {
+	normalized := strings.ToLower(output)
+	switch {
+	case normalized == "":
+		return nil
+	case isGuestLockedError(normalized):
+		return ErrGuestLocked
+	case strings.Contains(normalized, "no space left on device"),
+		strings.Contains(normalized, "not enough space"),
+		strings.Contains(normalized, "disk quota exceeded"):
+		return ErrNoSpace
+	case strings.Contains(normalized, "unexpected end of file"),
+		strings.Contains(normalized, "unexpected eof in archive"),
+		strings.Contains(normalized, "checksum mismatch"),
+		strings.Contains(normalized, "not in gzip format"),
+		strings.Contains(normalized, "does not look like a tar archive"),
+		strings.Contains(normalized, "data corruption detected"),
+		strings.Contains(normalized, "corrupted block detected"),
+		strings.Contains(normalized, "wrong vma extent header"):
+		return ErrArchiveCorrupt
+	case strings.Contains(normalized, "configuration file") && strings.Contains(normalized, "does not exist"),
+		strings.Contains(normalized, "no such vm"),
+		strings.Contains(normalized, "no such container"):
+		return ErrVMNotFound
+	case strings.Contains(normalized, "permission check failed"),
+		strings.Contains(normalized, "permission denied (publickey"),
+		strings.Contains(normalized, "authentication failure"),
+		strings.Contains(normalized, "unable to authenticate"):
+		return ErrAuth
+	}
+	return nil
+}
+
+// ErrorClass returns a short, stable name for the failure class of err, or
+// an empty string when it is not classified.
+func ErrorClass(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrGuestLocked):
+		return "guest_locked"
+	case errors.Is(err, ErrNoSpace):
+		return "no_space"
+	case errors.Is(err, ErrArchiveCorrupt):
+		return "archive_corrupt"
+	case errors.Is(err, ErrVMNotFound):
+		return "vm_not_found"
+	case errors.Is(err, ErrAuth):
+		return "auth"
+	case errors.Is(err, ErrStalled):
+		return "stalled"
+	case errors.Is(err, ErrConnectionLost):
+		return "connection_lost"
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import "testing"
+
+func TestClassifyOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   error
+	}{
+		{name: "empty", output: "", want: nil},
+		{name: "unrelated", output: "INFO: starting new backup job", want: nil},
+		{name: "vm locked", output: "VM is locked (backup)", want: ErrGuestLocked},
+		{name: "ct locked", output: "CT 100 is locked (snapshot)", want: ErrGuestLocked},
+		{name: "lock timeout", output: "can't lock file '/var/lock/vzdump.lock' - got timeout", want: ErrGuestLocked},
+		{name: "no space", output: "write error: No space left on device", want: ErrNoSpace},
+		{name: "quota", output: "Disk quota exceeded", want: ErrNoSpace},
+		{name: "truncated tar", output: "tar: Unexpected EOF in archive", want: ErrArchiveCorrupt},
+		{name: "gzip", output: "gzip: stdin: not in gzip format", want: ErrArchiveCorrupt},
+		{name: "zstd", output: "zstd: error 70 : Write error : Data corruption detected", want: ErrArchiveCorrupt},
+		{name: "vma", output: "vma: wrong vma extent header checksum", want: ErrArchiveCorrupt},
+		{name: "missing config", output: "Configuration file 'nodes/pve/qemu-server/100.conf' does not exist", want: ErrVMNotFound},
+		{name: "no such vm", output: "no such VM ('100')", want: ErrVMNotFound},
+		{name: "no such container", output: "no such container 101", want: ErrVMNotFound},
+		{name: "permission", output: "Permission check failed (/vms/100, VM.Backup)", want: ErrAuth},
+		{name: "publickey", output: "root@pve: Permission denied (publickey,password).", want: ErrAuth},
+		{name: "ticket", output: "authentication failure", want: ErrAuth},
+		{name: "plain permission denied", output: "mkdir: cannot create directory: Permission denied", want: nil},
+		{name: "config without missing", output: "configuration file updated", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyOutput(tt.output); got != tt.want {
+				t.Errorf("ClassifyOutput(%q) = %v, want %v", tt.output, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsGuestLockedError(t *testing.T) {
+	tests := []struct {
+		output string
+		want   bool
+	}{
+		{output: "VM is locked (backup)", want: true},
+		{output: "ERROR: VM 100 is locked (migrate)", want: true},
+		{output: "CT 101 is locked (backup)", want: true},
+		{output: "can't lock file '/var/run/vzdump.lock' - got timeout", want: true},
+		{output: "can't acquire lock '/var/run/lock/qemu-server/lock-100.conf'", want: true},
+		{output: "the screen is locked", want: false},
+		{output: "pvm 100 is locked", want: false},
+		{output: "backup finished", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isGuestLockedError(tt.output); got != tt.want {
+			t.Errorf("isGuestLockedError(%q) = %v, want %v", tt.output, got, tt.want)
+		}
+	}
+}
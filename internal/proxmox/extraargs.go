@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// extraArgNameRegex matches the option names of the PVE tools.
+var extraArgNameRegex = regexp.MustCompile(`^--[a-z][a-z0-9-]*$`)
+
+// vzdumpManagedArgs are the vzdump options set by the plugin itself, which
+// backup_extra_args may not override.
+var vzdumpManagedArgs = []string{
+	"--all", "--exclude", "--pool", "--dumpdir", "--storage", "--stdout",
+	"--mode", "--compress", "--node", "--lockwait", "--remove", "--prune-backups",
+}
+
+// ParseExtraArgs splits the value of an extra arguments option into the
+// argv appended to a PVE command. Words are split on blanks, single and
+// double quotes group words as in a shell, and every word must be either
+// an option (--name or --name=value) or the single value following one.
+// Options listed in managed are refused.
+func ParseExtraArgs(option, value string, managed []string) ([]string, error) {
+	words, err := splitArgs(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %w", option, err)
+	}
+
+	var (
+		args       []string
+		takesValue bool
+	)
+	for _, word := range words {
+		if !strings.HasPrefix(word, "--") {
+			if !takesValue {
+				return nil, fmt.Errorf("invalid %s value: %q is not an option", option, word)
+			}
+			args = append(args, word)
+			takesValue = false
+			continue
+		}
+
+		name, _, hasValue := strings.Cut(word, "=")
+		if !extraArgNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid %s value: invalid option name %q", option, name)
+		}
+		if slices.Contains(managed, name) {
+			return nil, fmt.Errorf("invalid %s value: %s is set by the plugin", option, name)
+		}
+		args = append(args, word)
+		takesValue = !hasValue
+	}
+	return args, nil
+}
+
+// splitArgs splits value into words like a POSIX shell would, without any
+// expansion. Control characters are refused.
+func splitArgs(value string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range value {
+		if r < 0x20 && r != '\t' || r == 0x7f {
+			return nil, fmt.Errorf("control character %q", r)
+		}
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// hasExtraArg reports whether args, as returned by ParseExtraArgs, set the
+// option name.
+func hasExtraArg(args []string, name string) bool {
+	for _, arg := range args {
+		if option, _, _ := strings.Cut(arg, "="); option == name {
+			return true
+		}
+	}
+	return false
+}
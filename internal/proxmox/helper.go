@@ -0,0 +1,501 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/nodehelper"
+)
+
+// nodeHelperDir is where node_helper is pushed: a directory only the
+// connecting user (root) may write to, so nobody else on the node can
+// replace the binary it runs.
+const nodeHelperDir = "/var/lib/plakar-proxmox"
+
+// errHelperClosed is returned by the calls of a closed helper session.
+var errHelperClosed = errors.New("node helper session closed")
+
+// helperSession is a running proxmox-helper. Calls may be issued
+// concurrently: their responses are routed back by request ID.
+type helperSession struct {
+	stream *CommandStream
+
+	writeMu sync.Mutex
+	stdin   *io.PipeWriter
+	out     *bufio.Writer
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan helperReply
+	err     error
+}
+
+type helperReply struct {
+	resp    nodehelper.Response
+	payload []byte
+}
+
+// DiskUsage is the size and free space of a node filesystem, in bytes.
+type DiskUsage struct {
+	Total int64
+	Free  int64
+	Avail int64
+}
+
+// startHelperSession pushes node_helper through runner and starts it. The
+// session lives until close, whatever ctx becomes.
+func startHelperSession(ctx context.Context, runner Runner, cfg *Config, logger *slog.Logger) (*helperSession, error) {
+	remotePath, err := pushNodeHelper(ctx, runner, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	stdinReader, stdinWriter := io.Pipe()
+	stream, err := runner.Stream(WithStdin(context.WithoutCancel(ctx), stdinReader), remotePath)
+	if err != nil {
+		_ = stdinWriter.Close()
+		return nil, err
+	}
+	go func() {
+		_, _ = io.Copy(io.Discard, stream.Stderr)
+	}()
+
+	s := &helperSession{
+		stream:  stream,
+		stdin:   stdinWriter,
+		out:     bufio.NewWriter(stdinWriter),
+		pending: make(map[int]chan helperReply),
+	}
+	in := bufio.NewReader(stream.Stdout)
+	hello, _, err := nodehelper.ReadResponse(in)
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("node helper did not start: %w", err)
+	}
+	if hello.Version != nodehelper.Version {
+		s.close()
+		return nil, fmt.Errorf("node helper speaks protocol version %d, expected %d", hello.Version, nodehelper.Version)
+	}
+	go s.readLoop(in)
+	logger.Debug("node helper started", "path", remotePath)
+	return s, nil
+}
+
+// pushNodeHelper uploads node_helper to the node, under a name derived from
+// its checksum so an up to date copy is reused. The copy on the node is
+// only run once its owner, mode and sha256 have been checked, every time a
+// session starts.
+func pushNodeHelper(ctx context.Context, runner Runner, cfg *Config, logger *slog.Logger) (string, error) {
+	data, err := os.ReadFile(cfg.NodeHelper)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	remotePath := path.Join(nodeHelperDir, "proxmox-helper-"+checksum[:12])
+
+	uid, err := prepareHelperDir(ctx, runner)
+	if err != nil {
+		return "", err
+	}
+	if err := checkHelperFile(ctx, runner, uid, remotePath, checksum); err == nil {
+		return remotePath, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("node helper copy rejected, pushing it again", "path", remotePath, "error", err)
+	}
+
+	// Concurrent runs each upload their own copy and rename it in place.
+	partPath := remotePath + ".part-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	writer, err := runner.Create(ctx, partPath)
+	if err != nil {
+		return "", err
+	}
+	if _, err := writer.Write(data); err != nil {
+		_ = writer.Close()
+		_ = runner.Remove(ctx, partPath)
+		return "", fmt.Errorf("node helper upload failed: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		_ = runner.Remove(ctx, partPath)
+		return "", fmt.Errorf("node helper upload failed: %w", err)
+	}
+	if _, stderr, err := runner.Run(ctx, "chmod", "0700", "--", partPath); err != nil {
+		_ = runner.Remove(ctx, partPath)
+		return "", NewCommandError("chmod failed", err, stderr)
+	}
+	if _, stderr, err := runner.Run(ctx, "mv", "-f", "--", partPath, remotePath); err != nil {
+		_ = runner.Remove(ctx, partPath)
+		return "", NewCommandError("mv failed", err, stderr)
+	}
+	if err := checkHelperFile(ctx, runner, uid, remotePath, checksum); err != nil {
+		return "", fmt.Errorf("node helper rejected after upload: %w", err)
+	}
+	logger.Info("node helper pushed", "path", remotePath)
+	return remotePath, nil
+}
+
+// prepareHelperDir creates nodeHelperDir with mode 0700 and checks that it
+// belongs to the connecting user, whose UID it returns.
+func prepareHelperDir(ctx context.Context, runner Runner) (int, error) {
+	stdout, stderr, err := runner.Run(ctx, "id", "-u")
+	if err != nil {
+		return 0, NewCommandError("id failed", err, stderr)
+	}
+	uid, err := strconv.Atoi(strings.TrimSpace(stdout))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected id output: %s", strings.TrimSpace(stdout))
+	}
+	if _, stderr, err := runner.Run(ctx, "mkdir", "-p", "-m", "0700", "--", nodeHelperDir); err != nil {
+		return 0, NewCommandError("mkdir failed", err, stderr)
+	}
+	if _, stderr, err := runner.Run(ctx, "chmod", "0700", "--", nodeHelperDir); err != nil {
+		return 0, NewCommandError("chmod failed", err, stderr)
+	}
+	owner, mode, err := statOwner(ctx, runner, nodeHelperDir)
+	if err != nil {
+		return 0, err
+	}
+	if owner != uid || mode&0077 != 0 {
+		return 0, fmt.Errorf("refusing node helper directory %s: owned by uid %d with mode %04o, expected uid %d and 0700", nodeHelperDir, owner, mode, uid)
+	}
+	return uid, nil
+}
+
+// checkHelperFile checks that the node helper at remotePath belongs to uid,
+// is writable by nobody else and has the expected sha256. It wraps
+// fs.ErrNotExist when there is no such file.
+func checkHelperFile(ctx context.Context, runner Runner, uid int, remotePath, checksum string) error {
+	owner, mode, err := statOwner(ctx, runner, remotePath)
+	if err != nil {
+		return err
+	}
+	if owner != uid || mode&0022 != 0 {
+		return fmt.Errorf("%s is owned by uid %d with mode %04o, expected uid %d and no group or other write", remotePath, owner, mode, uid)
+	}
+	stdout, stderr, err := runner.Run(ctx, "sha256sum", "--", remotePath)
+	if err != nil {
+		return NewCommandError("sha256sum failed", err, stderr)
+	}
+	if fields := strings.Fields(stdout); len(fields) == 0 || !strings.EqualFold(fields[0], checksum) {
+		return fmt.Errorf("%s does not match the sha256 of node_helper", remotePath)
+	}
+	return nil
+}
+
+// statOwner returns the owner UID and permission bits of a node file.
+func statOwner(ctx context.Context, runner Runner, filepath string) (int, fs.FileMode, error) {
+	stdout, stderr, err := runner.Run(ctx, "stat", "-c", "%u %a", "--", filepath)
+	if err != nil {
+		if strings.Contains(stderr, "No such file or directory") {
+			return 0, 0, &fs.PathError{Op: "stat", Path: filepath, Err: fs.ErrNotExist}
+		}
+		return 0, 0, NewCommandError("stat failed", err, stderr)
+	}
+	fields := strings.Fields(stdout)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected stat output: %s", strings.TrimSpace(stdout))
+	}
+	owner, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected stat output: %s", strings.TrimSpace(stdout))
+	}
+	mode, err := strconv.ParseUint(fields[1], 8, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected stat output: %s", strings.TrimSpace(stdout))
+	}
+	return owner, fs.FileMode(mode), nil
+}
+
+func (s *helperSession) readLoop(in *bufio.Reader) {
+	for {
+		resp, payload, err := nodehelper.ReadResponse(in)
+		if err != nil {
+			if err == io.EOF {
+				err = errHelperClosed
+			}
+			s.fail(fmt.Errorf("reading node helper response: %w", err))
+			return
+		}
+		s.mu.Lock()
+		reply := s.pending[resp.ID]
+		delete(s.pending, resp.ID)
+		s.mu.Unlock()
+		if reply != nil {
+			reply <- helperReply{resp: resp, payload: payload}
+		}
+	}
+}
+
+// fail breaks the session: pending and later calls return err.
+func (s *helperSession) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	pending := s.pending
+	s.pending = make(map[int]chan helperReply)
+	s.mu.Unlock()
+	for _, reply := range pending {
+		close(reply)
+	}
+	_ = s.stdin.CloseWithError(err)
+}
+
+func (s *helperSession) failure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		return errHelperClosed
+	}
+	return s.err
+}
+
+// call sends req with payload and returns its request ID and the channel
+// its response is delivered on. The channel is closed without a response
+// when the session breaks.
+func (s *helperSession) call(req nodehelper.Request, payload []byte) (int, <-chan helperReply, error) {
+	reply := make(chan helperReply, 1)
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return 0, nil, s.err
+	}
+	s.nextID++
+	req.ID = s.nextID
+	s.pending[req.ID] = reply
+	s.mu.Unlock()
+
+	req.Len = len(payload)
+	s.writeMu.Lock()
+	err := nodehelper.WriteMessage(s.out, req, payload)
+	if err == nil {
+		err = s.out.Flush()
+	}
+	s.writeMu.Unlock()
+	if err != nil {
+		err = fmt.Errorf("writing node helper request: %w", err)
+		s.fail(err)
+		return 0, nil, err
+	}
+	return req.ID, reply, nil
+}
+
+// do runs one request and waits for its response.
+func (s *helperSession) do(req nodehelper.Request, payload []byte) (nodehelper.Response, []byte, error) {
+	_, reply, err := s.call(req, payload)
+	if err != nil {
+		return nodehelper.Response{}, nil, err
+	}
+	return s.wait(reply)
+}
+
+func (s *helperSession) wait(reply <-chan helperReply) (nodehelper.Response, []byte, error) {
+	r, ok := <-reply
+	if !ok {
+		return nodehelper.Response{}, nil, s.failure()
+	}
+	return r.resp, r.payload, nil
+}
+
+func (s *helperSession) broken() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err != nil
+}
+
+func (s *helperSession) close() {
+	s.writeMu.Lock()
+	_ = s.stdin.Close()
+	s.writeMu.Unlock()
+	if err := s.stream.Finish(); err != nil {
+		_ = s.stream.Abort()
+	}
+}
+
+// nodeHelper returns the helper session of the metadata requests, starting
+// it on first use. It is nil when node_helper is not set, in local mode,
+// with conn_multiplex (the runner already goes through the helper), or
+// once the helper failed: callers then use plain commands.
+func (c *Client) nodeHelper(ctx context.Context) *helperSession {
+	if c.cfg.NodeHelper == "" || c.cfg.Mode == ModeLocal || c.cfg.ConnMultiplex {
+		return nil
+	}
+	c.helperMu.Lock()
+	defer c.helperMu.Unlock()
+	if c.helper == nil && !c.helperDisabled {
+		helper, err := startHelperSession(ctx, c.runner, c.cfg, c.logger)
+		if err != nil {
+			c.logger.Warn("node helper unavailable, using plain commands", "error", err)
+			c.helperDisabled = true
+		}
+		c.helper = helper
+	}
+	return c.helper
+}
+
+// disableNodeHelper stops a helper that failed mid-run.
+func (c *Client) disableNodeHelper(helper *helperSession, err error) {
+	c.logger.Warn("node helper failed, using plain commands", "error", err)
+	c.helperMu.Lock()
+	if c.helper == helper {
+		c.helper = nil
+		c.helperDisabled = true
+	}
+	c.helperMu.Unlock()
+	helper.close()
+}
+
+func (c *Client) closeNodeHelper() {
+	c.helperMu.Lock()
+	helper := c.helper
+	c.helper = nil
+	c.helperMu.Unlock()
+	if helper != nil {
+		helper.close()
+	}
+}
+
+// helperRequest runs one request on the node helper. ok is false when
+// there is no helper, and the caller must use plain commands.
+func (c *Client) helperRequest(ctx context.Context, op, filepath string) (resp nodehelper.Response, ok bool) {
+	helper := c.nodeHelper(ctx)
+	if helper == nil {
+		return nodehelper.Response{}, false
+	}
+	resp, _, err := helper.do(nodehelper.Request{Op: op, Path: filepath}, nil)
+	if err != nil {
+		c.disableNodeHelper(helper, err)
+		return nodehelper.Response{}, false
+	}
+	return resp, true
+}
+
+func helperError(op, filepath string, resp nodehelper.Response) error {
+	if resp.NotExist {
+		return &fs.PathError{Op: op, Path: filepath, Err: fs.ErrNotExist}
+	}
+	return fmt.Errorf("%s %s: %s", op, filepath, resp.Error)
+}
+
+func helperFileInfo(entry nodehelper.Entry) os.FileInfo {
+	mode := os.FileMode(0600)
+	if entry.Dir {
+		mode |= os.ModeDir
+	}
+	return &remoteFileInfo{
+		name:    entry.Name,
+		size:    entry.Size,
+		mode:    mode,
+		modTime: time.Unix(entry.ModTime, 0),
+	}
+}
+
+// ListDir returns the files of a node directory with their size and
+// modification time, in one helper request or with ls and one stat per
+// file.
+func (c *Client) ListDir(ctx context.Context, dir string) ([]os.FileInfo, error) {
+	if resp, ok := c.helperRequest(ctx, nodehelper.OpList, dir); ok {
+		if resp.Error != "" {
+			return nil, helperError("list", dir, resp)
+		}
+		infos := make([]os.FileInfo, len(resp.Entries))
+		for i, entry := range resp.Entries {
+			infos[i] = helperFileInfo(entry)
+		}
+		return infos, nil
+	}
+
+	stdout, stderr, err := c.runner.Run(ctx, "ls", "-1", "--", dir)
+	if err != nil {
+		return nil, NewCommandError("listing failed", err, stderr)
+	}
+	var infos []os.FileInfo
+	for _, name := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		info, err := c.runner.Stat(ctx, path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Checksum returns the hex sha256 of a node file.
+func (c *Client) Checksum(ctx context.Context, filepath string) (string, error) {
+	if resp, ok := c.helperRequest(ctx, nodehelper.OpSHA256, filepath); ok {
+		if resp.Error != "" {
+			return "", helperError("sha256", filepath, resp)
+		}
+		return resp.SHA256, nil
+	}
+
+	stdout, stderr, err := c.runner.Run(ctx, "sha256sum", "--", filepath)
+	if err != nil {
+		return "", NewCommandError("sha256sum failed", err, stderr)
+	}
+	fields := strings.Fields(stdout)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("unexpected sha256sum output: %s", strings.TrimSpace(stdout))
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// DiskFree returns the usage of the node filesystem holding dir.
+func (c *Client) DiskFree(ctx context.Context, dir string) (DiskUsage, error) {
+	if resp, ok := c.helperRequest(ctx, nodehelper.OpDF, dir); ok {
+		if resp.Error != "" {
+			return DiskUsage{}, helperError("df", dir, resp)
+		}
+		return DiskUsage{Total: resp.Total, Free: resp.Free, Avail: resp.Avail}, nil
+	}
+
+	stdout, stderr, err := c.runner.Run(ctx, "df", "-P", "-B1", "--", dir)
+	if err != nil {
+		return DiskUsage{}, NewCommandError("df failed", err, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 4 {
+		return DiskUsage{}, fmt.Errorf("unexpected df output: %s", strings.TrimSpace(stdout))
+	}
+	var usage [3]int64
+	for i := range usage {
+		if usage[i], err = strconv.ParseInt(fields[i+1], 10, 64); err != nil {
+			return DiskUsage{}, fmt.Errorf("unexpected df output: %s", strings.TrimSpace(stdout))
+		}
+	}
+	return DiskUsage{Total: usage[0], Free: usage[0] - usage[1], Avail: usage[2]}, nil
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imageBasesName is the file of dump_dir recording, for each guest backed
+// up with engine_incremental, the storage snapshot of the last run that
+// handed all of its records to plakar. The next incremental images are
+// exported from it.
+const imageBasesName = ".plakar-proxmox-bases.json"
+
+func imageBaseKey(engine string, vmid int) string {
+	return engine + "/" + strconv.Itoa(vmid)
+}
+
+// imageBase returns the confirmed base snapshot of a guest, or "".
+func (c *Client) imageBase(ctx context.Context, engine string, vmid int) (string, error) {
+	bases, err := c.readImageBases(ctx)
+	if err != nil {
+		return "", err
+	}
+	return bases[imageBaseKey(engine, vmid)], nil
+}
+
+func (c *Client) readImageBases(ctx context.Context) (map[string]string, error) {
+	data, err := c.readNodeFile(ctx, path.Join(c.cfg.DumpDir, imageBasesName))
+	if errors.Is(err, fs.ErrNotExist) || (err != nil && strings.Contains(err.Error(), "No such file or directory")) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	bases := map[string]string{}
+	if err := json.Unmarshal(data, &bases); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", imageBasesName, err)
+	}
+	return bases, nil
+}
+
+// ConfirmImageBases records the snapshots kept by the incremental backups
+// of the run as the bases of the next one. The importer calls it once the
+// run handed every record to plakar: until then, the next run keeps
+// exporting from the previous base, which stays on the storage.
+func (c *Client) ConfirmImageBases(ctx context.Context) error {
+	c.imageBasesMu.Lock()
+	pending := c.pendingBases
+	c.pendingBases = nil
+	c.imageBasesMu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	bases, err := c.readImageBases(ctx)
+	if err != nil {
+		return err
+	}
+	for key, snap := range pending {
+		bases[key] = snap
+	}
+	data, err := json.MarshalIndent(bases, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	target := path.Join(c.cfg.DumpDir, imageBasesName)
+	partPath := target + ".part-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	writer, err := c.runner.Create(ctx, partPath)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", target, err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		_ = writer.Close()
+		_ = c.runner.Remove(ctx, partPath)
+		return fmt.Errorf("unable to write %s: %w", target, err)
+	}
+	if err := writer.Close(); err != nil {
+		_ = c.runner.Remove(ctx, partPath)
+		return fmt.Errorf("unable to write %s: %w", target, err)
+	}
+	if _, stderr, err := c.runner.Run(ctx, "mv", "-f", "--", partPath, target); err != nil {
+		_ = c.runner.Remove(ctx, partPath)
+		return NewCommandError("mv failed", err, stderr)
+	}
+	return nil
+}
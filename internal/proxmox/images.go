@@ -0,0 +1,706 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backup engines. vzdump produces one archive per guest; the other engines
+// snapshot the guest disks on their storage and export one image per disk.
+const (
+	EngineVzdump  = "vzdump"
+	EngineZFS     = "zfs"
+	EngineRBD     = "rbd"
+	EngineLVMThin = "lvmthin"
+	EngineQCOW2   = "qcow2"
+)
+
+// BackupEngines lists the accepted values of the engine option.
+var BackupEngines = []string{EngineVzdump, EngineZFS, EngineRBD, EngineLVMThin, EngineQCOW2}
+
+// IncrementalEngines lists the engines accepting engine_incremental.
+var IncrementalEngines = []string{EngineZFS, EngineRBD}
+
+// imageSnapshotPrefix names the storage snapshots taken by the disk
+// engines, so that the latest one can be found again for incrementals.
+const imageSnapshotPrefix = "plakar-"
+
+const incrementalImageSuffix = ".incr"
+
+var imageNameRegex = regexp.MustCompile(`^plakar-(.+?)-(qemu|lxc)-(\d+)-(\d{4}_\d{2}_\d{2}-\d{2}_\d{2}_\d{2})(?:_((?:ide|sata|scsi|virtio|efidisk|tpmstate|mp)\d+|rootfs)(\.incr)?\.([a-z0-9]+))?$`)
+
+// ImageName is a parsed disk image name,
+// plakar-<engine>-<type>-<vmid>-<timestamp>_<disk>[.incr].<ext>. The part
+// before the disk is the archive name shared by the images of a backup and
+// by its sidecars.
+type ImageName struct {
+	Archive     string
+	Engine      string
+	Type        string
+	VMID        int
+	Disk        string
+	Incremental bool
+}
+
+func BuildImageArchiveName(engine, vmType string, vmid int, createdAt time.Time) string {
+	return fmt.Sprintf("plakar-%s-%s-%d-%s", engine, vmType, vmid, createdAt.Format(dumpTimestampLayout))
+}
+
+func BuildImageFilename(archive, disk, ext string, incremental bool) string {
+	name := archive + "_" + disk
+	if incremental {
+		name += incrementalImageSuffix
+	}
+	return name + "." + ext
+}
+
+// imageSnapshotName returns the name of the storage snapshot the images of
+// archive are exported from.
+func imageSnapshotName(archive string) string {
+	return imageSnapshotPrefix + archive[len(archive)-len(dumpTimestampLayout):]
+}
+
+// ParseImageFilename parses the name of a disk image record.
+func ParseImageFilename(name string) (ImageName, bool) {
+	image, ok := parseImageName(filepath.Base(name))
+	if !ok || image.Disk == "" {
+		return ImageName{}, false
+	}
+	return image, true
+}
+
+func parseImageName(base string) (ImageName, bool) {
+	matches := imageNameRegex.FindStringSubmatch(base)
+	if matches == nil {
+		return ImageName{}, false
+	}
+	vmid, err := strconv.Atoi(matches[3])
+	if err != nil {
+		return ImageName{}, false
+	}
+	return ImageName{
+		Archive:     fmt.Sprintf("plakar-%s-%s-%d-%s", matches[1], matches[2], vmid, matches[4]),
+		Engine:      matches[1],
+		Type:        matches[2],
+		VMID:        vmid,
+		Disk:        matches[5],
+		Incremental: matches[6] != "",
+	}, true
+}
+
+// ParseArchiveName returns the guest type and VMID of a vzdump archive or
+// of a disk engine archive.
+func ParseArchiveName(name string) (string, int, error) {
+	if image, ok := parseImageName(filepath.Base(name)); ok && image.Disk == "" {
+		return image.Type, image.VMID, nil
+	}
+	return ParseDumpFilename(name)
+}
+
+// ArchiveEngine returns the engine that produced an archive, from its
+// name.
+func ArchiveEngine(name string) string {
+	if image, ok := parseImageName(filepath.Base(name)); ok {
+		return image.Engine
+	}
+	return EngineVzdump
+}
+
+// CheckRestoreEngine reports whether archives produced by engine can be
+// restored into a guest of type vmType by this plugin.
+func CheckRestoreEngine(engine, vmType string) error {
+	if !slices.Contains(BackupEngines, engine) {
+		return fmt.Errorf("archive was produced by engine=%s, which this plugin cannot restore", engine)
+	}
+	if engine == EngineQCOW2 && vmType != "qemu" {
+		return fmt.Errorf("engine=%s archives can only be restored as QEMU guests, not %s", engine, vmType)
+	}
+	return nil
+}
+
+// CheckImageStorage reports whether the images of engine can be restored
+// into storage.
+func (c *Client) CheckImageStorage(ctx context.Context, engine, storage string) error {
+	eng, err := newDiskEngine(c, engine)
+	if err != nil {
+		return err
+	}
+	info, err := c.Storage(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(eng.storageTypes(), info.Type) {
+		return fmt.Errorf("storage %s (%s) cannot receive engine=%s images, it must be one of: %s", storage, info.Type, engine, strings.Join(eng.storageTypes(), ", "))
+	}
+	return nil
+}
+
+// diskEngine backs up and restores guest disks through storage snapshots.
+type diskEngine interface {
+	// extension is the file extension of the exported images.
+	extension() string
+	incremental() bool
+	// storageTypes lists the storage types the engine handles.
+	storageTypes() []string
+	// volume returns the node-side name of disk on a storage of one of
+	// the storageTypes.
+	volume(disk GuestDisk, storage StorageInfo) (string, error)
+	snapshot(ctx context.Context, job *imageJob) error
+	// snapshots returns the plakar snapshots of vol, oldest first.
+	snapshots(ctx context.Context, vol engineVolume) ([]string, error)
+	// export writes the image of vol at job.snap to out, as an increment
+	// from base when base is set.
+	export(ctx context.Context, job *imageJob, vol engineVolume, base, out string) error
+	removeSnapshot(ctx context.Context, job *imageJob, vols []engineVolume, snap string) error
+	// restore writes an image into the target volume and returns the
+	// volume name to put in the guest config.
+	restore(ctx context.Context, target imageTarget) (string, error)
+}
+
+// streamEngine is implemented by the engines able to send an image on
+// stdout. Their images are read by plakar while the storage produces them
+// instead of being written to dump_dir first.
+type streamEngine interface {
+	// stream starts sending the image of vol at job.snap, as an increment
+	// from base when base is set.
+	stream(ctx context.Context, job *imageJob, vol engineVolume, base string) (*CommandStream, error)
+}
+
+type engineVolume struct {
+	disk    GuestDisk
+	storage StorageInfo
+	name    string
+}
+
+type imageJob struct {
+	vmType string
+	vmid   int
+	vols   []engineVolume
+	snap   string
+}
+
+type imageTarget struct {
+	vmType      string
+	vmid        int
+	vol         engineVolume
+	image       string
+	incremental bool
+	// snap is the storage snapshot the image was exported from.
+	snap string
+}
+
+// engineVolumeName checks that eng handles storage and returns the name
+// of disk on it.
+func engineVolumeName(eng diskEngine, engine string, disk GuestDisk, storage StorageInfo) (string, error) {
+	if !slices.Contains(eng.storageTypes(), storage.Type) {
+		return "", fmt.Errorf("storage %s (%s) is not supported by engine=%s", storage.ID, storage.Type, engine)
+	}
+	return eng.volume(disk, storage)
+}
+
+func newDiskEngine(c *Client, engine string) (diskEngine, error) {
+	switch engine {
+	case EngineZFS:
+		return &zfsEngine{c: c}, nil
+	case EngineRBD:
+		return &rbdEngine{c: c}, nil
+	case EngineLVMThin:
+		return &lvmThinEngine{c: c}, nil
+	case EngineQCOW2:
+		return &qcow2Engine{c: c}, nil
+	default:
+		return nil, fmt.Errorf("unsupported disk engine: %s", engine)
+	}
+}
+
+// ImageBackup is the result of a disk engine backup: one image per disk,
+// read from a storage snapshot that stays until ReleaseImageBackup.
+type ImageBackup struct {
+	Archive   string
+	CreatedAt time.Time
+	Images    []*BackupImage
+
+	engine      string
+	eng         diskEngine
+	job         *imageJob
+	incremental bool
+	// snaps lists the plakar snapshots of each volume before the backup,
+	// and bases the one its image is an increment from.
+	snaps [][]string
+	bases []string
+}
+
+// BackupImage is the image of one disk. Images of engines that stream are
+// read from the storage when plakar reads the record, through the image
+// itself; the others are written to Path in dump_dir.
+type BackupImage struct {
+	// Name is the file name of the image record.
+	Name string
+	// Path is the image in dump_dir, or "" when it is streamed.
+	Path string
+
+	open   func() (io.ReadCloser, func(), error)
+	reader io.ReadCloser
+	abort  func()
+	eof    bool
+	size   int64
+	err    error
+	once   sync.Once
+	done   chan struct{}
+}
+
+// errImageNotRead is the error of a streamed image closed before it was
+// read, e.g. because the record was excluded.
+var errImageNotRead = errors.New("image was not read")
+
+// Read starts the image stream on first use.
+func (i *BackupImage) Read(p []byte) (int, error) {
+	if i.reader == nil {
+		if i.err != nil {
+			return 0, i.err
+		}
+		reader, abort, err := i.open()
+		if err != nil {
+			i.err = err
+			return 0, err
+		}
+		i.reader, i.abort = reader, abort
+	}
+	n, err := i.reader.Read(p)
+	i.size += int64(n)
+	if err == io.EOF {
+		i.eof = true
+	} else if err != nil && i.err == nil {
+		i.err = err
+	}
+	return n, err
+}
+
+// Close stops the image stream, when it was not read to the end, and
+// records how it ended.
+func (i *BackupImage) Close() error {
+	i.once.Do(func() {
+		switch {
+		case i.reader == nil:
+			if i.err == nil {
+				i.err = errImageNotRead
+			}
+		default:
+			if !i.eof && i.err == nil {
+				i.err = errImageNotRead
+			}
+			if !i.eof {
+				i.abort()
+			}
+			if err := i.reader.Close(); err != nil && i.err == nil {
+				i.err = err
+			}
+		}
+		close(i.done)
+	})
+	return nil
+}
+
+// Size returns the bytes read from a streamed image so far.
+func (i *BackupImage) Size() int64 {
+	return i.size
+}
+
+// Wait waits until plakar is done with the streamed images of the backup
+// and returns the first error met while reading them.
+func (b *ImageBackup) Wait(ctx context.Context) error {
+	for _, image := range b.Images {
+		if image.Path != "" {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-image.done:
+		}
+		if image.err != nil {
+			return fmt.Errorf("image %s: %w", image.Name, image.err)
+		}
+	}
+	return nil
+}
+
+// BackupImages snapshots the disks of a guest and prepares one image per
+// disk. With incremental, each image is the difference from the base
+// snapshot confirmed by the last completed run, or a full image when there
+// is none.
+func (c *Client) BackupImages(ctx context.Context, engine string, vmid int, incremental bool) (*ImageBackup, error) {
+	ctx, span := c.tracer.Start(ctx, "image_backup", "vmid", vmid, "engine", engine)
+	backup, err := c.backupImages(ctx, engine, vmid, incremental)
+	if backup != nil {
+		span.SetAttribute("archive", backup.Archive)
+	}
+	span.End(err)
+	return backup, err
+}
+
+func (c *Client) backupImages(ctx context.Context, engine string, vmid int, incremental bool) (*ImageBackup, error) {
+	eng, err := newDiskEngine(c, engine)
+	if err != nil {
+		return nil, err
+	}
+	if incremental && !eng.incremental() {
+		return nil, fmt.Errorf("engine=%s does not support incremental backups", engine)
+	}
+
+	vmType, err := c.VMType(ctx, vmid)
+	if err != nil {
+		return nil, err
+	}
+	config, err := c.readVMConfig(ctx, vmType, vmid)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &imageJob{vmType: vmType, vmid: vmid}
+	for _, disk := range ParseGuestDisks(vmType, config) {
+		if !disk.Backed() {
+			c.logger.Info("disk left out of the backup", "vmid", vmid, "disk", disk.Key, "volume", disk.Volume)
+			continue
+		}
+		storage, err := c.Storage(ctx, disk.Storage)
+		if err != nil {
+			return nil, err
+		}
+		name, err := engineVolumeName(eng, engine, disk, storage)
+		if err != nil {
+			return nil, fmt.Errorf("disk %s of vmid %d: %w", disk.Key, vmid, err)
+		}
+		job.vols = append(job.vols, engineVolume{disk: disk, storage: storage, name: name})
+	}
+	if len(job.vols) == 0 {
+		return nil, fmt.Errorf("vmid %d has no disk to back up with engine=%s", vmid, engine)
+	}
+
+	createdAt := time.Now()
+	backup := &ImageBackup{
+		Archive:     BuildImageArchiveName(engine, vmType, vmid, createdAt),
+		CreatedAt:   createdAt,
+		engine:      engine,
+		eng:         eng,
+		job:         job,
+		incremental: incremental,
+		snaps:       make([][]string, len(job.vols)),
+		bases:       make([]string, len(job.vols)),
+	}
+	if incremental {
+		confirmed, err := c.imageBase(ctx, engine, vmid)
+		if err != nil {
+			return nil, err
+		}
+		for i, vol := range job.vols {
+			if backup.snaps[i], err = eng.snapshots(ctx, vol); err != nil {
+				return nil, err
+			}
+			if confirmed != "" && slices.Contains(backup.snaps[i], confirmed) {
+				backup.bases[i] = confirmed
+			} else if len(backup.snaps[i]) > 0 {
+				c.logger.Warn("no confirmed base snapshot, exporting a full image", "vmid", vmid, "volume", vol.name, "confirmed", confirmed)
+			}
+		}
+	}
+
+	job.snap = imageSnapshotName(backup.Archive)
+	if err := eng.snapshot(ctx, job); err != nil {
+		return nil, err
+	}
+
+	streamer, streams := eng.(streamEngine)
+	for i, vol := range job.vols {
+		base := backup.bases[i]
+		image := &BackupImage{
+			Name: BuildImageFilename(backup.Archive, vol.disk.Key, eng.extension(), base != ""),
+			done: make(chan struct{}),
+		}
+		if streams {
+			image.open = func() (io.ReadCloser, func(), error) {
+				return c.openImageStream(ctx, streamer, job, vol, base)
+			}
+			backup.Images = append(backup.Images, image)
+			continue
+		}
+		image.Path = path.Join(c.cfg.DumpDir, image.Name)
+		if err := eng.export(ctx, job, vol, base, image.Path); err != nil {
+			_ = c.Remove(context.Background(), image.Path)
+			c.RemoveImageFiles(backup)
+			c.removeImageSnapshot(eng, job, job.vols, job.snap)
+			return nil, err
+		}
+		backup.Images = append(backup.Images, image)
+	}
+	return backup, nil
+}
+
+// openImageStream starts streaming the image of vol and returns its reader
+// and a function stopping the stream early.
+func (c *Client) openImageStream(ctx context.Context, eng streamEngine, job *imageJob, vol engineVolume, base string) (io.ReadCloser, func(), error) {
+	stream, err := eng.stream(ctx, job, vol, base)
+	if err != nil {
+		return nil, nil, err
+	}
+	stderr := &bytes.Buffer{}
+	stderrDone := make(chan struct{})
+	go func() {
+		defer close(stderrDone)
+		_, _ = io.Copy(stderr, stream.Stderr)
+	}()
+	abort := func() { _ = stream.Abort() }
+	reader := &streamReadCloser{
+		op:         "image export failed for " + vol.name,
+		stdout:     stream.Stdout,
+		finish:     stream.Finish,
+		stderr:     stderr,
+		stderrDone: stderrDone,
+	}
+	return NewStallReader(reader, "image stream", c.cfg.StallTimeout, abort), abort, nil
+}
+
+// RemoveImageFiles removes the images of backup written to dump_dir.
+func (c *Client) RemoveImageFiles(backup *ImageBackup) {
+	for _, image := range backup.Images {
+		if image.Path == "" {
+			continue
+		}
+		if err := c.Remove(context.Background(), image.Path); err != nil {
+			c.logger.Warn("unable to remove disk image", "path", image.Path, "error", err)
+		}
+	}
+}
+
+// ReleaseImageBackup removes the storage snapshot of backup once its
+// images are stored, or failed. With engine_incremental, the snapshot of
+// stored images is kept as the next base, to be confirmed with
+// ConfirmImageBases, along with the base it was exported from: the older
+// plakar snapshots are removed. A failed incremental snapshot is removed,
+// so the next run exports from the same base again.
+func (c *Client) ReleaseImageBackup(backup *ImageBackup, stored bool) {
+	job := backup.job
+	if !backup.incremental || !stored {
+		c.removeImageSnapshot(backup.eng, job, job.vols, job.snap)
+		return
+	}
+
+	stale := make(map[string][]engineVolume)
+	for i, vol := range job.vols {
+		for _, snap := range backup.snaps[i] {
+			if snap != backup.bases[i] {
+				stale[snap] = append(stale[snap], vol)
+			}
+		}
+	}
+	for snap, vols := range stale {
+		c.removeImageSnapshot(backup.eng, job, vols, snap)
+	}
+
+	c.imageBasesMu.Lock()
+	if c.pendingBases == nil {
+		c.pendingBases = make(map[string]string)
+	}
+	c.pendingBases[imageBaseKey(backup.engine, job.vmid)] = job.snap
+	c.imageBasesMu.Unlock()
+}
+
+// removeImageSnapshot removes a snapshot taken by a disk engine. A leftover
+// snapshot only costs space, so failures are logged.
+func (c *Client) removeImageSnapshot(eng diskEngine, job *imageJob, vols []engineVolume, snap string) {
+	if err := eng.removeSnapshot(context.Background(), job, vols, snap); err != nil {
+		c.logger.Warn("unable to remove storage snapshot", "vmid", job.vmid, "snapshot", snap, "error", err)
+	}
+}
+
+// ImageRestore describes the disk images of a backup to restore into a
+// guest.
+type ImageRestore struct {
+	Engine     string
+	VMType     string
+	SourceVMID int
+	TargetVMID int
+
+	// Config is the config sidecar of the backup, which maps images to
+	// disks. It is written as the config of the target guest, with the
+	// restored volumes.
+	Config []byte
+
+	// Images maps disk keys (scsi0, rootfs, ...) to the staged images.
+	Images map[string]RestoreImage
+
+	// Storage, when set, receives every disk instead of its original
+	// storage.
+	Storage string
+
+	// StorageMap maps original storages to the ones receiving their
+	// disks. Storages it does not list are kept.
+	StorageMap map[string]string
+}
+
+type RestoreImage struct {
+	Path        string
+	Incremental bool
+}
+
+// RestoreImages writes the images of a disk engine backup into volumes of
+// the target guest, then writes its config.
+func (c *Client) RestoreImages(ctx context.Context, r ImageRestore) (err error) {
+	ctx, span := c.tracer.Start(ctx, "image_restore", "vmid", r.TargetVMID, "engine", r.Engine)
+	defer func() { span.End(err) }()
+
+	eng, err := newDiskEngine(c, r.Engine)
+	if err != nil {
+		return err
+	}
+
+	volumes := make(map[string]string)
+	var dropped []string
+	for _, disk := range ParseGuestDisks(r.VMType, r.Config) {
+		image, ok := r.Images[disk.Key]
+		if !ok {
+			if disk.Backed() {
+				return fmt.Errorf("missing image of disk %s: %w", disk.Key, ErrArchiveCorrupt)
+			}
+			// backup=0 disks have no data to restore; CD-ROMs and
+			// absolute paths are kept as they are.
+			if media, _ := disk.Option("media"); disk.Storage != "" && media != "cdrom" {
+				dropped = append(dropped, disk.Key)
+			}
+			continue
+		}
+
+		target := disk
+		target.Volume = retargetVolume(disk.Volume, r.SourceVMID, r.TargetVMID)
+		if r.Storage != "" {
+			target.Storage = r.Storage
+		} else if storage, ok := r.StorageMap[disk.Storage]; ok {
+			target.Storage = storage
+		}
+		storage, err := c.Storage(ctx, target.Storage)
+		if err != nil {
+			return err
+		}
+		name, err := engineVolumeName(eng, r.Engine, target, storage)
+		if err != nil {
+			return fmt.Errorf("disk %s: %w", disk.Key, err)
+		}
+
+		var snap string
+		if parsed, ok := ParseImageFilename(image.Path); ok {
+			snap = imageSnapshotName(parsed.Archive)
+		}
+
+		c.logger.Info("restoring disk image", "vmid", r.TargetVMID, "disk", disk.Key, "volume", target.VolID())
+		volume, err := eng.restore(ctx, imageTarget{
+			vmType:      r.VMType,
+			vmid:        r.TargetVMID,
+			vol:         engineVolume{disk: target, storage: storage, name: name},
+			image:       image.Path,
+			incremental: image.Incremental,
+			snap:        snap,
+		})
+		if err != nil {
+			return err
+		}
+		volumes[disk.Key] = target.Storage + ":" + volume
+	}
+	for key := range r.Images {
+		if _, ok := volumes[key]; !ok {
+			return fmt.Errorf("image of disk %s has no entry in the guest config: %w", key, ErrArchiveCorrupt)
+		}
+	}
+
+	configPath, err := VMConfigPath(r.VMType, r.TargetVMID)
+	if err != nil {
+		return err
+	}
+	c.guestConfigs.drop(r.VMType, r.TargetVMID)
+	writer, err := c.Create(ctx, configPath)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(rewriteDiskConfig(r.Config, volumes, dropped)); err != nil {
+		_ = writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+var volumeOwnerRegex = regexp.MustCompile(`^(?:(\d+)/)?((?:vm|subvol|base|basevol)-)(\d+)(-.*)$`)
+
+// retargetVolume renames a volume owned by vmid from to one owned by to,
+// e.g. vm-100-disk-0 to vm-200-disk-0 or 100/vm-100-disk-0.qcow2 to
+// 200/vm-200-disk-0.qcow2.
+func retargetVolume(volume string, from, to int) string {
+	matches := volumeOwnerRegex.FindStringSubmatch(volume)
+	if matches == nil || matches[3] != strconv.Itoa(from) {
+		return volume
+	}
+	renamed := matches[2] + strconv.Itoa(to) + matches[4]
+	if matches[1] != "" {
+		renamed = strconv.Itoa(to) + "/" + renamed
+	}
+	return renamed
+}
+
+// rewriteDiskConfig returns config with the volumes of the given disks
+// replaced and the dropped disks removed. Snapshot sections and the
+// parent, lock and unused entries are removed too: they refer to volumes
+// and snapshots that were not restored.
+func rewriteDiskConfig(config []byte, volumes map[string]string, dropped []string) []byte {
+	var out strings.Builder
+	for _, line := range strings.Split(string(config), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			break
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		key = strings.TrimSpace(key)
+		if ok {
+			switch {
+			case key == "parent" || key == "lock" || strings.HasPrefix(key, "unused"):
+				continue
+			case slices.Contains(dropped, key):
+				continue
+			}
+			if volume, ok := volumes[key]; ok {
+				_, options, hasOptions := strings.Cut(strings.TrimSpace(value), ",")
+				line = key + ": " + volume
+				if hasOptions {
+					line += "," + options
+				}
+			}
+		}
+		out.WriteString(line)
+		out.WriteString("\n")
+	}
+	return []byte(strings.TrimRight(out.String(), "\n") + "\n")
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const jobLockName = ".plakar-proxmox.lock"
+const jobLockOwnerFile = "owner"
+
+// Locks older than this are considered left over by a crashed run.
+const jobLockStaleAfter = 24 * time.Hour
+
+// JobLock is an advisory lock held on the node for the duration of an
+// import or export run. It is a directory created atomically in DumpDir, so
+// it works the same way through the local and SSH runners.
+type JobLock struct {
+	client *Client
+	path   string
+}
+
+func (c *Client) AcquireJobLock(ctx context.Context, job string) (*JobLock, error) {
+	lockPath := path.Join(c.cfg.DumpDir, jobLockName)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		_, stderr, err := c.runner.Run(ctx, "mkdir", "--", lockPath)
+		if err == nil {
+			lock := &JobLock{client: c, path: lockPath}
+			if err := lock.writeOwner(ctx, job); err != nil {
+				_ = lock.Release(ctx)
+				return nil, err
+			}
+			return lock, nil
+		}
+
+		info, statErr := c.runner.Stat(ctx, lockPath)
+		if statErr != nil {
+			return nil, fmt.Errorf("unable to create job lock %s: %w: %s", lockPath, err, strings.TrimSpace(stderr))
+		}
+		now, err := c.nodeTime(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to check job lock %s: %w", lockPath, err)
+		}
+		if now.Sub(info.ModTime()) < jobLockStaleAfter {
+			return nil, fmt.Errorf("another plakar job is running on this node (lock %s held since %s by %s)",
+				lockPath, info.ModTime().Format(time.RFC3339), c.jobLockOwner(ctx, lockPath))
+		}
+
+		c.logger.Warn("removing stale job lock", "path", lockPath, "since", info.ModTime())
+		if _, stderr, err := c.runner.Run(ctx, "rm", "-rf", "--", lockPath); err != nil {
+			return nil, fmt.Errorf("unable to remove stale job lock %s: %w: %s", lockPath, err, strings.TrimSpace(stderr))
+		}
+	}
+
+	return nil, fmt.Errorf("unable to acquire job lock %s", lockPath)
+}
+
+// nodeTime returns the clock of the node, which the age of the lock is
+// measured against: the clock of the plakar host may differ.
+func (c *Client) nodeTime(ctx context.Context) (time.Time, error) {
+	stdout, stderr, err := c.runner.Run(ctx, "date", "+%s")
+	if err != nil {
+		return time.Time{}, NewCommandError("date failed", err, stderr)
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(stdout), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid node time %q: %w", strings.TrimSpace(stdout), err)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+func (l *JobLock) Release(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	_, stderr, err := l.client.runner.Run(ctx, "rm", "-rf", "--", l.path)
+	if err != nil {
+		return fmt.Errorf("unable to release job lock %s: %w: %s", l.path, err, strings.TrimSpace(stderr))
+	}
+	return nil
+}
+
+func (l *JobLock) writeOwner(ctx context.Context, job string) error {
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%s pid=%d host=%s started=%s\n", job, os.Getpid(), hostname, time.Now().Format(time.RFC3339))
+
+	writer, err := l.client.runner.Create(ctx, path.Join(l.path, jobLockOwnerFile))
+	if err != nil {
+		return fmt.Errorf("unable to write job lock owner: %w", err)
+	}
+	if _, err := io.WriteString(writer, owner); err != nil {
+		_ = writer.Close()
+		return fmt.Errorf("unable to write job lock owner: %w", err)
+	}
+	return writer.Close()
+}
+
+func (c *Client) jobLockOwner(ctx context.Context, lockPath string) string {
+	reader, err := c.runner.Open(ctx, path.Join(lockPath, jobLockOwnerFile))
+	if err != nil {
+		return "unknown owner"
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, 512))
+	if err != nil || strings.TrimSpace(string(data)) == "" {
+		return "unknown owner"
+	}
+	return strings.TrimSpace(string(data))
+}
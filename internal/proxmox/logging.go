@@ -0,0 +1,192 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// NewLogger returns a text logger writing to w at the given level. A nil
+// writer discards every message.
+func NewLogger(w io.Writer, level slog.Level) *slog.Logger {
+	if w == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+func parseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("invalid log_level value: %s", value)
+	}
+}
+
+// loggingRunner logs every command and file transfer at debug level before
+// handing it to the wrapped runner.
+type loggingRunner struct {
+	runner Runner
+	logger *slog.Logger
+}
+
+func newLoggingRunner(runner Runner, logger *slog.Logger) Runner {
+	return &loggingRunner{runner: runner, logger: logger}
+}
+
+func (r *loggingRunner) Run(ctx context.Context, name string, args ...string) (string, string, error) {
+	start := time.Now()
+	stdout, stderr, err := r.runner.Run(ctx, name, args...)
+	attrs := []any{"cmd", shellCommand(name, args...), "duration", time.Since(start)}
+	if err != nil {
+		attrs = append(attrs, "error", err, "stderr", strings.TrimSpace(stderr))
+	}
+	r.logger.Debug("command finished", attrs...)
+	return stdout, stderr, err
+}
+
+func (r *loggingRunner) Stream(ctx context.Context, name string, args ...string) (*CommandStream, error) {
+	cmd := shellCommand(name, args...)
+	stream, err := r.runner.Stream(ctx, name, args...)
+	if err != nil {
+		r.logger.Debug("command failed to start", "cmd", cmd, "error", err)
+		return nil, err
+	}
+	r.logger.Debug("command started", "cmd", cmd)
+
+	start := time.Now()
+	finish := stream.finish
+	stream.finish = func() error {
+		var err error
+		if finish != nil {
+			err = finish()
+		}
+		attrs := []any{"cmd", cmd, "duration", time.Since(start)}
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		}
+		r.logger.Debug("command finished", attrs...)
+		return err
+	}
+	return stream, nil
+}
+
+func (r *loggingRunner) Open(ctx context.Context, filepath string) (io.ReadCloser, error) {
+	reader, err := r.runner.Open(ctx, filepath)
+	if err != nil {
+		r.logger.Debug("open failed", "path", filepath, "error", err)
+		return nil, err
+	}
+	return &loggedReadCloser{ReadCloser: reader, logger: r.logger, path: filepath, start: time.Now()}, nil
+}
+
+func (r *loggingRunner) OpenRange(ctx context.Context, filepath string, offset, length int64) (io.ReadCloser, error) {
+	reader, err := r.runner.OpenRange(ctx, filepath, offset, length)
+	if err != nil {
+		r.logger.Debug("open failed", "path", filepath, "offset", offset, "length", length, "error", err)
+		return nil, err
+	}
+	return &loggedReadCloser{ReadCloser: reader, logger: r.logger, path: filepath, start: time.Now()}, nil
+}
+
+func (r *loggingRunner) Create(ctx context.Context, filepath string) (io.WriteCloser, error) {
+	writer, err := r.runner.Create(ctx, filepath)
+	if err != nil {
+		r.logger.Debug("create failed", "path", filepath, "error", err)
+		return nil, err
+	}
+	return &loggedWriteCloser{WriteCloser: writer, logger: r.logger, path: filepath, start: time.Now()}, nil
+}
+
+func (r *loggingRunner) Stat(ctx context.Context, filepath string) (os.FileInfo, error) {
+	return r.runner.Stat(ctx, filepath)
+}
+
+func (r *loggingRunner) Remove(ctx context.Context, filepath string) error {
+	err := r.runner.Remove(ctx, filepath)
+	if err != nil {
+		r.logger.Debug("remove failed", "path", filepath, "error", err)
+	} else {
+		r.logger.Debug("removed", "path", filepath)
+	}
+	return err
+}
+
+func (r *loggingRunner) Close() error {
+	return r.runner.Close()
+}
+
+type loggedReadCloser struct {
+	io.ReadCloser
+	logger *slog.Logger
+	path   string
+	start  time.Time
+	bytes  int64
+}
+
+func (r *loggedReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *loggedReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	attrs := []any{"path", r.path, "bytes", r.bytes, "duration", time.Since(r.start)}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	r.logger.Debug("read finished", attrs...)
+	return err
+}
+
+type loggedWriteCloser struct {
+	io.WriteCloser
+	logger *slog.Logger
+	path   string
+	start  time.Time
+	bytes  int64
+}
+
+func (w *loggedWriteCloser) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *loggedWriteCloser) Close() error {
+	err := w.WriteCloser.Close()
+	attrs := []any{"path", w.path, "bytes", w.bytes, "duration", time.Since(w.start)}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	w.logger.Debug("write finished", attrs...)
+	return err
+}
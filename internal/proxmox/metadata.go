@@ -0,0 +1,325 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DumpMetadataVersion and DumpMetadataMinor are the version of
+// DumpMetadata written by this plugin. Minor versions only add fields, so
+// any minor of a known major can be read; a new major is incompatible.
+const (
+	DumpMetadataVersion = 1
+	DumpMetadataMinor   = 4
+)
+
+const DumpMetadataSuffix = "_meta.json"
+
+// DumpMetadata describes an archive and the node it was taken on. It is
+// stored as a sidecar next to the archive.
+type DumpMetadata struct {
+	Version    int                `json:"version"`
+	Minor      int                `json:"minor,omitempty"`
+	Archive    string             `json:"archive"`
+	Engine     string             `json:"engine,omitempty"`
+	VMID       int                `json:"vmid"`
+	Type       string             `json:"type"`
+	Name       string             `json:"name,omitempty"`
+	Node       string             `json:"node,omitempty"`
+	Cluster    string             `json:"cluster,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"`
+	Hypervisor HypervisorVersions `json:"hypervisor"`
+
+	// Disks is the disk layout of the guest config, since 1.2.
+	Disks []DiskLayout `json:"disks,omitempty"`
+
+	// Mountpoints records the container mount points whose backup flag
+	// was overridden for this archive by backup_mountpoints, since 1.3.
+	// Disks already reflect the override.
+	Mountpoints map[string]bool `json:"mountpoints,omitempty"`
+
+	// BindMounts lists the container bind mounts, with the record of
+	// those backed up by bind_mounts=backup, since 1.3.
+	BindMounts []BindMount `json:"bind_mounts,omitempty"`
+
+	// Settings holds the guest options a restore may lose, since 1.4.
+	Settings *GuestSettings `json:"settings,omitempty"`
+}
+
+// GuestSettings are the guest options qmrestore and pct restore do not
+// always carry over, for instance to a new VMID or with --unique. Empty
+// fields were not set on the guest.
+type GuestSettings struct {
+	Description string `json:"description,omitempty"`
+	OnBoot      string `json:"onboot,omitempty"`
+	Startup     string `json:"startup,omitempty"`
+	Boot        string `json:"boot,omitempty"`
+}
+
+// ParseGuestSettings returns the GuestSettings of a guest config, or nil
+// when none of them is set.
+func ParseGuestSettings(config []byte) *GuestSettings {
+	settings := GuestSettings{
+		Description: configDescription(config),
+		OnBoot:      configOption(config, "onboot"),
+		Startup:     configOption(config, "startup"),
+		Boot:        configOption(config, "boot"),
+	}
+	if settings == (GuestSettings{}) {
+		return nil
+	}
+	return &settings
+}
+
+// Options returns the settings as key/value pairs in qm set / pct set
+// order, leaving out the empty ones.
+func (s GuestSettings) Options() [][2]string {
+	var options [][2]string
+	for _, option := range [][2]string{
+		{"description", s.Description},
+		{"onboot", s.OnBoot},
+		{"startup", s.Startup},
+		{"boot", s.Boot},
+	} {
+		if option[1] != "" {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+// HypervisorVersions holds the versions reported by pveversion -v.
+type HypervisorVersions struct {
+	PVEManager string            `json:"pve_manager,omitempty"`
+	Kernel     string            `json:"kernel,omitempty"`
+	QEMU       string            `json:"qemu,omitempty"`
+	LXC        string            `json:"lxc,omitempty"`
+	Packages   map[string]string `json:"packages,omitempty"`
+}
+
+// upgradeDumpMetadata fills the fields an older version did not record,
+// from the archive name. Unknown fields of a newer minor version are
+// already dropped by the decoder.
+func upgradeDumpMetadata(meta *DumpMetadata) {
+	if meta.Archive == "" {
+		return
+	}
+	// The engine is recorded since 1.1.
+	if meta.Engine == "" {
+		meta.Engine = ArchiveEngine(meta.Archive)
+	}
+	if meta.Version >= DumpMetadataVersion {
+		return
+	}
+	if meta.Type == "" || meta.VMID == 0 {
+		if vmType, vmid, err := ParseArchiveName(meta.Archive); err == nil {
+			if meta.Type == "" {
+				meta.Type = vmType
+			}
+			if meta.VMID == 0 {
+				meta.VMID = vmid
+			}
+		}
+	}
+	if meta.CreatedAt.IsZero() {
+		if createdAt, ok := ParseDumpTimestamp(meta.Archive); ok {
+			meta.CreatedAt = createdAt.UTC()
+		}
+	}
+}
+
+func EncodeDumpMetadata(meta DumpMetadata) ([]byte, error) {
+	return json.MarshalIndent(meta, "", "  ")
+}
+
+func DecodeDumpMetadata(data []byte) (DumpMetadata, error) {
+	var meta DumpMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return DumpMetadata{}, fmt.Errorf("invalid dump metadata: %w: %w", ErrArchiveCorrupt, err)
+	}
+	if meta.Version > DumpMetadataVersion {
+		return DumpMetadata{}, fmt.Errorf("unsupported dump metadata version %d.%d, this plugin reads up to %d.x", meta.Version, meta.Minor, DumpMetadataVersion)
+	}
+	upgradeDumpMetadata(&meta)
+	if meta.Archive == "" || meta.VMID <= 0 || (meta.Type != "qemu" && meta.Type != "lxc") {
+		return DumpMetadata{}, fmt.Errorf("invalid dump metadata: missing archive, vmid or type")
+	}
+	return meta, nil
+}
+
+// CheckDumpMetadata reports whether meta describes archiveName: the guest
+// type, VMID and engine recorded in the metadata must match the ones in
+// the filename. A mismatch means restoring the pair could overwrite the
+// wrong guest, or hand the archive to the wrong restore path.
+func CheckDumpMetadata(meta DumpMetadata, archiveName string) error {
+	vmType, vmid, err := ParseArchiveName(archiveName)
+	if err != nil {
+		return err
+	}
+	if meta.Archive != archiveName {
+		return fmt.Errorf("metadata describes archive %s, not %s: %w", meta.Archive, archiveName, ErrArchiveCorrupt)
+	}
+	if meta.Type != vmType || meta.VMID != vmid {
+		return fmt.Errorf("archive %s is %s %d but its metadata says %s %d: %w", archiveName, vmType, vmid, meta.Type, meta.VMID, ErrArchiveCorrupt)
+	}
+	if engine := ArchiveEngine(archiveName); meta.Engine != "" && meta.Engine != engine {
+		return fmt.Errorf("archive %s was produced by engine=%s but its metadata says engine=%s: %w", archiveName, engine, meta.Engine, ErrArchiveCorrupt)
+	}
+	return nil
+}
+
+// ConvertDumpMetadata rewrites decoded metadata as version major.minor.
+// Only versions this plugin can write are accepted; fields that version
+// does not define are dropped.
+func ConvertDumpMetadata(meta DumpMetadata, major, minor int) (DumpMetadata, error) {
+	if major != DumpMetadataVersion || minor < 0 || minor > DumpMetadataMinor {
+		return DumpMetadata{}, fmt.Errorf("cannot convert dump metadata to version %d.%d, supported versions are %d.0 to %d.%d", major, minor, DumpMetadataVersion, DumpMetadataVersion, DumpMetadataMinor)
+	}
+	meta.Version = major
+	meta.Minor = minor
+	if minor < 1 {
+		meta.Engine = ""
+	}
+	if minor < 2 {
+		meta.Disks = nil
+	}
+	if minor < 3 {
+		meta.Mountpoints = nil
+		meta.BindMounts = nil
+	}
+	if minor < 4 {
+		meta.Settings = nil
+	}
+	return meta, nil
+}
+
+func BuildDumpMetadataFilename(archiveName string) string {
+	return archiveName + DumpMetadataSuffix
+}
+
+func IsDumpMetadataFilename(name string) bool {
+	return strings.HasSuffix(strings.ToLower(filepath.Base(name)), DumpMetadataSuffix)
+}
+
+func ParseDumpMetadataFilename(name string) (string, error) {
+	base := filepath.Base(name)
+	if !IsDumpMetadataFilename(base) || len(base) == len(DumpMetadataSuffix) {
+		return "", fmt.Errorf("invalid dump metadata filename: %s", base)
+	}
+	return base[:len(base)-len(DumpMetadataSuffix)], nil
+}
+
+// ParsePVEVersions parses the output of pveversion -v, one
+// "package: version" line per package. The running kernel is taken from the
+// proxmox-ve line, "proxmox-ve: 8.2.0 (running kernel: 6.8.4-2-pve)".
+func ParsePVEVersions(output string) HypervisorVersions {
+	versions := HypervisorVersions{Packages: make(map[string]string)}
+	for _, line := range strings.Split(output, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		version, details, _ := strings.Cut(value, " ")
+		if version == "" || version == "not" {
+			// "not correctly installed" and similar.
+			continue
+		}
+		versions.Packages[name] = version
+
+		switch name {
+		case "pve-manager":
+			versions.PVEManager = version
+		case "pve-qemu-kvm":
+			versions.QEMU = version
+		case "lxc-pve":
+			versions.LXC = version
+		case "proxmox-ve":
+			if kernel, ok := strings.CutPrefix(strings.Trim(details, "()"), "running kernel: "); ok {
+				versions.Kernel = kernel
+			}
+		}
+	}
+	return versions
+}
+
+// HypervisorVersions returns the versions of the node, queried once per
+// client. A failure is cached too, unless ctx was cancelled.
+func (c *Client) HypervisorVersions(ctx context.Context) (HypervisorVersions, error) {
+	c.versionsMu.Lock()
+	defer c.versionsMu.Unlock()
+
+	if c.versions != nil {
+		return *c.versions, nil
+	}
+	if c.versionsErr != nil {
+		return HypervisorVersions{}, c.versionsErr
+	}
+	stdout, stderr, err := c.runner.Run(ctx, "pveversion", "-v")
+	if err != nil {
+		err = NewCommandError("pveversion failed", err, stderr)
+		if ctx.Err() == nil {
+			c.versionsErr = err
+		}
+		return HypervisorVersions{}, err
+	}
+	versions := ParsePVEVersions(stdout)
+	c.versions = &versions
+	return versions, nil
+}
+
+// CompareVersions compares two Debian-style versions such as 8.2.2 or
+// 8.1.5-5 numerically, field by field. Missing fields count as 0.
+func CompareVersions(a, b string) int {
+	fa, fb := versionFields(a), versionFields(b)
+	for i := 0; i < max(len(fa), len(fb)); i++ {
+		var x, y int
+		if i < len(fa) {
+			x = fa[i]
+		}
+		if i < len(fb) {
+			y = fb[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionFields(version string) []int {
+	var fields []int
+	for _, field := range strings.FieldsFunc(version, func(r rune) bool { return r < '0' || r > '9' }) {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			break
+		}
+		fields = append(fields, n)
+	}
+	return fields
+}
@@ -0,0 +1,304 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsPrefix = "plakar_proxmox_"
+
+const metricsFlushInterval = 30 * time.Second
+
+const (
+	MetricBytesRead        = "bytes_read_total"
+	MetricBytesWritten     = "bytes_written_total"
+	MetricCommands         = "commands_total"
+	MetricCommandFailures  = "command_failures_total"
+	MetricRetries          = "retries_total"
+	MetricGuests           = "guests_total"
+	MetricGuestDuration    = "guest_duration_seconds"
+	MetricGuestBytes       = "guest_bytes"
+	MetricRunStart         = "run_start_timestamp_seconds"
+	MetricRunDuration      = "run_duration_seconds"
+	MetricRunGuestsPending = "run_guests_pending"
+	MetricDiskProgress     = "disk_progress_ratio"
+)
+
+var metricTypes = map[string]string{
+	MetricBytesRead:        "counter",
+	MetricBytesWritten:     "counter",
+	MetricCommands:         "counter",
+	MetricCommandFailures:  "counter",
+	MetricRetries:          "counter",
+	MetricGuests:           "counter",
+	MetricGuestDuration:    "gauge",
+	MetricGuestBytes:       "gauge",
+	MetricRunStart:         "gauge",
+	MetricRunDuration:      "gauge",
+	MetricRunGuestsPending: "gauge",
+	MetricDiskProgress:     "gauge",
+}
+
+// Metrics holds the counters and gauges of a run. WriteTextfile renders
+// the current values in the Prometheus text format so they can be picked
+// up by the node_exporter textfile collector.
+type Metrics struct {
+	mu     sync.Mutex
+	values map[string]map[string]float64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{values: make(map[string]map[string]float64)}
+}
+
+// Add increments a counter. Labels are given as key/value pairs.
+func (m *Metrics) Add(name string, delta float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.update(name, labels, func(current float64) float64 { return current + delta })
+}
+
+// Set sets a gauge. Labels are given as key/value pairs.
+func (m *Metrics) Set(name string, value float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.update(name, labels, func(float64) float64 { return value })
+}
+
+func (m *Metrics) update(name string, labels []string, apply func(float64) float64) {
+	key := labelKey(labels)
+
+	m.mu.Lock()
+	series, ok := m.values[name]
+	if !ok {
+		series = make(map[string]float64)
+		m.values[name] = series
+	}
+	series[key] = apply(series[key])
+	m.mu.Unlock()
+}
+
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.values))
+	for name := range m.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		metricType := metricTypes[name]
+		if metricType == "" {
+			metricType = "untyped"
+		}
+		if _, err := fmt.Fprintf(w, "# TYPE %s%s %s\n", metricsPrefix, name, metricType); err != nil {
+			return err
+		}
+
+		series := m.values[name]
+		keys := make([]string, 0, len(series))
+		for key := range series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if _, err := fmt.Fprintf(w, "%s%s%s %g\n", metricsPrefix, name, key, series[key]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteTextfile atomically replaces path with the current metrics.
+func (m *Metrics) WriteTextfile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if err := m.WritePrometheus(tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// StartTextfile writes the metrics to path periodically until the returned
+// function is called, which performs a final write.
+func (m *Metrics) StartTextfile(ctx context.Context, path string) func() error {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(metricsFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = m.WriteTextfile(path)
+			}
+		}
+	}()
+
+	return func() error {
+		close(done)
+		wg.Wait()
+		return m.WriteTextfile(path)
+	}
+}
+
+func labelKey(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// meteredRunner counts commands and transferred bytes.
+type meteredRunner struct {
+	Runner
+	metrics *Metrics
+}
+
+func newMeteredRunner(runner Runner, metrics *Metrics) Runner {
+	return &meteredRunner{Runner: runner, metrics: metrics}
+}
+
+func (r *meteredRunner) Run(ctx context.Context, name string, args ...string) (string, string, error) {
+	stdout, stderr, err := r.Runner.Run(ctx, name, args...)
+	r.count(name, err)
+	return stdout, stderr, err
+}
+
+func (r *meteredRunner) Stream(ctx context.Context, name string, args ...string) (*CommandStream, error) {
+	stream, err := r.Runner.Stream(ctx, name, args...)
+	if err != nil {
+		r.count(name, err)
+		return nil, err
+	}
+	if stream.Stdout != nil {
+		stream.Stdout = &meteredReader{Reader: stream.Stdout, metrics: r.metrics}
+	}
+	finish := stream.finish
+	stream.finish = func() error {
+		var err error
+		if finish != nil {
+			err = finish()
+		}
+		r.count(name, err)
+		return err
+	}
+	return stream, nil
+}
+
+func (r *meteredRunner) Open(ctx context.Context, filepath string) (io.ReadCloser, error) {
+	reader, err := r.Runner.Open(ctx, filepath)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredReadCloser{ReadCloser: reader, metrics: r.metrics}, nil
+}
+
+func (r *meteredRunner) OpenRange(ctx context.Context, filepath string, offset, length int64) (io.ReadCloser, error) {
+	reader, err := r.Runner.OpenRange(ctx, filepath, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredReadCloser{ReadCloser: reader, metrics: r.metrics}, nil
+}
+
+func (r *meteredRunner) Create(ctx context.Context, filepath string) (io.WriteCloser, error) {
+	writer, err := r.Runner.Create(ctx, filepath)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredWriteCloser{WriteCloser: writer, metrics: r.metrics}, nil
+}
+
+func (r *meteredRunner) count(name string, err error) {
+	r.metrics.Add(MetricCommands, 1, "command", name)
+	if err != nil {
+		r.metrics.Add(MetricCommandFailures, 1, "command", name)
+	}
+}
+
+type meteredReader struct {
+	io.Reader
+	metrics *Metrics
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.metrics.Add(MetricBytesRead, float64(n))
+	return n, err
+}
+
+type meteredReadCloser struct {
+	io.ReadCloser
+	metrics *Metrics
+}
+
+func (r *meteredReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.metrics.Add(MetricBytesRead, float64(n))
+	return n, err
+}
+
+type meteredWriteCloser struct {
+	io.WriteCloser
+	metrics *Metrics
+}
+
+func (w *meteredWriteCloser) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.metrics.Add(MetricBytesWritten, float64(n))
+	return n, err
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Value returns the config value of the disk, as ParseGuestDisks read it.
+func (d GuestDisk) Value() string {
+	value := d.Volume
+	if d.Storage != "" {
+		value = d.VolID()
+	}
+	if d.Options != "" {
+		value += "," + d.Options
+	}
+	return value
+}
+
+// WithBackup returns the disk with its backup option set to backed.
+func (d GuestDisk) WithBackup(backed bool) GuestDisk {
+	flag := "backup=0"
+	if backed {
+		flag = "backup=1"
+	}
+	var options []string
+	found := false
+	for _, opt := range strings.Split(d.Options, ",") {
+		if opt == "" {
+			continue
+		}
+		if key, _, _ := strings.Cut(opt, "="); strings.TrimSpace(key) == "backup" {
+			opt = flag
+			found = true
+		}
+		options = append(options, opt)
+	}
+	if !found {
+		options = append(options, flag)
+	}
+	d.Options = strings.Join(options, ",")
+	return d
+}
+
+// SetGuestDisk rewrites the config value of a disk or mount point.
+func (c *Client) SetGuestDisk(ctx context.Context, vmid int, disk GuestDisk) error {
+	res, err := c.vmResourceByID(ctx, vmid)
+	if err != nil {
+		return err
+	}
+	if c.cliControl() {
+		err = c.cliSetConfig(ctx, res.Type, vmid, "--"+disk.Key, disk.Value())
+	} else {
+		endpoint := fmt.Sprintf("/nodes/%s/%s/%d/config", res.Node, res.Type, vmid)
+		_, err = c.runPvesh(ctx, "failed to update guest config", "set", endpoint, "--"+disk.Key, disk.Value())
+	}
+	c.guestConfigs.drop(res.Type, vmid)
+	return err
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Bounds of the VMIDs Proxmox accepts.
+const (
+	minVMID = 100
+	maxVMID = 999999999
+)
+
+// vmList is /etc/pve/.vmlist, which lists the guests of the whole cluster.
+type vmList struct {
+	IDs map[string]json.RawMessage `json:"ids"`
+}
+
+// NextFreeVMID returns the lowest VMID, from the one /cluster/nextid
+// suggests on, that no guest uses and that is not in reserved.
+// /cluster/nextid keeps suggesting the same VMID until a guest takes it, so
+// VMIDs handed out but not created yet are passed in reserved. With
+// control=cli, or when /cluster/nextid fails, the search starts at 100.
+func (c *Client) NextFreeVMID(ctx context.Context, reserved map[int]bool) (int, error) {
+	used, err := c.usedVMIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	next := minVMID
+	if !c.cliControl() {
+		suggested, err := c.clusterNextID(ctx)
+		switch {
+		case err == nil:
+			next = suggested
+		case ctx.Err() != nil:
+			return 0, err
+		default:
+			c.logger.Warn("cluster nextid unavailable, scanning the inventory", "error", err)
+		}
+	}
+	for vmid := next; vmid <= maxVMID; vmid++ {
+		if !used[vmid] && !reserved[vmid] {
+			return vmid, nil
+		}
+	}
+	return 0, fmt.Errorf("no free VMID from %d", next)
+}
+
+func (c *Client) clusterNextID(ctx context.Context) (int, error) {
+	stdout, err := c.runPvesh(ctx, "pvesh get cluster nextid failed", "get", "/cluster/nextid", "--output-format", "json")
+	if err != nil {
+		return 0, err
+	}
+	vmid, err := strconv.Atoi(strings.Trim(strings.TrimSpace(stdout), `"`))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse cluster nextid: %s", strings.TrimSpace(stdout))
+	}
+	return vmid, nil
+}
+
+// usedVMIDs returns the VMIDs of the cluster. qm list and pct list only
+// cover the local node, so control=cli reads the cluster-wide .vmlist.
+func (c *Client) usedVMIDs(ctx context.Context) (map[int]bool, error) {
+	used := make(map[int]bool)
+	if c.cliControl() {
+		data, err := c.readNodeFile(ctx, "/etc/pve/.vmlist")
+		if err != nil {
+			return nil, err
+		}
+		var list vmList
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, fmt.Errorf("failed to parse /etc/pve/.vmlist: %w", err)
+		}
+		for id := range list.IDs {
+			if vmid, err := strconv.Atoi(id); err == nil {
+				used[vmid] = true
+			}
+		}
+		return used, nil
+	}
+
+	resources, err := c.queryResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, resource := range resources {
+		used[resource.VMID] = true
+	}
+	return used, nil
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ClusterNodes returns the names of the nodes of the cluster, or the local
+// node alone for a standalone one.
+func (c *Client) ClusterNodes(ctx context.Context) ([]string, error) {
+	if c.cliControl() {
+		return nil, fmt.Errorf("cluster nodes are %w", errNeedsAPI)
+	}
+	stdout, err := c.runPvesh(ctx, "pvesh get cluster status failed", "get", "/cluster/status", "--output-format", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []clusterStatusEntry
+	if err := json.Unmarshal([]byte(stdout), &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse cluster status: %w", err)
+	}
+	var nodes []string
+	for _, entry := range entries {
+		if entry.Type == "node" {
+			nodes = append(nodes, entry.Name)
+		}
+	}
+	return nodes, nil
+}
+
+// GuestRestoreOn describes the restore of a vzdump archive on a node
+// other than the one commands run on, through the API.
+type GuestRestoreOn struct {
+	Node string
+	Type string
+	VMID int
+	// Archive is the backup volume (<storage>:backup/<file>) restored, which
+	// the node must be able to read.
+	Archive string
+	Storage string
+	Pool    string
+	// Extra are further options of the create call, as --key value pairs.
+	Extra []string
+}
+
+// RestoreGuestOn restores an archive on a node of the cluster, replacing
+// the guest when it exists there, as qmrestore and pct restore do locally.
+func (c *Client) RestoreGuestOn(ctx context.Context, restore GuestRestoreOn) error {
+	vmid := strconv.Itoa(restore.VMID)
+	var args []string
+	switch restore.Type {
+	case "qemu":
+		args = []string{"create", "/nodes/" + restore.Node + "/qemu", "--vmid", vmid, "--archive", restore.Archive, "--force", "1"}
+	case "lxc":
+		args = []string{"create", "/nodes/" + restore.Node + "/lxc", "--vmid", vmid, "--ostemplate", restore.Archive, "--restore", "1", "--force", "1"}
+	default:
+		return fmt.Errorf("unsupported backup type: %s", restore.Type)
+	}
+	if restore.Storage != "" {
+		args = append(args, "--storage", restore.Storage)
+	}
+	if restore.Pool != "" {
+		args = append(args, "--pool", restore.Pool)
+	}
+	args = append(args, restore.Extra...)
+	_, err := c.runPvesh(ctx, fmt.Sprintf("restore failed for %s %d on node %s", restore.Type, restore.VMID, restore.Node), args...)
+	return err
+}
+
+// StopGuestOn stops a guest of node.
+func (c *Client) StopGuestOn(ctx context.Context, node, vmType string, vmid int) error {
+	endpoint := fmt.Sprintf("/nodes/%s/%s/%d/status/stop", node, vmType, vmid)
+	_, err := c.runPvesh(ctx, fmt.Sprintf("stop failed for %s %d on node %s", vmType, vmid, node), "create", endpoint)
+	return err
+}
+
+// SetGuestConfigOn sets options of the config of a guest of node, given as
+// key and value pairs.
+func (c *Client) SetGuestConfigOn(ctx context.Context, node, vmType string, vmid int, options [][2]string) error {
+	args := []string{"set", fmt.Sprintf("/nodes/%s/%s/%d/config", node, vmType, vmid)}
+	for _, option := range options {
+		args = append(args, "--"+option[0], option[1])
+	}
+	_, err := c.runPvesh(ctx, fmt.Sprintf("set failed for %s %d on node %s", vmType, vmid, node), args...)
+	c.guestConfigs.drop(vmType, vmid)
+	return err
+}
+
+// MoveDiskOn moves a disk of a guest of node to storage, deleting the
+// source volume.
+func (c *Client) MoveDiskOn(ctx context.Context, node, vmType string, vmid int, disk, storage string) error {
+	var args []string
+	switch vmType {
+	case "qemu":
+		args = []string{"create", fmt.Sprintf("/nodes/%s/qemu/%d/move_disk", node, vmid), "--disk", disk, "--storage", storage, "--delete", "1"}
+	case "lxc":
+		args = []string{"create", fmt.Sprintf("/nodes/%s/lxc/%d/move_volume", node, vmid), "--volume", disk, "--storage", storage, "--delete", "1"}
+	default:
+		return fmt.Errorf("unsupported backup type: %s", vmType)
+	}
+	_, err := c.runPvesh(ctx, fmt.Sprintf("moving disk %s of %s %d to %s failed", disk, vmType, vmid, storage), args...)
+	return err
+}
+
+// StartGuestOn starts a guest of node, which qm start and pct start cannot
+// do from another node.
+func (c *Client) StartGuestOn(ctx context.Context, node, vmType string, vmid int) error {
+	endpoint := fmt.Sprintf("/nodes/%s/%s/%d/status/start", node, vmType, vmid)
+	_, err := c.runPvesh(ctx, fmt.Sprintf("start failed for %s %d on node %s", vmType, vmid, node), "create", endpoint)
+	return err
+}
+
+// GuestStatusOn returns the status of a guest of node, such as running or
+// stopped.
+func (c *Client) GuestStatusOn(ctx context.Context, node, vmType string, vmid int) (string, error) {
+	endpoint := fmt.Sprintf("/nodes/%s/%s/%d/status/current", node, vmType, vmid)
+	stdout, err := c.runPvesh(ctx, "pvesh get guest status failed", "get", endpoint, "--output-format", "json")
+	if err != nil {
+		return "", err
+	}
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &status); err != nil {
+		return "", fmt.Errorf("failed to parse guest status: %w", err)
+	}
+	return status.Status, nil
+}
+
+// GuestRunning reports whether a guest of the cluster is running.
+func (c *Client) GuestRunning(ctx context.Context, vmid int) (bool, error) {
+	res, err := c.vmResourceByID(ctx, vmid)
+	if err != nil {
+		return false, err
+	}
+	var status string
+	if c.cliControl() {
+		tool := "qm"
+		if res.Type == "lxc" {
+			tool = "pct"
+		}
+		stdout, stderr, err := c.runner.Run(ctx, tool, "status", strconv.Itoa(vmid))
+		if err != nil {
+			return false, NewCommandError(tool+" status failed", err, stderr)
+		}
+		status = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(stdout), "status:"))
+	} else if status, err = c.GuestStatusOn(ctx, res.Node, res.Type, vmid); err != nil {
+		return false, err
+	}
+	return status == "running", nil
+}
+
+// ReadGuestConfigOn reads the config of a guest of node from the cluster
+// filesystem, where /etc/pve/qemu-server and /etc/pve/lxc only hold the
+// guests of the local node.
+func (c *Client) ReadGuestConfigOn(ctx context.Context, node, vmType string, vmid int) ([]byte, error) {
+	dir := path.Base(qemuConfigDir)
+	if vmType == "lxc" {
+		dir = path.Base(lxcConfigDir)
+	}
+	return c.readNodeFile(ctx, path.Join("/etc/pve/nodes", node, dir, strconv.Itoa(vmid)+".conf"))
+}
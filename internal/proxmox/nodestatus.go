@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// NodeLoad is the pressure of a node as reported by /nodes/<node>/status.
+type NodeLoad struct {
+	// Load is the one minute load average.
+	Load float64
+	// CPU and IOWait are the CPU usage and the IO wait, in percent.
+	CPU    float64
+	IOWait float64
+	CPUs   int
+}
+
+type nodeStatus struct {
+	LoadAvg []string `json:"loadavg"`
+	CPU     float64  `json:"cpu"`
+	Wait    float64  `json:"wait"`
+	CPUInfo struct {
+		CPUs int `json:"cpus"`
+	} `json:"cpuinfo"`
+}
+
+// NodeLoad returns the current load of node. With control=cli, it is the
+// load of the node commands run on.
+func (c *Client) NodeLoad(ctx context.Context, node string) (NodeLoad, error) {
+	if c.cliControl() {
+		return c.cliNodeLoad(ctx)
+	}
+	stdout, err := c.runPvesh(ctx, "pvesh get node status failed", "get", "/nodes/"+node+"/status", "--output-format", "json")
+	if err != nil {
+		return NodeLoad{}, err
+	}
+
+	var status nodeStatus
+	if err := json.Unmarshal([]byte(stdout), &status); err != nil {
+		return NodeLoad{}, fmt.Errorf("failed to parse node status: %w", err)
+	}
+	load := NodeLoad{
+		CPU:    status.CPU * 100,
+		IOWait: status.Wait * 100,
+		CPUs:   status.CPUInfo.CPUs,
+	}
+	if len(status.LoadAvg) > 0 {
+		if load.Load, err = strconv.ParseFloat(status.LoadAvg[0], 64); err != nil {
+			return NodeLoad{}, fmt.Errorf("failed to parse load average of node %s: %w", node, err)
+		}
+	}
+	return load, nil
+}
+
+type nodeTime struct {
+	Timezone  string `json:"timezone"`
+	Time      int64  `json:"time"`
+	LocalTime int64  `json:"localtime"`
+}
+
+// NodeLocation returns the time zone of node, the one vzdump names its
+// archives in. Zones missing from the local time zone database are
+// returned as their current offset.
+func (c *Client) NodeLocation(ctx context.Context, node string) (*time.Location, error) {
+	if c.cliControl() {
+		return c.cliNodeLocation(ctx)
+	}
+	stdout, err := c.runPvesh(ctx, "pvesh get node time failed", "get", "/nodes/"+node+"/time", "--output-format", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var status nodeTime
+	if err := json.Unmarshal([]byte(stdout), &status); err != nil {
+		return nil, fmt.Errorf("failed to parse node time: %w", err)
+	}
+	if loc, err := time.LoadLocation(status.Timezone); err == nil {
+		return loc, nil
+	}
+	return time.FixedZone(status.Timezone, int(status.LocalTime-status.Time)), nil
+}
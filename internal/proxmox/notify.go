@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// Values of pve_notify.
+const (
+	NotifyNever   = "never"
+	NotifyFailure = "failure"
+	NotifyAlways  = "always"
+)
+
+// notifyTemplate is the name of the notification template installed on
+// the node. PVE renders notifications from handlebars templates only, so
+// the plugin ships its own.
+const notifyTemplate = "plakar-proxmox"
+
+// notifyTemplateDir is where PVE looks for custom templates, before its own
+// in /usr/share/pve-manager/templates/default, which package upgrades
+// overwrite.
+const notifyTemplateDir = "/etc/pve/notification-templates/default"
+
+const notifyTimeout = 30 * time.Second
+
+var notifyTemplates = map[string]string{
+	notifyTemplate + "-subject.txt.hbs": "plakar {{operation}} {{status}} on {{origin}}\n",
+	notifyTemplate + "-body.txt.hbs": `plakar {{operation}} of {{origin}}: {{status}}
+{{#if error}}
+Error: {{error}}
+{{/if}}
+Guests: {{guests}} ({{failed}} failed, {{skipped}} skipped)
+Bytes: {{bytes}}
+Duration: {{duration}}s
+
+{{details}}
+`,
+}
+
+// notifyScript sends a notification through PVE::Notify, so it is routed
+// by the notification matchers and targets configured in the cluster
+// (Datacenter > Notifications), like the ones of vzdump jobs. Matchers can
+// select plakar runs with the field type=plakar. The data is read from a
+// file: the run details may exceed the size limit of one argument.
+const notifyScript = `use strict; use JSON; use PVE::Notify;
+open(my $fh, '<', $ARGV[1]) or die "unable to read $ARGV[1]: $!\n";
+my $data = decode_json(do { local $/; <$fh> });
+PVE::Notify::notify($ARGV[0], '` + notifyTemplate + `', $data, { type => 'plakar', operation => $data->{operation} });`
+
+// notifyRun reports a finished run through the PVE notification system
+// according to pve_notify. Failures are logged, never returned: they must
+// not change the outcome of the run.
+func (c *Client) notifyRun(summary *RunSummary) {
+	switch c.cfg.Notify {
+	case NotifyAlways:
+	case NotifyFailure:
+		if summary.Status != GuestStatusFailed {
+			return
+		}
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := c.sendNotification(ctx, summary); err != nil {
+		c.logger.Warn("unable to send PVE notification", "error", err)
+	}
+}
+
+func (c *Client) sendNotification(ctx context.Context, summary *RunSummary) error {
+	if err := c.installNotifyTemplates(ctx); err != nil {
+		return err
+	}
+
+	details, err := summary.Encode()
+	if err != nil {
+		return err
+	}
+	summary.mu.Lock()
+	data := map[string]any{
+		"operation": summary.Operation,
+		"origin":    summary.Origin,
+		"status":    summary.Status,
+		"error":     summary.Error,
+		"guests":    len(summary.Guests),
+		"failed":    len(summary.Failed),
+		"skipped":   len(summary.Skipped),
+		"bytes":     summary.Bytes,
+		"duration":  fmt.Sprintf("%.0f", summary.Duration),
+		"details":   string(details),
+	}
+	summary.mu.Unlock()
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	severity := "info"
+	if summary.Status == GuestStatusFailed {
+		severity = "error"
+	}
+	dataPath := path.Join(c.cfg.DumpDir, fmt.Sprintf(".plakar-proxmox-notify-%d.json", time.Now().UnixNano()))
+	if err := c.writeSmallFile(ctx, dataPath, payload); err != nil {
+		return fmt.Errorf("failed to write notification data: %w", err)
+	}
+	defer func() {
+		_ = c.Remove(context.Background(), dataPath)
+	}()
+	if _, stderr, err := c.runner.Run(ctx, "perl", "-e", notifyScript, severity, dataPath); err != nil {
+		return NewCommandError("PVE::Notify failed", err, stderr)
+	}
+	return nil
+}
+
+// installNotifyTemplates writes the templates of notifyTemplate on the
+// node when they are missing or outdated.
+func (c *Client) installNotifyTemplates(ctx context.Context) error {
+	if _, stderr, err := c.runner.Run(ctx, "mkdir", "-p", "--", notifyTemplateDir); err != nil {
+		return NewCommandError("failed to create "+notifyTemplateDir, err, stderr)
+	}
+	for name, content := range notifyTemplates {
+		target := path.Join(notifyTemplateDir, name)
+		if current, err := c.readSmallFile(ctx, target); err == nil && current == content {
+			continue
+		}
+		if err := c.writeSmallFile(ctx, target, []byte(content)); err != nil {
+			return fmt.Errorf("failed to install notification template %s: %w", target, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) writeSmallFile(ctx context.Context, filepath string, data []byte) error {
+	writer, err := c.runner.Create(ctx, filepath)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (c *Client) readSmallFile(ctx context.Context, filepath string) (string, error) {
+	reader, err := c.runner.Open(ctx, filepath)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	_, err = io.Copy(&b, io.LimitReader(reader, 64*1024))
+	if closeErr := reader.Close(); err == nil {
+		err = closeErr
+	}
+	return b.String(), err
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// commonOptions lists the keys understood by both the importer and the
+// exporter.
+var commonOptions = []string{
+	"location", "mode", "conn_method", "conn_username", "conn_password",
+	"conn_identity_file", "conn_otp_command", "conn_compression", "dump_dir", "backup_compression", "backup_mode",
+	"backup_extra_args", "backup_notification", "backup_tmpdir", "node", "pool", "cleanup", "stall_timeout", "job_lock",
+	"log_level", "metrics_file", "trace_file", "env", "strict_config",
+	"runner", "pve_notify", "webhook_url", "webhook_secret", "dump_name_template",
+	"timestamp_utc", "timestamp_format", "node_helper", "conn_multiplex",
+	"pvesh_rate", "pvesh_retries", "control", "check_permissions",
+	"api_url", "api_token", "api_fingerprint",
+}
+
+// CheckOptions looks for keys of config that are neither common options
+// nor listed in known, usually typos such as backup_compresion. With
+// strict_config=true they are reported as an error, otherwise they are
+// returned as warnings for the caller to log.
+func CheckOptions(config map[string]string, known []string) ([]string, error) {
+	strict, err := parseBool(config, "strict_config", false)
+	if err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	for key := range config {
+		if isKnownOption(key, known) {
+			continue
+		}
+		msg := "unknown option " + key
+		if suggestion := suggestOption(key, known); suggestion != "" {
+			msg += " (did you mean " + suggestion + "?)"
+		}
+		unknown = append(unknown, msg)
+	}
+	sort.Strings(unknown)
+
+	if strict && len(unknown) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(unknown, "; "))
+	}
+	return unknown, nil
+}
+
+func isKnownOption(key string, known []string) bool {
+	if slices.Contains(commonOptions, key) || slices.Contains(known, key) {
+		return true
+	}
+	name, ok := strings.CutPrefix(key, "bin_")
+	return ok && slices.Contains(binaryOptions, name)
+}
+
+// suggestOption returns the known option closest to key when it is at most
+// two edits away.
+func suggestOption(key string, known []string) string {
+	candidates := slices.Concat(commonOptions, known)
+	for _, name := range binaryOptions {
+		candidates = append(candidates, "bin_"+name)
+	}
+
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if d := editDistance(key, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckOptions(t *testing.T) {
+	known := []string{"storage", "restore_order"}
+	tests := []struct {
+		name   string
+		config map[string]string
+		want   []string
+		err    string
+	}{
+		{
+			name:   "known",
+			config: map[string]string{"location": "proxmox://pve", "storage": "local", "bin_vzdump": "/usr/bin/vzdump"},
+		},
+		{
+			name:   "typos",
+			config: map[string]string{"backup_compresion": "zstd", "restor_order": "100", "storage": "local"},
+			want: []string{
+				"unknown option backup_compresion (did you mean backup_compression?)",
+				"unknown option restor_order (did you mean restore_order?)",
+			},
+		},
+		{
+			name:   "no suggestion",
+			config: map[string]string{"completely_unrelated": "1"},
+			want:   []string{"unknown option completely_unrelated"},
+		},
+		{
+			name:   "unknown binary",
+			config: map[string]string{"bin_tar": "/bin/tar"},
+			want:   []string{"unknown option bin_tar"},
+		},
+		{
+			name:   "strict",
+			config: map[string]string{"strict_config": "true", "storge": "local", "pool_x": "a"},
+			err:    "unknown option pool_x (did you mean pool?); unknown option storge (did you mean storage?)",
+		},
+		{
+			name:   "strict without unknown keys",
+			config: map[string]string{"strict_config": "true", "storage": "local"},
+		},
+		{
+			name:   "invalid strict_config",
+			config: map[string]string{"strict_config": "maybe"},
+			err:    "strict_config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CheckOptions(tt.config, known)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuggestOption(t *testing.T) {
+	known := []string{"restore_conflict"}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "dump_dri", want: "dump_dir"},
+		{key: "restore_conflit", want: "restore_conflict"},
+		{key: "bin_qmm", want: "bin_qm"},
+		{key: "locaton", want: "location"},
+		{key: "mod", want: "mode"},
+		{key: "restore_conflict_xyz", want: ""},
+		{key: "zzz", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := suggestOption(tt.key, known); got != tt.want {
+				t.Errorf("suggestOption(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"node", "", 4},
+		{"", "node", 4},
+		{"node", "node", 0},
+		{"node", "mode", 1},
+		{"pool", "poll", 1},
+		{"storage", "strage", 1},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestParseConfigJoinsErrors(t *testing.T) {
+	_, err := ParseConfig(map[string]string{
+		"location":            "proxmox://pve",
+		"mode":                "sideways",
+		"timestamp_utc":       "maybe",
+		"backup_notification": "sometimes",
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("error %T is not joined", err)
+	}
+	if n := len(joined.Unwrap()); n != 3 {
+		t.Errorf("got %d errors, want 3: %v", n, err)
+	}
+	for _, want := range []string{"invalid mode: sideways", "timestamp_utc", "invalid backup_notification value: sometimes"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestParseConfigMissingLocationAndMode(t *testing.T) {
+	_, err := ParseConfig(map[string]string{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"missing location", "missing mode"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pbsPasswordDir holds the secrets PVE keeps for its pbs storages, one
+// <storage>.pw file each.
+const pbsPasswordDir = "/etc/pve/priv/storage"
+
+// pbsImportScript unpacks the vzdump archive $0 of guest type $1 next to
+// itself and uploads the result with proxmox-backup-client ($2), laid out
+// as vzdump lays out the backups it sends to a PBS: qemu-server.conf and
+// one drive-<disk>.img per disk for QEMU, pct.conf and root.pxar for LXC.
+// The backup is encrypted with the key file $3 when the storage has one, as
+// vzdump does. The remaining arguments are passed to proxmox-backup-client
+// backup.
+const pbsImportScript = `set -e
+archive=$0 type=$1 client=$2 keyfile=$3
+shift 3
+if [ -e "$keyfile" ]; then
+	set -- "$@" --keyfile "$keyfile"
+fi
+dir=$(mktemp -d "$archive.pbs.XXXXXX")
+trap 'rm -rf -- "$dir"' EXIT
+case $archive in
+*.zst) unpack='zstd -q -d -c --' ;;
+*.gz) unpack='gzip -d -c --' ;;
+*.lzo) unpack='lzop -d -c --' ;;
+*) unpack='cat --' ;;
+esac
+if [ "$type" = qemu ]; then
+	$unpack "$archive" | vma extract - "$dir/vm"
+	set -- "$@" "qemu-server.conf:$dir/vm/qemu-server.conf"
+	for disk in "$dir"/vm/disk-*.raw; do
+		[ -e "$disk" ] || continue
+		name=${disk##*/disk-}
+		set -- "$@" "${name%.raw}.img:$disk"
+	done
+else
+	mkdir -- "$dir/ct"
+	$unpack "$archive" | tar -x --numeric-owner --xattrs --acls -C "$dir/ct"
+	mv -- "$dir/ct/etc/vzdump/pct.conf" "$dir/pct.conf"
+	rmdir -- "$dir/ct/etc/vzdump"
+	set -- "$@" "pct.conf:$dir/pct.conf" "root.pxar:$dir/ct"
+fi
+"$client" backup "$@"
+`
+
+// PBSImport describes a vzdump archive staged on the node and converted to
+// a backup of a PBS datastore.
+type PBSImport struct {
+	Dump string
+	Type string
+	VMID int
+
+	// BackupTime is the time of the PBS snapshot, the creation time of the
+	// archive.
+	BackupTime time.Time
+
+	// Storage is the PVE storage of type pbs the backup is sent to.
+	Storage StorageInfo
+}
+
+// PBSRepository returns the proxmox-backup-client repository of a pbs
+// storage, [[user@]server[:port]:]datastore.
+func (s StorageInfo) PBSRepository() (string, error) {
+	if s.Type != "pbs" {
+		return "", fmt.Errorf("storage %s (%s) is not a Proxmox Backup Server storage", s.ID, s.Type)
+	}
+	if s.Server == "" || s.Datastore == "" {
+		return "", fmt.Errorf("storage %s has no server or datastore", s.ID)
+	}
+	server := s.Server
+	if strings.Contains(server, ":") {
+		server = "[" + server + "]"
+	}
+	if s.Port != 0 {
+		server += ":" + strconv.Itoa(s.Port)
+	}
+	if s.Username != "" {
+		server = s.Username + "@" + server
+	}
+	return server + ":" + s.Datastore, nil
+}
+
+// ImportToPBS converts a staged vzdump archive into a backup of the PBS
+// datastore behind imp.Storage, with the credentials PVE keeps for that
+// storage. The archive is unpacked in its own directory first, which needs
+// as much free space as the guest disks.
+func (c *Client) ImportToPBS(ctx context.Context, imp PBSImport) error {
+	repository, err := imp.Storage.PBSRepository()
+	if err != nil {
+		return err
+	}
+
+	var backupType string
+	switch imp.Type {
+	case "qemu":
+		backupType = "vm"
+	case "lxc":
+		backupType = "ct"
+	default:
+		return fmt.Errorf("unsupported backup type: %s", imp.Type)
+	}
+
+	args := []string{"PBS_PASSWORD_FILE=" + pbsPasswordDir + "/" + imp.Storage.ID + ".pw"}
+	if imp.Storage.Fingerprint != "" {
+		args = append(args, "PBS_FINGERPRINT="+imp.Storage.Fingerprint)
+	}
+	args = append(args, "bash", "-o", "pipefail", "-c", pbsImportScript, imp.Dump, imp.Type, "proxmox-backup-client",
+		pbsPasswordDir+"/"+imp.Storage.ID+".enc",
+		"--repository", repository,
+		"--backup-type", backupType,
+		"--backup-id", strconv.Itoa(imp.VMID),
+		"--backup-time", strconv.FormatInt(imp.BackupTime.Unix(), 10))
+	if imp.Storage.Namespace != "" {
+		args = append(args, "--ns", imp.Storage.Namespace)
+	}
+
+	stdout, stderr, err := c.runner.Run(ctx, "env", args...)
+	if err != nil {
+		output := strings.TrimSpace(stderr)
+		if output == "" {
+			output = strings.TrimSpace(stdout)
+		}
+		return NewCommandError(fmt.Sprintf("PBS import failed for %s %d", imp.Type, imp.VMID), err, output)
+	}
+	return nil
+}
+
+// PBSConfigDir holds the configuration of a Proxmox Backup Server host:
+// datastores, remotes, sync, verify and prune jobs, users, ACLs and keys.
+const PBSConfigDir = "/etc/proxmox-backup"
+
+// ArchivePBSConfig returns a tar of PBSConfigDir, read from a Proxmox Backup
+// Server host. The configuration is small enough to be held in memory.
+// GNU tar exits 1 when a file changed while it was read, as the task logs
+// and state files of a running PBS do: the archive is still complete, so
+// this is only a warning.
+func (c *Client) ArchivePBSConfig(ctx context.Context) ([]byte, error) {
+	stdout, stderr, err := c.runner.Run(ctx, "tar", "-C", PBSConfigDir, "--numeric-owner", "-cpf", "-", ".")
+	if err != nil && exitStatus(err) == 1 && ctx.Err() == nil {
+		c.logger.Warn("files changed while "+PBSConfigDir+" was archived", "stderr", strings.TrimSpace(stderr))
+		err = nil
+	}
+	if err != nil {
+		return nil, NewCommandError("tar of "+PBSConfigDir+" failed", err, stderr)
+	}
+	return []byte(stdout), nil
+}
+
+// PingPBS checks that the host is a Proxmox Backup Server, which has no
+// pvesh or pveversion.
+func (c *Client) PingPBS(ctx context.Context) error {
+	if _, stderr, err := c.runner.Run(ctx, "proxmox-backup-manager", "versions"); err != nil {
+		return NewCommandError("proxmox-backup-manager unavailable", err, stderr)
+	}
+	return nil
+}
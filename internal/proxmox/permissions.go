@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Privileges checked before a run with check_permissions=true.
+const (
+	PrivVMAudit                = "VM.Audit"
+	PrivVMBackup               = "VM.Backup"
+	PrivVMAllocate             = "VM.Allocate"
+	PrivVMConfigOptions        = "VM.Config.Options"
+	PrivVMPowerMgmt            = "VM.PowerMgmt"
+	PrivDatastoreAllocateSpace = "Datastore.AllocateSpace"
+	PrivSysAudit               = "Sys.Audit"
+	PrivSysConsole             = "Sys.Console"
+)
+
+// Permission is a privilege required on an ACL path such as /vms/100 or
+// /storage/local.
+type Permission struct {
+	Path      string
+	Privilege string
+	// Reason tells what the privilege is needed for.
+	Reason string
+}
+
+func (p Permission) String() string {
+	s := p.Privilege + " on " + p.Path
+	if p.Reason != "" {
+		s += " (" + p.Reason + ")"
+	}
+	return s
+}
+
+// MissingPermissionsError lists the privileges the account lacks. It
+// wraps ErrAuth.
+type MissingPermissionsError struct {
+	Missing []Permission
+}
+
+func (e *MissingPermissionsError) Error() string {
+	missing := make([]string, len(e.Missing))
+	for i, permission := range e.Missing {
+		missing[i] = permission.String()
+	}
+	return "missing permissions: " + strings.Join(missing, ", ")
+}
+
+func (e *MissingPermissionsError) Unwrap() error {
+	return ErrAuth
+}
+
+// CheckPermissions queries the effective privileges of the account on the
+// path of every required permission and returns a MissingPermissionsError
+// listing all those it lacks. Paths are queried once each.
+func (c *Client) CheckPermissions(ctx context.Context, required []Permission) error {
+	granted := make(map[string]map[string]int)
+	var missing []Permission
+	for _, permission := range required {
+		privileges, ok := granted[permission.Path]
+		if !ok {
+			var err error
+			if privileges, err = c.pathPrivileges(ctx, permission.Path); err != nil {
+				return err
+			}
+			granted[permission.Path] = privileges
+		}
+		if _, ok := privileges[permission.Privilege]; !ok {
+			missing = append(missing, permission)
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingPermissionsError{Missing: missing}
+	}
+	return nil
+}
+
+// pathPrivileges returns the privileges of the account on path, as
+// /access/permissions computes them from the ACLs, pools and groups.
+func (c *Client) pathPrivileges(ctx context.Context, path string) (map[string]int, error) {
+	stdout, err := c.runPvesh(ctx, "pvesh get permissions failed", "get", "/access/permissions", "--path", path, "--output-format", "json")
+	if err != nil {
+		return nil, err
+	}
+	var permissions map[string]map[string]int
+	if err := json.Unmarshal([]byte(stdout), &permissions); err != nil {
+		return nil, fmt.Errorf("failed to parse permissions of %s: %w", path, err)
+	}
+	return permissions[path], nil
+}
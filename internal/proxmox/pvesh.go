@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPveshRetries is how many times a pvesh call failing with a
+// transient API error is retried by default.
+const DefaultPveshRetries = 2
+
+// pveshRetryInterval is the delay before the first retry of a pvesh call,
+// doubled on every further retry.
+const pveshRetryInterval = time.Second
+
+// pveshServerErrorRegex matches the status line pvesh prints when the API
+// answers with a server-side error.
+var pveshServerErrorRegex = regexp.MustCompile(`(?m)^\s*(500|502|503|504|596)\s`)
+
+// pveshThrottle spaces pvesh calls so that no more than pvesh_rate of them
+// start per second, whatever the number of concurrent callers.
+type pveshThrottle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newPveshThrottle(perSecond int) *pveshThrottle {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &pveshThrottle{interval: time.Second / time.Duration(perSecond)}
+}
+
+// wait blocks until the next call slot. A nil throttle never waits.
+func (t *pveshThrottle) wait(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	now := time.Now()
+	slot := t.next
+	if slot.Before(now) {
+		slot = now
+	}
+	t.next = slot.Add(t.interval)
+	t.mu.Unlock()
+
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isTransientPveshError reports whether the output of a failed pvesh call
+// points at a hiccup of pveproxy or pmxcfs worth retrying, rather than at
+// the request itself.
+func isTransientPveshError(output string) bool {
+	if pveshServerErrorRegex.MatchString(output) {
+		return true
+	}
+	normalized := strings.ToLower(output)
+	return strings.Contains(normalized, "got timeout") ||
+		strings.Contains(normalized, "timed out") ||
+		strings.Contains(normalized, "temporarily unavailable") ||
+		strings.Contains(normalized, "cluster not ready") ||
+		strings.Contains(normalized, "connection refused")
+}
+
+// runPvesh runs pvesh, throttled by pvesh_rate, and retries reads (get and
+// ls) failing with a transient API error up to pvesh_retries times with an
+// exponential backoff. Calls changing the cluster may have been applied
+// before failing, so they are not retried.
+func (c *Client) runPvesh(ctx context.Context, errPrefix string, args ...string) (string, error) {
+	retries := c.cfg.PveshRetries
+	if len(args) == 0 || (args[0] != "get" && args[0] != "ls") {
+		retries = 0
+	}
+	delay := pveshRetryInterval
+	for attempt := 0; ; attempt++ {
+		if err := c.pveshThrottle.wait(ctx); err != nil {
+			return "", err
+		}
+		stdout, stderr, err := c.runner.Run(ctx, "pvesh", args...)
+		if err == nil {
+			return stdout, nil
+		}
+		if ctx.Err() != nil || attempt >= retries || !isTransientPveshError(stderr) {
+			return "", NewCommandError(errPrefix, err, stderr)
+		}
+
+		c.logger.Warn("pvesh call failed, retrying", "path", pveshPath(args), "attempt", attempt+1, "retry_in", delay, "error", strings.TrimSpace(stderr))
+		c.metrics.Add(MetricRetries, 1, "reason", "pvesh")
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// pveshPath returns the API path of a pvesh command line, for logs.
+func pveshPath(args []string) string {
+	if len(args) < 2 {
+		return strings.Join(args, " ")
+	}
+	return args[0] + " " + args[1]
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimitChunk bounds how much data is waited for at once, so throttled
+// transfers stay smooth instead of bursting a full copy buffer.
+const rateLimitChunk = 64 * 1024
+
+// RateLimiter is a token bucket shared by every transfer it throttles, so
+// concurrent transfers split the bandwidth instead of each getting the
+// full limit.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing kibPerSecond KiB/s, the unit
+// vzdump and qmrestore use for --bwlimit, or nil when kibPerSecond is 0.
+func NewRateLimiter(kibPerSecond int64) *RateLimiter {
+	if kibPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(kibPerSecond) * 1024
+	return &RateLimiter{
+		rate:   rate,
+		burst:  max(rate, rateLimitChunk),
+		tokens: rateLimitChunk,
+		last:   time.Now(),
+	}
+}
+
+// NewAdjustableRateLimiter is NewRateLimiter for a limit changed along the
+// way with SetLimit: it returns a limiter even when kibPerSecond is 0, which
+// then lets transfers run at full speed.
+func NewAdjustableRateLimiter(kibPerSecond int64) *RateLimiter {
+	l := &RateLimiter{tokens: rateLimitChunk, last: time.Now()}
+	l.SetLimit(kibPerSecond)
+	return l
+}
+
+// SetLimit changes the limit of every transfer the limiter throttles to
+// kibPerSecond KiB/s, or lifts it when kibPerSecond is 0.
+func (l *RateLimiter) SetLimit(kibPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = float64(max(kibPerSecond, 0)) * 1024
+	l.burst = max(l.rate, rateLimitChunk)
+	l.tokens = min(l.tokens, l.burst)
+	l.last = time.Now()
+}
+
+// wait takes n bytes from the bucket, sleeping for as long as the bucket
+// is in debt.
+func (l *RateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	debt := -l.tokens
+	l.mu.Unlock()
+
+	if debt <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(debt / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type rateLimitedReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *RateLimiter
+}
+
+// NewRateLimitedReader throttles reads from r with limiter. A nil limiter
+// returns r unchanged.
+func NewRateLimitedReader(ctx context.Context, r io.ReadCloser, limiter *RateLimiter) io.ReadCloser {
+	if limiter == nil {
+		return r
+	}
+	return &rateLimitedReader{ReadCloser: r, ctx: ctx, limiter: limiter}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if err := r.limiter.wait(r.ctx, min(len(p), rateLimitChunk)); err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(p[:min(len(p), rateLimitChunk)])
+}
+
+type rateLimitedWriter struct {
+	io.WriteCloser
+	ctx     context.Context
+	limiter *RateLimiter
+}
+
+// NewRateLimitedWriter throttles writes to w with limiter. A nil limiter
+// returns w unchanged.
+func NewRateLimitedWriter(ctx context.Context, w io.WriteCloser, limiter *RateLimiter) io.WriteCloser {
+	if limiter == nil {
+		return w
+	}
+	return &rateLimitedWriter{WriteCloser: w, ctx: ctx, limiter: limiter}
+}
+
+func (w *rateLimitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), rateLimitChunk)]
+		if err := w.limiter.wait(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.WriteCloser.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	ReportFormatJSON = "json"
+	ReportFormatHTML = "html"
+)
+
+// ReportInDumpDir is the report path that writes the report into dump_dir
+// on the node instead of a local file.
+const ReportInDumpDir = "dump_dir"
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"join":     strings.Join,
+	"seconds":  func(d float64) string { return fmt.Sprintf("%.1fs", d) },
+	"datetime": func(t time.Time) string { return t.Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>plakar {{.Operation}} report - {{.Origin}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+.ok { color: #080; } .failed { color: #c00; } .skipped { color: #888; }
+</style>
+</head>
+<body>
+<h1>plakar {{.Operation}} report</h1>
+<p>Origin: {{.Origin}}<br>
+Started: {{datetime .StartedAt}}<br>
+Finished: {{datetime .FinishedAt}} ({{seconds .Duration}})<br>
+Status: <span class="{{.Status}}">{{.Status}}</span>{{if .Error}} - {{.Error}}{{end}}</p>
+<table>
+<tr><th>Archive</th><th>Source VMID</th><th>VMID</th><th>Type</th><th>Node</th><th>Storages</th><th>Bytes</th><th>SHA256</th><th>Duration</th><th>Status</th><th>Verification</th><th>Details</th></tr>
+{{range .Guests}}<tr>
+<td>{{.Archive}}</td><td>{{if .SourceVMID}}{{.SourceVMID}}{{end}}</td><td>{{.VMID}}</td><td>{{.Type}}</td><td>{{.Node}}</td><td>{{join .Storages ", "}}</td><td>{{.Bytes}}</td><td>{{.Checksum}}</td><td>{{seconds .Duration}}</td>
+<td class="{{.Status}}">{{.Status}}</td><td>{{.Verification}}</td>
+<td>{{.Error}}{{if .ConfigDrift}}<pre>{{join .ConfigDrift "\n"}}</pre>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// RenderReport renders a finished summary as a report in format: the
+// summary document itself for json, a standalone page for html.
+func RenderReport(summary *RunSummary, format string) ([]byte, error) {
+	switch format {
+	case ReportFormatJSON:
+		data, err := summary.Encode()
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case ReportFormatHTML:
+		summary.mu.Lock()
+		defer summary.mu.Unlock()
+		var buf bytes.Buffer
+		if err := reportTemplate.Execute(&buf, summary); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported report format: %s", format)
+	}
+}
+
+// ReportFilename returns the name of a report written into dump_dir.
+func ReportFilename(operation, format string, at time.Time) string {
+	return fmt.Sprintf("plakar-%s-report-%s.%s", operation, at.UTC().Format(dumpTimestampLayout), format)
+}
+
+// WriteReport renders the report of a finished run in format and writes it
+// to reportPath, or into dump_dir on the node when reportPath is
+// ReportInDumpDir. It returns the path written.
+func (c *Client) WriteReport(summary *RunSummary, reportPath, format string) (string, error) {
+	data, err := RenderReport(summary, format)
+	if err != nil {
+		return reportPath, err
+	}
+	if reportPath != ReportInDumpDir {
+		return reportPath, os.WriteFile(reportPath, data, 0644)
+	}
+
+	reportPath = path.Join(c.cfg.DumpDir, ReportFilename(summary.Operation, format, time.Now()))
+	writer, err := c.Create(context.Background(), reportPath)
+	if err != nil {
+		return reportPath, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		_ = writer.Close()
+		return reportPath, err
+	}
+	return reportPath, writer.Close()
+}
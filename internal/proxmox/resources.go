@@ -19,20 +19,33 @@ package proxmox
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const resourceCacheTTL = 15 * time.Second
 
+// Bounds of the delay before /cluster/resources is queried again after a
+// failure, during which the last inventory is used instead.
+const (
+	resourceBackoffMin = 5 * time.Second
+	resourceBackoffMax = 2 * time.Minute
+)
+
 type vmResource struct {
 	VMID int    `json:"vmid"`
 	Type string `json:"type"`
 	Node string `json:"node"`
 	Name string `json:"name,omitempty"`
 	Pool string `json:"pool,omitempty"`
+	Lock string `json:"lock,omitempty"`
+	Tags string `json:"tags,omitempty"`
+
+	MaxDisk int64 `json:"maxdisk,omitempty"`
 }
 
 type poolResponse struct {
@@ -47,12 +60,40 @@ func (c *Client) ListAllVMIDs(ctx context.Context) ([]int, error) {
 	return filterVMIDs(resources, c.cfg.Node), nil
 }
 
+// VMType returns the type of the guest, qemu or lxc. When the cluster
+// resources cannot be listed at all, typically on a single node where pvesh
+// is broken or restricted, the type is probed with qm config and pct config
+// instead.
 func (c *Client) VMType(ctx context.Context, vmid int) (string, error) {
 	res, err := c.vmResourceByID(ctx, vmid)
-	if err != nil {
+	if err == nil {
+		return res.Type, nil
+	}
+	if errors.Is(err, ErrVMNotFound) || ctx.Err() != nil {
 		return "", err
 	}
-	return res.Type, nil
+
+	vmType, probeErr := c.probeVMType(ctx, vmid)
+	if probeErr != nil {
+		return "", errors.Join(err, probeErr)
+	}
+	c.logger.Warn("cluster resources unavailable, guest type probed on the node", "vmid", vmid, "type", vmType, "error", err)
+	return vmType, nil
+}
+
+// probeVMType finds the type of a guest of this node from the exit code of
+// qm config and pct config, which read the local config without pvesh.
+func (c *Client) probeVMType(ctx context.Context, vmid int) (string, error) {
+	vmidStr := strconv.Itoa(vmid)
+	for _, probe := range []struct{ vmType, cmd string }{{"qemu", "qm"}, {"lxc", "pct"}} {
+		if _, _, err := c.runner.Run(ctx, probe.cmd, "config", vmidStr); err == nil {
+			return probe.vmType, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("neither qm config nor pct config knows vmid %d: %w", vmid, ErrVMNotFound)
 }
 
 func (c *Client) VMPool(ctx context.Context, vmid int) (string, error) {
@@ -71,11 +112,44 @@ func (c *Client) VMName(ctx context.Context, vmid int) (string, error) {
 	return strings.TrimSpace(res.Name), nil
 }
 
+// VMTags returns the tags of the guest.
+func (c *Client) VMTags(ctx context.Context, vmid int) ([]string, error) {
+	res, err := c.vmResourceByID(ctx, vmid)
+	if err != nil {
+		return nil, err
+	}
+	return strings.FieldsFunc(res.Tags, func(r rune) bool {
+		return r == ';' || r == ',' || r == ' '
+	}), nil
+}
+
+// VMNode returns the node hosting the guest.
+func (c *Client) VMNode(ctx context.Context, vmid int) (string, error) {
+	res, err := c.vmResourceByID(ctx, vmid)
+	if err != nil {
+		return "", err
+	}
+	return res.Node, nil
+}
+
+// VMDiskSize returns the total provisioned disk size of the guest in bytes,
+// as reported by the cluster resources.
+func (c *Client) VMDiskSize(ctx context.Context, vmid int) (int64, error) {
+	res, err := c.vmResourceByID(ctx, vmid)
+	if err != nil {
+		return 0, err
+	}
+	return res.MaxDisk, nil
+}
+
 func (c *Client) PoolExists(ctx context.Context, pool string) (bool, error) {
 	pool = strings.TrimSpace(pool)
 	if pool == "" {
 		return false, nil
 	}
+	if c.cliControl() {
+		return c.cliPoolExists(ctx, pool)
+	}
 
 	_, err := c.runPvesh(ctx, "pvesh get pool failed", "get", "/pools/"+pool, "--output-format", "json")
 	if err != nil {
@@ -97,7 +171,19 @@ func isMissingPoolError(output string) bool {
 		strings.Contains(normalized, "no such")
 }
 
+// AddToPool adds a guest to an existing pool.
+func (c *Client) AddToPool(ctx context.Context, pool string, vmid int) error {
+	if c.cliControl() {
+		return c.cliAddToPool(ctx, pool, vmid)
+	}
+	_, err := c.runPvesh(ctx, "pvesh set pool failed", "set", "/pools/"+pool, "--vms", strconv.Itoa(vmid))
+	return err
+}
+
 func (c *Client) ListPoolVMIDs(ctx context.Context, pool string) ([]int, error) {
+	if c.cliControl() {
+		return c.cliPoolVMIDs(ctx, pool)
+	}
 	stdout, err := c.runPvesh(ctx, "pvesh get pool failed", "get", "/pools/"+pool, "--output-format", "json")
 	if err != nil {
 		return nil, err
@@ -148,14 +234,43 @@ func (c *Client) vmResourceByID(ctx context.Context, vmid int) (vmResource, erro
 		}
 	}
 
-	return vmResource{}, fmt.Errorf("unable to determine VM resource for vmid %d", vmid)
+	return vmResource{}, fmt.Errorf("unable to determine VM resource for vmid %d: %w", vmid, ErrVMNotFound)
 }
 
+// listResources returns the guests of the cluster. When the query fails
+// (typically while quorum flaps) and an inventory was read earlier in the
+// run, that inventory is returned with a warning instead of failing, and
+// the query is retried with an exponential backoff.
 func (c *Client) listResources(ctx context.Context) ([]vmResource, error) {
 	if cached, ok := c.cachedResources(); ok {
 		return cached, nil
 	}
+	if stale, _, ok := c.staleResources(true); ok {
+		return stale, nil
+	}
+
+	resources, err := c.queryResources(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		stale, age, ok := c.staleResources(false)
+		if !ok {
+			return nil, err
+		}
+		backoff := c.resourceQueryFailed()
+		c.logger.Warn("cluster resources unavailable, using the last inventory", "age", age.Round(time.Second), "retry_in", backoff, "error", err)
+		return stale, nil
+	}
 
+	c.setResourceCache(resources)
+	return resources, nil
+}
+
+func (c *Client) queryResources(ctx context.Context) ([]vmResource, error) {
+	if c.cliControl() {
+		return c.cliResources(ctx)
+	}
 	stdout, err := c.runPvesh(ctx, "pvesh get cluster resources failed", "get", "/cluster/resources", "--type", "vm", "--output-format", "json")
 	if err != nil {
 		return nil, err
@@ -165,8 +280,6 @@ func (c *Client) listResources(ctx context.Context) ([]vmResource, error) {
 	if err := json.Unmarshal([]byte(stdout), &resources); err != nil {
 		return nil, fmt.Errorf("failed to parse cluster resources: %w", err)
 	}
-
-	c.setResourceCache(resources)
 	return resources, nil
 }
 
@@ -185,9 +298,49 @@ func (c *Client) cachedResources() ([]vmResource, bool) {
 	return cached, true
 }
 
+// staleResources returns the last inventory read, whatever its age, and
+// that age. With backoffOnly, it is only returned while the query is
+// backing off after a failure.
+func (c *Client) staleResources(backoffOnly bool) ([]vmResource, time.Duration, bool) {
+	c.resourceCacheMu.Lock()
+	defer c.resourceCacheMu.Unlock()
+
+	if len(c.resourceCache) == 0 {
+		return nil, 0, false
+	}
+	if backoffOnly && !time.Now().Before(c.resourceRetryAt) {
+		return nil, 0, false
+	}
+	stale := make([]vmResource, len(c.resourceCache))
+	copy(stale, c.resourceCache)
+	return stale, time.Since(c.resourceCacheAt), true
+}
+
+// resourceQueryFailed doubles the backoff of the resources query and
+// returns it.
+func (c *Client) resourceQueryFailed() time.Duration {
+	c.resourceCacheMu.Lock()
+	defer c.resourceCacheMu.Unlock()
+
+	c.resourceBackoff = min(max(c.resourceBackoff*2, resourceBackoffMin), resourceBackoffMax)
+	c.resourceRetryAt = time.Now().Add(c.resourceBackoff)
+	return c.resourceBackoff
+}
+
+// invalidateResourceCache forces the next lookup to query the cluster. The
+// inventory is kept as a fallback should that query fail.
+func (c *Client) invalidateResourceCache() {
+	c.resourceCacheMu.Lock()
+	c.resourceCacheAt = time.Time{}
+	c.resourceRetryAt = time.Time{}
+	c.resourceCacheMu.Unlock()
+}
+
 func (c *Client) setResourceCache(resources []vmResource) {
 	c.resourceCacheMu.Lock()
 	c.resourceCache = append([]vmResource(nil), resources...)
 	c.resourceCacheAt = time.Now()
+	c.resourceBackoff = 0
+	c.resourceRetryAt = time.Time{}
 	c.resourceCacheMu.Unlock()
 }
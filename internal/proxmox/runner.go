@@ -18,25 +18,68 @@ package proxmox
 
 import (
 	"context"
+	"errors"
 	"io"
+	"log/slog"
 	"os"
 )
 
+// localeEnv forces English, locale-independent output from node commands.
+const localeEnv = "LC_ALL=C LANG=C"
+
 type Runner interface {
 	Run(ctx context.Context, name string, args ...string) (string, string, error)
 	Stream(ctx context.Context, name string, args ...string) (*CommandStream, error)
 	Open(ctx context.Context, filepath string) (io.ReadCloser, error)
+	OpenRange(ctx context.Context, filepath string, offset, length int64) (io.ReadCloser, error)
 	Create(ctx context.Context, filepath string) (io.WriteCloser, error)
 	Stat(ctx context.Context, filepath string) (os.FileInfo, error)
 	Remove(ctx context.Context, filepath string) error
 	Close() error
 }
 
+type stdinKey struct{}
+
+// WithStdin returns a context under which Stream feeds stdin to the
+// command, for the runners that support it (SSH and local). Other runners
+// leave the standard input of the command empty.
+func WithStdin(ctx context.Context, stdin io.Reader) context.Context {
+	return context.WithValue(ctx, stdinKey{}, stdin)
+}
+
+func stdinFrom(ctx context.Context) io.Reader {
+	stdin, _ := ctx.Value(stdinKey{}).(io.Reader)
+	return stdin
+}
+
+type liveOutputKey struct{}
+
+// WithLiveOutput returns a context under which Stream hands out the output
+// of the command as it is written, for commands whose output is parsed
+// while they run, like the log of a vzdump job. conn_compression would
+// hold it back until a whole gzip block is filled.
+func WithLiveOutput(ctx context.Context) context.Context {
+	return context.WithValue(ctx, liveOutputKey{}, true)
+}
+
+func liveOutput(ctx context.Context) bool {
+	live, _ := ctx.Value(liveOutputKey{}).(bool)
+	return live
+}
+
 type CommandStream struct {
 	Stdout io.Reader
 	Stderr io.Reader
 	finish func() error
 	abort  func() error
+	signal func(os.Signal) error
+}
+
+// NewCommandStream builds a stream for Runner implementations outside this
+// package. finish waits for the command and reports its status; abort, which
+// may be nil, stops it early.
+func NewCommandStream(stdout, stderr io.Reader, finish, abort func() error) *CommandStream {
+	return &CommandStream{Stdout: stdout, Stderr: stderr, finish: finish, abort: abort}
 }
 
 func (s *CommandStream) Finish() error {
@@ -53,9 +96,67 @@ func (s *CommandStream) Abort() error {
 	return s.abort()
 }
 
-func NewRunner(cfg *Config) (Runner, error) {
+// Signal delivers sig to the running command, letting callers stop vzdump
+// or a restore cleanly instead of tearing the connection down.
+func (s *CommandStream) Signal(sig os.Signal) error {
+	if s == nil || s.signal == nil {
+		return errors.ErrUnsupported
+	}
+	return s.signal(sig)
+}
+
+func NewRunner(cfg *Config, logger *slog.Logger) (Runner, error) {
+	if cfg.MockFixtures != "" {
+		runner, err := NewMockRunner(cfg.MockFixtures)
+		if err != nil {
+			return nil, err
+		}
+		return WrapRunner(runner, cfg, logger), nil
+	}
 	if cfg.Mode == ModeLocal {
-		return &LocalRunner{}, nil
+		return WrapRunner(&LocalRunner{env: cfg.Env}, cfg, logger), nil
+	}
+
+	logger.Debug("connecting", "hosts", cfg.Hosts, "user", cfg.ConnUsername, "method", cfg.ConnMethod)
+	runner, err := NewSSHRunner(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("connected", "host", runner.Endpoint())
+	if cfg.ConnMultiplex {
+		return WrapRunner(newMuxRunner(runner, cfg, logger), cfg, logger), nil
+	}
+	return WrapRunner(runner, cfg, logger), nil
+}
+
+// WrapRunner adds command logging and the bin_* overrides to runner, as
+// NewRunner does for the built-in runners. With control=https, pvesh calls
+// go to the API below the logging.
+func WrapRunner(runner Runner, cfg *Config, logger *slog.Logger) Runner {
+	if cfg.Control == ControlHTTPS {
+		runner = newAPIRunner(runner, cfg)
+	}
+	return newBinaryRunner(newLoggingRunner(runner, logger), cfg)
+}
+
+// binaryRunner substitutes the configured bin_* paths for PVE tool names, so
+// every caller gets the override without resolving it itself.
+type binaryRunner struct {
+	Runner
+	cfg *Config
+}
+
+func newBinaryRunner(runner Runner, cfg *Config) Runner {
+	if len(cfg.Binaries) == 0 {
+		return runner
 	}
-	return NewSSHRunner(cfg)
+	return &binaryRunner{Runner: runner, cfg: cfg}
+}
+
+func (r *binaryRunner) Run(ctx context.Context, name string, args ...string) (string, string, error) {
+	return r.Runner.Run(ctx, r.cfg.Binary(name), args...)
+}
+
+func (r *binaryRunner) Stream(ctx context.Context, name string, args ...string) (*CommandStream, error) {
+	return r.Runner.Stream(ctx, r.cfg.Binary(name), args...)
 }
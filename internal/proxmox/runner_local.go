@@ -22,20 +22,29 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strings"
 )
 
-type LocalRunner struct{}
+type LocalRunner struct {
+	env []string
+}
 
 func (r *LocalRunner) Run(ctx context.Context, name string, args ...string) (string, string, error) {
 	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(localeEnviron(), r.env...)
+	setProcessGroup(cmd)
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	return stdout.String(), stderr.String(), cmd.Run()
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
 }
 
 func (r *LocalRunner) Stream(ctx context.Context, name string, args ...string) (*CommandStream, error) {
 	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(localeEnviron(), r.env...)
+	setProcessGroup(cmd)
+	cmd.Stdin = stdinFrom(ctx)
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, err
@@ -55,7 +64,13 @@ func (r *LocalRunner) Stream(ctx context.Context, name string, args ...string) (
 			if cmd.Process == nil {
 				return nil
 			}
-			return cmd.Process.Kill()
+			return cmd.Cancel()
+		},
+		signal: func(sig os.Signal) error {
+			if cmd.Process == nil {
+				return nil
+			}
+			return cmd.Process.Signal(sig)
 		},
 	}, nil
 }
@@ -64,6 +79,26 @@ func (r *LocalRunner) Open(ctx context.Context, filepath string) (io.ReadCloser,
 	return os.Open(filepath)
 }
 
+func (r *LocalRunner) OpenRange(ctx context.Context, filepath string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &limitedReadCloser{
+		Reader: io.LimitReader(file, length),
+		Closer: file,
+	}, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
 func (r *LocalRunner) Create(ctx context.Context, filepath string) (io.WriteCloser, error) {
 	return os.Create(filepath)
 }
@@ -79,3 +114,7 @@ func (r *LocalRunner) Remove(ctx context.Context, filepath string) error {
 func (r *LocalRunner) Close() error {
 	return nil
 }
+
+func localeEnviron() []string {
+	return append(os.Environ(), strings.Fields(localeEnv)...)
+}
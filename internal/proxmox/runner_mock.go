@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// MockFixture scripts the result of the commands whose arguments match Args.
+// Each element of Args is a path.Match pattern for the argument at the same
+// position, and a trailing "**" matches any remaining arguments.
+type MockFixture struct {
+	Args       []string `json:"args"`
+	Stdout     string   `json:"stdout,omitempty"`
+	StdoutFile string   `json:"stdout_file,omitempty"`
+	Stderr     string   `json:"stderr,omitempty"`
+	ExitCode   int      `json:"exit_code,omitempty"`
+}
+
+// MockRunner serves command results from fixtures instead of a Proxmox
+// node, for end-to-end tests of the importer and exporter. Fixtures are read
+// from <dir>/commands.json; the first matching fixture wins. File
+// operations are confined to <dir>/files, so /var/lib/vz/dump/x.vma maps to
+// <dir>/files/var/lib/vz/dump/x.vma.
+type MockRunner struct {
+	dir      string
+	fixtures []MockFixture
+
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func NewMockRunner(dir string) (*MockRunner, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "commands.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mock fixtures: %w", err)
+	}
+	var fixtures []MockFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("failed to parse mock fixtures: %w", err)
+	}
+	return &MockRunner{dir: dir, fixtures: fixtures}, nil
+}
+
+// Calls returns the commands run so far, in order.
+func (r *MockRunner) Calls() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]string(nil), r.calls...)
+}
+
+func (r *MockRunner) Run(ctx context.Context, name string, args ...string) (string, string, error) {
+	fixture, err := r.match(name, args)
+	if err != nil {
+		return "", "", err
+	}
+	stdout, err := r.stdout(fixture)
+	if err != nil {
+		return "", "", err
+	}
+	return string(stdout), fixture.Stderr, fixture.exitError()
+}
+
+func (r *MockRunner) Stream(ctx context.Context, name string, args ...string) (*CommandStream, error) {
+	fixture, err := r.match(name, args)
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := r.stdout(fixture)
+	if err != nil {
+		return nil, err
+	}
+	return NewCommandStream(bytes.NewReader(stdout), strings.NewReader(fixture.Stderr), fixture.exitError, nil), nil
+}
+
+func (r *MockRunner) Open(ctx context.Context, filepath string) (io.ReadCloser, error) {
+	return os.Open(r.localPath(filepath))
+}
+
+func (r *MockRunner) OpenRange(ctx context.Context, filepath string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(r.localPath(filepath))
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReadCloser{
+		Reader: io.NewSectionReader(file, offset, length),
+		Closer: file,
+	}, nil
+}
+
+func (r *MockRunner) Create(ctx context.Context, filepath string) (io.WriteCloser, error) {
+	local := r.localPath(filepath)
+	if err := os.MkdirAll(path.Dir(local), 0o755); err != nil {
+		return nil, err
+	}
+	return os.Create(local)
+}
+
+func (r *MockRunner) Stat(ctx context.Context, filepath string) (os.FileInfo, error) {
+	return os.Stat(r.localPath(filepath))
+}
+
+func (r *MockRunner) Remove(ctx context.Context, filepath string) error {
+	return os.Remove(r.localPath(filepath))
+}
+
+func (r *MockRunner) Close() error {
+	return nil
+}
+
+func (r *MockRunner) localPath(remote string) string {
+	return filepath.Join(r.dir, "files", filepath.FromSlash(path.Clean("/"+remote)))
+}
+
+func (r *MockRunner) match(name string, args []string) (MockFixture, error) {
+	command := append([]string{name}, args...)
+	r.mu.Lock()
+	r.calls = append(r.calls, command)
+	r.mu.Unlock()
+
+	for _, fixture := range r.fixtures {
+		if matchArgs(fixture.Args, command) {
+			return fixture, nil
+		}
+	}
+	return MockFixture{}, fmt.Errorf("no mock fixture for command: %s", strings.Join(command, " "))
+}
+
+func (r *MockRunner) stdout(fixture MockFixture) ([]byte, error) {
+	if fixture.StdoutFile == "" {
+		return []byte(fixture.Stdout), nil
+	}
+	return os.ReadFile(filepath.Join(r.dir, fixture.StdoutFile))
+}
+
+func (f MockFixture) exitError() error {
+	if f.ExitCode == 0 {
+		return nil
+	}
+	return fmt.Errorf("exit status %d", f.ExitCode)
+}
+
+func matchArgs(patterns, command []string) bool {
+	for i, pattern := range patterns {
+		if pattern == "**" && i == len(patterns)-1 {
+			return true
+		}
+		if i >= len(command) {
+			return false
+		}
+		if ok, err := path.Match(pattern, command[i]); err != nil || !ok {
+			return false
+		}
+	}
+	return len(patterns) == len(command)
+}
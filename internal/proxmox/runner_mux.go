@@ -0,0 +1,374 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/nodehelper"
+)
+
+const (
+	// muxChunkSize is the size of the read and write requests of file
+	// transfers over the helper session.
+	muxChunkSize = 1 << 20
+	// muxWindow is how many chunks of a transfer are in flight at once,
+	// so a transfer is not paced by the round trip time.
+	muxWindow = 8
+)
+
+// muxRunner runs commands and file transfers over one long-lived helper
+// session (conn_multiplex=true) instead of an SSH session each. Streams
+// (vzdump) keep their own sessions. When the session breaks, the next call
+// starts a new one; when the helper cannot be started at all, the runner
+// falls back to plain SSH sessions.
+type muxRunner struct {
+	Runner
+	cfg    *Config
+	logger *slog.Logger
+	env    []string
+
+	mu       sync.Mutex
+	session  *helperSession
+	disabled bool
+}
+
+func newMuxRunner(runner Runner, cfg *Config, logger *slog.Logger) *muxRunner {
+	return &muxRunner{
+		Runner: runner,
+		cfg:    cfg,
+		logger: logger,
+		env:    append(strings.Fields(localeEnv), cfg.Env...),
+	}
+}
+
+// remoteExitError is the exit status of a command run by the helper.
+type remoteExitError struct {
+	status int
+}
+
+func (e *remoteExitError) Error() string {
+	return fmt.Sprintf("Process exited with status %d", e.status)
+}
+
+func (e *remoteExitError) ExitStatus() int {
+	return e.status
+}
+
+func (r *muxRunner) helper(ctx context.Context) *helperSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.session != nil && r.session.broken() {
+		r.logger.Warn("multiplexed session lost, reconnecting", "error", r.session.failure())
+		go r.session.close()
+		r.session = nil
+	}
+	if r.session == nil && !r.disabled {
+		session, err := startHelperSession(ctx, r.Runner, r.cfg, r.logger)
+		if err != nil {
+			r.logger.Warn("multiplexed session unavailable, using one SSH session per command", "error", err)
+			r.disabled = true
+			return nil
+		}
+		r.session = session
+	}
+	return r.session
+}
+
+func (r *muxRunner) Run(ctx context.Context, name string, args ...string) (string, string, error) {
+	session := r.helper(ctx)
+	if session == nil {
+		return r.Runner.Run(ctx, name, args...)
+	}
+
+	id, reply, err := session.call(nodehelper.Request{Op: nodehelper.OpRun, Path: name, Args: args, Env: r.env}, nil)
+	if err != nil {
+		return "", "", err
+	}
+	var (
+		result    helperReply
+		ok        bool
+		cancelErr error
+	)
+	select {
+	case result, ok = <-reply:
+	case <-ctx.Done():
+		// Like an SSH session, stop the command and report how it ended.
+		cancelErr = ctx.Err()
+		_, _, _ = session.do(nodehelper.Request{Op: nodehelper.OpCancel, Target: id}, nil)
+		result, ok = <-reply
+	}
+	if !ok {
+		return "", "", session.failure()
+	}
+	resp, stdout := result.resp, string(result.payload)
+	switch {
+	case resp.Error != "":
+		return "", "", fmt.Errorf("%s: %s", name, resp.Error)
+	case resp.Exit != 0:
+		return stdout, resp.Stderr, &remoteExitError{status: resp.Exit}
+	}
+	return stdout, resp.Stderr, cancelErr
+}
+
+func (r *muxRunner) Stat(ctx context.Context, filepath string) (os.FileInfo, error) {
+	session := r.helper(ctx)
+	if session == nil {
+		return r.Runner.Stat(ctx, filepath)
+	}
+	resp, _, err := session.do(nodehelper.Request{Op: nodehelper.OpStat, Path: filepath}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" || resp.Entry == nil {
+		return nil, helperError("stat", filepath, resp)
+	}
+	return helperFileInfo(*resp.Entry), nil
+}
+
+func (r *muxRunner) Remove(ctx context.Context, filepath string) error {
+	session := r.helper(ctx)
+	if session == nil {
+		return r.Runner.Remove(ctx, filepath)
+	}
+	resp, _, err := session.do(nodehelper.Request{Op: nodehelper.OpRemove, Path: filepath}, nil)
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return helperError("remove", filepath, resp)
+	}
+	return nil
+}
+
+func (r *muxRunner) Open(ctx context.Context, filepath string) (io.ReadCloser, error) {
+	return r.OpenRange(ctx, filepath, 0, -1)
+}
+
+// OpenRange reads length bytes of filepath from offset, or up to the end
+// of the file when length is negative. The first chunk is read before
+// returning, so a missing file is reported here.
+func (r *muxRunner) OpenRange(ctx context.Context, filepath string, offset, length int64) (io.ReadCloser, error) {
+	session := r.helper(ctx)
+	if session == nil {
+		if length < 0 {
+			return r.Runner.Open(ctx, filepath)
+		}
+		return r.Runner.OpenRange(ctx, filepath, offset, length)
+	}
+
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	reader := &muxReader{session: session, path: filepath, offset: offset, remaining: length}
+	if err := reader.fill(); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+func (r *muxRunner) Create(ctx context.Context, filepath string) (io.WriteCloser, error) {
+	session := r.helper(ctx)
+	if session == nil {
+		return r.Runner.Create(ctx, filepath)
+	}
+	resp, _, err := session.do(nodehelper.Request{Op: nodehelper.OpWrite, Path: filepath, Create: true}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, helperError("create", filepath, resp)
+	}
+	return &muxWriter{session: session, path: filepath}, nil
+}
+
+func (r *muxRunner) Close() error {
+	r.mu.Lock()
+	session := r.session
+	r.session = nil
+	r.mu.Unlock()
+	if session != nil {
+		session.close()
+	}
+	return r.Runner.Close()
+}
+
+// muxReader reads a file with a window of read requests in flight.
+type muxReader struct {
+	session   *helperSession
+	path      string
+	offset    int64 // of the next request
+	remaining int64 // bytes left to request, negative for up to EOF
+
+	inflight []<-chan helperReply
+	buf      []byte
+	eof      bool
+	err      error
+}
+
+// fill keeps the window of read requests full and waits for the oldest.
+func (r *muxReader) fill() error {
+	for !r.eof && r.remaining != 0 && len(r.inflight) < muxWindow {
+		size := int64(muxChunkSize)
+		if r.remaining > 0 && r.remaining < size {
+			size = r.remaining
+		}
+		_, reply, err := r.session.call(nodehelper.Request{Op: nodehelper.OpRead, Path: r.path, Offset: r.offset, Length: size}, nil)
+		if err != nil {
+			return err
+		}
+		r.inflight = append(r.inflight, reply)
+		r.offset += size
+		if r.remaining > 0 {
+			r.remaining -= size
+		}
+	}
+	if len(r.inflight) == 0 {
+		return io.EOF
+	}
+
+	reply := r.inflight[0]
+	r.inflight = r.inflight[1:]
+	resp, payload, err := r.session.wait(reply)
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return helperError("read", r.path, resp)
+	}
+	if resp.EOF {
+		// Chunks requested past the end of the file are dropped.
+		r.eof = true
+		r.drain()
+	}
+	r.buf = payload
+	return nil
+}
+
+func (r *muxReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.eof && len(r.inflight) == 0 {
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			r.err = err
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *muxReader) drain() {
+	for _, reply := range r.inflight {
+		_, _, _ = r.session.wait(reply)
+	}
+	r.inflight = nil
+}
+
+func (r *muxReader) Close() error {
+	r.drain()
+	r.err = errors.New("read on closed file")
+	return nil
+}
+
+// muxWriter writes a file created by Create, with a window of write
+// requests in flight.
+type muxWriter struct {
+	session  *helperSession
+	path     string
+	offset   int64
+	buf      []byte
+	inflight []<-chan helperReply
+	err      error
+}
+
+func (w *muxWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		room := muxChunkSize - len(w.buf)
+		if room > len(p) {
+			room = len(p)
+		}
+		w.buf = append(w.buf, p[:room]...)
+		p = p[room:]
+		if len(w.buf) == muxChunkSize {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *muxWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	for len(w.inflight) >= muxWindow {
+		if err := w.ack(); err != nil {
+			return err
+		}
+	}
+	_, reply, err := w.session.call(nodehelper.Request{Op: nodehelper.OpWrite, Path: w.path, Offset: w.offset}, w.buf)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	w.inflight = append(w.inflight, reply)
+	w.offset += int64(len(w.buf))
+	w.buf = make([]byte, 0, muxChunkSize)
+	return nil
+}
+
+// ack waits for the oldest write in flight.
+func (w *muxWriter) ack() error {
+	reply := w.inflight[0]
+	w.inflight = w.inflight[1:]
+	resp, _, err := w.session.wait(reply)
+	if err == nil && resp.Error != "" {
+		err = helperError("write", w.path, resp)
+	}
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+func (w *muxWriter) Close() error {
+	if w.err == nil {
+		_ = w.flush()
+	}
+	for len(w.inflight) > 0 {
+		_ = w.ack()
+	}
+	return w.err
+}
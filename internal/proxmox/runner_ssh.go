@@ -19,63 +19,197 @@ package proxmox
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
+const remotePIDMarker = "plakar-proxmox-pid="
+
+// cancelGrace is how long a cancelled remote command gets to clean up
+// (release guest locks, remove temporary files) before it is killed.
+const cancelGrace = 30 * time.Second
+
+// sshReconnectAttempts bounds how many times a dropped connection is
+// redialled before commands fail with ErrConnectionLost.
+const sshReconnectAttempts = 3
+
+// sshKeepaliveInterval is how often the connection is probed, so a dead
+// link is noticed before the next command hangs on it.
+const sshKeepaliveInterval = 30 * time.Second
+
 type SSHRunner struct {
-	client *ssh.Client
+	mu        sync.Mutex
+	client    *ssh.Client
+	closed    bool
+	clientCfg *ssh.ClientConfig
+	addrs     []string
+	current   int
+	env       []string
+	logger    *slog.Logger
+
+	// compress gzips file transfers of uncompressed content, and Stream
+	// output when vzdump does not compress (conn_compression=true).
+	compress       bool
+	compressStream bool
 }
 
-func NewSSHRunner(cfg *Config) (*SSHRunner, error) {
+func NewSSHRunner(cfg *Config, logger *slog.Logger) (*SSHRunner, error) {
 	if cfg.ConnUsername == "" {
 		return nil, fmt.Errorf("missing conn_username")
 	}
 
-	var auth ssh.AuthMethod
-	switch cfg.ConnMethod {
-	case ConnMethodPassword:
-		auth = ssh.Password(cfg.ConnPassword)
-	case ConnMethodIdentity:
-		key, err := os.ReadFile(cfg.ConnIdentityFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read identity file: %w", err)
-		}
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse identity file: %w", err)
-		}
-		auth = ssh.PublicKeys(signer)
-	default:
-		return nil, fmt.Errorf("unsupported conn_method: %s", cfg.ConnMethod)
+	auth, err := sshAuthMethods(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	clientCfg := &ssh.ClientConfig{
 		User:            cfg.ConnUsername,
-		Auth:            []ssh.AuthMethod{auth},
+		Auth:            auth,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		Timeout:         30 * time.Second,
 	}
 
-	addr := normalizeSSHAddr(cfg.Host)
+	addrs := make([]string, 0, len(cfg.Hosts))
+	for _, host := range cfg.Hosts {
+		addrs = append(addrs, normalizeSSHAddr(host))
+	}
+
+	r := &SSHRunner{
+		clientCfg:      clientCfg,
+		addrs:          addrs,
+		env:            cfg.Env,
+		logger:         logger,
+		compress:       cfg.ConnCompression,
+		compressStream: cfg.ConnCompression && cfg.BackupCompression == "0",
+	}
+	client, err := r.connect()
+	if err != nil {
+		return nil, err
+	}
+	r.client = client
+	go keepalive(client)
+	return r, nil
+}
+
+// connect dials the endpoints in order, starting with the last one that
+// worked, and returns the first connection established.
+func (r *SSHRunner) connect() (*ssh.Client, error) {
+	var lastErr error
+	for i := range r.addrs {
+		idx := (r.current + i) % len(r.addrs)
+		client, err := dialSSH(r.addrs[idx], r.clientCfg)
+		if err == nil {
+			if idx != r.current {
+				r.logger.Warn("failed over to another endpoint", "host", r.addrs[idx])
+			}
+			r.current = idx
+			return client, nil
+		}
+		if len(r.addrs) > 1 {
+			r.logger.Warn("endpoint unreachable", "host", r.addrs[idx], "error", err)
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Endpoint returns the address of the node the runner is connected to.
+func (r *SSHRunner) Endpoint() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addrs[r.current]
+}
+
+func dialSSH(addr string, clientCfg *ssh.ClientConfig) (*ssh.Client, error) {
 	client, err := ssh.Dial("tcp", addr, clientCfg)
 	if err != nil {
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			return nil, fmt.Errorf("ssh dial failed: %w: %w", ErrAuth, err)
+		}
 		return nil, fmt.Errorf("ssh dial failed: %w", err)
 	}
+	return client, nil
+}
+
+// newSession opens a session, redialling first when the connection has
+// dropped since the previous command. Commands running when the link died
+// still fail; only the following ones benefit from the new connection.
+func (r *SSHRunner) newSession() (*ssh.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, fmt.Errorf("ssh connection closed")
+	}
+	session, err := r.client.NewSession()
+	if err == nil {
+		return session, nil
+	}
+	var openErr *ssh.OpenChannelError
+	if errors.As(err, &openErr) {
+		// The server refused the channel, the connection itself is fine.
+		return nil, err
+	}
+
+	r.logger.Warn("ssh connection lost, reconnecting", "host", r.addrs[r.current], "error", err)
+	_ = r.client.Close()
+	for attempt := 1; attempt <= sshReconnectAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * 5 * time.Second)
+		}
+		client, dialErr := r.connect()
+		if dialErr != nil {
+			err = dialErr
+			r.logger.Warn("ssh reconnection failed", "attempt", attempt, "error", dialErr)
+			continue
+		}
+		r.client = client
+		go keepalive(client)
+		r.logger.Info("ssh reconnected", "host", r.addrs[r.current], "attempt", attempt)
+		return client.NewSession()
+	}
+	return nil, fmt.Errorf("%w: %w", ErrConnectionLost, err)
+}
 
-	return &SSHRunner{client: client}, nil
+// keepalive closes client when the server stops answering keepalive
+// requests, so the next newSession redials instead of hanging.
+func keepalive(client *ssh.Client) {
+	ticker := time.NewTicker(sshKeepaliveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		replied := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+		select {
+		case err := <-replied:
+			if err == nil {
+				continue
+			}
+		case <-time.After(sshKeepaliveInterval):
+		}
+		_ = client.Close()
+		return
+	}
 }
 
 func (r *SSHRunner) Run(ctx context.Context, name string, args ...string) (string, string, error) {
-	session, err := r.client.NewSession()
+	session, err := r.newSession()
 	if err != nil {
 		return "", "", err
 	}
@@ -84,21 +218,21 @@ func (r *SSHRunner) Run(ctx context.Context, name string, args ...string) (strin
 	}()
 
 	var stdout, stderr bytes.Buffer
+	capture := &pidCapture{dst: &stderr}
 	session.Stdout = &stdout
-	session.Stderr = &stderr
+	session.Stderr = capture
 
-	cmd := shellCommand(name, args...)
-	go func() {
-		<-ctx.Done()
-		_ = session.Close()
-	}()
+	exited := make(chan struct{})
+	defer close(exited)
+	go r.cancelOnDone(ctx, exited, session, capture)
 
-	err = session.Run(cmd)
+	cmd := shellCommand(name, args...)
+	err = session.Run(r.remoteCommand(cmd))
 	return stdout.String(), stderr.String(), err
 }
 
 func (r *SSHRunner) Stream(ctx context.Context, name string, args ...string) (*CommandStream, error) {
-	session, err := r.client.NewSession()
+	session, err := r.newSession()
 	if err != nil {
 		return nil, err
 	}
@@ -109,39 +243,155 @@ func (r *SSHRunner) Stream(ctx context.Context, name string, args ...string) (*C
 		return nil, err
 	}
 
-	stderr, err := session.StderrPipe()
-	if err != nil {
-		_ = session.Close()
-		return nil, err
-	}
+	stderr, stderrWriter := io.Pipe()
+	capture := &pidCapture{dst: stderrWriter}
+	session.Stderr = capture
+
+	// Interactive commands and parsed logs are not compressed: gzip would
+	// hold their output back until a whole block is filled.
+	stdin := stdinFrom(ctx)
+	compress := r.compressStream && stdin == nil && !liveOutput(ctx)
+	session.Stdin = stdin
 
 	cmd := shellCommand(name, args...)
-	if err := session.Start(cmd); err != nil {
+	if compress {
+		cmd = gzipOutput(cmd)
+	}
+	if err := session.Start(r.remoteCommand(cmd)); err != nil {
+		_ = stderrWriter.Close()
 		_ = session.Close()
 		return nil, err
 	}
 
+	exited := make(chan struct{})
+	var waitErr error
 	go func() {
-		<-ctx.Done()
-		_ = session.Close()
+		waitErr = session.Wait()
+		_ = stderrWriter.Close()
+		close(exited)
 	}()
+	go r.cancelOnDone(ctx, exited, session, capture)
+
+	if compress {
+		stdout = &gzipReader{src: stdout}
+	}
 
 	return &CommandStream{
 		Stdout: stdout,
 		Stderr: stderr,
 		finish: func() error {
-			err := session.Wait()
+			<-exited
 			_ = session.Close()
-			return err
+			return waitErr
 		},
 		abort: func() error {
+			r.stopRemote(session, capture, exited)
 			return session.Close()
 		},
+		signal: func(sig os.Signal) error {
+			name, err := sshSignal(sig)
+			if err != nil {
+				return err
+			}
+			return session.Signal(name)
+		},
 	}, nil
 }
 
+// cancelOnDone stops the remote command when ctx is cancelled before it
+// exits. Closing the session alone leaves vzdump running on the node with
+// the guest still locked.
+func (r *SSHRunner) cancelOnDone(ctx context.Context, exited <-chan struct{}, session *ssh.Session, capture *pidCapture) {
+	select {
+	case <-ctx.Done():
+		r.stopRemote(session, capture, exited)
+		_ = session.Close()
+	case <-exited:
+	}
+}
+
+// stopRemote asks the remote command to terminate with an SSH signal
+// request. Signal requests are fire-and-forget and some servers ignore
+// them, so the process group is killed by PID if the command is still
+// running after cancelGrace.
+func (r *SSHRunner) stopRemote(session *ssh.Session, capture *pidCapture, exited <-chan struct{}) {
+	if err := session.Signal(ssh.SIGTERM); err == nil {
+		select {
+		case <-exited:
+			return
+		case <-time.After(cancelGrace):
+		}
+	}
+	r.terminateRemote(capture.PID())
+}
+
+func sshSignal(sig os.Signal) (ssh.Signal, error) {
+	switch sig {
+	case os.Interrupt:
+		return ssh.SIGINT, nil
+	case os.Kill:
+		return ssh.SIGKILL, nil
+	case syscall.SIGTERM:
+		return ssh.SIGTERM, nil
+	case syscall.SIGHUP:
+		return ssh.SIGHUP, nil
+	case syscall.SIGQUIT:
+		return ssh.SIGQUIT, nil
+	default:
+		return "", fmt.Errorf("unsupported signal for ssh session: %v", sig)
+	}
+}
+
+// terminateRemote sends SIGTERM to the process group led by pid, waits up
+// to cancelGrace for it to exit and falls back to SIGKILL.
+func (r *SSHRunner) terminateRemote(pid int) {
+	if pid <= 1 {
+		return
+	}
+	session, err := r.newSession()
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = session.Close()
+	}()
+
+	script := fmt.Sprintf("kill -TERM -- -%[1]d 2>/dev/null || exit 0; i=0; "+
+		"while kill -0 -- -%[1]d 2>/dev/null && [ $i -lt %[2]d ]; do sleep 1; i=$((i+1)); done; "+
+		"kill -KILL -- -%[1]d 2>/dev/null; exit 0", pid, int(cancelGrace/time.Second))
+
+	timer := time.AfterFunc(cancelGrace+10*time.Second, func() {
+		_ = session.Close()
+	})
+	defer timer.Stop()
+	_ = session.Run(withLocale(script))
+}
+
 func (r *SSHRunner) Open(ctx context.Context, filepath string) (io.ReadCloser, error) {
-	session, err := r.client.NewSession()
+	if r.compressTransfer(filepath) {
+		return r.openCompressed(ctx, fmt.Sprintf("gzip -1 -c -- %s", shellQuote(filepath)))
+	}
+	return r.openCommand(ctx, fmt.Sprintf("cat -- %s", shellQuote(filepath)))
+}
+
+func (r *SSHRunner) OpenRange(ctx context.Context, filepath string, offset, length int64) (io.ReadCloser, error) {
+	cmd := fmt.Sprintf("dd if=%s iflag=skip_bytes,count_bytes skip=%d count=%d bs=4M status=none", shellQuote(filepath), offset, length)
+	if r.compressTransfer(filepath) {
+		return r.openCompressed(ctx, gzipOutput(cmd))
+	}
+	return r.openCommand(ctx, cmd)
+}
+
+func (r *SSHRunner) openCompressed(ctx context.Context, cmd string) (io.ReadCloser, error) {
+	reader, err := r.openCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return &gzipReader{src: reader}, nil
+}
+
+func (r *SSHRunner) openCommand(ctx context.Context, cmd string) (io.ReadCloser, error) {
+	session, err := r.newSession()
 	if err != nil {
 		return nil, err
 	}
@@ -155,21 +405,34 @@ func (r *SSHRunner) Open(ctx context.Context, filepath string) (io.ReadCloser, e
 	var stderr bytes.Buffer
 	session.Stderr = &stderr
 
-	cmd := fmt.Sprintf("cat -- %s", shellQuote(filepath))
-	if err := session.Start(cmd); err != nil {
+	if err := session.Start(withLocale(cmd)); err != nil {
 		_ = session.Close()
 		return nil, err
 	}
 
+	done := make(chan struct{})
+	go closeOnDone(ctx, done, session)
+
 	return &sshReadCloser{
 		session: session,
 		stdout:  stdout,
 		stderr:  &stderr,
+		done:    done,
 	}, nil
 }
 
+// closeOnDone closes session when ctx is cancelled before done, unblocking
+// pending reads and writes on file transfers.
+func closeOnDone(ctx context.Context, done <-chan struct{}, session *ssh.Session) {
+	select {
+	case <-ctx.Done():
+		_ = session.Close()
+	case <-done:
+	}
+}
+
 func (r *SSHRunner) Create(ctx context.Context, filepath string) (io.WriteCloser, error) {
-	session, err := r.client.NewSession()
+	session, err := r.newSession()
 	if err != nil {
 		return nil, err
 	}
@@ -184,23 +447,35 @@ func (r *SSHRunner) Create(ctx context.Context, filepath string) (io.WriteCloser
 	session.Stderr = &stderr
 
 	cmd := fmt.Sprintf("cat > %s", shellQuote(filepath))
-	if err := session.Start(cmd); err != nil {
+	compress := r.compressTransfer(filepath)
+	if compress {
+		cmd = fmt.Sprintf("gzip -d -c > %s", shellQuote(filepath))
+	}
+	if err := session.Start(withLocale(cmd)); err != nil {
 		_ = stdin.Close()
 		_ = session.Close()
 		return nil, err
 	}
 
-	return &sshWriteCloser{
+	done := make(chan struct{})
+	go closeOnDone(ctx, done, session)
+
+	writer := &sshWriteCloser{
 		session: session,
 		stdin:   stdin,
 		stderr:  &stderr,
-	}, nil
+		done:    done,
+	}
+	if compress {
+		return newGzipWriter(writer), nil
+	}
+	return writer, nil
 }
 
 func (r *SSHRunner) Stat(ctx context.Context, filepath string) (os.FileInfo, error) {
 	stdout, stderr, err := r.Run(ctx, "stat", "-c", "%s %Y", "--", filepath)
 	if err != nil {
-		return nil, fmt.Errorf("stat failed: %w: %s", err, strings.TrimSpace(stderr))
+		return nil, NewCommandError("stat failed", err, stderr)
 	}
 
 	fields := strings.Fields(stdout)
@@ -229,12 +504,16 @@ func (r *SSHRunner) Stat(ctx context.Context, filepath string) (os.FileInfo, err
 func (r *SSHRunner) Remove(ctx context.Context, filepath string) error {
 	_, stderr, err := r.Run(ctx, "rm", "-f", "--", filepath)
 	if err != nil {
-		return fmt.Errorf("rm failed: %w: %s", err, strings.TrimSpace(stderr))
+		return NewCommandError("rm failed", err, stderr)
 	}
 	return nil
 }
 
 func (r *SSHRunner) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
 	if r.client != nil {
 		return r.client.Close()
 	}
@@ -259,6 +538,7 @@ type sshReadCloser struct {
 	session *ssh.Session
 	stdout  io.Reader
 	stderr  *bytes.Buffer
+	done    chan struct{}
 	closed  bool
 }
 
@@ -271,11 +551,12 @@ func (r *sshReadCloser) Close() error {
 		return nil
 	}
 	r.closed = true
+	defer close(r.done)
 
 	err := r.session.Wait()
 	_ = r.session.Close()
 	if err != nil {
-		return fmt.Errorf("remote read failed: %w: %s", err, strings.TrimSpace(r.stderr.String()))
+		return NewCommandError("remote read failed", err, r.stderr.String())
 	}
 	return nil
 }
@@ -284,6 +565,7 @@ type sshWriteCloser struct {
 	session *ssh.Session
 	stdin   io.WriteCloser
 	stderr  *bytes.Buffer
+	done    chan struct{}
 	closed  bool
 }
 
@@ -296,6 +578,7 @@ func (w *sshWriteCloser) Close() error {
 		return nil
 	}
 	w.closed = true
+	defer close(w.done)
 
 	errClose := w.stdin.Close()
 	errWait := w.session.Wait()
@@ -305,7 +588,7 @@ func (w *sshWriteCloser) Close() error {
 		return errClose
 	}
 	if errWait != nil {
-		return fmt.Errorf("remote write failed: %w: %s", errWait, strings.TrimSpace(w.stderr.String()))
+		return NewCommandError("remote write failed", errWait, w.stderr.String())
 	}
 	return nil
 }
@@ -317,7 +600,84 @@ func normalizeSSHAddr(host string) string {
 	if _, _, err := net.SplitHostPort(host); err == nil {
 		return host
 	}
-	return net.JoinHostPort(host, "22")
+	return net.JoinHostPort(strings.Trim(host, "[]"), "22")
+}
+
+// withLocale runs cmd under the C locale so the output parsed by the client
+// (vzdump archive paths, error messages) does not depend on the node's
+// language settings.
+func withLocale(cmd string) string {
+	return localeEnv + " " + cmd
+}
+
+// withEnv prefixes cmd with the locale and the configured env variables.
+// Assignments are passed to the remote shell rather than through SSH
+// setenv requests, which sshd usually rejects.
+func (r *SSHRunner) withEnv(cmd string) string {
+	if len(r.env) == 0 {
+		return withLocale(cmd)
+	}
+	assignments := make([]string, 0, len(r.env))
+	for _, entry := range r.env {
+		key, value, _ := strings.Cut(entry, "=")
+		assignments = append(assignments, key+"="+shellQuote(value))
+	}
+	return withLocale(strings.Join(assignments, " ") + " " + cmd)
+}
+
+// remoteCommand makes the remote shell report its PID on stderr before
+// exec'ing cmd. sshd starts every session in a new process group, so the
+// PID also identifies the group vzdump and its children run in.
+func (r *SSHRunner) remoteCommand(cmd string) string {
+	return "echo " + remotePIDMarker + "$$ >&2; exec env " + r.withEnv(cmd)
+}
+
+// pidCapture strips the PID line written by remoteCommand from stderr and
+// forwards everything else to dst.
+type pidCapture struct {
+	dst io.Writer
+
+	mu     sync.Mutex
+	pid    int
+	parsed bool
+	line   []byte
+}
+
+func (w *pidCapture) Write(p []byte) (int, error) {
+	n := len(p)
+	w.mu.Lock()
+	if !w.parsed {
+		idx := bytes.IndexByte(p, '\n')
+		if idx < 0 {
+			w.line = append(w.line, p...)
+			w.mu.Unlock()
+			return n, nil
+		}
+		line := append(w.line, p[:idx]...)
+		p = p[idx+1:]
+		w.parsed = true
+		w.line = nil
+		if value, ok := strings.CutPrefix(string(line), remotePIDMarker); ok {
+			w.pid, _ = strconv.Atoi(strings.TrimSpace(value))
+		} else {
+			p = append(append(line, '\n'), p...)
+		}
+	}
+	w.mu.Unlock()
+
+	if len(p) == 0 {
+		return n, nil
+	}
+	if _, err := w.dst.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (w *pidCapture) PID() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pid
 }
 
 func shellCommand(name string, args ...string) string {
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// otpCommandTimeout bounds conn_otp_command.
+const otpCommandTimeout = 30 * time.Second
+
+// Keyboard-interactive prompts answered with conn_password, and with the
+// output of conn_otp_command.
+var (
+	passwordPromptRegex = regexp.MustCompile(`(?i)pass(word|phrase)`)
+	otpPromptRegex      = regexp.MustCompile(`(?i)(verification|one[- ]time|otp|token|2fa|authenticator|passcode|\bcode\b)`)
+)
+
+// sshAuthMethods returns the SSH auth chain of conn_method. Hosts where PAM
+// or a second factor reject plain password auth are answered through
+// keyboard-interactive, and with conn_otp_command set a public key login
+// can be followed by a one-time code.
+func sshAuthMethods(cfg *Config) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	switch cfg.ConnMethod {
+	case ConnMethodPassword:
+		methods = append(methods, ssh.Password(cfg.ConnPassword))
+	case ConnMethodIdentity:
+		key, err := os.ReadFile(cfg.ConnIdentityFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity file: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse identity file: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	default:
+		return nil, fmt.Errorf("unsupported conn_method: %s", cfg.ConnMethod)
+	}
+	if cfg.ConnMethod == ConnMethodPassword || cfg.ConnOTPCommand != "" {
+		methods = append(methods, ssh.KeyboardInteractive(keyboardInteractive(cfg.ConnPassword, cfg.ConnOTPCommand)))
+	}
+	return methods, nil
+}
+
+// keyboardInteractive answers one-time code prompts ("Verification code",
+// "One-time password", ...) with the output of otpCommand and other
+// password prompts with password. otpCommand is run again for every
+// challenge so reconnections get a fresh code. Other prompts fail the
+// login rather than being answered blindly.
+func keyboardInteractive(password, otpCommand string) ssh.KeyboardInteractiveChallenge {
+	return func(_, _ string, questions []string, _ []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			switch {
+			case otpPromptRegex.MatchString(question):
+				if otpCommand == "" {
+					return nil, fmt.Errorf("one-time code prompt %q without conn_otp_command", strings.TrimSpace(question))
+				}
+				code, err := runOTPCommand(otpCommand)
+				if err != nil {
+					return nil, err
+				}
+				answers[i] = code
+			case passwordPromptRegex.MatchString(question):
+				if password == "" {
+					return nil, fmt.Errorf("password prompt %q without conn_password", strings.TrimSpace(question))
+				}
+				answers[i] = password
+			default:
+				return nil, fmt.Errorf("unexpected keyboard-interactive prompt %q", strings.TrimSpace(question))
+			}
+		}
+		return answers, nil
+	}
+}
+
+// runOTPCommand runs conn_otp_command with sh on the local host and
+// returns the first line it prints.
+func runOTPCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), otpCommandTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("conn_otp_command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	code, _, _ := strings.Cut(strings.TrimSpace(stdout.String()), "\n")
+	if code = strings.TrimSpace(code); code == "" {
+		return "", fmt.Errorf("conn_otp_command printed no code")
+	}
+	return code, nil
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+)
+
+// compressTransfer reports whether a transfer of filepath is gzipped on
+// the wire. Archives compressed by vzdump would only cost CPU on both
+// ends.
+func (r *SSHRunner) compressTransfer(filepath string) bool {
+	if !r.compress {
+		return false
+	}
+	name := strings.ToLower(filepath)
+	for _, suffix := range []string{".gz", ".zst", ".lzo"} {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// gzipOutput pipes the output of cmd through gzip. pipefail keeps the exit
+// status of cmd, so a failed dd or vzdump is not hidden by gzip.
+func gzipOutput(cmd string) string {
+	return "bash -o pipefail -c " + shellQuote(cmd+" | gzip -1 -c")
+}
+
+// gzipReader decompresses src. The gzip header is read on the first Read
+// rather than at creation, which would block until the remote command
+// produces output.
+type gzipReader struct {
+	src io.Reader
+	zr  *gzip.Reader
+}
+
+func (r *gzipReader) Read(p []byte) (int, error) {
+	if r.zr == nil {
+		zr, err := gzip.NewReader(r.src)
+		if err != nil {
+			return 0, err
+		}
+		r.zr = zr
+	}
+	return r.zr.Read(p)
+}
+
+func (r *gzipReader) Close() error {
+	if closer, ok := r.src.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type gzipWriter struct {
+	*gzip.Writer
+	dst io.WriteCloser
+}
+
+func newGzipWriter(dst io.WriteCloser) *gzipWriter {
+	zw, _ := gzip.NewWriterLevel(dst, gzip.BestSpeed)
+	return &gzipWriter{Writer: zw, dst: dst}
+}
+
+// Close flushes the gzip stream before closing dst, which waits for the
+// remote side to exit.
+func (w *gzipWriter) Close() error {
+	err := w.Writer.Close()
+	if closeErr := w.dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const PartsManifestSuffix = "_parts.conf"
+
+var partNameRegex = regexp.MustCompile(`^(.+)\.part(\d{4,})$`)
+
+// PartsManifest describes how an archive was split into part records. It is
+// stored as a sidecar next to the parts so the exporter can reassemble them.
+type PartsManifest struct {
+	Archive     string     `json:"archive"`
+	Size        int64      `json:"size"`
+	SegmentSize int64      `json:"segment_size"`
+	Parts       []PartInfo `json:"parts"`
+}
+
+type PartInfo struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+func PlanParts(archiveName string, size, segmentSize int64) PartsManifest {
+	manifest := PartsManifest{
+		Archive:     archiveName,
+		Size:        size,
+		SegmentSize: segmentSize,
+	}
+	for offset, index := int64(0), 1; offset < size; offset, index = offset+segmentSize, index+1 {
+		partSize := segmentSize
+		if offset+partSize > size {
+			partSize = size - offset
+		}
+		manifest.Parts = append(manifest.Parts, PartInfo{
+			Index:  index,
+			Name:   BuildPartFilename(archiveName, index),
+			Offset: offset,
+			Size:   partSize,
+		})
+	}
+	return manifest
+}
+
+func EncodePartsManifest(manifest PartsManifest) ([]byte, error) {
+	return json.MarshalIndent(manifest, "", "  ")
+}
+
+func DecodePartsManifest(data []byte) (PartsManifest, error) {
+	var manifest PartsManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return PartsManifest{}, fmt.Errorf("invalid parts manifest: %w: %w", ErrArchiveCorrupt, err)
+	}
+	if manifest.Archive == "" || len(manifest.Parts) == 0 {
+		return PartsManifest{}, fmt.Errorf("invalid parts manifest: missing archive or parts: %w", ErrArchiveCorrupt)
+	}
+
+	var total int64
+	for i, part := range manifest.Parts {
+		if part.Index != i+1 || part.Offset != total || part.Size <= 0 {
+			return PartsManifest{}, fmt.Errorf("invalid parts manifest for %s: part %d out of sequence: %w", manifest.Archive, i+1, ErrArchiveCorrupt)
+		}
+		total += part.Size
+	}
+	if total != manifest.Size {
+		return PartsManifest{}, fmt.Errorf("invalid parts manifest for %s: parts cover %d bytes, expected %d: %w", manifest.Archive, total, manifest.Size, ErrArchiveCorrupt)
+	}
+	return manifest, nil
+}
+
+func BuildPartFilename(archiveName string, index int) string {
+	return fmt.Sprintf("%s.part%04d", archiveName, index)
+}
+
+func ParsePartFilename(name string) (string, int, bool) {
+	matches := partNameRegex.FindStringSubmatch(filepath.Base(name))
+	if len(matches) != 3 {
+		return "", 0, false
+	}
+	index, err := strconv.Atoi(matches[2])
+	if err != nil || index <= 0 {
+		return "", 0, false
+	}
+	return matches[1], index, true
+}
+
+func BuildPartsManifestFilename(archiveName string) string {
+	return archiveName + PartsManifestSuffix
+}
+
+func IsPartsManifestFilename(name string) bool {
+	return strings.HasSuffix(strings.ToLower(filepath.Base(name)), PartsManifestSuffix)
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StallError reports a transfer that made no progress for Timeout.
+type StallError struct {
+	Op      string
+	Bytes   int64
+	Timeout time.Duration
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("%s stalled after %d bytes (no data for %s)", e.Op, e.Bytes, e.Timeout)
+}
+
+func (e *StallError) Unwrap() error {
+	return ErrStalled
+}
+
+// stallWatchdog calls abort when a Read or Write has been blocked for
+// longer than timeout. Time spent outside of Read/Write (a slow consumer)
+// does not count as a stall.
+type stallWatchdog struct {
+	op      string
+	timeout time.Duration
+	abort   func()
+
+	bytes   atomic.Int64
+	pending atomic.Int64
+	stalled atomic.Bool
+	stop    chan struct{}
+	once    sync.Once
+}
+
+func newStallWatchdog(op string, timeout time.Duration, abort func()) *stallWatchdog {
+	w := &stallWatchdog{
+		op:      op,
+		timeout: timeout,
+		abort:   abort,
+		stop:    make(chan struct{}),
+	}
+	go w.watch()
+	return w
+}
+
+func (w *stallWatchdog) watch() {
+	interval := max(w.timeout/4, time.Second)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			since := w.pending.Load()
+			if since == 0 || time.Since(time.Unix(0, since)) < w.timeout {
+				continue
+			}
+			w.stalled.Store(true)
+			w.abort()
+			return
+		}
+	}
+}
+
+func (w *stallWatchdog) begin() {
+	w.pending.Store(time.Now().UnixNano())
+}
+
+func (w *stallWatchdog) end(n int) {
+	w.pending.Store(0)
+	w.bytes.Add(int64(n))
+}
+
+// wrap replaces err with a StallError once the watchdog has fired.
+func (w *stallWatchdog) wrap(err error) error {
+	if err != nil && w.stalled.Load() {
+		return &StallError{Op: w.op, Bytes: w.bytes.Load(), Timeout: w.timeout}
+	}
+	return err
+}
+
+func (w *stallWatchdog) close() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+type stallReader struct {
+	reader   io.ReadCloser
+	watchdog *stallWatchdog
+}
+
+// NewStallReader wraps reader so that abort is called when a Read blocks
+// for longer than timeout; the failing Read then returns a StallError.
+// abort must unblock the pending Read, typically by cancelling the context
+// the reader was opened with. A zero timeout returns reader unchanged.
+func NewStallReader(reader io.ReadCloser, op string, timeout time.Duration, abort func()) io.ReadCloser {
+	if timeout <= 0 {
+		return reader
+	}
+	return &stallReader{reader: reader, watchdog: newStallWatchdog(op, timeout, abort)}
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	r.watchdog.begin()
+	n, err := r.reader.Read(p)
+	r.watchdog.end(n)
+	return n, r.watchdog.wrap(err)
+}
+
+func (r *stallReader) Close() error {
+	r.watchdog.close()
+	return r.watchdog.wrap(r.reader.Close())
+}
+
+type stallWriter struct {
+	writer   io.WriteCloser
+	watchdog *stallWatchdog
+}
+
+// NewStallWriter is the write-side counterpart of NewStallReader.
+func NewStallWriter(writer io.WriteCloser, op string, timeout time.Duration, abort func()) io.WriteCloser {
+	if timeout <= 0 {
+		return writer
+	}
+	return &stallWriter{writer: writer, watchdog: newStallWatchdog(op, timeout, abort)}
+}
+
+func (w *stallWriter) Write(p []byte) (int, error) {
+	w.watchdog.begin()
+	n, err := w.writer.Write(p)
+	w.watchdog.end(n)
+	return n, w.watchdog.wrap(err)
+}
+
+func (w *stallWriter) Close() error {
+	w.watchdog.close()
+	return w.watchdog.wrap(w.writer.Close())
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+)
+
+// StorageInfo is the part of a PVE storage definition the disk engines
+// need to locate volumes.
+type StorageInfo struct {
+	ID        string `json:"storage"`
+	Type      string `json:"type"`
+	Pool      string `json:"pool,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	VGName    string `json:"vgname,omitempty"`
+	ThinPool  string `json:"thinpool,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Content   string `json:"content,omitempty"`
+	Monhost   string `json:"monhost,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Shared    int    `json:"shared,omitempty"`
+
+	// Server, Port, Datastore and Fingerprint locate pbs storages.
+	Server      string `json:"server,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	Datastore   string `json:"datastore,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// sharedStorageTypes are the storage types visible from every node of a
+// cluster whatever their shared flag.
+var sharedStorageTypes = []string{"nfs", "cifs", "cephfs", "glusterfs"}
+
+// IsShared reports whether the storage is visible from every node.
+func (s StorageInfo) IsShared() bool {
+	return s.Shared == 1 || slices.Contains(sharedStorageTypes, s.Type)
+}
+
+// BackupDir returns the directory the storage keeps backups in, <path>/dump,
+// as PVE lays out file storages.
+func (s StorageInfo) BackupDir() (string, error) {
+	if s.Path == "" {
+		return "", fmt.Errorf("storage %s (%s) is not a file storage", s.ID, s.Type)
+	}
+	if !s.AllowsContent("backup") {
+		return "", fmt.Errorf("storage %s does not allow backup content", s.ID)
+	}
+	return path.Join(s.Path, "dump"), nil
+}
+
+// AllowsContent reports whether content (images, rootdir, ...) is one of
+// the content types of the storage.
+func (s StorageInfo) AllowsContent(content string) bool {
+	return slices.Contains(strings.Split(s.Content, ","), content)
+}
+
+// NodeStorage is the status of a storage on a node, as listed by
+// /nodes/<node>/storage.
+type NodeStorage struct {
+	ID      string `json:"storage"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	Active  int    `json:"active"`
+	Enabled int    `json:"enabled"`
+	Avail   int64  `json:"avail"`
+	Total   int64  `json:"total"`
+}
+
+// AllowsContent reports whether content (images, rootdir, ...) is one of
+// the content types of the storage.
+func (s NodeStorage) AllowsContent(content string) bool {
+	return slices.Contains(strings.Split(s.Content, ","), content)
+}
+
+// NodeStorages returns the storages available on node with their free
+// space. Unlike Storage, the result is not cached: free space changes as
+// guests are restored.
+func (c *Client) NodeStorages(ctx context.Context, node string) (map[string]NodeStorage, error) {
+	if c.cliControl() {
+		return c.cliNodeStorages(ctx)
+	}
+	stdout, err := c.runPvesh(ctx, "pvesh get node storage failed", "get", "/nodes/"+node+"/storage", "--output-format", "json")
+	if err != nil {
+		return nil, err
+	}
+	var list []NodeStorage
+	if err := json.Unmarshal([]byte(stdout), &list); err != nil {
+		return nil, fmt.Errorf("failed to parse node storage: %w", err)
+	}
+	storages := make(map[string]NodeStorage, len(list))
+	for _, storage := range list {
+		storages[storage.ID] = storage
+	}
+	return storages, nil
+}
+
+// DumpDirStorage returns the storage of node whose backup directory is
+// dump_dir, or "" when dump_dir belongs to no storage.
+func (c *Client) DumpDirStorage(ctx context.Context, node string) (string, error) {
+	storages, err := c.NodeStorages(ctx, node)
+	if err != nil {
+		return "", err
+	}
+	ids := slices.Sorted(maps.Keys(storages))
+	for _, id := range ids {
+		if !storages[id].AllowsContent("backup") {
+			continue
+		}
+		info, err := c.Storage(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		if dir, err := info.BackupDir(); err == nil && dir == path.Clean(c.cfg.DumpDir) {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+// Storage returns the definition of a storage, queried once per client.
+func (c *Client) Storage(ctx context.Context, id string) (StorageInfo, error) {
+	c.storageMu.Lock()
+	defer c.storageMu.Unlock()
+
+	if info, ok := c.storages[id]; ok {
+		return info, nil
+	}
+	var info StorageInfo
+	if c.cliControl() {
+		var err error
+		if info, err = c.cliStorage(ctx, id); err != nil {
+			return StorageInfo{}, err
+		}
+	} else {
+		stdout, err := c.runPvesh(ctx, "pvesh get storage failed", "get", "/storage/"+id, "--output-format", "json")
+		if err != nil {
+			return StorageInfo{}, err
+		}
+		if err := json.Unmarshal([]byte(stdout), &info); err != nil {
+			return StorageInfo{}, fmt.Errorf("invalid storage definition for %s: %w", id, err)
+		}
+	}
+	if info.ID == "" {
+		info.ID = id
+	}
+	if c.storages == nil {
+		c.storages = make(map[string]StorageInfo)
+	}
+	c.storages[id] = info
+	return info, nil
+}
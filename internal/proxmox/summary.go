@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	GuestStatusOK      = "ok"
+	GuestStatusSkipped = "skipped"
+	GuestStatusFailed  = "failed"
+)
+
+// RunSummary is the machine-readable outcome of a backup or restore run.
+type RunSummary struct {
+	mu sync.Mutex
+
+	Operation  string         `json:"operation"`
+	Origin     string         `json:"origin"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Duration   float64        `json:"duration_seconds"`
+	Status     string         `json:"status"`
+	Error      string         `json:"error,omitempty"`
+	ErrorClass string         `json:"error_class,omitempty"`
+	Bytes      int64          `json:"bytes"`
+	Guests     []GuestSummary `json:"guests"`
+	Skipped    []int          `json:"skipped"`
+	Failed     []int          `json:"failed"`
+
+	onAdd func(GuestSummary)
+}
+
+type GuestSummary struct {
+	VMID       int     `json:"vmid"`
+	Type       string  `json:"type,omitempty"`
+	Name       string  `json:"name,omitempty"`
+	Archive    string  `json:"archive,omitempty"`
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	ErrorClass string  `json:"error_class,omitempty"`
+	Bytes      int64   `json:"bytes"`
+	Checksum   string  `json:"checksum,omitempty"`
+	Duration   float64 `json:"duration_seconds"`
+
+	// ConfigDrift lists the config changes an overwrite restore rolled
+	// back, see diffGuestConfig in the exporter.
+	ConfigDrift []string `json:"config_drift,omitempty"`
+
+	// Restore report fields: where the guest was restored from and to,
+	// and the outcome of the checks run once it was.
+	SourceVMID   int      `json:"source_vmid,omitempty"`
+	Node         string   `json:"node,omitempty"`
+	Storages     []string `json:"storages,omitempty"`
+	Verification string   `json:"verification,omitempty"`
+}
+
+func NewRunSummary(operation, origin string) *RunSummary {
+	return &RunSummary{
+		Operation: operation,
+		Origin:    origin,
+		StartedAt: time.Now().UTC(),
+		Guests:    []GuestSummary{},
+		Skipped:   []int{},
+		Failed:    []int{},
+	}
+}
+
+// Add records the outcome of one guest. It is safe for concurrent use.
+func (s *RunSummary) Add(guest GuestSummary) {
+	s.mu.Lock()
+	s.Guests = append(s.Guests, guest)
+	s.Bytes += guest.Bytes
+	switch guest.Status {
+	case GuestStatusSkipped:
+		s.Skipped = append(s.Skipped, guest.VMID)
+	case GuestStatusFailed:
+		s.Failed = append(s.Failed, guest.VMID)
+	}
+	onAdd := s.onAdd
+	s.mu.Unlock()
+
+	if onAdd != nil {
+		onAdd(guest)
+	}
+}
+
+// Finish closes the summary; err is the error the run returned, if any.
+func (s *RunSummary) Finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.FinishedAt = time.Now().UTC()
+	s.Duration = s.FinishedAt.Sub(s.StartedAt).Seconds()
+	s.Status = GuestStatusOK
+	if err != nil {
+		s.Status = GuestStatusFailed
+		s.Error = err.Error()
+		s.ErrorClass = ErrorClass(err)
+	} else if len(s.Failed) > 0 {
+		s.Status = GuestStatusFailed
+	}
+	sort.Ints(s.Skipped)
+	sort.Ints(s.Failed)
+}
+
+func (s *RunSummary) Encode() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.MarshalIndent(s, "", "  ")
+}
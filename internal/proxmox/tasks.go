@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const runningBackupPollInterval = 15 * time.Second
+
+type nodeTask struct {
+	UPID      string `json:"upid"`
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	Node      string `json:"node"`
+	StartTime int64  `json:"starttime"`
+}
+
+// RunningBackup describes a vzdump job, not started by plakar, that is
+// currently backing up a guest.
+type RunningBackup struct {
+	UPID      string
+	StartedAt time.Time
+}
+
+// RunningBackup returns the vzdump job currently backing up vmid, if any. A
+// guest is considered busy when an active vzdump task targets it explicitly
+// or when it carries a "backup" lock (multi-guest jobs have an empty task id).
+func (c *Client) RunningBackup(ctx context.Context, vmid int) (*RunningBackup, error) {
+	res, err := c.vmResourceByID(ctx, vmid)
+	if err != nil {
+		return nil, err
+	}
+
+	node := res.Node
+	if node == "" {
+		node = c.cfg.Node
+	}
+	if node == "" {
+		return nil, fmt.Errorf("unable to determine node for vmid %d", vmid)
+	}
+
+	tasks, err := c.activeBackupTasks(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+
+	vmidStr := strconv.Itoa(vmid)
+	var multiGuest *nodeTask
+	for i := range tasks {
+		task := &tasks[i]
+		if task.Type != "vzdump" {
+			continue
+		}
+		if task.ID == vmidStr {
+			return &RunningBackup{UPID: task.UPID, StartedAt: time.Unix(task.StartTime, 0)}, nil
+		}
+		if task.ID == "" && multiGuest == nil {
+			multiGuest = task
+		}
+	}
+
+	if multiGuest != nil && strings.EqualFold(res.Lock, "backup") {
+		return &RunningBackup{UPID: multiGuest.UPID, StartedAt: time.Unix(multiGuest.StartTime, 0)}, nil
+	}
+	return nil, nil
+}
+
+// WaitRunningBackup polls until no foreign vzdump job targets vmid anymore,
+// or until timeout expires.
+func (c *Client) WaitRunningBackup(ctx context.Context, vmid int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		// Inventory is cached; the lock flag must be fresh on every poll.
+		c.invalidateResourceCache()
+
+		running, err := c.RunningBackup(ctx, vmid)
+		if err != nil {
+			return err
+		}
+		if running == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout while waiting for running backup %s of vmid %d", running.UPID, vmid)
+		}
+		c.logger.Info("waiting for running vzdump task", "vmid", vmid, "upid", running.UPID)
+		c.metrics.Add(MetricRetries, 1, "reason", "running_backup")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(runningBackupPollInterval):
+		}
+	}
+}
+
+// FindDumpSince returns the newest archive of vmid in DumpDir modified after
+// since, or an empty path when there is none.
+func (c *Client) FindDumpSince(ctx context.Context, vmid int, since time.Time) (string, error) {
+	latest, err := c.findLatestDump(ctx, vmid)
+	if err != nil || latest == "" {
+		return "", err
+	}
+
+	info, err := c.runner.Stat(ctx, latest)
+	if err != nil {
+		return "", err
+	}
+	if info.ModTime().Before(since) {
+		return "", nil
+	}
+	return path.Clean(latest), nil
+}
+
+// activeBackupTasks lists the vzdump tasks running on node.
+func (c *Client) activeBackupTasks(ctx context.Context, node string) ([]nodeTask, error) {
+	if c.cliControl() {
+		return c.cliActiveBackupTasks(ctx)
+	}
+	stdout, err := c.runPvesh(ctx, "pvesh get node tasks failed", "get", "/nodes/"+node+"/tasks", "--source", "active", "--typefilter", "vzdump", "--output-format", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []nodeTask
+	if err := json.Unmarshal([]byte(stdout), &tasks); err != nil {
+		return nil, fmt.Errorf("failed to parse node tasks: %w", err)
+	}
+	return tasks, nil
+}
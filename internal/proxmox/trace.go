@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Tracer records spans around pipeline phases and writes each finished span
+// as one JSON line, using OpenTelemetry field names so the file can be fed
+// to a collector. A nil Tracer is valid and records nothing.
+type Tracer struct {
+	mu     sync.Mutex
+	writer io.WriteCloser
+}
+
+type Span struct {
+	tracer *Tracer
+	once   sync.Once
+
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	Name         string         `json:"name"`
+	StartTime    int64          `json:"start_time_unix_nano"`
+	EndTime      int64          `json:"end_time_unix_nano"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Status       string         `json:"status"`
+	Error        string         `json:"error,omitempty"`
+}
+
+type spanContextKey struct{}
+
+// NewFileTracer appends spans to path.
+func NewFileTracer(path string) (*Tracer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open trace_file: %w", err)
+	}
+	return &Tracer{writer: file}, nil
+}
+
+// Start opens a span as a child of the span carried by ctx, if any.
+// Attributes are given as key/value pairs.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...any) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer:    t,
+		SpanID:    randomID(8),
+		Name:      name,
+		StartTime: time.Now().UnixNano(),
+	}
+	if parent, ok := ctx.Value(spanContextKey{}).(*Span); ok && parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+	} else {
+		span.TraceID = randomID(16)
+	}
+	if len(attrs) > 0 {
+		span.Attributes = make(map[string]any, len(attrs)/2)
+		for i := 0; i+1 < len(attrs); i += 2 {
+			span.Attributes[fmt.Sprint(attrs[i])] = attrs[i+1]
+		}
+	}
+
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.writer.Close()
+}
+
+func (t *Tracer) export(span *Span) {
+	data, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, _ = t.writer.Write(append(data, '\n'))
+}
+
+// End closes the span, recording err as its status. Only the first call
+// has an effect.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		s.EndTime = time.Now().UnixNano()
+		s.Status = "ok"
+		if err != nil {
+			s.Status = "error"
+			s.Error = err.Error()
+		}
+		s.tracer.export(s)
+	})
+}
+
+// SetAttribute adds an attribute to a span that has not ended yet.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]any)
+	}
+	s.Attributes[key] = value
+}
+
+func randomID(size int) string {
+	buf := make([]byte, size)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+	"github.com/gillesdubois/plakar-integration-proxmox/proxmoxtest"
+)
+
+// BenchmarkBackupVMStream measures a vzdump --stdout backup from the read
+// of its header, which names the archive after the compression it
+// detects, to the end of the stream.
+func BenchmarkBackupVMStream(b *testing.B) {
+	archive := make([]byte, 8<<20)
+	copy(archive, []byte{0x28, 0xb5, 0x2f, 0xfd})
+	node := proxmoxtest.NewNode("pve1", proxmoxtest.Guest{VMID: 100, Type: "qemu", Name: "web", Archive: archive})
+
+	cfg, err := proxmox.ParseConfig(map[string]string{"location": "proxmox://pve1", "mode": "local"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	client, err := proxmox.NewClientWithRunner(cfg, proxmox.NewLogger(io.Discard, 0), node)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ctx := context.Background()
+	b.SetBytes(int64(len(archive)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, reader, _, err := client.BackupVMStream(ctx, 100)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			b.Fatal(err)
+		}
+		if err := reader.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCompressionSuffix measures the normalization of a staged dump
+// name to the compression its header shows.
+func BenchmarkCompressionSuffix(b *testing.B) {
+	headers := [][]byte{
+		{0x28, 0xb5, 0x2f, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0x1f, 0x8b, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		[]byte("VMA\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"),
+	}
+	cfg := &proxmox.Config{}
+	now := time.Now()
+	for i := 0; i < b.N; i++ {
+		header := headers[i%len(headers)]
+		suffix := proxmox.DetectCompressionSuffix(header)
+		_ = proxmox.BuildRestoreDumpFilename(cfg, "vzdump-qemu-100-2026_01_01-00_00_00.vma"+suffix, proxmox.DumpName{Type: "qemu", VMID: 100}, now)
+	}
+}
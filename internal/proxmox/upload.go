@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// UploadContentImport is the content type of the storage upload API that
+// takes disk images. The API also takes iso and vztmpl content, but no
+// backup archives: vzdump dumps are always staged over SSH.
+const UploadContentImport = "import"
+
+// UploadImage uploads a disk image to the import directory of storage on
+// the local node with POST /nodes/<node>/storage/<storage>/upload, and
+// returns its path on the node. The SHA-256 of the data sent is passed
+// along, after the file, so pveproxy checks the received file before
+// moving it into place. Needs control=https.
+func (c *Client) UploadImage(ctx context.Context, storage, filename string, body io.Reader) (string, error) {
+	if c.cfg.Control != ControlHTTPS {
+		return "", fmt.Errorf("uploads through the storage API need control=https")
+	}
+	info, err := c.Storage(ctx, storage)
+	if err != nil {
+		return "", err
+	}
+	if info.Path == "" || !info.AllowsContent(UploadContentImport) {
+		return "", fmt.Errorf("storage %s is not a file storage with import content", storage)
+	}
+	node, err := c.LocalNode(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, span := c.tracer.Start(ctx, "upload_image", "storage", storage, "filename", filename)
+	err = newAPIRunner(nil, c.cfg).upload(ctx, node, storage, UploadContentImport, filename, body)
+	span.End(err)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(info.Path, UploadContentImport, filename), nil
+}
+
+// upload streams body as the file of a storage upload request and waits
+// for the task moving it into place. Uploads are not bounded by
+// apiTimeout.
+func (r *apiRunner) upload(ctx context.Context, node, storage, content, filename string, body io.Reader) error {
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeUploadForm(form, content, filename, body))
+	}()
+
+	endpoint := r.base + "/nodes/" + node + "/storage/" + storage + "/upload"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, reader)
+	if err != nil {
+		_ = reader.CloseWithError(err)
+		return err
+	}
+	req.Header.Set("Authorization", "PVEAPIToken="+r.token)
+	req.Header.Set("User-Agent", "plakar-integration-proxmox")
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	client := &http.Client{Transport: r.client.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		_ = reader.CloseWithError(err)
+		return fmt.Errorf("upload of %s failed: %w", filename, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("upload of %s failed: %w", filename, err)
+	}
+
+	var response struct {
+		Data   string            `json:"data"`
+		Errors map[string]string `json:"errors"`
+	}
+	_ = json.Unmarshal(data, &response)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message := resp.Status
+		for key, value := range response.Errors {
+			message += fmt.Sprintf(", %s: %s", key, strings.TrimSpace(value))
+		}
+		return fmt.Errorf("upload of %s failed: %s", filename, message)
+	}
+	if !strings.HasPrefix(response.Data, "UPID:") {
+		return nil
+	}
+	if stderr, err := r.waitTask(ctx, response.Data); err != nil {
+		return NewCommandError("upload of "+filename+" failed", err, stderr)
+	}
+	return nil
+}
+
+// writeUploadForm writes the fields of a storage upload request. pveproxy
+// reads the whole form before running the upload, so the checksum of the
+// file can follow it.
+func writeUploadForm(form *multipart.Writer, content, filename string, body io.Reader) error {
+	if err := form.WriteField("content", content); err != nil {
+		return err
+	}
+	part, err := form.CreateFormFile("filename", filename)
+	if err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(part, io.TeeReader(body, hasher)); err != nil {
+		return err
+	}
+	if err := form.WriteField("checksum-algorithm", "sha256"); err != nil {
+		return err
+	}
+	if err := form.WriteField("checksum", hex.EncodeToString(hasher.Sum(nil))); err != nil {
+		return err
+	}
+	return form.Close()
+}
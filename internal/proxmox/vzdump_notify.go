@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+)
+
+// Values of backup_notification.
+const (
+	BackupNotificationDefault = "default"
+	BackupNotificationNever   = "never"
+)
+
+// vzdumpNotificationOptions are the vzdump options set by
+// backup_notification=never, which backup_extra_args may not set then.
+var vzdumpNotificationOptions = []string{"--quiet", "--mailnotification", "--notification-mode", "--notification-policy"}
+
+// vzdumpNotificationArgs returns the vzdump options keeping the jobs of the
+// plugin out of the node alerting with backup_notification=never. The never
+// policy came with pve-manager 8.1 and covers a mailto of vzdump.conf; the
+// legacy-sendmail mode, which only mails the job mailto the plugin does not
+// set, came with 8.2. Before 8.1, mails can only be restricted to failures.
+// When the version cannot be read, the node is assumed to be recent.
+func (c *Client) vzdumpNotificationArgs(ctx context.Context) []string {
+	if c.cfg.BackupNotification != BackupNotificationNever {
+		return nil
+	}
+	args := []string{"--quiet", "1"}
+	versions, err := c.HypervisorVersions(ctx)
+	if err != nil || versions.PVEManager == "" {
+		return append(args, "--notification-mode", "legacy-sendmail", "--notification-policy", "never")
+	}
+	switch {
+	case CompareVersions(versions.PVEManager, "8.1") < 0:
+		c.legacyNotifyOnce.Do(func() {
+			c.logger.Warn("pve-manager predates 8.1, vzdump still mails failures with backup_notification=never", "pve_manager", versions.PVEManager)
+		})
+		return append(args, "--mailnotification", "failure")
+	case CompareVersions(versions.PVEManager, "8.2") < 0:
+		return append(args, "--notification-policy", "never")
+	}
+	return append(args, "--notification-mode", "legacy-sendmail", "--notification-policy", "never")
+}
+
+// conflictingNotificationArg returns the first backup_extra_args option
+// that backup_notification=never sets itself, if any.
+func conflictingNotificationArg(extraArgs []string) string {
+	for _, option := range vzdumpNotificationOptions {
+		if hasExtraArg(extraArgs, option) {
+			return option
+		}
+	}
+	return ""
+}
@@ -0,0 +1,33 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"context"
+	"fmt"
+)
+
+// CheckBackupPaths verifies that backup_tmpdir is a directory of the node,
+// so a typo fails the run instead of every vzdump job.
+func (c *Client) CheckBackupPaths(ctx context.Context) error {
+	if c.cfg.BackupTmpDir != "" {
+		if _, _, err := c.runner.Run(ctx, "test", "-d", c.cfg.BackupTmpDir); err != nil {
+			return fmt.Errorf("backup_tmpdir %s is not a directory of the node", c.cfg.BackupTmpDir)
+		}
+	}
+	return nil
+}
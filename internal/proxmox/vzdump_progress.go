@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The vzdump lines of a QEMU backup naming its disks, then reporting how
+// far the whole backup is:
+//
+//	INFO: include disk 'scsi0' 'local-lvm:vm-100-disk-0' 32G
+//	INFO:  12% (3.9 GiB of 32.0 GiB) in 30s, read: 132.0 MiB/s, write: 128.0 MiB/s
+var (
+	vzdumpDiskRegex     = regexp.MustCompile(`include disk '([^']+)' '([^']+)' ([0-9.]+[KMGT]?)`)
+	vzdumpProgressRegex = regexp.MustCompile(`^INFO:\s+(\d+)% \(([0-9.]+ [KMGT]?i?B) of ([0-9.]+ [KMGT]?i?B)\)`)
+)
+
+// vzdumpProgressStep is the step of the overall percentage between two
+// progress logs of the same disk.
+const vzdumpProgressStep = 10
+
+type vzdumpDisk struct {
+	key    string
+	volume string
+	size   int64
+}
+
+// vzdumpProgress follows the stderr of a streamed vzdump and reports which
+// disk is being read. vzdump only reports the bytes read over all disks,
+// which are read one after the other in the order they are included, so
+// the current disk is the one those bytes fall into.
+type vzdumpProgress struct {
+	c    *Client
+	vmid int
+
+	disks   []vzdumpDisk
+	current int
+	logged  int
+}
+
+func newVzdumpProgress(c *Client, vmid int) *vzdumpProgress {
+	return &vzdumpProgress{c: c, vmid: vmid, current: -1, logged: -1}
+}
+
+// copy copies the vzdump stderr to w, following the progress lines.
+func (p *vzdumpProgress) copy(w io.Writer, stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		_, _ = io.WriteString(w, line+"\n")
+		p.line(line)
+	}
+	// Keep draining so vzdump never blocks on a full pipe.
+	_, _ = io.Copy(w, stderr)
+}
+
+func (p *vzdumpProgress) line(line string) {
+	if m := vzdumpDiskRegex.FindStringSubmatch(line); m != nil {
+		size, _ := ParseDiskSize(m[3])
+		p.disks = append(p.disks, vzdumpDisk{key: m[1], volume: m[2], size: size})
+		return
+	}
+	m := vzdumpProgressRegex.FindStringSubmatch(strings.TrimRight(line, "\r"))
+	if m == nil {
+		return
+	}
+	percent, err := strconv.Atoi(m[1])
+	if err != nil {
+		return
+	}
+	done, _ := ParseDiskSize(vzdumpAmount(m[2]))
+
+	disk, diskPercent := p.locate(done)
+	if disk == p.current && percent/vzdumpProgressStep == p.logged/vzdumpProgressStep && percent < 100 {
+		return
+	}
+	p.current, p.logged = disk, percent
+
+	vmidLabel := strconv.Itoa(p.vmid)
+	if disk < 0 {
+		p.c.logger.Info("vzdump progress", "vmid", p.vmid, "percent", percent)
+		return
+	}
+	d := p.disks[disk]
+	p.c.logger.Info("vzdump progress", "vmid", p.vmid, "disk", d.key, "volume", d.volume, "disk_percent", diskPercent, "percent", percent)
+	p.c.metrics.Set(MetricDiskProgress, float64(diskPercent)/100, "operation", "backup", "vmid", vmidLabel, "disk", d.key)
+}
+
+// locate returns the index of the disk done bytes into the backup fall
+// into and how far into that disk they are, or -1 when the disks are not
+// known. Disk sizes are rounded by vzdump, so the last disk absorbs the
+// difference.
+func (p *vzdumpProgress) locate(done int64) (int, int) {
+	if len(p.disks) == 0 {
+		return -1, 0
+	}
+	var start int64
+	for i, disk := range p.disks {
+		if done < start+disk.size || i == len(p.disks)-1 {
+			if disk.size <= 0 {
+				return i, 0
+			}
+			percent := int((done - start) * 100 / disk.size)
+			return i, min(max(percent, 0), 100)
+		}
+		start += disk.size
+	}
+	return -1, 0
+}
+
+// vzdumpAmount turns an amount of a progress line, "3.9 GiB", into the
+// form of ParseDiskSize, "3.9G".
+func vzdumpAmount(amount string) string {
+	amount = strings.ReplaceAll(amount, " ", "")
+	amount = strings.TrimSuffix(amount, "B")
+	return strings.TrimSuffix(amount, "i")
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	vzdumpFinishedRegex = regexp.MustCompile(`Finished Backup of VM (\d+)`)
+	vzdumpFailedRegex   = regexp.MustCompile(`Backup of VM (\d+) failed - (.*)$`)
+)
+
+var errJobGuestFailed = errors.New("backup failed in vzdump job")
+
+// JobArchive is a guest done by a vzdump job: its archive, or the error
+// vzdump reported for it.
+type JobArchive struct {
+	VMID    int
+	Archive string
+	Err     error
+}
+
+// VzdumpJob is a running vzdump --all job. Archives are reported as soon
+// as vzdump finishes each guest, while it goes on with the next ones.
+type VzdumpJob struct {
+	archives chan JobArchive
+	stream   *CommandStream
+	done     chan struct{}
+	err      error
+
+	// guests are the guests the job backs up; those it did not report
+	// when stopped are checked for a leftover backup lock.
+	guests  []int
+	aborted atomic.Bool
+}
+
+// Archives returns the guests done by the job, in job order. It is closed
+// when the job ends.
+func (j *VzdumpJob) Archives() <-chan JobArchive {
+	return j.archives
+}
+
+// Wait waits for the end of the job and returns its error.
+func (j *VzdumpJob) Wait() error {
+	<-j.done
+	return j.err
+}
+
+// Abort stops the job. Archives must still be drained.
+func (j *VzdumpJob) Abort() error {
+	j.aborted.Store(true)
+	return j.stream.Abort()
+}
+
+// BackupAll starts a single vzdump --all job on node, leaving out the
+// exclude guests, like a scheduled PVE backup job; guests lists the ones
+// it backs up. The job of the node commands run on (node "") runs vzdump,
+// the job of another node runs it there through pvesh, whose output
+// follows the task log. Archives are written to dump_dir on the node.
+func (c *Client) BackupAll(ctx context.Context, node string, guests, exclude []int) (*VzdumpJob, error) {
+	var name string
+	var args []string
+	if node == "" {
+		name = "vzdump"
+		args = []string{"--all"}
+	} else {
+		name = "pvesh"
+		args = []string{"create", "/nodes/" + node + "/vzdump", "--all", "1"}
+	}
+	args = append(args, "--dumpdir", c.cfg.DumpDir, "--mode", c.cfg.BackupMode, "--compress", c.vzdumpCompression())
+	if len(exclude) > 0 {
+		ids := make([]string, len(exclude))
+		for i, vmid := range exclude {
+			ids[i] = strconv.Itoa(vmid)
+		}
+		args = append(args, "--exclude", strings.Join(ids, ","))
+	}
+	if node == "" {
+		args = c.appendVzdumpArgs(ctx, args)
+	} else {
+		// The node is part of the endpoint.
+		args = c.appendVzdumpOptions(ctx, args)
+	}
+
+	ctx, span := c.tracer.Start(ctx, "vzdump_all", "node", node, "guests", len(guests), "excluded", len(exclude), "mode", c.cfg.BackupMode)
+	stream, err := c.runner.Stream(WithLiveOutput(ctx), name, args...)
+	if err != nil {
+		span.End(err)
+		return nil, fmt.Errorf("vzdump failed to start: %w", err)
+	}
+
+	job := &VzdumpJob{
+		archives: make(chan JobArchive, len(guests)+1),
+		stream:   stream,
+		done:     make(chan struct{}),
+		guests:   guests,
+	}
+	go func() {
+		var output strings.Builder
+		reported := c.followVzdumpJob(ctx, job, stream, &output)
+		if err := stream.Finish(); err != nil {
+			job.err = NewCommandError("vzdump failed", err, output.String())
+		}
+		if job.aborted.Load() || ctx.Err() != nil {
+			for _, vmid := range guests {
+				if !reported[vmid] {
+					c.confirmGuestUnlocked(vmid)
+				}
+			}
+		}
+		span.End(job.err)
+		close(job.archives)
+		close(job.done)
+	}()
+	return job, nil
+}
+
+// followVzdumpJob parses the log of a vzdump job from both of its
+// outputs, reporting each guest once vzdump is done with it, and returns
+// the guests reported. The error lines are kept in output.
+func (c *Client) followVzdumpJob(ctx context.Context, job *VzdumpJob, stream *CommandStream, output *strings.Builder) map[int]bool {
+	lines := make(chan string)
+	var wg sync.WaitGroup
+	for _, r := range []io.Reader{stream.Stdout, stream.Stderr} {
+		if r == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(r io.Reader) {
+			defer wg.Done()
+			scanner := bufio.NewScanner(r)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+		}(r)
+	}
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+
+	current := make(map[int]string)
+	reported := make(map[int]bool)
+	for line := range lines {
+		if archive := parseArchivePath(line); archive != "" {
+			if _, vmid, err := ParseDumpFilename(archive); err == nil {
+				current[vmid] = archive
+			}
+			continue
+		}
+		if matches := vzdumpFailedRegex.FindStringSubmatch(line); matches != nil {
+			vmid, _ := strconv.Atoi(matches[1])
+			output.WriteString(line + "\n")
+			reported[vmid] = true
+			job.archives <- JobArchive{VMID: vmid, Err: NewCommandError(fmt.Sprintf("vzdump of vmid %d failed", vmid), errJobGuestFailed, matches[2])}
+			continue
+		}
+		if matches := vzdumpFinishedRegex.FindStringSubmatch(line); matches != nil {
+			vmid, _ := strconv.Atoi(matches[1])
+			reported[vmid] = true
+			job.archives <- c.jobArchive(ctx, vmid, current[vmid])
+		}
+	}
+	return reported
+}
+
+func (c *Client) jobArchive(ctx context.Context, vmid int, archive string) JobArchive {
+	if archive == "" {
+		// The archive line may come on the other output.
+		var err error
+		if archive, err = c.findLatestDump(ctx, vmid); err != nil {
+			return JobArchive{VMID: vmid, Err: err}
+		}
+		if archive == "" {
+			return JobArchive{VMID: vmid, Err: fmt.Errorf("unable to determine vzdump output file")}
+		}
+	}
+	if c.cfg.BackupCompression == CompressionZstdRsyncable {
+		compressed, err := c.compressRsyncable(ctx, archive)
+		if err != nil {
+			return JobArchive{VMID: vmid, Err: err}
+		}
+		archive = compressed
+	}
+	return JobArchive{VMID: vmid, Archive: archive}
+}
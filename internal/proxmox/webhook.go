@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Events posted to webhook_url.
+const (
+	EventRunStarted     = "run.started"
+	EventRunFinished    = "run.finished"
+	EventGuestSucceeded = "guest.succeeded"
+	EventGuestSkipped   = "guest.skipped"
+	EventGuestFailed    = "guest.failed"
+)
+
+const (
+	webhookTimeout = 10 * time.Second
+	webhookQueue   = 256
+	// webhookDrain bounds how long the end of a run waits for queued
+	// events to be delivered.
+	webhookDrain = 30 * time.Second
+)
+
+// WebhookEvent is the JSON body posted for every event.
+type WebhookEvent struct {
+	Event     string        `json:"event"`
+	Time      time.Time     `json:"time"`
+	Operation string        `json:"operation"`
+	Origin    string        `json:"origin"`
+	Guest     *GuestSummary `json:"guest,omitempty"`
+	Summary   *RunSummary   `json:"summary,omitempty"`
+}
+
+// webhook posts events in order from a single goroutine, so a slow
+// endpoint delays notifications but never the run itself.
+type webhook struct {
+	url    string
+	secret string
+	client *http.Client
+	logger *slog.Logger
+	queue  chan WebhookEvent
+	done   chan struct{}
+}
+
+func startWebhook(url, secret string, logger *slog.Logger) *webhook {
+	w := &webhook{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: webhookTimeout},
+		logger: logger,
+		queue:  make(chan WebhookEvent, webhookQueue),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *webhook) run() {
+	defer close(w.done)
+	for event := range w.queue {
+		if err := w.post(event); err != nil {
+			w.logger.Warn("webhook delivery failed", "event", event.Event, "error", err)
+		}
+	}
+}
+
+// send queues event, dropping it when the queue is full.
+func (w *webhook) send(event WebhookEvent) {
+	select {
+	case w.queue <- event:
+	default:
+		w.logger.Warn("webhook queue full, event dropped", "event", event.Event)
+	}
+}
+
+// close delivers the queued events, waiting at most webhookDrain.
+func (w *webhook) close() {
+	close(w.queue)
+	select {
+	case <-w.done:
+	case <-time.After(webhookDrain):
+		w.logger.Warn("webhook events still pending, giving up")
+	}
+}
+
+func (w *webhook) post(event WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "plakar-integration-proxmox")
+	req.Header.Set("X-Plakar-Event", event.Event)
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		req.Header.Set("X-Plakar-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// StartRun starts a run: guest configs cached by an earlier run are
+// dropped, and the start of the run is reported to webhook_url, along with
+// the outcome of each guest as it is added to summary.
+func (c *Client) StartRun(summary *RunSummary) {
+	c.guestConfigs.reset()
+	if c.cfg.WebhookURL == "" {
+		return
+	}
+	c.webhook = startWebhook(c.cfg.WebhookURL, c.cfg.WebhookSecret, c.logger)
+	hook := c.webhook
+	event := func(name string) WebhookEvent {
+		return WebhookEvent{Event: name, Time: time.Now().UTC(), Operation: summary.Operation, Origin: summary.Origin}
+	}
+
+	hook.send(event(EventRunStarted))
+	summary.onAdd = func(guest GuestSummary) {
+		e := event(EventGuestSucceeded)
+		switch guest.Status {
+		case GuestStatusSkipped:
+			e.Event = EventGuestSkipped
+		case GuestStatusFailed:
+			e.Event = EventGuestFailed
+		}
+		e.Guest = &guest
+		hook.send(e)
+	}
+}
+
+// FinishRun reports a finished run, whose summary must be finished, to
+// webhook_url and the PVE notification system.
+func (c *Client) FinishRun(summary *RunSummary) {
+	if hook := c.webhook; hook != nil {
+		c.webhook = nil
+		summary.mu.Lock()
+		summary.onAdd = nil
+		summary.mu.Unlock()
+		hook.send(WebhookEvent{Event: EventRunFinished, Time: time.Now().UTC(), Operation: summary.Operation, Origin: summary.Origin, Summary: summary})
+		hook.close()
+	}
+	c.notifyRun(summary)
+}
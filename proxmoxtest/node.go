@@ -0,0 +1,984 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package proxmoxtest provides an in-memory Proxmox node implementing the
+// runner seam, for integration tests of the importer and exporter that need
+// more than static fixtures: vzdump writes archives the importer then reads,
+// restores create guests, and failures can be injected mid-stream.
+package proxmoxtest
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/runner"
+)
+
+// ErrStreamFailed is returned by reads cut short by Node.StreamFailAfter.
+var ErrStreamFailed = errors.New("proxmoxtest: stream failed")
+
+// Guest is a VM or container hosted by the node.
+type Guest struct {
+	VMID    int
+	Type    string // qemu or lxc
+	Name    string
+	Pool    string
+	Lock    string
+	Tags    string // semicolon separated, as in /cluster/resources
+	Running bool
+	MaxDisk int64
+
+	// Config is served as /etc/pve/qemu-server/<vmid>.conf or
+	// /etc/pve/lxc/<vmid>.conf.
+	Config string
+
+	// Archive is the content vzdump produces for the guest.
+	Archive []byte
+
+	// Description is the guest notes, read and set through pvesh.
+	Description string
+}
+
+// Restore records a qmrestore or pct restore invocation.
+type Restore struct {
+	Type    string
+	VMID    int
+	Archive string
+	Data    []byte
+	Args    []string
+}
+
+// Node is a fake Proxmox node. It answers the pvesh, vzdump, qm, pct and
+// qmrestore invocations issued by the integration and keeps files in
+// memory. The zero value is not usable, use NewNode.
+type Node struct {
+	Name    string
+	Cluster string
+	DumpDir string
+
+	// StreamFailAfter makes vzdump streams and file reads fail with
+	// ErrStreamFailed after that many bytes. Zero disables it.
+	StreamFailAfter int64
+
+	// Failures maps a command name (vzdump, qmrestore, pct, ...) to the
+	// stderr it prints before exiting with status 1.
+	Failures map[string]string
+
+	// Versions is the output of pveversion -v. Empty means
+	// DefaultVersions.
+	Versions string
+
+	// Arch is the uname -m of the node. Empty means x86_64.
+	Arch string
+
+	// Machines and CPUModels are served as the QEMU capabilities of the
+	// node. Nil means DefaultMachines and DefaultCPUModels.
+	Machines  []string
+	CPUModels []string
+
+	// Storages are served as /storage/<id> and /nodes/<node>/storage.
+	// NewNode adds local (dir), local-zfs (zfspool), local-lvm (lvmthin)
+	// and ceph (rbd).
+	Storages map[string]Storage
+
+	// Load is the sequence of one minute load averages served by
+	// successive /nodes/<node>/status calls, the last one repeating.
+	// IOWait is served as its IO wait, in percent.
+	Load   []float64
+	IOWait float64
+
+	// Timezone is served as the time zone of /nodes/<node>/time. Empty
+	// means UTC.
+	Timezone string
+
+	// Privileges maps ACL paths to the privileges /access/permissions
+	// reports on them. Nil grants every privilege, as for root@pam.
+	Privileges map[string][]string
+
+	mu        sync.Mutex
+	guests    map[int]*Guest
+	files     map[string]memFile
+	dirs      map[string]time.Time
+	restores  []Restore
+	calls     [][]string
+	volumes   map[string][]byte
+	snapshots []volumeSnapshot
+	loadReads int
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+var _ runner.Runner = (*Node)(nil)
+
+// DefaultVersions is the pveversion -v output of a Node.
+const DefaultVersions = `proxmox-ve: 8.2.0 (running kernel: 6.8.4-2-pve)
+pve-manager: 8.2.4 (running version: 8.2.4/faa83925c9641325)
+proxmox-kernel-6.8.4-2-pve-signed: 6.8.4-2
+lxc-pve: 6.0.0-1
+pve-qemu-kvm: 8.1.5-6
+qemu-server: 8.2.1
+`
+
+// DefaultMachines and DefaultCPUModels are the QEMU capabilities of a Node.
+var (
+	DefaultMachines  = []string{"pc-i440fx-7.2", "pc-i440fx-8.0", "pc-i440fx-8.1", "pc-q35-7.2", "pc-q35-8.0", "pc-q35-8.1"}
+	DefaultCPUModels = []string{"host", "kvm64", "qemu64", "x86-64-v2", "x86-64-v2-AES", "x86-64-v3", "x86-64-v4", "Broadwell", "Cascadelake-Server", "EPYC", "EPYC-Rome", "EPYC-Milan", "Haswell", "Icelake-Server", "Skylake-Server"}
+)
+
+func NewNode(name string, guests ...Guest) *Node {
+	n := &Node{
+		Name:     name,
+		DumpDir:  "/var/lib/vz/dump",
+		Failures: make(map[string]string),
+		guests:   make(map[int]*Guest),
+		files:    make(map[string]memFile),
+		dirs:     make(map[string]time.Time),
+		Storages: map[string]Storage{
+			"local":     {Type: "dir", Path: "/var/lib/vz", Content: "backup,iso,vztmpl", Avail: 64 << 30, Total: 100 << 30},
+			"local-zfs": {Type: "zfspool", Pool: "rpool/data", Avail: 800 << 30, Total: 1 << 40},
+			"local-lvm": {Type: "lvmthin", VGName: "pve", ThinPool: "data", Avail: 800 << 30, Total: 1 << 40},
+			"ceph":      {Type: "rbd", Pool: "rbd", Avail: 8 << 40, Total: 16 << 40},
+		},
+		volumes: make(map[string][]byte),
+	}
+	for _, guest := range guests {
+		n.AddGuest(guest)
+	}
+	return n
+}
+
+// AddGuest adds or replaces a guest and its configuration file.
+func (n *Node) AddGuest(guest Guest) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	g := guest
+	n.guests[g.VMID] = &g
+	if g.Config != "" {
+		n.files[configPath(g.Type, g.VMID)] = memFile{data: []byte(g.Config), modTime: time.Now()}
+	}
+}
+
+// Guest returns the current state of a guest.
+func (n *Node) Guest(vmid int) (Guest, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	g, ok := n.guests[vmid]
+	if !ok {
+		return Guest{}, false
+	}
+	return *g, true
+}
+
+// Restores returns the restores performed so far, in order.
+func (n *Node) Restores() []Restore {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Restore(nil), n.restores...)
+}
+
+// Calls returns every command run on the node, in order.
+func (n *Node) Calls() [][]string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([][]string(nil), n.calls...)
+}
+
+// Files returns the paths of the files stored on the node, sorted.
+func (n *Node) Files() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	paths := make([]string, 0, len(n.files))
+	for p := range n.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (n *Node) WriteFile(name string, data []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.files[path.Clean(name)] = memFile{data: append([]byte(nil), data...), modTime: time.Now()}
+}
+
+func (n *Node) ReadFile(name string) ([]byte, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, ok := n.files[path.Clean(name)]
+	return f.data, ok
+}
+
+func (n *Node) Run(ctx context.Context, name string, args ...string) (string, string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.calls = append(n.calls, append([]string{name}, args...))
+	if stderr, ok := n.Failures[name]; ok {
+		return "", stderr, exitError(1)
+	}
+
+	switch name {
+	case "pvesh":
+		return n.pvesh(args)
+	case "vzdump":
+		return n.vzdump(args)
+	case "qm", "pct":
+		return n.guestCommand(name, args)
+	case "qmrestore":
+		if len(args) < 2 {
+			return "", "usage: qmrestore <archive> <vmid>", exitError(255)
+		}
+		return n.restore("qemu", args[0], args[1], args[2:])
+	case "ls":
+		return n.list(args[len(args)-1])
+	case "mkdir":
+		dir := path.Clean(args[len(args)-1])
+		if _, ok := n.dirs[dir]; ok {
+			return "", fmt.Sprintf("mkdir: cannot create directory '%s': File exists", dir), exitError(1)
+		}
+		n.dirs[dir] = time.Now()
+		return "", "", nil
+	case "rm":
+		n.remove(path.Clean(args[len(args)-1]))
+		return "", "", nil
+	case "mv":
+		from, to := path.Clean(args[len(args)-2]), path.Clean(args[len(args)-1])
+		f, ok := n.files[from]
+		if !ok {
+			return "", fmt.Sprintf("mv: cannot stat '%s': No such file or directory", from), exitError(1)
+		}
+		delete(n.files, from)
+		n.files[to] = f
+		return "", "", nil
+	case "sha256sum":
+		target := path.Clean(args[len(args)-1])
+		f, ok := n.files[target]
+		if !ok {
+			return "", fmt.Sprintf("sha256sum: %s: No such file or directory", target), exitError(1)
+		}
+		sum := sha256.Sum256(f.data)
+		return hex.EncodeToString(sum[:]) + "  " + target + "\n", "", nil
+	case "dd":
+		return n.dd(args)
+	case "lvcreate", "lvchange", "lvremove", "lvs":
+		return n.lvm(name, args)
+	case "pvesm":
+		return n.pvesm(args)
+	case "qemu-img":
+		return n.qemuImg(args)
+	case "udevadm", "test":
+		return "", "", nil
+	case "rbd":
+		return n.rbd(args)
+	case "sh":
+		return n.shell(args)
+	case "zfs":
+		return n.zfs(args)
+	case "zstd":
+		return n.zstd(args)
+	case "tar":
+		return n.tar(args)
+	case "pveversion":
+		if n.Versions != "" {
+			return n.Versions, "", nil
+		}
+		return DefaultVersions, "", nil
+	case "uname":
+		if n.Arch != "" {
+			return n.Arch + "\n", "", nil
+		}
+		return "x86_64\n", "", nil
+	}
+	return "", name + ": command not found", exitError(127)
+}
+
+func (n *Node) Stream(ctx context.Context, name string, args ...string) (*runner.CommandStream, error) {
+	stdout, stderr, err := n.Run(ctx, name, args...)
+	if err != nil {
+		return runner.NewCommandStream(strings.NewReader(stdout), strings.NewReader(stderr), func() error { return err }, nil), nil
+	}
+	if name != "vzdump" {
+		return runner.NewCommandStream(strings.NewReader(stdout), strings.NewReader(stderr), func() error { return nil }, nil), nil
+	}
+
+	reader := n.failingReader([]byte(stdout))
+	return runner.NewCommandStream(reader, strings.NewReader(stderr), func() error {
+		if reader.failed {
+			return exitError(1)
+		}
+		return nil
+	}, nil), nil
+}
+
+func (n *Node) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, ok := n.files[path.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return io.NopCloser(n.failingReader(f.data)), nil
+}
+
+func (n *Node) OpenRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, ok := n.files[path.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	end := min(offset+length, int64(len(f.data)))
+	offset = min(offset, end)
+	return io.NopCloser(n.failingReader(f.data[offset:end])), nil
+}
+
+func (n *Node) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return &memWriter{node: n, path: path.Clean(name)}, nil
+}
+
+func (n *Node) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	name = path.Clean(name)
+	if f, ok := n.files[name]; ok {
+		return &fileInfo{name: path.Base(name), size: int64(len(f.data)), modTime: f.modTime}, nil
+	}
+	if modTime, ok := n.dirs[name]; ok {
+		return &fileInfo{name: path.Base(name), modTime: modTime, dir: true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (n *Node) Remove(ctx context.Context, name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.remove(path.Clean(name))
+	return nil
+}
+
+func (n *Node) Close() error {
+	return nil
+}
+
+type resource struct {
+	VMID    int    `json:"vmid"`
+	Type    string `json:"type"`
+	Node    string `json:"node"`
+	Name    string `json:"name,omitempty"`
+	Pool    string `json:"pool,omitempty"`
+	Lock    string `json:"lock,omitempty"`
+	Tags    string `json:"tags,omitempty"`
+	MaxDisk int64  `json:"maxdisk,omitempty"`
+}
+
+func (n *Node) resources(pool string) []resource {
+	vmids := make([]int, 0, len(n.guests))
+	for vmid := range n.guests {
+		vmids = append(vmids, vmid)
+	}
+	sort.Ints(vmids)
+
+	out := make([]resource, 0, len(vmids))
+	for _, vmid := range vmids {
+		g := n.guests[vmid]
+		if pool != "" && g.Pool != pool {
+			continue
+		}
+		out = append(out, resource{VMID: g.VMID, Type: g.Type, Node: n.Name, Name: g.Name, Pool: g.Pool, Lock: g.Lock, Tags: g.Tags, MaxDisk: g.MaxDisk})
+	}
+	return out
+}
+
+// allPrivileges are the privileges granted on every path when
+// Node.Privileges is nil.
+var allPrivileges = []string{
+	"VM.Audit", "VM.Backup", "VM.Allocate", "VM.Config.Options", "VM.PowerMgmt",
+	"Datastore.Audit", "Datastore.AllocateSpace", "Sys.Audit", "Sys.Modify", "Pool.Allocate",
+}
+
+func (n *Node) permissions(acl string) map[string]map[string]int {
+	privileges := allPrivileges
+	if n.Privileges != nil {
+		privileges = n.Privileges[acl]
+	}
+	granted := make(map[string]int, len(privileges))
+	for _, privilege := range privileges {
+		granted[privilege] = 1
+	}
+	return map[string]map[string]int{acl: granted}
+}
+
+func (n *Node) pvesh(args []string) (string, string, error) {
+	if len(args) >= 2 && args[0] == "set" && strings.HasSuffix(args[1], "/config") {
+		return n.setConfig(args[1], args[2:])
+	}
+	if len(args) < 2 || args[0] != "get" {
+		return "", "pvesh: unsupported call", exitError(255)
+	}
+
+	var result any
+	switch endpoint := args[1]; {
+	case endpoint == "/version":
+		result = map[string]string{"version": "8.2.4", "release": "8.2"}
+	case endpoint == "/cluster/resources":
+		result = n.resources("")
+	case endpoint == "/cluster/nextid":
+		vmid := 100
+		for n.guests[vmid] != nil {
+			vmid++
+		}
+		result = strconv.Itoa(vmid)
+	case endpoint == "/access/permissions":
+		result = n.permissions(flagValue(args, "--path", "/"))
+	case endpoint == "/cluster/status":
+		entries := []map[string]any{{"type": "node", "name": n.Name, "local": 1}}
+		if n.Cluster != "" {
+			entries = append([]map[string]any{{"type": "cluster", "name": n.Cluster}}, entries...)
+		}
+		result = entries
+	case strings.HasPrefix(endpoint, "/storage/"):
+		id := strings.TrimPrefix(endpoint, "/storage/")
+		storage, ok := n.Storages[id]
+		if !ok {
+			return "", fmt.Sprintf("storage '%s' does not exist", id), exitError(2)
+		}
+		result = storage.definition(id)
+	case strings.HasPrefix(endpoint, "/pools/"):
+		pool := strings.TrimPrefix(endpoint, "/pools/")
+		members := n.resources(pool)
+		if len(members) == 0 {
+			return "", fmt.Sprintf("pool '%s' does not exist", pool), exitError(2)
+		}
+		result = map[string]any{"members": members}
+	case endpoint == "/nodes/"+n.Name+"/time":
+		zone := cmp.Or(n.Timezone, "UTC")
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			loc = time.UTC
+		}
+		now := time.Now().In(loc)
+		_, offset := now.Zone()
+		result = map[string]any{"timezone": zone, "time": now.Unix(), "localtime": now.Unix() + int64(offset)}
+	case endpoint == "/nodes/"+n.Name+"/status":
+		load := 0.0
+		if len(n.Load) > 0 {
+			load = n.Load[min(n.loadReads, len(n.Load)-1)]
+			n.loadReads++
+		}
+		avg := strconv.FormatFloat(load, 'f', 2, 64)
+		result = map[string]any{
+			"loadavg": []string{avg, avg, avg},
+			"cpu":     min(load/8, 1),
+			"wait":    n.IOWait / 100,
+			"cpuinfo": map[string]any{"cpus": 8},
+		}
+	case endpoint == "/nodes/"+n.Name+"/storage":
+		ids := make([]string, 0, len(n.Storages))
+		for id := range n.Storages {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		entries := make([]map[string]any, 0, len(ids))
+		for _, id := range ids {
+			entries = append(entries, n.Storages[id].status(id))
+		}
+		result = entries
+	case endpoint == "/nodes/"+n.Name+"/capabilities/qemu/machines":
+		machines := n.Machines
+		if machines == nil {
+			machines = DefaultMachines
+		}
+		entries := make([]map[string]string, 0, len(machines))
+		for _, id := range machines {
+			entries = append(entries, map[string]string{"id": id})
+		}
+		result = entries
+	case endpoint == "/nodes/"+n.Name+"/capabilities/qemu/cpu":
+		models := n.CPUModels
+		if models == nil {
+			models = DefaultCPUModels
+		}
+		entries := make([]map[string]any, 0, len(models))
+		for _, name := range models {
+			entries = append(entries, map[string]any{"name": name, "custom": 0})
+		}
+		result = entries
+	case strings.HasPrefix(endpoint, "/nodes/") && strings.HasSuffix(endpoint, "/tasks"):
+		result = []any{}
+	case strings.HasPrefix(endpoint, "/nodes/") && strings.HasSuffix(endpoint, "/config"):
+		g, ok := n.guestByEndpoint(endpoint)
+		if !ok {
+			return "", fmt.Sprintf("no such resource '%s'", endpoint), exitError(2)
+		}
+		result = map[string]any{"name": g.Name, "description": g.Description}
+	case strings.HasPrefix(endpoint, "/nodes/") && strings.HasSuffix(endpoint, "/status/current"):
+		g, ok := n.guestByEndpoint(endpoint)
+		if !ok {
+			return "", fmt.Sprintf("no such resource '%s'", endpoint), exitError(2)
+		}
+		status := "stopped"
+		if g.Running {
+			status = "running"
+		}
+		result = map[string]any{"status": status}
+	default:
+		return "", fmt.Sprintf("no such resource '%s'", endpoint), exitError(2)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err.Error(), exitError(255)
+	}
+	return string(data), "", nil
+}
+
+// guestByEndpoint resolves /nodes/<node>/<type>/<vmid>/config and
+// /nodes/<node>/<type>/<vmid>/status/current.
+func (n *Node) guestByEndpoint(endpoint string) (*Guest, bool) {
+	parts := strings.Split(strings.Trim(endpoint, "/"), "/")
+	if len(parts) != 5 && len(parts) != 6 {
+		return nil, false
+	}
+	vmid, _ := strconv.Atoi(parts[3])
+	g, ok := n.guests[vmid]
+	if !ok || g.Type != parts[2] {
+		return nil, false
+	}
+	return g, true
+}
+
+func (n *Node) setConfig(endpoint string, args []string) (string, string, error) {
+	g, ok := n.guestByEndpoint(endpoint)
+	if !ok {
+		return "", fmt.Sprintf("no such resource '%s'", endpoint), exitError(2)
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		key := strings.TrimPrefix(args[i], "--")
+		if key == "description" {
+			g.Description = args[i+1]
+			continue
+		}
+		// PVE only records mount point changes of a running container as
+		// pending, applied at its next start.
+		if g.Type == "lxc" && g.Running && strings.HasPrefix(key, "mp") {
+			continue
+		}
+		g.Config = setConfigLine(g.Config, key, args[i+1])
+	}
+	return "", "", nil
+}
+
+// setConfigLine replaces the key line of a guest config, or appends it.
+func setConfigLine(config, key, value string) string {
+	line := key + ": " + value
+	lines := strings.Split(strings.TrimRight(config, "\n"), "\n")
+	for i, existing := range lines {
+		if k, _, ok := strings.Cut(existing, ":"); ok && strings.TrimSpace(k) == key {
+			lines[i] = line
+			return strings.Join(lines, "\n") + "\n"
+		}
+	}
+	if strings.TrimSpace(config) == "" {
+		return line + "\n"
+	}
+	return strings.Join(append(lines, line), "\n") + "\n"
+}
+
+func (n *Node) vzdump(args []string) (string, string, error) {
+	if len(args) == 0 {
+		return "", "vzdump: missing vmid", exitError(255)
+	}
+	var vmids []int
+	for len(args) > 0 {
+		vmid, err := strconv.Atoi(args[0])
+		if err != nil {
+			break
+		}
+		vmids = append(vmids, vmid)
+		args = args[1:]
+	}
+	if hasFlag(args, "--all") {
+		excluded := strings.Split(flagValue(args, "--exclude", ""), ",")
+		for vmid := range n.guests {
+			if !slices.Contains(excluded, strconv.Itoa(vmid)) {
+				vmids = append(vmids, vmid)
+			}
+		}
+		sort.Ints(vmids)
+	}
+	if len(vmids) == 1 && !hasFlag(args, "--all") {
+		return n.vzdumpGuest(vmids[0], args)
+	}
+
+	// Like vzdump, a job of several guests goes on after a failed one and
+	// reports the failures at the end.
+	var stdout, stderr strings.Builder
+	failed := false
+	for _, vmid := range vmids {
+		out, errOut, err := n.vzdumpGuest(vmid, args)
+		stdout.WriteString(out)
+		if err != nil {
+			failed = true
+			stderr.WriteString(errOut + "\n")
+		}
+	}
+	if failed {
+		stderr.WriteString("INFO: Backup job finished with errors\njob errors\n")
+		return stdout.String(), stderr.String(), exitError(255)
+	}
+	return stdout.String(), stderr.String(), nil
+}
+
+func (n *Node) vzdumpGuest(vmid int, args []string) (string, string, error) {
+	g, ok := n.guests[vmid]
+	if !ok {
+		return "", fmt.Sprintf("ERROR: Backup of VM %d failed - unable to find VM '%d'", vmid, vmid), exitError(255)
+	}
+	if g.Lock != "" {
+		return "", fmt.Sprintf("ERROR: Backup of VM %d failed - VM is locked (%s)", vmid, g.Lock), exitError(255)
+	}
+
+	if hasFlag(args, "--stdout") {
+		return string(g.Archive), "INFO: starting new backup job: vzdump " + strings.Join(args, " "), nil
+	}
+
+	dumpDir := flagValue(args, "--dumpdir", n.DumpDir)
+	ext := "vma"
+	if g.Type == "lxc" {
+		ext = "tar"
+	}
+	name := fmt.Sprintf("vzdump-%s-%d-%s.%s%s", g.Type, vmid, time.Now().Format("2006_01_02-15_04_05"), ext, compressionSuffix(flagValue(args, "--compress", "0")))
+	archive := path.Join(dumpDir, name)
+	n.files[archive] = memFile{data: append([]byte(nil), g.Archive...), modTime: time.Now()}
+	return fmt.Sprintf("INFO: creating vzdump archive '%s'\nINFO: Finished Backup of VM %d\n", archive, vmid), "", nil
+}
+
+func (n *Node) guestCommand(tool string, args []string) (string, string, error) {
+	if len(args) < 2 {
+		return "", tool + ": missing arguments", exitError(255)
+	}
+	guestType := "qemu"
+	if tool == "pct" {
+		guestType = "lxc"
+	}
+
+	if args[0] == "restore" {
+		if len(args) < 3 {
+			return "", "usage: pct restore <vmid> <archive>", exitError(255)
+		}
+		return n.restore(guestType, args[2], args[1], args[3:])
+	}
+	if args[0] == "disk" {
+		// qm disk move <vmid> <disk> <storage>: the subcommand shifts the
+		// arguments by one.
+		if args[1] != "move" {
+			return "", fmt.Sprintf("%s: unknown command 'disk %s'", tool, args[1]), exitError(255)
+		}
+		args = append([]string{"move-volume"}, args[2:]...)
+	}
+
+	vmid, _ := strconv.Atoi(args[1])
+	g, ok := n.guests[vmid]
+	if !ok || g.Type != guestType {
+		return "", fmt.Sprintf("Configuration file 'nodes/%s/%s/%d.conf' does not exist", n.Name, configDir(guestType), vmid), exitError(2)
+	}
+
+	switch args[0] {
+	case "status":
+		if g.Running {
+			return "status: running\n", "", nil
+		}
+		return "status: stopped\n", "", nil
+	case "start":
+		g.Running = true
+	case "stop":
+		g.Running = false
+	case "set":
+	case "move-volume":
+		if len(args) < 4 {
+			return "", tool + ": missing disk or storage", exitError(255)
+		}
+		if g.Running {
+			return "", fmt.Sprintf("cannot move disk %s of running guest %d", args[2], vmid), exitError(255)
+		}
+	case "snapshot", "delsnapshot":
+		if len(args) < 3 {
+			return "", tool + ": missing snapshot name", exitError(255)
+		}
+		return n.guestSnapshot(g, args[0] == "snapshot", args[2])
+	default:
+		return "", fmt.Sprintf("%s: unknown command '%s'", tool, args[0]), exitError(255)
+	}
+	return "", "", nil
+}
+
+func (n *Node) restore(guestType, archive, vmidArg string, args []string) (string, string, error) {
+	vmid, err := strconv.Atoi(vmidArg)
+	if err != nil {
+		return "", "invalid vmid " + vmidArg, exitError(255)
+	}
+	f, ok := n.files[path.Clean(archive)]
+	if !ok {
+		return "", fmt.Sprintf("unable to restore '%s': archive does not exist", archive), exitError(255)
+	}
+	if existing, ok := n.guests[vmid]; ok && !hasFlag(args, "--force") {
+		return "", fmt.Sprintf("unable to restore VM %d - VM %d already exists on node '%s'", vmid, existing.VMID, n.Name), exitError(255)
+	}
+
+	n.restores = append(n.restores, Restore{Type: guestType, VMID: vmid, Archive: archive, Data: f.data, Args: args})
+	n.guests[vmid] = &Guest{VMID: vmid, Type: guestType, Pool: flagValue(args, "--pool", ""), Archive: f.data}
+	return fmt.Sprintf("restore %s %d from %s done\n", guestType, vmid, archive), "", nil
+}
+
+func (n *Node) list(dir string) (string, string, error) {
+	dir = path.Clean(dir)
+	var names []string
+	for p := range n.files {
+		if path.Dir(p) == dir {
+			names = append(names, path.Base(p))
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return "", "", nil
+	}
+	return strings.Join(names, "\n") + "\n", "", nil
+}
+
+// shell runs the sh -c scripts issued by the integration.
+func (n *Node) shell(args []string) (string, string, error) {
+	if len(args) < 3 || args[0] != "-c" {
+		return "", "sh: unsupported script", exitError(2)
+	}
+	switch args[1] {
+	case `cat -- "$@" > "$0"`:
+		return n.concat(args)
+	case `zfs send "$@" > "$0"`:
+		return n.zfsSend(args[2], args[3:])
+	case `zfs receive -F "$1" < "$0"`:
+		return n.zfsReceive(args[2], args[3])
+	}
+	return "", "sh: unsupported script", exitError(2)
+}
+
+// concat implements the sh -c 'cat -- "$@" > "$0"' <out> <parts...> call
+// used to reassemble segmented dumps.
+func (n *Node) concat(args []string) (string, string, error) {
+	if len(args) < 3 || args[0] != "-c" {
+		return "", "sh: unsupported script", exitError(2)
+	}
+	var buf bytes.Buffer
+	for _, part := range args[3:] {
+		f, ok := n.files[path.Clean(part)]
+		if !ok {
+			return "", fmt.Sprintf("cat: %s: No such file or directory", part), exitError(1)
+		}
+		buf.Write(f.data)
+	}
+	n.files[path.Clean(args[2])] = memFile{data: buf.Bytes(), modTime: time.Now()}
+	return "", "", nil
+}
+
+// zstd handles "zstd ... -o <output> -- <input>" like vzdump does
+// --compress: the content is kept as is, only the file is renamed.
+func (n *Node) zstd(args []string) (string, string, error) {
+	input := path.Clean(args[len(args)-1])
+	f, ok := n.files[input]
+	if !ok {
+		return "", fmt.Sprintf("zstd: %s: No such file or directory", input), exitError(1)
+	}
+	output := flagValue(args, "-o", input+".zst")
+	n.files[output] = memFile{data: f.data, modTime: time.Now()}
+	if hasFlag(args, "--rm") {
+		delete(n.files, input)
+	}
+	return "", "", nil
+}
+
+// tar writes the files under -C as a tar archive to -cpf.
+func (n *Node) tar(args []string) (string, string, error) {
+	dir := path.Clean(flagValue(args, "-C", "/"))
+	output := flagValue(args, "-cpf", "")
+	var names []string
+	for p := range n.files {
+		if strings.HasPrefix(p, dir+"/") {
+			names = append(names, p)
+		}
+	}
+	if _, ok := n.dirs[dir]; !ok && len(names) == 0 {
+		return "", fmt.Sprintf("tar: %s: Cannot open: No such file or directory", dir), exitError(2)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		f := n.files[name]
+		header := &tar.Header{Name: "./" + strings.TrimPrefix(name, dir+"/"), Mode: 0644, Size: int64(len(f.data)), ModTime: f.modTime}
+		if err := tw.WriteHeader(header); err != nil {
+			return "", err.Error(), exitError(2)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return "", err.Error(), exitError(2)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err.Error(), exitError(2)
+	}
+	n.files[path.Clean(output)] = memFile{data: buf.Bytes(), modTime: time.Now()}
+	return "", "", nil
+}
+
+func (n *Node) remove(name string) {
+	delete(n.files, name)
+	delete(n.dirs, name)
+	for p := range n.files {
+		if strings.HasPrefix(p, name+"/") {
+			delete(n.files, p)
+		}
+	}
+}
+
+func (n *Node) failingReader(data []byte) *failingReader {
+	return &failingReader{data: data, failAfter: n.StreamFailAfter}
+}
+
+// failingReader serves data, failing after failAfter bytes when set.
+type failingReader struct {
+	data      []byte
+	offset    int64
+	failAfter int64
+	failed    bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	limit := int64(len(r.data))
+	if r.failAfter > 0 && r.failAfter < limit {
+		limit = r.failAfter
+	}
+	if r.offset >= limit {
+		if limit < int64(len(r.data)) {
+			r.failed = true
+			return 0, ErrStreamFailed
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[r.offset:limit])
+	r.offset += int64(n)
+	return n, nil
+}
+
+type memWriter struct {
+	node *Node
+	path string
+	buf  bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memWriter) Close() error {
+	w.node.WriteFile(w.path, w.buf.Bytes())
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() any           { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0o755
+	}
+	return 0o600
+}
+
+type exitError int
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func configDir(guestType string) string {
+	if guestType == "lxc" {
+		return "lxc"
+	}
+	return "qemu-server"
+}
+
+func configPath(guestType string, vmid int) string {
+	return fmt.Sprintf("/etc/pve/%s/%d.conf", configDir(guestType), vmid)
+}
+
+func compressionSuffix(compress string) string {
+	switch compress {
+	case "gzip":
+		return ".gz"
+	case "lzo":
+		return ".lzo"
+	case "1", "zstd":
+		return ".zst"
+	}
+	return ""
+}
+
+func hasFlag(args []string, flag string) bool {
+	for _, arg := range args {
+		if arg == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func flagValue(args []string, flag, fallback string) string {
+	for i, arg := range args {
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,565 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package proxmoxtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Storage is a PVE storage definition of a Node.
+type Storage struct {
+	Type     string
+	Pool     string
+	VGName   string
+	ThinPool string
+	Path     string
+
+	// Content is the content types the storage allows. Empty means
+	// images,rootdir.
+	Content string
+
+	// Shared sets the shared flag of the definition.
+	Shared bool
+
+	// Avail and Total are the free and total space in bytes reported by
+	// /nodes/<node>/storage.
+	Avail int64
+	Total int64
+}
+
+func (s Storage) content() string {
+	if s.Content == "" {
+		return "images,rootdir"
+	}
+	return s.Content
+}
+
+func (s Storage) definition(id string) map[string]any {
+	def := map[string]any{"storage": id, "type": s.Type, "content": s.content()}
+	for key, value := range map[string]string{"pool": s.Pool, "vgname": s.VGName, "thinpool": s.ThinPool, "path": s.Path} {
+		if value != "" {
+			def[key] = value
+		}
+	}
+	if s.Shared {
+		def["shared"] = 1
+	}
+	return def
+}
+
+// status is the entry of the storage in /nodes/<node>/storage.
+func (s Storage) status(id string) map[string]any {
+	return map[string]any{
+		"storage": id,
+		"type":    s.Type,
+		"content": s.content(),
+		"active":  1,
+		"enabled": 1,
+		"avail":   s.Avail,
+		"total":   s.Total,
+		"used":    s.Total - s.Avail,
+	}
+}
+
+type volumeSnapshot struct {
+	volume string
+	name   string
+	data   []byte
+}
+
+// SetVolume sets the content of a storage volume, named as the storage
+// tools name it: rpool/data/vm-100-disk-0 for ZFS, rbd/vm-100-disk-0 for
+// Ceph, pve/vm-100-disk-0 for LVM.
+func (n *Node) SetVolume(name string, data []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.volumes[name] = append([]byte(nil), data...)
+}
+
+// Volume returns the content of a storage volume.
+func (n *Node) Volume(name string) ([]byte, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	data, ok := n.volumes[name]
+	return data, ok
+}
+
+func (n *Node) snapshot(volume, name string) (volumeSnapshot, bool) {
+	for _, snap := range n.snapshots {
+		if snap.volume == volume && snap.name == name {
+			return snap, true
+		}
+	}
+	return volumeSnapshot{}, false
+}
+
+func (n *Node) removeSnapshot(volume, name string) bool {
+	for i, snap := range n.snapshots {
+		if snap.volume == volume && snap.name == name {
+			n.snapshots = append(n.snapshots[:i], n.snapshots[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// zfs handles zfs snapshot, list -t snapshot, destroy, set and inherit on
+// the volumes of the node.
+func (n *Node) zfs(args []string) (string, string, error) {
+	if len(args) < 2 {
+		return "", "zfs: missing arguments", exitError(2)
+	}
+	switch args[0] {
+	case "snapshot":
+		for _, arg := range args[1:] {
+			volume, name, _ := strings.Cut(arg, "@")
+			data, ok := n.volumes[volume]
+			if !ok {
+				return "", fmt.Sprintf("cannot open '%s': dataset does not exist", volume), exitError(1)
+			}
+			n.snapshots = append(n.snapshots, volumeSnapshot{volume: volume, name: name, data: data})
+		}
+	case "list":
+		volume := args[len(args)-1]
+		var out strings.Builder
+		for _, snap := range n.snapshots {
+			if snap.volume == volume {
+				out.WriteString(volume + "@" + snap.name + "\n")
+			}
+		}
+		return out.String(), "", nil
+	case "set", "inherit":
+		if _, ok := n.volumes[args[len(args)-1]]; !ok {
+			return "", fmt.Sprintf("cannot open '%s': dataset does not exist", args[len(args)-1]), exitError(1)
+		}
+	case "send":
+		data, stderr, err := n.zfsSendData(args[1:])
+		return string(data), stderr, err
+	case "destroy":
+		volume, name, _ := strings.Cut(args[1], "@")
+		if !n.removeSnapshot(volume, name) {
+			return "", "could not find any snapshots to destroy; check snapshot names.", exitError(1)
+		}
+	default:
+		return "", fmt.Sprintf("zfs: unsupported command '%s'", args[0]), exitError(2)
+	}
+	return "", "", nil
+}
+
+// zfsSend writes the content of a snapshot to out. Incremental streams
+// carry the whole content too.
+func (n *Node) zfsSend(out string, args []string) (string, string, error) {
+	data, stderr, err := n.zfsSendData(args)
+	if err != nil {
+		return "", stderr, err
+	}
+	n.files[path.Clean(out)] = memFile{data: data, modTime: time.Now()}
+	return "", "", nil
+}
+
+func (n *Node) zfsSendData(args []string) ([]byte, string, error) {
+	volume, name, _ := strings.Cut(args[len(args)-1], "@")
+	snap, ok := n.snapshot(volume, name)
+	if !ok {
+		return nil, fmt.Sprintf("cannot open '%s@%s': dataset does not exist", volume, name), exitError(1)
+	}
+	if base := flagValue(args, "-i", ""); base != "" {
+		baseVolume, baseName, _ := strings.Cut(base, "@")
+		if _, ok := n.snapshot(baseVolume, baseName); !ok {
+			return nil, fmt.Sprintf("cannot open '%s': dataset does not exist", base), exitError(1)
+		}
+	}
+	return append([]byte(nil), snap.data...), "", nil
+}
+
+func (n *Node) zfsReceive(in, volume string) (string, string, error) {
+	f, ok := n.files[path.Clean(in)]
+	if !ok {
+		return "", fmt.Sprintf("sh: %s: No such file or directory", in), exitError(1)
+	}
+	n.volumes[volume] = append([]byte(nil), f.data...)
+	return "", "", nil
+}
+
+// rbd handles the rbd snap, export, import, info and rm commands on the
+// volumes of the node. Diffs carry the whole content.
+func (n *Node) rbd(args []string) (string, string, error) {
+	for len(args) >= 2 && (args[0] == "-m" || args[0] == "--id" || args[0] == "--keyring") {
+		args = args[2:]
+	}
+	var operands []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--no-progress":
+		case "--format", "--from-snap":
+			i++
+		default:
+			operands = append(operands, args[i])
+		}
+	}
+	if len(operands) < 2 || (operands[0] == "snap" && len(operands) < 3) {
+		return "", "rbd: missing arguments", exitError(2)
+	}
+
+	missing := func(name string) (string, string, error) {
+		return "", fmt.Sprintf("rbd: error opening image %s: (2) No such file or directory", name), exitError(2)
+	}
+	switch operands[0] + " " + operands[1] {
+	case "snap create":
+		volume, name, _ := strings.Cut(operands[2], "@")
+		data, ok := n.volumes[volume]
+		if !ok {
+			return missing(volume)
+		}
+		n.snapshots = append(n.snapshots, volumeSnapshot{volume: volume, name: name, data: data})
+		return "", "", nil
+	case "snap ls":
+		var out []map[string]any
+		for i, snap := range n.snapshots {
+			if snap.volume == operands[2] {
+				out = append(out, map[string]any{"id": i + 1, "name": snap.name, "size": len(snap.data)})
+			}
+		}
+		data, _ := json.Marshal(out)
+		return string(data), "", nil
+	case "snap rm":
+		volume, name, _ := strings.Cut(operands[2], "@")
+		if !n.removeSnapshot(volume, name) {
+			return "", fmt.Sprintf("rbd: failed to remove snapshot %s: (2) No such file or directory", operands[2]), exitError(2)
+		}
+		return "", "", nil
+	case "snap purge":
+		kept := n.snapshots[:0]
+		for _, snap := range n.snapshots {
+			if snap.volume != operands[2] {
+				kept = append(kept, snap)
+			}
+		}
+		n.snapshots = kept
+		return "", "", nil
+	}
+
+	switch operands[0] {
+	case "export", "export-diff":
+		volume, name, _ := strings.Cut(operands[1], "@")
+		snap, ok := n.snapshot(volume, name)
+		if !ok || len(operands) < 3 {
+			return missing(operands[1])
+		}
+		if base := flagValue(args, "--from-snap", ""); base != "" {
+			if _, ok := n.snapshot(volume, base); !ok {
+				return missing(volume + "@" + base)
+			}
+		}
+		if operands[2] == "-" {
+			return string(snap.data), "", nil
+		}
+		n.files[path.Clean(operands[2])] = memFile{data: append([]byte(nil), snap.data...), modTime: time.Now()}
+	case "import", "import-diff":
+		if len(operands) < 3 {
+			return "", "rbd: missing arguments", exitError(2)
+		}
+		f, ok := n.files[path.Clean(operands[1])]
+		if !ok {
+			return "", fmt.Sprintf("rbd: error opening %s: (2) No such file or directory", operands[1]), exitError(2)
+		}
+		_, exists := n.volumes[operands[2]]
+		if operands[0] == "import" && exists {
+			return "", "rbd: image creation failed: (17) File exists", exitError(2)
+		}
+		if operands[0] == "import-diff" && !exists {
+			return missing(operands[2])
+		}
+		n.volumes[operands[2]] = append([]byte(nil), f.data...)
+	case "info":
+		if _, ok := n.volumes[operands[1]]; !ok {
+			return missing(operands[1])
+		}
+	case "rm":
+		if _, ok := n.volumes[operands[1]]; !ok {
+			return missing(operands[1])
+		}
+		delete(n.volumes, operands[1])
+	default:
+		return "", fmt.Sprintf("rbd: unsupported command '%s'", operands[0]), exitError(2)
+	}
+	return "", "", nil
+}
+
+// lvm handles the lvcreate, lvchange, lvremove and lvs calls on the
+// volumes of the node. Snapshots are volumes of their own, as in LVM.
+func (n *Node) lvm(tool string, args []string) (string, string, error) {
+	if len(args) == 0 {
+		return "", tool + ": missing arguments", exitError(3)
+	}
+	lv := args[len(args)-1]
+	missing := func(name string) (string, string, error) {
+		return "", fmt.Sprintf("Failed to find logical volume \"%s\"", name), exitError(5)
+	}
+	switch tool {
+	case "lvcreate":
+		name := flagValue(args, "-n", "")
+		if pool := flagValue(args, "-T", ""); pool != "" {
+			vg, _, _ := strings.Cut(pool, "/")
+			if _, ok := n.volumes[vg+"/"+name]; ok {
+				return "", fmt.Sprintf("Logical Volume \"%s\" already exists in volume group \"%s\"", name, vg), exitError(5)
+			}
+			n.volumes[vg+"/"+name] = []byte{}
+			return "", "", nil
+		}
+		data, ok := n.volumes[lv]
+		if !ok {
+			return missing(lv)
+		}
+		vg, _, _ := strings.Cut(lv, "/")
+		n.volumes[vg+"/"+name] = data
+	case "lvremove":
+		if _, ok := n.volumes[lv]; !ok {
+			return missing(lv)
+		}
+		delete(n.volumes, lv)
+	default:
+		if _, ok := n.volumes[lv]; !ok {
+			return missing(lv)
+		}
+	}
+	return "", "", nil
+}
+
+// dd copies between files and /dev/<vg>/<lv> volumes of the node.
+func (n *Node) dd(args []string) (string, string, error) {
+	var in, out string
+	for _, arg := range args {
+		if value, ok := strings.CutPrefix(arg, "if="); ok {
+			in = value
+		} else if value, ok := strings.CutPrefix(arg, "of="); ok {
+			out = value
+		}
+	}
+	var data []byte
+	if volume, ok := strings.CutPrefix(in, "/dev/"); ok {
+		if data, ok = n.volumes[volume]; !ok {
+			return "", fmt.Sprintf("dd: failed to open '%s': No such file or directory", in), exitError(1)
+		}
+	} else {
+		f, ok := n.files[path.Clean(in)]
+		if !ok {
+			return "", fmt.Sprintf("dd: failed to open '%s': No such file or directory", in), exitError(1)
+		}
+		data = f.data
+	}
+	data = append([]byte(nil), data...)
+	if volume, ok := strings.CutPrefix(out, "/dev/"); ok {
+		if _, ok := n.volumes[volume]; !ok {
+			return "", fmt.Sprintf("dd: failed to open '%s': No such file or directory", out), exitError(1)
+		}
+		n.volumes[volume] = data
+		return "", "", nil
+	}
+	n.files[path.Clean(out)] = memFile{data: data, modTime: time.Now()}
+	return "", "", nil
+}
+
+var guestDiskRegex = regexp.MustCompile(`^(?:(?:ide|sata|scsi|virtio|efidisk|tpmstate|mp)\d+|rootfs):\s*([^,\s]+)(.*)$`)
+
+// volumePath returns the path of a volume as pvesm path prints it.
+func (n *Node) volumePath(volid string) (string, bool) {
+	id, volume, _ := strings.Cut(volid, ":")
+	storage, ok := n.Storages[id]
+	if !ok {
+		return "", false
+	}
+	switch storage.Type {
+	case "zfspool":
+		return "/dev/zvol/" + storage.Pool + "/" + volume, true
+	case "rbd":
+		return "rbd:" + storage.Pool + "/" + volume, true
+	case "lvmthin":
+		return "/dev/" + storage.VGName + "/" + volume, true
+	default:
+		return storage.Path + "/images/" + volume, true
+	}
+}
+
+// volumeKey returns the name of the volume at path p, as the storage tools
+// name it, or p itself for file volumes.
+func volumeKey(p string) string {
+	if key, ok := strings.CutPrefix(p, "rbd:"); ok {
+		key, _, _ = strings.Cut(key, ":")
+		return key
+	}
+	if key, ok := strings.CutPrefix(p, "/dev/zvol/"); ok {
+		return key
+	}
+	if key, ok := strings.CutPrefix(p, "/dev/"); ok {
+		return key
+	}
+	return p
+}
+
+// guestSnapshot takes or removes a snapshot of every disk of the guest.
+// On LVM-thin, the snapshot is a volume of its own.
+func (n *Node) guestSnapshot(g *Guest, create bool, name string) (string, string, error) {
+	for _, line := range strings.Split(g.Config, "\n") {
+		matches := guestDiskRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if matches == nil || strings.Contains(matches[2], "media=cdrom") {
+			continue
+		}
+		p, ok := n.volumePath(matches[1])
+		if !ok {
+			continue
+		}
+		key := volumeKey(p)
+		id, volume, _ := strings.Cut(matches[1], ":")
+		snapLV := ""
+		if storage := n.Storages[id]; storage.Type == "lvmthin" {
+			snapLV = storage.VGName + "/snap_" + volume + "_" + name
+		}
+		if !create {
+			n.removeSnapshot(key, name)
+			delete(n.volumes, snapLV)
+			continue
+		}
+		data, ok := n.volumes[key]
+		if !ok {
+			return "", fmt.Sprintf("volume '%s' does not exist", matches[1]), exitError(255)
+		}
+		n.snapshots = append(n.snapshots, volumeSnapshot{volume: key, name: name, data: data})
+		if snapLV != "" {
+			n.volumes[snapLV] = data
+		}
+	}
+	return "", "", nil
+}
+
+// pvesm handles pvesm path, alloc and free on the volumes of the node.
+func (n *Node) pvesm(args []string) (string, string, error) {
+	if len(args) < 2 {
+		return "", "pvesm: missing arguments", exitError(255)
+	}
+	switch args[0] {
+	case "path":
+		p, ok := n.volumePath(args[1])
+		if !ok {
+			return "", fmt.Sprintf("storage '%s' does not exist", args[1]), exitError(255)
+		}
+		return p + "\n", "", nil
+	case "alloc":
+		if len(args) < 5 {
+			return "", "pvesm: missing arguments", exitError(255)
+		}
+		volid := args[1] + ":" + args[3]
+		if n.Storages[args[1]].Type == "dir" {
+			volid = args[1] + ":" + args[2] + "/" + args[3]
+		}
+		p, ok := n.volumePath(volid)
+		if !ok {
+			return "", fmt.Sprintf("storage '%s' does not exist", args[1]), exitError(255)
+		}
+		if _, ok := n.volumes[volumeKey(p)]; ok {
+			return "", fmt.Sprintf("volume '%s' already exists", volid), exitError(255)
+		}
+		n.volumes[volumeKey(p)] = []byte{}
+		return fmt.Sprintf("successfully created '%s'\n", volid), "", nil
+	case "free":
+		p, ok := n.volumePath(args[1])
+		if _, exists := n.volumes[volumeKey(p)]; !ok || !exists {
+			return "", fmt.Sprintf("volume '%s' does not exist", args[1]), exitError(255)
+		}
+		delete(n.volumes, volumeKey(p))
+		return "", "", nil
+	}
+	return "", fmt.Sprintf("pvesm: unknown command '%s'", args[0]), exitError(255)
+}
+
+// qemuImg handles qemu-img info and convert between files, volumes and
+// volume snapshots of the node. Images keep the raw content.
+func (n *Node) qemuImg(args []string) (string, string, error) {
+	var operands []string
+	snap, existing := "", false
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-n":
+			existing = true
+		case "-U", "--force-share":
+		case "-f", "-O", "--output":
+			i++
+		case "-l":
+			i++
+			if i < len(args) {
+				snap = strings.TrimPrefix(args[i], "snapshot.name=")
+			}
+		default:
+			operands = append(operands, args[i])
+		}
+	}
+	if len(args) == 0 || len(operands) == 0 {
+		return "", "qemu-img: missing arguments", exitError(1)
+	}
+
+	read := func(p string) ([]byte, bool) {
+		key := volumeKey(p)
+		if volume, name, ok := strings.Cut(key, "@"); ok {
+			snap, ok := n.snapshot(volume, name)
+			return snap.data, ok
+		}
+		if snap != "" {
+			snap, ok := n.snapshot(key, snap)
+			return snap.data, ok
+		}
+		if data, ok := n.volumes[key]; ok {
+			return data, true
+		}
+		f, ok := n.files[path.Clean(p)]
+		return f.data, ok
+	}
+
+	switch args[0] {
+	case "info":
+		data, ok := read(operands[0])
+		if !ok {
+			return "", fmt.Sprintf("qemu-img: Could not open '%s': No such file or directory", operands[0]), exitError(1)
+		}
+		out, _ := json.Marshal(map[string]any{"virtual-size": len(data), "format": "qcow2", "filename": operands[0]})
+		return string(out), "", nil
+	case "convert":
+		if len(operands) < 2 {
+			return "", "qemu-img: missing arguments", exitError(1)
+		}
+		data, ok := read(operands[0])
+		if !ok {
+			return "", fmt.Sprintf("qemu-img: Could not open '%s': No such file or directory", operands[0]), exitError(1)
+		}
+		data = append([]byte(nil), data...)
+		if existing {
+			key := volumeKey(operands[1])
+			if _, ok := n.volumes[key]; !ok {
+				return "", fmt.Sprintf("qemu-img: Could not open '%s': No such file or directory", operands[1]), exitError(1)
+			}
+			n.volumes[key] = data
+			return "", "", nil
+		}
+		n.files[path.Clean(operands[1])] = memFile{data: data, modTime: time.Now()}
+		return "", "", nil
+	}
+	return "", fmt.Sprintf("qemu-img: unknown command '%s'", args[0]), exitError(1)
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright (c) 2026 Gilles DUBOIS
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package runner exposes the seam through which the importer and exporter
+// run commands and transfer files on the Proxmox node, so downstream users
+// can drive them with their own Runner in tests.
+package runner
+
+import (
+	"io"
+
+	"github.com/gillesdubois/plakar-integration-proxmox/internal/proxmox"
+)
+
+type (
+	Runner        = proxmox.Runner
+	CommandStream = proxmox.CommandStream
+	MockRunner    = proxmox.MockRunner
+	MockFixture   = proxmox.MockFixture
+)
+
+// NewCommandStream builds the stream returned by Runner.Stream.
+func NewCommandStream(stdout, stderr io.Reader, finish, abort func() error) *CommandStream {
+	return proxmox.NewCommandStream(stdout, stderr, finish, abort)
+}
+
+// NewMockRunner loads the fixtures of dir, see the runner=mock option.
+func NewMockRunner(dir string) (*MockRunner, error) {
+	return proxmox.NewMockRunner(dir)
+}